@@ -52,6 +52,9 @@ variables, for example:
 # these env var are as for the relay serve instance being monitored
 export RELAY_MONITOR_AUDIENCE=https://app.practable.io/ed0/access
 export RELAY_MONITOR_SECRET=somesecret
+# or, to sign with a key from the relay's RELAY_KEYS, set its kid, and its private key file or HMAC secret
+# export RELAY_MONITOR_KEY_ID=monitor-2024
+# export RELAY_MONITOR_KEY_FILE=/etc/relay/monitor-key.pem
 
 # these env var are specific to the monitor
 export RELAY_MONITOR_LOG_LEVEL=warn
@@ -91,6 +94,8 @@ ${RELAY_AUDIENCE}/session/${RELAY_MONITOR_TOPIC}
 		// of which instance we are monitoring are explicitly set
 		viper.SetDefault("audience", "")
 		viper.SetDefault("secret", "")
+		viper.SetDefault("key_file", "")
+		viper.SetDefault("key_id", "")
 
 		// set sensible defaults for logging to make configuration easier
 		viper.SetDefault("log_level", "warn")
@@ -115,6 +120,8 @@ ${RELAY_AUDIENCE}/session/${RELAY_MONITOR_TOPIC}
 		// read configuration
 		audience := viper.GetString("audience")
 		secret := viper.GetString("secret")
+		keyFile := viper.GetString("key_file")
+		keyID := viper.GetString("key_id")
 		logLevel := viper.GetString("log_level")
 		logFormat := viper.GetString("log_format")
 		logFile := viper.GetString("log_file")
@@ -132,10 +139,20 @@ ${RELAY_AUDIENCE}/session/${RELAY_MONITOR_TOPIC}
 			fmt.Println("You must set RELAY_MONITOR_AUDIENCE")
 			ok = false
 		}
-		if secret == "" {
+		if secret == "" && keyFile == "" {
 			fmt.Println("You must set RELAY_MONITOR_SECRET")
 			ok = false
 		}
+		if keyFile != "" && keyID == "" {
+			fmt.Println("You must set RELAY_MONITOR_KEY_ID with RELAY_MONITOR_KEY_FILE")
+			ok = false
+		}
+
+		signer, err := newSigner(keyID, keyFile, secret)
+		if err != nil {
+			fmt.Println(err)
+			ok = false
+		}
 
 		// parse durations
 		threshold, err := time.ParseDuration(thresholdStr)
@@ -216,7 +233,11 @@ ${RELAY_AUDIENCE}/session/${RELAY_MONITOR_TOPIC}
 		// Report useful info
 		log.Infof("relay version: %s", versionString())
 		log.Infof("Audience: [%s]", audience)
-		log.Debugf("Secret: [%s...%s]", secret[:4], secret[len(secret)-4:])
+		if len(secret) > 8 {
+			log.Debugf("Secret: [%s...%s]", secret[:4], secret[len(secret)-4:])
+		}
+		log.Infof("Key ID: [%s]", keyID)
+		log.Infof("Key file: [%s]", keyFile)
 
 		log.Infof("Log file: [%s]", logFile)
 		log.Infof("Log format: [%s]", logFormat)
@@ -256,6 +277,7 @@ ${RELAY_AUDIENCE}/session/${RELAY_MONITOR_TOPIC}
 			ReconnectEvery:     reconnectEvery,
 			RelayAudience:      audience,
 			RelaySecret:        secret,
+			RelaySigner:        signer,
 			Topic:              topic,
 			TriggerAfterMisses: triggerAfterMisses,
 		}
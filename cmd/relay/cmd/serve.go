@@ -18,7 +18,11 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof" //ok in production https://medium.com/google-cloud/continuous-profiling-of-go-programs-96d4416af77b
 	"os"
@@ -26,8 +30,16 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/practable/relay/internal/cert"
+	"github.com/practable/relay/internal/crossbar"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/handover"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/metrics"
 	"github.com/practable/relay/internal/relay"
 	log "github.com/sirupsen/logrus"
 
@@ -53,23 +65,109 @@ variables, for example:
 
 export RELAY_ALLOW_NO_BOOKING_ID=true
 export RELAY_AUDIENCE=https://example.org
+export RELAY_AUDIT_DIR=/var/log/relay/audit
+export RELAY_AUDIT_KEEP_DAYS=90
 export RELAY_BUFFER_SIZE=128
+export RELAY_CLUSTER_NODE=relay1
+export RELAY_CLUSTER_PEERS=wss://relay2.example.io,wss://relay3.example.io
+export RELAY_CLUSTER_SECRET=someclustersecret
+export RELAY_DRAIN_WAIT=5s
+export RELAY_EXPIRY_WARNING=5m
+export RELAY_FLOOR_CONTROL="pend*-data,spinner/**"
+export RELAY_KEYS=/etc/relay/keys.json
+export RELAY_LIMIT_BYTES=20000000/1s
+export RELAY_LIMIT_CONNECT=60/1m
+export RELAY_LIMIT_MESSAGES=500/1s
+export RELAY_LIMIT_SESSION=30/1m
 export RELAY_LOG_LEVEL=warn
 export RELAY_LOG_FORMAT=json
 export RELAY_LOG_FILE=/var/log/relay/relay.log
+export RELAY_METRICS=true
 export RELAY_PORT_ACCESS=3000
+export RELAY_PORT_METRICS=6062
 export RELAY_PORT_PROFILE=6061
 export RELAY_PORT_RELAY=3001
 export RELAY_PROFILE=true
+export RELAY_RECORD="pend*-data"
+export RELAY_RECORD_DIR=/var/lib/relay/recordings
 export RELAY_SECRET=somesecret
+export RELAY_SLOW_CONSUMER="*-data=disconnect,*-video=drop-stale:500ms"
+export RELAY_STATE_DIR=/var/lib/relay
 export RELAY_STATS_EVERY=5s
+export RELAY_STORE_PORT=3002
+export RELAY_STORE_SECRET=somestoresecret
+export RELAY_STORE_URL=http://relay-store:3002
 export RELAY_TIDY_EVERY=5m 
+export RELAY_TLS_CERT=/etc/relay/tls/fullchain.pem
+export RELAY_TLS_CLIENT_CA=/etc/relay/tls/admin-ca.pem
+export RELAY_TLS_KEY=/etc/relay/tls/privkey.pem
+export RELAY_TRUSTED_PROXIES=127.0.0.1,10.0.0.0/8
 export RELAY_URL=wss://example.io/relay 
 relay serve 
 
 Notes:
 RELAY_URL tells access the FQDN for RELAY_PORT_RELAY; without it, access cannot redirect clients
 RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the default value
+RELAY_CLUSTER_NODE is optional; if set, this relay links to RELAY_CLUSTER_PEERS so that messages on session topics reach
+  clients on every node, and /status reports on them all. Each node needs a unique node name, and must be linked to every other
+  node, so list each peer's RELAY_URL on at least one of the pair. Links are authenticated with RELAY_CLUSTER_SECRET, which
+  defaults to RELAY_SECRET. Shell connections are not shared between nodes.
+RELAY_AUDIT_DIR is optional; if set, connections (with why they ended), refused connections, and deny and allow calls
+  are logged there, one file per day, with files removed after RELAY_AUDIT_KEEP_DAYS (default 90, 0 to keep forever).
+  Admins can query the log with the access API at /audit.
+RELAY_DRAIN_WAIT is how long to wait for connections to close when stopping. On SIGINT or SIGTERM, the relay drains:
+  access refuses new sessions, every websocket is closed with 1001 (going away) so clients reconnect, and the relay exits
+  after RELAY_DRAIN_WAIT. On SIGUSR2, the relay first starts a new copy of itself (e.g. a newly installed version) and
+  hands over its listening sockets, so that no connections are refused while it drains. Codes and deny lists are not
+  handed over, except deny lists saved in RELAY_STATE_DIR, so clients with codes from the old relay ask access again.
+  The supervisor must not stop the new process when the old one exits.
+RELAY_EXPIRY_WARNING is how long before a connection expires to warn clients that request the websocket subprotocol
+  session.relay.practable.io, with {"session":"expiring","expiresAt":<unix seconds>}. These clients can extend their
+  connection without reconnecting by sending {"session":"refresh","code":"<code from access>"} or
+  {"session":"refresh","token":"<token for access>"}, and are sent {"session":"refreshed",...} or {"session":"refused",...}
+  in reply. Set to 0s for no warnings.
+RELAY_FLOOR_CONTROL is optional; on session topics matching any of these patterns, only one writer at a time holds control.
+  Writers send {"floor":"request"}, {"floor":"release"} or {"floor":"grant","to":"<id>"}, and tokens with the admin scope can
+  send {"floor":"seize"}. Every change is announced to all clients on the topic. Control is not shared between cluster nodes.
+RELAY_KEYS is optional; if set, tokens with a kid header are verified with the key with that kid in this JSON Web Key Set
+  file, which can hold HMAC secrets (kty oct), RSA public keys (kty RSA, RS256) and Ed25519 public keys (kty OKP, EdDSA).
+  Tokens without a kid must still be signed with RELAY_SECRET. The file is checked every 10s, and on SIGHUP, so keys can
+  be added before they are used, and removed once they are retired, without restarting. See relay token for signing.
+RELAY_LIMIT_* are optional rate limits in the form count/duration, allowing bursts of up to count; each is unlimited if not set.
+  RELAY_LIMIT_CONNECT limits new websocket connections per source address (HTTP 429 when exceeded),
+  RELAY_LIMIT_SESSION limits requests to access per booking ID (HTTP 429), and RELAY_LIMIT_MESSAGES and
+  RELAY_LIMIT_BYTES limit what each write connection may send (closed with 1008 policy violation when exceeded).
+  RELAY_LIMIT_BYTES count must be larger than the largest message e.g. a video key frame.
+RELAY_POLICY is optional; if set, session topics matching the patterns in this JSON file have their own limits, e.g.
+  {"topics":[{"pattern":"*-video","bufferSize":32,"maxMessageSize":1048576,"maxReaders":20,"maxWriters":1,
+  "maxPerBooking":2,"messageTypes":["binary"]}]}. The first match applies, and omitted limits take the defaults.
+  Connections over the reader, writer or per-booking caps are refused with HTTP 429, and clients that send a message
+  larger than maxMessageSize, or of a type not listed, are closed with 1009 or 1003. "framing":"message" sends
+  messages queued for slow clients as separate websocket messages, instead of joining them as one ("stream").
+  "compress":true compresses messages for clients that offer permessage-deflate, once for all the readers.
+  The file is reloaded on SIGHUP, and the new limits apply to new connections.
+RELAY_METRICS serves prometheus metrics at http://<host>:RELAY_PORT_METRICS/metrics
+RELAY_RECORD is optional; messages on session topics matching any of these patterns are recorded in RELAY_RECORD_DIR,
+  which must be set too. Admins can list, download and replay the recordings in RELAY_RECORD_DIR with the access API
+  at /recordings, even when RELAY_RECORD is not set.
+RELAY_SLOW_CONSUMER is optional; it sets what happens when a client cannot keep up, for topics matching each pattern
+  (first match wins). Policies are drop-newest (default), drop-oldest, drop-stale:<max age>, and disconnect
+RELAY_STORE_PORT is optional; if set, this relay serves its codes and deny lists to other replicas on that port
+RELAY_STORE_URL is optional; if set, this relay uses the codes and deny lists served by another relay at that URL,
+  instead of its own, so that codes issued by any replica's access can be used at any replica's relay.
+  RELAY_STORE_SECRET must be set on both, and the store port should not be exposed outside your private network.
+RELAY_STATE_DIR is optional; if set, the deny and allow lists are saved there so that cancelled bookings stay cancelled after a restart.
+  The relay does not start if the saved lists cannot be loaded, rather than start without them.
+RELAY_TLS_CERT and RELAY_TLS_KEY are optional; if set, access and relay serve TLS themselves with this PEM encoded
+  certificate (with any intermediates) and key, so RELAY_AUDIENCE and RELAY_URL should be https:// and wss://. The files
+  are checked every minute, and on SIGHUP, and a renewed certificate is used for new connections without closing any.
+RELAY_TLS_CLIENT_CA is optional; if set, the admin endpoints of access (/audit, /bids, /connections, /recordings, /status
+  and /topics) are refused unless the client presents a certificate signed by one of the CAs in this PEM file, as well
+  as an admin token.
+RELAY_TRUSTED_PROXIES is optional; it lists the addresses and networks of the proxies in front of the relay. Requests
+  from them are taken to come from the right-most X-Forwarded-For address that is not a trusted proxy, for
+  RELAY_LIMIT_CONNECT and the audit log. X-Forwarded-For is ignored in requests from anywhere else, so that it
+  cannot be forged.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -81,32 +179,88 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 
 		viper.SetDefault("allow_no_booking_id", false) // default to most secure option; set true for backwards compatibility
 		viper.SetDefault("audience", "")               //so we can check it's been provided
+		viper.SetDefault("audit_dir", "")              // connections are not audited unless set
+		viper.SetDefault("audit_keep_days", 90)
 		viper.SetDefault("buffer_size", 128)
+		viper.SetDefault("cluster_node", "") // not clustered unless set
+		viper.SetDefault("cluster_peers", "")
+		viper.SetDefault("cluster_secret", "") // defaults to secret
+		viper.SetDefault("drain_wait", "5s")
+		viper.SetDefault("expiry_warning", "5m")
+		viper.SetDefault("floor_control", "") // no topics are floor-controlled unless set
+		viper.SetDefault("keys", "")          // only tokens signed with secret are accepted unless set
+		viper.SetDefault("limit_bytes", "")   // unlimited unless set
+		viper.SetDefault("limit_connect", "")
+		viper.SetDefault("limit_messages", "")
+		viper.SetDefault("limit_session", "")
 		viper.SetDefault("log_file", "/var/log/relay/relay.log")
 		viper.SetDefault("log_format", "json")
 		viper.SetDefault("log_level", "warn")
+		viper.SetDefault("metrics", false)
+		viper.SetDefault("policy", "") // no topic policies unless set
 		viper.SetDefault("port_access", 3000)
+		viper.SetDefault("port_metrics", 6062)
 		viper.SetDefault("port_relay", 3001)
 		viper.SetDefault("profile", "true")
 		viper.SetDefault("profile_port", 6061)
+		viper.SetDefault("record", "") // no topics are recorded unless set
+		viper.SetDefault("record_dir", "")
 		viper.SetDefault("secret", "") //so we can check it's been provided
+		viper.SetDefault("slow_consumer", "")
+		viper.SetDefault("state_dir", "") // lists are not persisted unless set
 		viper.SetDefault("stats_every", "5s")
+		viper.SetDefault("store_port", 0) // codes and lists are not served unless set
+		viper.SetDefault("store_secret", "")
+		viper.SetDefault("store_url", "") // codes and lists are local unless set
 		viper.SetDefault("tidy_every", "5m")
-		viper.SetDefault("url", "") //so we can check it's been provided
+		viper.SetDefault("tls_cert", "") // TLS is terminated elsewhere unless set
+		viper.SetDefault("tls_client_ca", "")
+		viper.SetDefault("tls_key", "")
+		viper.SetDefault("trusted_proxies", "") // X-Forwarded-For is ignored unless set
+		viper.SetDefault("url", "")             //so we can check it's been provided
 
 		allowNoBookingID := viper.GetBool("allow_no_booking_id")
 		audience := viper.GetString("audience")
+		auditDir := viper.GetString("audit_dir")
+		auditKeepDays := viper.GetInt("audit_keep_days")
 		bufferSize := viper.GetInt64("buffer_size")
+		clusterNode := viper.GetString("cluster_node")
+		clusterPeersStr := viper.GetString("cluster_peers")
+		clusterSecret := viper.GetString("cluster_secret")
+		drainWaitStr := viper.GetString("drain_wait")
+		expiryWarningStr := viper.GetString("expiry_warning")
+		floorControlStr := viper.GetString("floor_control")
+		keysFile := viper.GetString("keys")
+		limitStr := map[string]string{
+			"RELAY_LIMIT_BYTES":    viper.GetString("limit_bytes"),
+			"RELAY_LIMIT_CONNECT":  viper.GetString("limit_connect"),
+			"RELAY_LIMIT_MESSAGES": viper.GetString("limit_messages"),
+			"RELAY_LIMIT_SESSION":  viper.GetString("limit_session"),
+		}
 		logFile := viper.GetString("log_file")
 		logFormat := viper.GetString("log_format")
 		logLevel := viper.GetString("log_level")
+		metricsOn := viper.GetBool("metrics")
+		policyFile := viper.GetString("policy")
 		portAccess := viper.GetInt("port_access")
+		portMetrics := viper.GetInt("port_metrics")
 		portProfile := viper.GetInt("port_profile")
 		portRelay := viper.GetInt("port_relay")
 		profile := viper.GetBool("profile")
+		recordStr := viper.GetString("record")
+		recordDir := viper.GetString("record_dir")
 		secret := viper.GetString("secret")
+		slowConsumerStr := viper.GetString("slow_consumer")
+		stateDir := viper.GetString("state_dir")
 		statsEveryStr := viper.GetString("stats_every")
+		storePort := viper.GetInt("store_port")
+		storeSecret := viper.GetString("store_secret")
+		storeURL := viper.GetString("store_url")
 		tidyEveryStr := viper.GetString("tidy_every")
+		tlsCert := viper.GetString("tls_cert")
+		tlsClientCA := viper.GetString("tls_client_ca")
+		tlsKey := viper.GetString("tls_key")
+		trustedProxiesStr := viper.GetString("trusted_proxies")
 		URL := viper.GetString("url")
 
 		// Sanity checks
@@ -127,6 +281,36 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 			ok = false
 		}
 
+		if (storePort > 0 || storeURL != "") && storeSecret == "" {
+			fmt.Println("You must set RELAY_STORE_SECRET when setting RELAY_STORE_PORT or RELAY_STORE_URL")
+			ok = false
+		}
+
+		if recordStr != "" && recordDir == "" {
+			fmt.Println("You must set RELAY_RECORD_DIR when setting RELAY_RECORD")
+			ok = false
+		}
+
+		if storePort > 0 && storeURL != "" {
+			fmt.Println("You must not set both RELAY_STORE_PORT and RELAY_STORE_URL")
+			ok = false
+		}
+
+		if auditKeepDays < 0 {
+			fmt.Println("You must not set RELAY_AUDIT_KEEP_DAYS to less than 0")
+			ok = false
+		}
+
+		if (tlsCert == "") != (tlsKey == "") {
+			fmt.Println("You must set both RELAY_TLS_CERT and RELAY_TLS_KEY, or neither")
+			ok = false
+		}
+
+		if tlsClientCA != "" && tlsCert == "" {
+			fmt.Println("You must set RELAY_TLS_CERT and RELAY_TLS_KEY when setting RELAY_TLS_CLIENT_CA")
+			ok = false
+		}
+
 		if !ok {
 			os.Exit(1)
 		}
@@ -139,6 +323,20 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 			os.Exit(1)
 		}
 
+		drainWait, err := time.ParseDuration(drainWaitStr)
+
+		if err != nil {
+			fmt.Print("cannot parse duration in RELAY_DRAIN_WAIT=" + drainWaitStr)
+			os.Exit(1)
+		}
+
+		expiryWarning, err := time.ParseDuration(expiryWarningStr)
+
+		if err != nil {
+			fmt.Print("cannot parse duration in RELAY_EXPIRY_WARNING=" + expiryWarningStr)
+			os.Exit(1)
+		}
+
 		tidyEvery, err := time.ParseDuration(tidyEveryStr)
 
 		if err != nil {
@@ -146,6 +344,55 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 			os.Exit(1)
 		}
 
+		slowConsumer, err := crossbar.ParseSlowConsumerRules(slowConsumerStr)
+
+		if err != nil {
+			fmt.Print("cannot parse RELAY_SLOW_CONSUMER=" + slowConsumerStr + " because " + err.Error())
+			os.Exit(1)
+		}
+
+		proxies, err := forwarded.Parse(trustedProxiesStr)
+
+		if err != nil {
+			fmt.Print("cannot parse RELAY_TRUSTED_PROXIES=" + trustedProxiesStr + " because " + err.Error())
+			os.Exit(1)
+		}
+
+		clusterPeers := []string{}
+
+		for _, peer := range strings.Split(clusterPeersStr, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				clusterPeers = append(clusterPeers, peer)
+			}
+		}
+
+		limits := make(map[string]limit.Rate)
+
+		for name, s := range limitStr {
+			r, err := limit.ParseRate(s)
+			if err != nil {
+				fmt.Print("cannot parse " + name + "=" + s + " because " + err.Error())
+				os.Exit(1)
+			}
+			limits[name] = r
+		}
+
+		floorControl := []string{}
+
+		for _, pattern := range strings.Split(floorControlStr, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				floorControl = append(floorControl, pattern)
+			}
+		}
+
+		record := []string{}
+
+		for _, pattern := range strings.Split(recordStr, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				record = append(record, pattern)
+			}
+		}
+
 		// set up logging
 		switch strings.ToLower(logLevel) {
 		case "trace":
@@ -195,19 +442,66 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 		log.Infof("relay version: %s", versionString())
 		log.Infof("Allow no booking ID: [%t]", allowNoBookingID)
 		log.Infof("Audience: [%s]", audience)
+		log.Infof("Audit dir: [%s]", auditDir)
+		log.Infof("Audit keep days: [%d]", auditKeepDays)
 		log.Infof("Buffer Size: [%d]", bufferSize)
+		log.Infof("Cluster node: [%s]", clusterNode)
+		log.Infof("Cluster peers: [%s]", strings.Join(clusterPeers, ","))
+		log.Infof("Drain wait: [%s]", drainWait)
+		log.Infof("Expiry warning: [%s]", expiryWarning)
+		log.Infof("Floor control: [%s]", strings.Join(floorControl, ","))
+		log.Infof("Keys: [%s]", keysFile)
+		log.Infof("Limit bytes: [%s]", limits["RELAY_LIMIT_BYTES"])
+		log.Infof("Limit connect: [%s]", limits["RELAY_LIMIT_CONNECT"])
+		log.Infof("Limit messages: [%s]", limits["RELAY_LIMIT_MESSAGES"])
+		log.Infof("Limit session: [%s]", limits["RELAY_LIMIT_SESSION"])
 		log.Infof("Log file: [%s]", logFile)
 		log.Infof("Log format: [%s]", logFormat)
 		log.Infof("Log level: [%s]", logLevel)
+		log.Infof("Metrics is on: [%t]", metricsOn)
+		log.Infof("Policy: [%s]", policyFile)
 		log.Infof("Port for access: [%d]", portAccess)
+		log.Infof("Port for metrics: [%d]", portMetrics)
 		log.Infof("Port for profile: [%d]", portProfile)
 		log.Infof("Port for relay: [%d]", portRelay)
 		log.Infof("Profiling is on: [%t]", profile)
+		log.Infof("Record: [%s]", strings.Join(record, ","))
+		log.Infof("Record dir: [%s]", recordDir)
 		log.Debugf("Secret: [%s...%s]", secret[:4], secret[len(secret)-4:])
+		log.Infof("Slow consumer: [%s]", slowConsumerStr)
+		log.Infof("State dir: [%s]", stateDir)
 		log.Infof("Stats every: [%s]", statsEvery)
+		log.Infof("Store port: [%d]", storePort)
+		log.Infof("Store URL: [%s]", storeURL)
 		log.Infof("Tidy every: [%s]", tidyEvery)
+		log.Infof("TLS cert: [%s]", tlsCert)
+		log.Infof("TLS client CA: [%s]", tlsClientCA)
+		log.Infof("TLS key: [%s]", tlsKey)
+		log.Infof("Trusted proxies: [%s]", proxies)
 		log.Infof("URL: [%s]", URL)
 
+		// Listen on the ports that are handed over to a new process on SIGUSR2,
+		// or take them over from the process that started this one
+		ports := map[string]int{"access": portAccess, "relay": portRelay}
+
+		if metricsOn {
+			ports["metrics"] = portMetrics
+		}
+
+		if storePort > 0 {
+			ports["store"] = storePort
+		}
+
+		listeners := make(map[string]net.Listener)
+
+		for name, port := range ports {
+			l, err := handover.Listen(name, ":"+strconv.Itoa(port))
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "listener": name, "port": port}).Fatal("cannot listen")
+			}
+			listeners[name] = l
+		}
+
 		// Optionally start the profiling server
 		if profile {
 			go func() {
@@ -219,16 +513,142 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 			}()
 		}
 
+		// Optionally start the metrics server, on its own mux so that
+		// neither the profiler nor the relay are exposed on this port
+		if metricsOn {
+			go func() {
+				mux := http.NewServeMux()
+				mux.Handle("/metrics", metrics.Handler())
+				err := http.Serve(listeners["metrics"], mux)
+				// the listener is closed once handed over to a new process
+				if err != nil && !errors.Is(err, net.ErrClosed) {
+					log.Errorf(err.Error())
+				}
+			}()
+		}
+
 		var wg sync.WaitGroup
 
 		closed := make(chan struct{})
+		drain := make(chan struct{})
+
+		// Optionally terminate TLS, on listeners that wrap the ones that are handed over
+		accessListener := listeners["access"]
+		relayListener := listeners["relay"]
+
+		var reloader *cert.Reloader
+
+		if tlsCert != "" {
+
+			reloader, err = cert.New(tlsCert, tlsKey)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "cert": tlsCert, "key": tlsKey}).Fatal("cannot load certificate")
+			}
+
+			var cas *x509.CertPool
+
+			if tlsClientCA != "" {
+				cas, err = cert.LoadCAs(tlsClientCA)
+				if err != nil {
+					log.WithFields(log.Fields{"error": err.Error(), "ca": tlsClientCA}).Fatal("cannot load client CAs")
+				}
+			}
+
+			accessListener = tls.NewListener(accessListener, reloader.Config(cas))
+			relayListener = tls.NewListener(relayListener, reloader.Config(nil))
+
+			go reloader.Watch(closed, time.Minute)
+		}
+
+		// Optionally verify tokens with keys from a file, as well as the secret
+		keys := keyset.New(secret)
+
+		if keysFile != "" {
+
+			err = keys.Load(keysFile)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "file": keysFile}).Fatal("cannot load keys")
+			}
+
+			log.WithFields(log.Fields{"file": keysFile, "kids": keys.IDs()}).Info("keys loaded")
+
+			go keys.Watch(closed, 10*time.Second)
+		}
+
+		// Optionally set limits for session topics from a file
+		var policies *crossbar.Policies
+
+		if policyFile != "" {
+
+			policies = crossbar.NewPolicies()
+
+			err = policies.Load(policyFile)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "file": policyFile}).Fatal("cannot load policies")
+			}
+
+			log.WithFields(log.Fields{"file": policyFile, "policies": policies.Len()}).Info("policies loaded")
+		}
 
 		c := make(chan os.Signal, 1)
 
-		signal.Notify(c, os.Interrupt)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR2, syscall.SIGHUP)
 
 		go func() {
-			for range c {
+			for sig := range c {
+
+				if sig == syscall.SIGHUP {
+					if reloader != nil {
+						if err := reloader.Reload(); err != nil {
+							log.WithFields(log.Fields{"error": err.Error(), "cert": tlsCert, "key": tlsKey}).Error("certificate not reloaded")
+						} else {
+							log.WithFields(log.Fields{"cert": tlsCert, "key": tlsKey}).Info("certificate reloaded")
+						}
+					}
+					if keysFile != "" {
+						if err := keys.Reload(); err != nil {
+							log.WithFields(log.Fields{"error": err.Error(), "file": keysFile}).Error("keys not reloaded")
+						} else {
+							log.WithFields(log.Fields{"file": keysFile, "kids": keys.IDs()}).Info("keys reloaded")
+						}
+					}
+					if policies != nil {
+						if err := policies.Reload(); err != nil {
+							log.WithFields(log.Fields{"error": err.Error(), "file": policyFile}).Error("policies not reloaded")
+						} else {
+							log.WithFields(log.Fields{"file": policyFile, "policies": policies.Len()}).Info("policies reloaded")
+						}
+					}
+					continue
+				}
+
+				if sig == syscall.SIGUSR2 {
+					cmd, err := handover.Start(listeners)
+					if err != nil {
+						log.WithField("error", err.Error()).Error("new process not started, so carrying on")
+						continue
+					}
+					// carry on if the new process fails straight away, e.g. because of bad config
+					exited := make(chan error, 1)
+					go func() { exited <- cmd.Wait() }()
+					select {
+					case err := <-exited:
+						log.WithField("error", fmt.Sprint(err)).Error("new process exited, so carrying on")
+						continue
+					case <-time.After(time.Second):
+					}
+					// stop accepting before draining, so that the new process accepts every new connection,
+					// and let requests accepted just before then finish, rather than be refused by the drain
+					if err := handover.Release(listeners); err != nil {
+						log.WithField("error", err.Error()).Error("listeners not released")
+					}
+					log.WithField("pid", cmd.Process.Pid).Info("listeners handed over to new process")
+					time.Sleep(time.Second)
+				}
+
+				log.WithFields(log.Fields{"signal": sig.String(), "wait": drainWait.String()}).Info("draining")
+				close(drain)
+				time.Sleep(drainWait)
 				close(closed)
 				wg.Wait()
 				os.Exit(0)
@@ -238,18 +658,47 @@ RELAY_TIDY_EVERY is an optional tuning parameter that can safely be left at the
 		wg.Add(1)
 
 		config := relay.Config{
+			AccessListener:   accessListener,
 			AccessPort:       portAccess,
+			AdminClientCert:  tlsClientCA != "",
 			AllowNoBookingID: allowNoBookingID,
+			AuditDir:         auditDir,
+			AuditKeep:        time.Duration(auditKeepDays) * 24 * time.Hour,
 			Audience:         audience,
 			BufferSize:       bufferSize,
+			ClusterNode:      clusterNode,
+			ClusterPeers:     clusterPeers,
+			ClusterSecret:    clusterSecret,
+			Drain:            drain,
+			ExpiryWarning:    expiryWarning,
+			FloorControl:     floorControl,
+			Keys:             keys,
+			LimitBytes:       limits["RELAY_LIMIT_BYTES"],
+			LimitConnect:     limits["RELAY_LIMIT_CONNECT"],
+			LimitMessages:    limits["RELAY_LIMIT_MESSAGES"],
+			LimitSession:     limits["RELAY_LIMIT_SESSION"],
+			Policies:         policies,
+			Proxies:          proxies,
 			PruneEvery:       tidyEvery,
+			Record:           record,
+			RecordDir:        recordDir,
+			RelayListener:    relayListener,
 			RelayPort:        portRelay,
 			Secret:           secret,
+			SlowConsumer:     slowConsumer,
+			StateDir:         stateDir,
 			StatsEvery:       statsEvery,
+			StoreListener:    listeners["store"],
+			StorePort:        storePort,
+			StoreSecret:      storeSecret,
+			StoreURL:         storeURL,
 			Target:           URL,
 		}
 
-		go relay.Relay(closed, &wg, config) //accessPort, relayPort, audience, secret, target, allowNoBookingID)
+		err = relay.Relay(closed, &wg, config) //accessPort, relayPort, audience, secret, target, allowNoBookingID)
+		if err != nil {
+			log.WithField("error", err.Error()).Fatal("relay not started")
+		}
 
 		wg.Wait()
 
@@ -22,9 +22,8 @@ import (
 	"os"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/ory/viper"
-	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/pkg/token"
 	"github.com/spf13/cobra"
 )
 
@@ -47,6 +46,11 @@ export RELAY_TOKEN_SCOPE_WRITE=true
 export RELAY_TOKEN_SCOPE_OTHER=expt
 export RELAY_TOKEN_CONNECTION_TYPE=session
 The scopes read and write do NOT modify the permissions granted with relay:admin scope so can be omitted for admin tokens
+
+To sign with a key from the relay's RELAY_KEYS instead, set its kid, and either a PEM encoded
+RSA or Ed25519 private key (whose public key is in RELAY_KEYS), or its HMAC secret:
+export RELAY_TOKEN_KEY_ID=booking-2024
+export RELAY_TOKEN_KEY_FILE=/etc/booking/relay-key.pem
 `,
 
 	Run: func(cmd *cobra.Command, args []string) {
@@ -59,11 +63,15 @@ The scopes read and write do NOT modify the permissions granted with relay:admin
 		viper.SetDefault("scope_write", "true")
 		viper.SetDefault("scope_admin", "false")
 		viper.SetDefault("booking_id", "relay-token-cli")
+		viper.SetDefault("key_file", "")
+		viper.SetDefault("key_id", "")
 
 		bookingID := viper.GetString("booking_id")
 		lifetime := viper.GetInt64("lifetime")
 		audience := viper.GetString("audience")
 		secret := viper.GetString("secret")
+		keyFile := viper.GetString("key_file")
+		keyID := viper.GetString("key_id")
 		topic := viper.GetString("topic")
 		connectionType := viper.GetString("connection_type")
 		scope_other := viper.GetString("scope_other")
@@ -79,10 +87,14 @@ The scopes read and write do NOT modify the permissions granted with relay:admin
 			fmt.Println("RELAY_TOKEN_LIFETIME not set")
 			ok = false
 		}
-		if secret == "" {
+		if secret == "" && keyFile == "" {
 			fmt.Println("RELAY_TOKEN_SECRET not set")
 			ok = false
 		}
+		if keyFile != "" && keyID == "" {
+			fmt.Println("RELAY_TOKEN_KEY_ID must be set with RELAY_TOKEN_KEY_FILE")
+			ok = false
+		}
 		if topic == "" && !scope_admin {
 			fmt.Println("RELAY_TOKEN_TOPIC not set")
 			ok = false
@@ -120,6 +132,13 @@ The scopes read and write do NOT modify the permissions granted with relay:admin
 			ok = false
 		}
 
+		signer, err := newSigner(keyID, keyFile, secret)
+
+		if err != nil {
+			fmt.Println(err)
+			ok = false
+		}
+
 		if !ok {
 			os.Exit(1)
 		}
@@ -128,17 +147,13 @@ The scopes read and write do NOT modify the permissions granted with relay:admin
 		nbf := iat
 		exp := iat + lifetime
 
-		var claims permission.Token
-		claims.IssuedAt = jwt.NewNumericDate(time.Unix(iat, 0))
-		claims.NotBefore = jwt.NewNumericDate(time.Unix(nbf, 0))
-		claims.ExpiresAt = jwt.NewNumericDate(time.Unix(exp, 0))
-		claims.Audience = jwt.ClaimStrings{audience}
-		claims.BookingID = bookingID
-		claims.Topic = topic
-		claims.ConnectionType = connectionType // e.g. session
-		claims.Scopes = scopes
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		bearer, err := token.SignedString([]byte(secret))
+		var bearer string
+
+		if signer != nil {
+			bearer, err = signer.Sign(time.Unix(iat, 0), time.Unix(nbf, 0), time.Unix(exp, 0), scopes, audience, bookingID, connectionType, topic)
+		} else {
+			bearer, err = token.New(time.Unix(iat, 0), time.Unix(nbf, 0), time.Unix(exp, 0), scopes, audience, bookingID, connectionType, secret, topic)
+		}
 
 		if err != nil {
 			fmt.Println(err)
@@ -151,6 +166,24 @@ The scopes read and write do NOT modify the permissions granted with relay:admin
 	},
 }
 
+// newSigner returns a signer for the key with the kid in the relay's keyset, with a private
+// key from keyFile, or else the secret, or nil if there is no kid, to sign with the secret alone
+func newSigner(keyID, keyFile, secret string) (*token.Signer, error) {
+
+	if keyID == "" {
+		return nil, nil
+	}
+
+	if keyFile == "" {
+		s := token.NewHMACSigner(keyID, secret)
+		return &s, nil
+	}
+
+	s, err := token.LoadSigner(keyID, keyFile)
+
+	return &s, err
+}
+
 func init() {
 	rootCmd.AddCommand(tokenCmd)
 
@@ -23,11 +23,13 @@ require (
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/ory/viper v1.7.5
 	github.com/phayes/freeport v0.0.0-20220201140144-74d24b5ae9f5
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.4.0
 	github.com/spf13/viper v1.14.0
 	github.com/stretchr/testify v1.8.1
 	golang.org/x/net v0.0.0-20221014081412-f15817d10f9b
+	golang.org/x/time v0.0.0-20220609170525-579cf78fd858
 )
 
 require (
@@ -1,35 +1,57 @@
 package access
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-openapi/loads"
+	"github.com/go-openapi/runtime"
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/go-openapi/runtime/security"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/practable/relay/internal/access/models"
 	"github.com/practable/relay/internal/access/restapi"
 	"github.com/practable/relay/internal/access/restapi/operations"
+	"github.com/practable/relay/internal/audit"
+	"github.com/practable/relay/internal/cert"
 	"github.com/practable/relay/internal/crossbar"
 	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/metrics"
 	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/record"
 	"github.com/practable/relay/internal/ttlcode"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 // Config specifies parameters for the access service
 type Config struct {
+	AdminClientCert  bool // admin endpoints need a verified client certificate, as well as a token
 	AllowNoBookingID bool
-	CodeStore        *ttlcode.CodeStore
+	Audit            *audit.Log // logs deny and allow calls, and serves queries, if set
+	CodeStore        ttlcode.Backend
 	DenyChannel      chan string
-	DenyStore        *deny.Store
+	DenyStore        deny.Backend
+	Drain            <-chan struct{}
 	Host             string
 	Hub              *crossbar.Hub
+	Keys             *keyset.Keyset // verifies tokens; if nil, only tokens signed with Secret are accepted
+	LimitSession     limit.Rate
+	Listener         net.Listener
 	Port             int
+	Proxies          *forwarded.Proxies // trusted to set X-Forwarded-For in audit records; if nil, none are
+	Recordings       *record.Store
 	Secret           string
 	Target           string
 }
@@ -42,53 +64,128 @@ type Config struct {
 // @host - external FQDN of the host (for checking against tokens) e.g. https://relay-access.practable.io
 // @target - FQDN of the relay instance e.g. wss://relay.practable.io
 // @secret- HMAC shared secret which incoming tokens will be signed with
-// @cs - pointer to the CodeStore this API shares with the crossbar websocket relay
-// @ds - pointer to the Deny list
+// @cs - the CodeStore this API shares with the crossbar websocket relay
+// @ds - the Deny list
 // allowNoBookingID - whether to accept tokens without bookingID (set to yes to be backwards compatible)
+// @drain - when closed, access stops accepting new sessions, and stops serving
+// @listener - used instead of listening on port, if set
 // @options - for future backwards compatibility (no options currently available)
 func API(closed <-chan struct{}, wg *sync.WaitGroup, config Config) {
 
+	defer wg.Done()
+
+	handler, err := Handler(closed, config)
+	if err != nil {
+		log.WithField("error", err.Error()).Error("access API not started")
+		return
+	}
+
+	listener := config.Listener
+
+	if listener == nil {
+		listener, err = net.Listen("tcp", ":"+strconv.Itoa(config.Port))
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "port": config.Port}).Error("access API cannot listen")
+			return
+		}
+	}
+
+	h := &http.Server{Handler: handler}
+
+	go func() {
+		select {
+		case <-config.Drain:
+			log.Info("access draining")
+		case <-closed:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := h.Shutdown(ctx)
+		if err != nil {
+			log.Errorf("Server shudown error %s", err.Error())
+		}
+	}()
+
+	//serve API
+	// the listener is closed once handed over to a new process
+	if err := h.Serve(listener); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+		log.WithField("error", err.Error()).Error("access API stopped")
+	}
+}
+
+// Handler returns the handler for the access API, so that it can be served
+// by the caller, e.g. on its own mux. Replays stop when closed is closed.
+func Handler(closed <-chan struct{}, config Config) (http.Handler, error) {
+
 	swaggerSpec, err := loads.Analyzed(restapi.SwaggerJSON, "")
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	//create new service API
 	api := operations.NewAccessAPI(swaggerSpec)
-	server := restapi.NewServer(api)
 
-	//parse flags
-	flag.Parse()
+	// set the Authorizer
+	keys := config.Keys
 
-	// set the port this service will run on
-	server.Port = config.Port
+	if keys == nil {
+		keys = keyset.New(config.Secret)
+	}
 
-	// set the Authorizer
-	api.BearerAuth = validateHeader(config.Secret, config.Host)
+	api.BearerAuth = validateHeader(keys, config.Host)
 
 	// set the Handler
-	api.SessionHandler = operations.SessionHandlerFunc(sessionHandler(config))
+	api.SessionHandler = operations.SessionHandlerFunc(sessionHandler(config, limit.NewKeyed(config.LimitSession)))
 	api.AllowHandler = operations.AllowHandlerFunc(allowHandler(config))
 	api.DenyHandler = operations.DenyHandlerFunc(denyHandler(config))
+	api.DisconnectHandler = operations.DisconnectHandlerFunc(disconnectHandler(config))
 	api.GetStatusHandler = operations.GetStatusHandlerFunc(getStatusHandler(config))
 	api.ListDeniedHandler = operations.ListDeniedHandlerFunc(listDeniedHandler(config))
 	api.ListAllowedHandler = operations.ListAllowedHandlerFunc(listAllowedHandler(config))
+	api.DenyTopicHandler = operations.DenyTopicHandlerFunc(denyTopicHandler(config))
+	api.AllowTopicHandler = operations.AllowTopicHandlerFunc(allowTopicHandler(config))
+	api.ListDeniedTopicsHandler = operations.ListDeniedTopicsHandlerFunc(listDeniedTopicsHandler(config))
+	api.ListRecordingsHandler = operations.ListRecordingsHandlerFunc(listRecordingsHandler(config))
+	api.DownloadRecordingHandler = operations.DownloadRecordingHandlerFunc(downloadRecordingHandler(config))
+	api.ReplayRecordingHandler = operations.ReplayRecordingHandlerFunc(replayRecordingHandler(closed, config))
+	api.QueryAuditHandler = operations.QueryAuditHandlerFunc(queryAuditHandler(config))
+
+	if config.AdminClientCert {
+		return requireClientCert(api.Serve(nil)), nil
+	}
 
-	go func() {
-		<-closed
-		err := server.Shutdown()
-		if err != nil {
-			log.Errorf("Server shudown error %s", err.Error())
-		}
-	}()
+	return api.Serve(nil), nil
+}
 
-	//serve API
-	if err := server.Serve(); err != nil {
-		log.Fatalln(err)
-	}
+// adminPaths are the endpoints that need a client certificate, if AdminClientCert is set
+var adminPaths = []string{"/audit", "/bids/", "/connections/", "/recordings", "/status", "/topics/"}
+
+// requireClientCert refuses requests to the admin endpoints that have not presented
+// a verified client certificate, before their token is checked
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		for _, p := range adminPaths {
 
-	wg.Done()
+			if !strings.HasPrefix(r.URL.Path, p) || cert.HasClientCert(r.TLS) {
+				continue
+			}
+
+			log.WithFields(log.Fields{"path": r.URL.Path, "remote_addr": r.RemoteAddr}).Warn("admin request refused because it has no client certificate")
+
+			c := "403"
+			m := "client certificate required"
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			err := json.NewEncoder(w).Encode(models.Error{Code: &c, Message: &m})
+			if err != nil {
+				log.WithField("error", err.Error()).Error("client certificate error not written")
+			}
+			return
+		}
 
+		next.ServeHTTP(w, r)
+	})
 }
 
 func getStatusHandler(config Config) func(operations.GetStatusParams, interface{}) middleware.Responder {
@@ -122,31 +219,56 @@ func getStatusHandler(config Config) func(operations.GetStatusParams, interface{
 
 		reports := config.Hub.GetClientReports()
 
-		mreports := []*models.Report{}
-
-		for _, r := range reports {
+		return operations.NewGetStatusOK().WithPayload(statusReports(reports))
+	}
+}
 
-			rm := models.Report{
-				CanRead:     r.CanRead,
-				CanWrite:    r.CanWrite,
-				ConnectedAt: r.ConnectedAt,
-				ExpiresAt:   r.ExpiresAt,
-				RemoteAddr:  r.RemoteAddr,
-				Scopes:      r.Scopes,
-				Topic:       r.Topic,
-				UserAgent:   r.UserAgent,
-				//todo complete
-			}
-			mreports = append(mreports, &rm)
+// statusReports converts the crossbar's reports on its connections for the API
+func statusReports(reports []*crossbar.ClientReport) models.Status {
+
+	mreports := models.Status{}
+
+	for _, r := range reports {
+
+		rm := models.Report{
+			BookingID:   r.BookingID,
+			CanRead:     r.CanRead,
+			CanWrite:    r.CanWrite,
+			ConnectedAt: r.ConnectedAt,
+			Dropped: &models.Dropped{
+				Newest: r.Dropped.Newest,
+				Oldest: r.Dropped.Oldest,
+				Stale:  r.Dropped.Stale,
+			},
+			ExpiresAt:  r.ExpiresAt,
+			ID:         r.ID,
+			Node:       r.Node,
+			RemoteAddr: r.RemoteAddr,
+			Scopes:     r.Scopes,
+			Stats: &models.Stats{
+				Rx: &models.Details{
+					Fps:  float32(r.Stats.Rx.FPS),
+					Last: r.Stats.Rx.Last,
+					Size: float32(r.Stats.Rx.Size),
+				},
+				Tx: &models.Details{
+					Fps:  float32(r.Stats.Tx.FPS),
+					Last: r.Stats.Tx.Last,
+					Size: float32(r.Stats.Tx.Size),
+				},
+			},
+			Topic:     r.Topic,
+			UserAgent: r.UserAgent,
 		}
-
-		return operations.NewGetStatusOK().WithPayload(mreports)
+		mreports = append(mreports, &rm)
 	}
+
+	return mreports
 }
 
 // ValidateHeader checks the bearer token.
-// wrap the secret so we can get it at runtime without using global
-func validateHeader(secret, host string) security.TokenAuthentication {
+// wrap the keys so we can get them at runtime without using global
+func validateHeader(keys *keyset.Keyset, host string) security.TokenAuthentication {
 
 	return func(bearerToken string) (rt interface{}, re error) {
 
@@ -161,12 +283,7 @@ func validateHeader(secret, host string) security.TokenAuthentication {
 		// For apiKey security syntax see https://swagger.io/docs/specification/2-0/authentication/
 		claims := &permission.Token{}
 
-		token, err := jwt.ParseWithClaims(bearerToken, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method was %v", token.Header["alg"])
-			}
-			return []byte(secret), nil
-		})
+		token, err := jwt.ParseWithClaims(bearerToken, claims, keys.Keyfunc)
 
 		if err != nil {
 			msg := "error parsing token " + err.Error()
@@ -195,9 +312,12 @@ func validateHeader(secret, host string) security.TokenAuthentication {
 	}
 }
 
-func sessionHandler(config Config) func(operations.SessionParams, interface{}) middleware.Responder {
+func sessionHandler(config Config, sessions *limit.Keyed) func(operations.SessionParams, interface{}) middleware.Responder {
 	return func(params operations.SessionParams, principal interface{}) middleware.Responder {
 
+		timer := prometheus.NewTimer(metrics.SessionDuration)
+		defer timer.ObserveDuration()
+
 		token, ok := principal.(*jwt.Token)
 		if !ok {
 			return operations.NewSessionUnauthorized().WithPayload("Token Not JWT")
@@ -229,11 +349,35 @@ func sessionHandler(config Config) func(operations.SessionParams, interface{}) m
 			return operations.NewSessionBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
 
+		// limit by booking, so that one misbehaving client cannot flood the code store;
+		// tokens without a booking ID share a limit for their topic
+		key := claims.BookingID
+		if key == "" {
+			key = "topic:" + claims.Topic
+		}
+
+		if isClosed(config.Drain) {
+			log.WithFields(log.Fields{"topic": claims.Topic, "booking_id": claims.BookingID}).Info("session request refused because access is draining")
+			return middleware.Error(http.StatusServiceUnavailable, "relay is restarting, please try again")
+		}
+
+		if !sessions.Allow(key) {
+			log.WithFields(log.Fields{"topic": claims.Topic, "booking_id": claims.BookingID}).Warn("session request refused because it exceeded the rate limit")
+			metrics.RateLimited.WithLabelValues("session").Inc()
+			return middleware.Error(http.StatusTooManyRequests, "too many session requests")
+		}
+
 		if config.DenyStore.IsDenied(claims.BookingID) {
 			c := "400"
 			m := "bookingID has been deny-listed, probably because the session was cancelled"
 			return operations.NewSessionBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
+
+		if d, ok := crossbar.DeniedTopic(config.DenyStore, claims.Topic, claims.Scopes); ok {
+			c := "400"
+			m := "topic has been deny-listed: " + crossbar.TopicDeniedReason(d)
+			return operations.NewSessionBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
 		// track bookingIDs for which we have received connection requests
 		config.DenyStore.Allow(claims.BookingID, claims.ExpiresAt.Unix())
 
@@ -253,6 +397,11 @@ func sessionHandler(config Config) func(operations.SessionParams, interface{}) m
 
 		code := config.CodeStore.SubmitToken(pt)
 
+		if code == "" {
+			log.WithFields(log.Fields{"topic": claims.Topic, "booking_id": claims.BookingID}).Error("code store did not accept token")
+			return middleware.Error(http.StatusInternalServerError, "code store unavailable")
+		}
+
 		log.Trace(fmt.Sprintf("submitting token of type %T", pt))
 
 		uri := config.Target + "/" + claims.ConnectionType + "/" + claims.Topic + "?code=" + code
@@ -271,7 +420,7 @@ func denyHandler(config Config) func(operations.DenyParams, interface{}) middlew
 		// the deny listing has to be done by admin, typically a booking system
 		// else anyone could spam deny requests
 
-		_, err := isRelayAdmin(principal)
+		claims, err := isRelayAdmin(principal)
 
 		if err != nil {
 			c := "401"
@@ -285,17 +434,27 @@ func denyHandler(config Config) func(operations.DenyParams, interface{}) middlew
 			return operations.NewDenyBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
 
-		if params.Exp < config.DenyStore.Now() {
+		if params.Exp < config.DenyStore.GetTime() {
 			c := "400"
 			m := "booking expiry time (exp) of [" + strconv.Itoa(int(params.Exp)) + "] missing or in the past"
 			return operations.NewDenyBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
 
 		config.DenyStore.Deny(params.Bid, params.Exp)
+		metrics.DenyListSize.Set(float64(len(config.DenyStore.GetDenyList())))
 
 		config.CodeStore.DeleteByBookingID(params.Bid) //remove any tokens with the bookingID in them
 		config.DenyChannel <- params.Bid               // alert crossbar we need to cancel some connections
 
+		config.Audit.Add(audit.Event{
+			Event:      audit.Deny,
+			BookingID:  params.Bid,
+			ExpiresAt:  params.Exp,
+			Caller:     caller(principal, claims),
+			RemoteAddr: config.Proxies.RemoteAddr(params.HTTPRequest),
+			UserAgent:  params.HTTPRequest.UserAgent(),
+		})
+
 		return operations.NewDenyNoContent()
 	}
 }
@@ -310,7 +469,7 @@ func allowHandler(config Config) func(operations.AllowParams, interface{}) middl
 		// the allow listing has to be done by admin, typically a booking system
 		// else anyone could spam allow requests
 
-		_, err := isRelayAdmin(principal)
+		claims, err := isRelayAdmin(principal)
 
 		if err != nil {
 			c := "401"
@@ -324,18 +483,127 @@ func allowHandler(config Config) func(operations.AllowParams, interface{}) middl
 			return operations.NewAllowBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
 
-		if params.Exp < config.DenyStore.Now() {
+		if params.Exp < config.DenyStore.GetTime() {
 			c := "400"
 			m := "exp (booking expiry time) missing or in the past"
 			return operations.NewAllowBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
 		}
 
 		config.DenyStore.Allow(params.Bid, params.Exp)
+		metrics.DenyListSize.Set(float64(len(config.DenyStore.GetDenyList())))
+
+		config.Audit.Add(audit.Event{
+			Event:      audit.Allow,
+			BookingID:  params.Bid,
+			ExpiresAt:  params.Exp,
+			Caller:     caller(principal, claims),
+			RemoteAddr: config.Proxies.RemoteAddr(params.HTTPRequest),
+			UserAgent:  params.HTTPRequest.UserAgent(),
+		})
 
 		return operations.NewAllowNoContent()
 	}
 }
 
+// disconnectHandler closes individual connections, or all the connections on a topic, optionally
+// denying their bookings too, so that e.g. a stuck browser tab can be cleared without cancelling the session
+func disconnectHandler(config Config) func(operations.DisconnectParams, interface{}) middleware.Responder {
+	return func(params operations.DisconnectParams, principal interface{}) middleware.Responder {
+
+		claims, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "is token missing relay:admin scope? " + err.Error()
+			return operations.NewDisconnectUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		var id, topic, reason string
+
+		if params.ID != nil {
+			id = *params.ID
+		}
+		if params.Topic != nil {
+			topic = *params.Topic
+		}
+		if params.Reason != nil {
+			reason = *params.Reason
+		}
+
+		if id == "" && topic == "" {
+			c := "400"
+			m := "id or topic missing"
+			return operations.NewDisconnectBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if len(reason) > crossbar.MaxCloseReason {
+			c := "400"
+			m := "reason longer than " + strconv.Itoa(crossbar.MaxCloseReason) + " bytes"
+			return operations.NewDisconnectBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		reports := config.Hub.Disconnect(id, topic, reason)
+
+		config.Audit.Add(audit.Event{
+			Event:      audit.Close,
+			Topic:      topic,
+			Name:       id,
+			Reason:     reason,
+			Caller:     caller(principal, claims),
+			RemoteAddr: config.Proxies.RemoteAddr(params.HTTPRequest),
+			UserAgent:  params.HTTPRequest.UserAgent(),
+		})
+
+		if len(reports) == 0 {
+			c := "404"
+			m := "no connections match"
+			return operations.NewDisconnectNotFound().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if params.Deny != nil && *params.Deny {
+			denyBookings(config, reports, caller(principal, claims), params.HTTPRequest)
+		}
+
+		return operations.NewDisconnectOK().WithPayload(statusReports(reports))
+	}
+}
+
+// denyBookings denies the bookings of disconnected connections until their tokens
+// expire, as denyHandler does, so that the clients cannot reconnect
+func denyBookings(config Config, reports []*crossbar.ClientReport, by string, r *http.Request) {
+
+	denied := make(map[string]bool)
+
+	for _, report := range reports {
+
+		if report.BookingID == "" || denied[report.BookingID] {
+			continue
+		}
+
+		ea, err := time.Parse(time.RFC3339, report.ExpiresAt)
+		if err != nil || ea.Unix() < config.DenyStore.GetTime() {
+			continue // nothing to deny once the token has expired
+		}
+
+		denied[report.BookingID] = true
+
+		config.DenyStore.Deny(report.BookingID, ea.Unix())
+		config.CodeStore.DeleteByBookingID(report.BookingID)
+		config.DenyChannel <- report.BookingID
+
+		config.Audit.Add(audit.Event{
+			Event:      audit.Deny,
+			BookingID:  report.BookingID,
+			ExpiresAt:  ea.Unix(),
+			Caller:     by,
+			RemoteAddr: config.Proxies.RemoteAddr(r),
+			UserAgent:  r.UserAgent(),
+		})
+	}
+
+	metrics.DenyListSize.Set(float64(len(config.DenyStore.GetDenyList())))
+}
+
 func listDeniedHandler(config Config) func(operations.ListDeniedParams, interface{}) middleware.Responder {
 	return func(params operations.ListDeniedParams, principal interface{}) middleware.Responder {
 
@@ -378,6 +646,373 @@ func listAllowedHandler(config Config) func(operations.ListAllowedParams, interf
 	}
 }
 
+// denyTopicHandler takes a topic, or the topics matching a pattern, offline until exp, whoever has
+// booked them, e.g. when an experiment's hardware breaks. Tokens with exempt scopes are still let through.
+func denyTopicHandler(config Config) func(operations.DenyTopicParams, interface{}) middleware.Responder {
+	return func(params operations.DenyTopicParams, principal interface{}) middleware.Responder {
+
+		claims, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "is token missing relay:admin scope? " + err.Error()
+			return operations.NewDenyTopicUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if params.Topic == "" {
+			c := "400"
+			m := "topic missing"
+			return operations.NewDenyTopicBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if params.Exp < config.DenyStore.GetTime() {
+			c := "400"
+			m := "expiry time (exp) of [" + strconv.Itoa(int(params.Exp)) + "] missing or in the past"
+			return operations.NewDenyTopicBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		d := deny.Topic{
+			Pattern: params.Topic,
+			Exp:     params.Exp,
+			Exempt:  params.Exempt,
+		}
+
+		if params.Reason != nil {
+			d.Reason = *params.Reason
+		}
+
+		if len(d.Reason) > crossbar.MaxCloseReason {
+			c := "400"
+			m := "reason longer than " + strconv.Itoa(crossbar.MaxCloseReason) + " bytes"
+			return operations.NewDenyTopicBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		config.DenyStore.DenyTopic(d)
+
+		reports := config.Hub.Quarantine(d)
+
+		log.WithFields(log.Fields{"topic": d.Pattern, "exp": d.Exp, "exempt": d.Exempt, "closed": len(reports)}).Info("topic denied")
+
+		config.Audit.Add(audit.Event{
+			Event:      audit.Deny,
+			Topic:      d.Pattern,
+			ExpiresAt:  d.Exp,
+			Reason:     d.Reason,
+			Caller:     caller(principal, claims),
+			RemoteAddr: config.Proxies.RemoteAddr(params.HTTPRequest),
+			UserAgent:  params.HTTPRequest.UserAgent(),
+		})
+
+		return operations.NewDenyTopicNoContent()
+	}
+}
+
+// allowTopicHandler undoes a previous denyTopic operation (don't fail if there was no denial,
+// for the same reasons as allowHandler)
+func allowTopicHandler(config Config) func(operations.AllowTopicParams, interface{}) middleware.Responder {
+	return func(params operations.AllowTopicParams, principal interface{}) middleware.Responder {
+
+		claims, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewAllowTopicUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if params.Topic == "" {
+			c := "400"
+			m := "topic missing"
+			return operations.NewAllowTopicBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		config.DenyStore.AllowTopic(params.Topic)
+
+		log.WithFields(log.Fields{"topic": params.Topic}).Info("topic allowed")
+
+		config.Audit.Add(audit.Event{
+			Event:      audit.Allow,
+			Topic:      params.Topic,
+			Caller:     caller(principal, claims),
+			RemoteAddr: config.Proxies.RemoteAddr(params.HTTPRequest),
+			UserAgent:  params.HTTPRequest.UserAgent(),
+		})
+
+		return operations.NewAllowTopicNoContent()
+	}
+}
+
+func listDeniedTopicsHandler(config Config) func(operations.ListDeniedTopicsParams, interface{}) middleware.Responder {
+	return func(params operations.ListDeniedTopicsParams, principal interface{}) middleware.Responder {
+
+		_, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewListDeniedTopicsUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		topics, err := config.DenyStore.GetDeniedTopics()
+
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error()}).Error("denied topics could not be listed")
+			return middleware.Error(http.StatusServiceUnavailable, "denied topics could not be listed, please try again")
+		}
+
+		denied := models.DeniedTopics{}
+
+		for _, d := range topics {
+			denied = append(denied, &models.DeniedTopic{
+				Topic:  d.Pattern,
+				Exp:    d.Exp,
+				Exempt: d.Exempt,
+				Reason: d.Reason,
+			})
+		}
+
+		return operations.NewListDeniedTopicsOK().WithPayload(denied)
+	}
+}
+
+// isClosed reports whether a channel has been closed; a nil channel is never closed
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordingFilter returns a filter for the recordings selected by the query parameters
+func recordingFilter(topic, bid *string, from, to *int64) record.Filter {
+	var f record.Filter
+	if topic != nil {
+		f.Topic = *topic
+	}
+	if bid != nil {
+		f.BookingID = *bid
+	}
+	if from != nil {
+		f.From = time.Unix(*from, 0)
+	}
+	if to != nil {
+		f.To = time.Unix(*to, 0)
+	}
+	return f
+}
+
+func listRecordingsHandler(config Config) func(operations.ListRecordingsParams, interface{}) middleware.Responder {
+	return func(params operations.ListRecordingsParams, principal interface{}) middleware.Responder {
+
+		_, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewListRecordingsUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if config.Recordings == nil {
+			c := "400"
+			m := "recording is not enabled"
+			return operations.NewListRecordingsBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		f := recordingFilter(params.Topic, params.Bid, params.From, params.To)
+
+		if f.Topic != "" && !record.ValidTopic(f.Topic) {
+			c := "400"
+			m := "topic cannot be recorded"
+			return operations.NewListRecordingsBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		recordings, err := config.Recordings.List(f)
+
+		if err != nil {
+			log.WithField("error", err.Error()).Error("recordings not listed")
+			return middleware.Error(http.StatusInternalServerError, "recordings not listed")
+		}
+
+		mrecordings := models.Recordings{}
+
+		for _, r := range recordings {
+			mrecordings = append(mrecordings, &models.Recording{
+				BookingIds: r.BookingIDs,
+				End:        r.End.Format(time.RFC3339Nano),
+				Messages:   int64(r.Messages),
+				Name:       r.Name,
+				Size:       r.Size,
+				Start:      r.Start.Format(time.RFC3339Nano),
+				Topic:      r.Topic,
+			})
+		}
+
+		return operations.NewListRecordingsOK().WithPayload(mrecordings)
+	}
+}
+
+// downloadRecordingHandler streams the selected records as newline-delimited JSON,
+// in the same format as the recordings are stored
+func downloadRecordingHandler(config Config) func(operations.DownloadRecordingParams, interface{}) middleware.Responder {
+	return func(params operations.DownloadRecordingParams, principal interface{}) middleware.Responder {
+
+		_, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewDownloadRecordingUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if config.Recordings == nil {
+			c := "400"
+			m := "recording is not enabled"
+			return operations.NewDownloadRecordingBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if !record.ValidTopic(params.Topic) {
+			c := "400"
+			m := "topic cannot be recorded"
+			return operations.NewDownloadRecordingBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		f := recordingFilter(&params.Topic, params.Bid, params.From, params.To)
+
+		return middleware.ResponderFunc(func(rw http.ResponseWriter, _ runtime.Producer) {
+
+			rw.Header().Set("Content-Type", "application/x-ndjson")
+			rw.WriteHeader(http.StatusOK)
+
+			enc := json.NewEncoder(rw)
+
+			err := config.Recordings.Read(f, func(r record.Record) error {
+				return enc.Encode(r)
+			})
+
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "topic": f.Topic}).Error("recording download incomplete")
+			}
+		})
+	}
+}
+
+func queryAuditHandler(config Config) func(operations.QueryAuditParams, interface{}) middleware.Responder {
+	return func(params operations.QueryAuditParams, principal interface{}) middleware.Responder {
+
+		_, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewQueryAuditUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if config.Audit == nil {
+			c := "400"
+			m := "audit log is not enabled"
+			return operations.NewQueryAuditBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		rf := recordingFilter(params.Topic, params.Bid, params.From, params.To)
+		f := audit.Filter{Topic: rf.Topic, BookingID: rf.BookingID, From: rf.From, To: rf.To}
+
+		return middleware.ResponderFunc(func(rw http.ResponseWriter, _ runtime.Producer) {
+
+			rw.Header().Set("Content-Type", "application/x-ndjson")
+			rw.WriteHeader(http.StatusOK)
+
+			enc := json.NewEncoder(rw)
+
+			err := config.Audit.Read(f, func(e audit.Event) error {
+				return enc.Encode(e)
+			})
+
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "topic": f.Topic, "booking_id": f.BookingID}).Error("audit query incomplete")
+			}
+		})
+	}
+}
+
+// caller identifies who made an admin request, for the audit log, by the booking ID
+// of their token, and the kid of the key it was signed with, if any
+func caller(principal interface{}, claims *permission.Token) string {
+
+	c := claims.BookingID
+
+	if token, ok := principal.(*jwt.Token); ok {
+		if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+			c += " kid=" + kid
+		}
+	}
+
+	return c
+}
+
+func replayRecordingHandler(closed <-chan struct{}, config Config) func(operations.ReplayRecordingParams, interface{}) middleware.Responder {
+	return func(params operations.ReplayRecordingParams, principal interface{}) middleware.Responder {
+
+		_, err := isRelayAdmin(principal)
+
+		if err != nil {
+			c := "401"
+			m := "token missing relay:admin scope"
+			return operations.NewReplayRecordingUnauthorized().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if config.Recordings == nil {
+			c := "400"
+			m := "recording is not enabled"
+			return operations.NewReplayRecordingBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		into := params.Topic
+		if params.Into != nil && *params.Into != "" {
+			into = *params.Into
+		}
+
+		if !record.ValidTopic(params.Topic) {
+			c := "400"
+			m := "topic cannot be recorded"
+			return operations.NewReplayRecordingBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		if crossbar.IsPattern(into) {
+			c := "400"
+			m := "cannot replay into a topic pattern"
+			return operations.NewReplayRecordingBadRequest().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		f := recordingFilter(&params.Topic, params.Bid, params.From, params.To)
+
+		recordings, err := config.Recordings.List(f)
+
+		if err != nil {
+			log.WithField("error", err.Error()).Error("recordings not listed")
+			return middleware.Error(http.StatusInternalServerError, "recordings not listed")
+		}
+
+		if len(recordings) == 0 {
+			c := "404"
+			m := "no recorded messages match"
+			return operations.NewReplayRecordingNotFound().WithPayload(&models.Error{Code: &c, Message: &m})
+		}
+
+		go func() {
+			err := config.Hub.Replay(closed, config.Recordings, f, into)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "topic": f.Topic, "into": into}).Error("replay failed")
+			}
+		}()
+
+		log.WithFields(log.Fields{"topic": f.Topic, "into": into, "booking_id": f.BookingID}).Info("replay started")
+
+		return operations.NewReplayRecordingAccepted()
+	}
+}
+
 // Function isBookingAdmin does in-handler validation for booking:admin tasks
 func isRelayAdmin(principal interface{}) (*permission.Token, error) {
 
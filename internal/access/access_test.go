@@ -3,10 +3,14 @@ package access
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
@@ -16,10 +20,16 @@ import (
 	"github.com/phayes/freeport"
 	"github.com/practable/relay/internal/access/models"
 	"github.com/practable/relay/internal/access/restapi/operations"
+	"github.com/practable/relay/internal/audit"
 	"github.com/practable/relay/internal/crossbar"
 	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/limit"
 	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/record"
+	"github.com/practable/relay/internal/store"
 	"github.com/practable/relay/internal/ttlcode"
+	"github.com/practable/relay/pkg/token"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -602,3 +612,567 @@ func TestBadBearerHandledOK(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestSessionRateLimit(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret := "testsecret"
+
+	audience := "http://[::]:" + strconv.Itoa(port)
+
+	wg.Add(1)
+
+	config := Config{
+		AllowNoBookingID: true,
+		CodeStore:        ttlcode.NewDefaultCodeStore(),
+		DenyChannel:      make(chan string, 2),
+		DenyStore:        deny.New(),
+		Host:             audience,
+		LimitSession:     limit.Rate{Count: 2, Per: time.Minute},
+		Port:             port,
+		Secret:           secret,
+		Target:           "wss://relay.example.io",
+	}
+
+	go API(closed, &wg, config)
+
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{}
+
+	bearer := func(bid string) string {
+		var claims permission.Token
+		start := jwt.NewNumericDate(time.Now().Add(-time.Second))
+		claims.IssuedAt = start
+		claims.NotBefore = start
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(5 * time.Second))
+		claims.Audience = jwt.ClaimStrings{audience}
+		claims.BookingID = bid
+		claims.Topic = "123"
+		claims.ConnectionType = "session"
+		claims.Scopes = []string{"read", "write"}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		s, err := token.SignedString([]byte(secret))
+		assert.NoError(t, err)
+		return s
+	}
+
+	status := func(bearer string) int {
+		req, err := http.NewRequest("POST", audience+"/session/123", nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// each booking gets its own allowance
+	assert.Equal(t, http.StatusOK, status(bearer("bid-a")))
+	assert.Equal(t, http.StatusOK, status(bearer("bid-a")))
+	assert.Equal(t, http.StatusTooManyRequests, status(bearer("bid-a")))
+	assert.Equal(t, http.StatusOK, status(bearer("bid-b")))
+
+	// tokens without a booking ID share an allowance for the topic
+	assert.Equal(t, http.StatusOK, status(bearer("")))
+	assert.Equal(t, http.StatusOK, status(bearer("")))
+	assert.Equal(t, http.StatusTooManyRequests, status(bearer("")))
+
+	close(closed)
+	wg.Wait()
+
+}
+
+func TestSessionStoreUnreachable(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	secret := "testsecret"
+
+	audience := "http://[::]:" + strconv.Itoa(port)
+
+	// the deny lists are shared via a store, which then stops
+	ds := deny.New()
+	ds.DenyTopic(deny.Topic{Pattern: "123", Exp: ds.GetTime() + 60})
+
+	s := httptest.NewServer(store.Handler(ttlcode.NewDefaultCodeStore(), ds, "storesecret"))
+	defer s.Close()
+
+	wg.Add(1)
+
+	config := Config{
+		AllowNoBookingID: true,
+		CodeStore:        ttlcode.NewDefaultCodeStore(),
+		DenyChannel:      make(chan string, 2),
+		DenyStore:        store.NewClient(s.URL, "storesecret"),
+		Host:             audience,
+		Port:             port,
+		Secret:           secret,
+		Target:           "wss://relay.example.io",
+	}
+
+	go API(closed, &wg, config)
+
+	time.Sleep(100 * time.Millisecond)
+
+	var claims permission.Token
+	start := jwt.NewNumericDate(time.Now().Add(-time.Second))
+	claims.IssuedAt = start
+	claims.NotBefore = start
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(5 * time.Second))
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.BookingID = "bid-a"
+	claims.Topic = "123"
+	claims.ConnectionType = "session"
+	claims.Scopes = []string{"read", "write"}
+	bearer, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	assert.NoError(t, err)
+
+	request := func() *http.Response {
+		req, err := http.NewRequest("POST", audience+"/session/123", nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := request()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "topic has been deny-listed")
+
+	// the topic is still refused when the store cannot be reached
+	s.Close()
+
+	resp = request()
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	close(closed)
+	wg.Wait()
+}
+
+func TestRecordings(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret := "testsecret"
+
+	audience := "http://[::]:" + strconv.Itoa(port)
+
+	// record some messages before starting
+	rs := record.New(t.TempDir(), 16)
+	t0 := time.Now().Add(-time.Minute).Truncate(time.Second)
+	rs.Add(record.NewRecord(t0, record.Received, "b0", "pend00-data", false, []byte("a")))
+	rs.Add(record.NewRecord(t0.Add(time.Second), record.Received, "", "pend00-data", false, []byte("b")))
+	rs.Add(record.NewRecord(t0.Add(2*time.Second), record.Received, "b1", "pend01-data", false, []byte("c")))
+	rsClosed := make(chan struct{})
+	rsDone := make(chan struct{})
+	go func() {
+		rs.Run(rsClosed)
+		close(rsDone)
+	}()
+	close(rsClosed)
+	<-rsDone
+
+	wg.Add(1)
+
+	config := Config{
+		AllowNoBookingID: true,
+		CodeStore:        ttlcode.NewDefaultCodeStore(),
+		DenyChannel:      make(chan string, 2),
+		DenyStore:        deny.New(),
+		Host:             audience,
+		Hub:              crossbar.New(),
+		Port:             port,
+		Recordings:       rs,
+		Secret:           secret,
+		Target:           "wss://relay.example.io",
+	}
+
+	go API(closed, &wg, config)
+
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{}
+
+	bearer := func(scopes []string) string {
+		var claims permission.Token
+		start := jwt.NewNumericDate(time.Now().Add(-time.Second))
+		claims.Audience = jwt.ClaimStrings{audience}
+		claims.IssuedAt = start
+		claims.NotBefore = start
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(5 * time.Second))
+		claims.Scopes = scopes
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		s, err := token.SignedString([]byte(secret))
+		assert.NoError(t, err)
+		return s
+	}
+
+	admin := bearer([]string{"relay:admin"})
+
+	do := func(method, path string, query map[string]string, bearer string) (int, []byte) {
+		req, err := http.NewRequest(method, audience+path, nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		q := req.URL.Query()
+		for k, v := range query {
+			q.Add(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp.StatusCode, body
+	}
+
+	// *** TestRecordingsNeedAdmin
+	code, _ := do("GET", "/recordings", nil, bearer([]string{"relay:stats"}))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// *** TestListRecordings
+	code, body := do("GET", "/recordings", nil, admin)
+	assert.Equal(t, http.StatusOK, code)
+	var list models.Recordings
+	assert.NoError(t, json.Unmarshal(body, &list))
+	assert.Equal(t, 2, len(list))
+
+	code, body = do("GET", "/recordings", map[string]string{"bid": "b0"}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &list))
+	if assert.Equal(t, 1, len(list)) {
+		assert.Equal(t, "pend00-data", list[0].Topic)
+		assert.Equal(t, []string{"b0"}, list[0].BookingIds)
+		assert.Equal(t, int64(2), list[0].Messages)
+	}
+
+	code, body = do("GET", "/recordings", map[string]string{"from": strconv.FormatInt(t0.Add(time.Hour).Unix(), 10)}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &list))
+	assert.Equal(t, 0, len(list))
+
+	// *** TestDownloadRecording
+	code, body = do("GET", "/recordings/download", map[string]string{"topic": "pend00-data", "from": strconv.FormatInt(t0.Add(time.Second).Unix(), 10)}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"t":"`+t0.Add(time.Second).UTC().Format(time.RFC3339Nano)+`","dir":"rx","topic":"pend00-data","type":"text","text":"b"}`+"\n", string(body))
+
+	code, _ = do("GET", "/recordings/download", nil, admin)
+	assert.Equal(t, http.StatusUnprocessableEntity, code) // topic is required
+
+	// topics naming the recording directory, or its parent, cannot be read
+	for _, topic := range []string{"..", "."} {
+		code, _ = do("GET", "/recordings/download", map[string]string{"topic": topic}, admin)
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = do("GET", "/recordings", map[string]string{"topic": topic}, admin)
+		assert.Equal(t, http.StatusBadRequest, code)
+		code, _ = do("POST", "/recordings/replay", map[string]string{"topic": topic}, admin)
+		assert.Equal(t, http.StatusBadRequest, code)
+	}
+
+	// *** TestReplayRecording
+	code, _ = do("POST", "/recordings/replay", map[string]string{"topic": "pend00-data", "bid": "nobody"}, admin)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do("POST", "/recordings/replay", map[string]string{"topic": "pend00-data", "into": "pend*"}, admin)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do("POST", "/recordings/replay", map[string]string{"topic": "pend00-data", "into": "replay00"}, admin)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	close(closed)
+	wg.Wait()
+
+}
+
+func TestAudit(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret := "testsecret"
+
+	audience := "http://[::]:" + strconv.Itoa(port)
+
+	al := audit.New(t.TempDir(), 16, 0)
+	alClosed := make(chan struct{})
+	alDone := make(chan struct{})
+	go func() {
+		al.Run(alClosed)
+		close(alDone)
+	}()
+	defer func() {
+		close(alClosed)
+		<-alDone
+	}()
+
+	wg.Add(1)
+
+	config := Config{
+		Audit:       al,
+		CodeStore:   ttlcode.NewDefaultCodeStore(),
+		DenyChannel: make(chan string, 2),
+		DenyStore:   deny.New(),
+		Host:        audience,
+		Hub:         crossbar.New(),
+		Port:        port,
+		Secret:      secret,
+		Target:      "wss://relay.example.io",
+	}
+
+	go API(closed, &wg, config)
+
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{}
+
+	now := time.Now()
+	admin, err := token.New(now.Add(-time.Second), now.Add(-time.Second), now.Add(5*time.Second), []string{"relay:admin"}, audience, "booking-system", "", secret, "")
+	assert.NoError(t, err)
+
+	do := func(method, path string, query map[string]string, bearer string) (int, []byte) {
+		req, err := http.NewRequest(method, audience+path, nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		req.Header.Add("User-Agent", "booking/1.0")
+		req.Header.Add("X-Forwarded-For", "203.0.113.9") // not from a trusted proxy
+		q := req.URL.Query()
+		for k, v := range query {
+			q.Add(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp.StatusCode, body
+	}
+
+	exp := strconv.FormatInt(now.Unix()+60, 10)
+
+	code, _ := do("POST", "/bids/deny", map[string]string{"bid": "b0", "exp": exp}, admin)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do("POST", "/bids/deny", map[string]string{"bid": "b1", "exp": exp}, admin)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do("POST", "/bids/allow", map[string]string{"bid": "b0", "exp": exp}, admin)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	// give the log time to write the events
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestAuditNeedsAdmin
+	user, err := token.New(now.Add(-time.Second), now.Add(-time.Second), now.Add(5*time.Second), []string{"read"}, audience, "b0", "session", secret, "expt00")
+	assert.NoError(t, err)
+	code, _ = do("GET", "/audit", nil, user)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// *** TestQueryAudit
+	code, body := do("GET", "/audit", map[string]string{"bid": "b0"}, admin)
+	assert.Equal(t, http.StatusOK, code)
+
+	var events []audit.Event
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var e audit.Event
+		assert.NoError(t, dec.Decode(&e))
+		events = append(events, e)
+	}
+
+	if assert.Equal(t, 2, len(events)) {
+		assert.Equal(t, audit.Deny, events[0].Event)
+		assert.Equal(t, audit.Allow, events[1].Event)
+		assert.Equal(t, "b0", events[0].BookingID)
+		assert.Equal(t, "booking-system", events[0].Caller)
+		assert.Equal(t, "booking/1.0", events[0].UserAgent)
+		assert.Equal(t, now.Unix()+60, events[0].ExpiresAt)
+		assert.NotEqual(t, "", events[0].RemoteAddr)
+		assert.NotEqual(t, "203.0.113.9", events[0].RemoteAddr, "remoteAddr must not be forged")
+	}
+
+	code, body = do("GET", "/audit", map[string]string{"from": strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(body))
+
+	close(closed)
+	wg.Wait()
+
+}
+
+func TestDrain(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	drain := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	audience := "http://[::]:" + strconv.Itoa(port)
+
+	wg.Add(1)
+
+	config := Config{
+		AllowNoBookingID: true,
+		CodeStore:        ttlcode.NewDefaultCodeStore(),
+		DenyChannel:      make(chan string, 2),
+		DenyStore:        deny.New(),
+		Drain:            drain,
+		Host:             audience,
+		Port:             port,
+		Secret:           "testsecret",
+		Target:           "wss://relay.example.io",
+	}
+
+	go API(closed, &wg, config)
+
+	time.Sleep(100 * time.Millisecond)
+
+	get := func() error {
+		resp, err := http.Get(audience + "/bids/deny")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get())
+
+	// access stops serving when it drains, so that no new sessions are started
+	close(drain)
+	wg.Wait()
+
+	assert.Error(t, get())
+
+	close(closed)
+}
+
+func TestIsClosed(t *testing.T) {
+	assert.False(t, isClosed(nil))
+	c := make(chan struct{})
+	assert.False(t, isClosed(c))
+	close(c)
+	assert.True(t, isClosed(c))
+}
+
+func TestRequireClientCert(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	h := requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+
+	tests := []struct {
+		path   string
+		tls    *tls.ConnectionState
+		status int
+	}{
+		{"/bids/deny", nil, http.StatusForbidden},
+		{"/bids/allow", &tls.ConnectionState{}, http.StatusForbidden},
+		{"/recordings/download", nil, http.StatusForbidden},
+		{"/status", nil, http.StatusForbidden},
+		{"/status", verified, http.StatusOK},
+		{"/bids/deny", verified, http.StatusOK},
+		{"/session/abc", nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.TLS = test.tls
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, test.status, w.Code, test.path)
+
+		if test.status == http.StatusForbidden {
+			var e models.Error
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+			assert.Equal(t, "client certificate required", *e.Message)
+		}
+	}
+}
+
+func TestValidateHeaderWithKeys(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	host := "https://relay-access.example.io"
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"keys":[{"kty":"oct","kid":"booking0","k":"Ym9va2luZ3NlY3JldA"}]}`), 0600))
+
+	keys := keyset.New("somesecret")
+	assert.NoError(t, keys.Load(file))
+
+	validate := validateHeader(keys, host)
+
+	now := time.Now()
+
+	legacy, err := token.New(now, now, now.Add(time.Minute), []string{"read"}, host, "bid0", "session", "somesecret", "expt00")
+	assert.NoError(t, err)
+	_, err = validate(legacy)
+	assert.NoError(t, err)
+
+	rotated, err := token.NewHMACSigner("booking0", "bookingsecret").Sign(now, now, now.Add(time.Minute), []string{"read"}, host, "bid0", "session", "expt00")
+	assert.NoError(t, err)
+	_, err = validate(rotated)
+	assert.NoError(t, err)
+
+	wrong, err := token.NewHMACSigner("booking0", "somesecret").Sign(now, now, now.Add(time.Minute), []string{"read"}, host, "bid0", "session", "expt00")
+	assert.NoError(t, err)
+	_, err = validate(wrong)
+	assert.Error(t, err)
+}
@@ -8,6 +8,7 @@ package models
 import (
 	"context"
 
+	"github.com/go-openapi/errors"
 	"github.com/go-openapi/strfmt"
 	"github.com/go-openapi/swag"
 )
@@ -17,6 +18,9 @@ import (
 // swagger:model Report
 type Report struct {
 
+	// booking id
+	BookingID string `json:"booking_id,omitempty"`
+
 	// can read
 	CanRead bool `json:"can_read,omitempty"`
 
@@ -26,15 +30,27 @@ type Report struct {
 	// connected at
 	ConnectedAt string `json:"connected_at,omitempty"`
 
+	// dropped
+	Dropped *Dropped `json:"dropped,omitempty"`
+
 	// expires at
 	ExpiresAt string `json:"expires_at,omitempty"`
 
+	// identifies the connection, e.g. to disconnect it
+	ID string `json:"id,omitempty"`
+
+	// node the connection is on, if clustered
+	Node string `json:"node,omitempty"`
+
 	// remote addr
 	RemoteAddr string `json:"remote_addr,omitempty"`
 
 	// scopes
 	Scopes []string `json:"scopes"`
 
+	// stats
+	Stats *Stats `json:"stats,omitempty"`
+
 	// topic
 	Topic string `json:"topic,omitempty"`
 
@@ -44,11 +60,107 @@ type Report struct {
 
 // Validate validates this report
 func (m *Report) Validate(formats strfmt.Registry) error {
+	var res []error
+
+	if err := m.validateDropped(formats); err != nil {
+		res = append(res, err)
+	}
+
+	if err := m.validateStats(formats); err != nil {
+		res = append(res, err)
+	}
+
+	if len(res) > 0 {
+		return errors.CompositeValidationError(res...)
+	}
 	return nil
 }
 
-// ContextValidate validates this report based on context it is used
+func (m *Report) validateDropped(formats strfmt.Registry) error {
+	if swag.IsZero(m.Dropped) { // not required
+		return nil
+	}
+
+	if m.Dropped != nil {
+		if err := m.Dropped.Validate(formats); err != nil {
+			if ve, ok := err.(*errors.Validation); ok {
+				return ve.ValidateName("dropped")
+			} else if ce, ok := err.(*errors.CompositeError); ok {
+				return ce.ValidateName("dropped")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Report) validateStats(formats strfmt.Registry) error {
+	if swag.IsZero(m.Stats) { // not required
+		return nil
+	}
+
+	if m.Stats != nil {
+		if err := m.Stats.Validate(formats); err != nil {
+			if ve, ok := err.(*errors.Validation); ok {
+				return ve.ValidateName("stats")
+			} else if ce, ok := err.(*errors.CompositeError); ok {
+				return ce.ValidateName("stats")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ContextValidate validate this report based on the context it is used
 func (m *Report) ContextValidate(ctx context.Context, formats strfmt.Registry) error {
+	var res []error
+
+	if err := m.contextValidateDropped(ctx, formats); err != nil {
+		res = append(res, err)
+	}
+
+	if err := m.contextValidateStats(ctx, formats); err != nil {
+		res = append(res, err)
+	}
+
+	if len(res) > 0 {
+		return errors.CompositeValidationError(res...)
+	}
+	return nil
+}
+
+func (m *Report) contextValidateDropped(ctx context.Context, formats strfmt.Registry) error {
+
+	if m.Dropped != nil {
+		if err := m.Dropped.ContextValidate(ctx, formats); err != nil {
+			if ve, ok := err.(*errors.Validation); ok {
+				return ve.ValidateName("dropped")
+			} else if ce, ok := err.(*errors.CompositeError); ok {
+				return ce.ValidateName("dropped")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Report) contextValidateStats(ctx context.Context, formats strfmt.Registry) error {
+
+	if m.Stats != nil {
+		if err := m.Stats.ContextValidate(ctx, formats); err != nil {
+			if ve, ok := err.(*errors.Validation); ok {
+				return ve.ValidateName("stats")
+			} else if ce, ok := err.(*errors.CompositeError); ok {
+				return ce.ValidateName("stats")
+			}
+			return err
+		}
+	}
+
 	return nil
 }
 
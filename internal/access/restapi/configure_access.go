@@ -55,11 +55,31 @@ func configureAPI(api *operations.AccessAPI) http.Handler {
 			return middleware.NotImplemented("operation operations.Allow has not yet been implemented")
 		})
 	}
+	if api.AllowTopicHandler == nil {
+		api.AllowTopicHandler = operations.AllowTopicHandlerFunc(func(params operations.AllowTopicParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.AllowTopic has not yet been implemented")
+		})
+	}
 	if api.DenyHandler == nil {
 		api.DenyHandler = operations.DenyHandlerFunc(func(params operations.DenyParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation operations.Deny has not yet been implemented")
 		})
 	}
+	if api.DenyTopicHandler == nil {
+		api.DenyTopicHandler = operations.DenyTopicHandlerFunc(func(params operations.DenyTopicParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.DenyTopic has not yet been implemented")
+		})
+	}
+	if api.DisconnectHandler == nil {
+		api.DisconnectHandler = operations.DisconnectHandlerFunc(func(params operations.DisconnectParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.Disconnect has not yet been implemented")
+		})
+	}
+	if api.DownloadRecordingHandler == nil {
+		api.DownloadRecordingHandler = operations.DownloadRecordingHandlerFunc(func(params operations.DownloadRecordingParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.DownloadRecording has not yet been implemented")
+		})
+	}
 	if api.GetStatusHandler == nil {
 		api.GetStatusHandler = operations.GetStatusHandlerFunc(func(params operations.GetStatusParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation operations.GetStatus has not yet been implemented")
@@ -75,6 +95,26 @@ func configureAPI(api *operations.AccessAPI) http.Handler {
 			return middleware.NotImplemented("operation operations.ListDenied has not yet been implemented")
 		})
 	}
+	if api.ListDeniedTopicsHandler == nil {
+		api.ListDeniedTopicsHandler = operations.ListDeniedTopicsHandlerFunc(func(params operations.ListDeniedTopicsParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.ListDeniedTopics has not yet been implemented")
+		})
+	}
+	if api.ListRecordingsHandler == nil {
+		api.ListRecordingsHandler = operations.ListRecordingsHandlerFunc(func(params operations.ListRecordingsParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.ListRecordings has not yet been implemented")
+		})
+	}
+	if api.QueryAuditHandler == nil {
+		api.QueryAuditHandler = operations.QueryAuditHandlerFunc(func(params operations.QueryAuditParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.QueryAudit has not yet been implemented")
+		})
+	}
+	if api.ReplayRecordingHandler == nil {
+		api.ReplayRecordingHandler = operations.ReplayRecordingHandlerFunc(func(params operations.ReplayRecordingParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation operations.ReplayRecording has not yet been implemented")
+		})
+	}
 	if api.SessionHandler == nil {
 		api.SessionHandler = operations.SessionHandlerFunc(func(params operations.SessionParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation operations.Session has not yet been implemented")
@@ -15,6 +15,7 @@
 //
 //	Produces:
 //	  - application/json
+//	  - application/x-ndjson
 //
 // swagger:meta
 package restapi
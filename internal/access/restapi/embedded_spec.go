@@ -41,6 +41,63 @@ func init() {
   "host": "localhost",
   "basePath": "/",
   "paths": {
+    "/audit": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Query the audit log of connections, and of deny and allow calls, optionally only the events for a bid (booking id) or topic, or between from and to (unix times in seconds), as newline-delimited JSON with one event per line. Needs a relay:admin token.",
+        "produces": [
+          "application/x-ndjson"
+        ],
+        "summary": "Query the audit log",
+        "operationId": "queryAudit",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Audit events, one per line",
+            "schema": {
+              "type": "file"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
     "/bids/allow": {
       "get": {
         "security": [
@@ -187,43 +244,46 @@ func init() {
         }
       }
     },
-    "/session/{session_id}": {
+    "/connections/disconnect": {
       "post": {
         "security": [
           {
             "Bearer": []
           }
         ],
-        "description": "access the specified session",
+        "description": "Close the connection with the id from the status report, or every connection on a topic, or both, on this node, with an optional reason. If deny is true, the booking ids of the closed connections are also denied until their tokens expire, as if they had been posted to /bids/deny. Needs a relay:admin token.",
         "produces": [
           "application/json"
         ],
-        "summary": "session",
-        "operationId": "session",
+        "summary": "Disconnect connections",
+        "operationId": "disconnect",
         "parameters": [
           {
             "type": "string",
-            "description": "Session identification code",
-            "name": "session_id",
-            "in": "path",
-            "required": true
+            "name": "id",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "boolean",
+            "name": "deny",
+            "in": "query"
           }
         ],
         "responses": {
           "200": {
-            "description": "",
+            "description": "The connections that were closed",
             "schema": {
-              "type": "object",
-              "properties": {
-                "uri": {
-                  "type": "string"
-                }
-              }
-            },
-            "examples": {
-              "application/json": {
-                "code": "b142eb22-1f16-4af1-ba14-e70a7afcbcc2"
-              }
+              "$ref": "#/definitions/Status"
             }
           },
           "400": {
@@ -234,163 +294,65 @@ func init() {
           },
           "401": {
             "description": "Unauthorized",
-            "schema": {}
-          }
-        }
-      }
-    },
-    "/status": {
-      "get": {
-        "security": [
-          {
-            "Bearer": []
-          }
-        ],
-        "description": "Get a list of all current connections",
-        "produces": [
-          "application/json"
-        ],
-        "summary": "Get a list of all current connections",
-        "operationId": "getStatus",
-        "responses": {
-          "200": {
-            "description": "List of current connections",
             "schema": {
-              "$ref": "#/definitions/Status"
+              "$ref": "#/definitions/Error"
             }
           },
-          "401": {
-            "description": "Unauthorized",
+          "404": {
+            "description": "No connections match the query",
             "schema": {
               "$ref": "#/definitions/Error"
             }
           }
         }
       }
-    }
-  },
-  "definitions": {
-    "BookingIDs": {
-      "type": "object",
-      "title": "Set of booking IDs (bids)",
-      "required": [
-        "booking_ids"
-      ],
-      "properties": {
-        "booking_ids": {
-          "description": "list bids in string format",
-          "type": "array",
-          "items": {
-            "type": "string"
-          }
-        }
-      }
-    },
-    "Error": {
-      "type": "object",
-      "required": [
-        "code",
-        "message"
-      ],
-      "properties": {
-        "code": {
-          "type": "string"
-        },
-        "message": {
-          "type": "string"
-        }
-      }
-    },
-    "Report": {
-      "type": "object",
-      "properties": {
-        "can_read": {
-          "type": "boolean"
-        },
-        "can_write": {
-          "type": "boolean"
-        },
-        "connected_at": {
-          "type": "string"
-        },
-        "expires_at": {
-          "type": "string"
-        },
-        "remote_addr": {
-          "type": "string"
-        },
-        "scopes": {
-          "type": "array",
-          "items": {
-            "type": "string"
-          }
-        },
-        "topic": {
-          "type": "string"
-        },
-        "user_agent": {
-          "type": "string"
-        }
-      }
-    },
-    "Status": {
-      "type": "array",
-      "title": "status reports",
-      "items": {
-        "$ref": "#/definitions/Report"
-      }
-    }
-  },
-  "securityDefinitions": {
-    "Bearer": {
-      "type": "apiKey",
-      "name": "Authorization",
-      "in": "header"
-    }
-  }
-}`))
-	FlatSwaggerJSON = json.RawMessage([]byte(`{
-  "consumes": [
-    "application/json"
-  ],
-  "produces": [
-    "application/json"
-  ],
-  "schemes": [
-    "http"
-  ],
-  "swagger": "2.0",
-  "info": {
-    "description": "API for accessing github.com/practable/relay websocket relay. Note scheme http and host localhost due to running behind proxy",
-    "title": "RelayAccess",
-    "contact": {
-      "name": "Timothy Drysdale",
-      "url": "https://github.com/timdrysdale",
-      "email": "timothy.d.drysdale@gmail.com"
     },
-    "version": "0.4"
-  },
-  "host": "localhost",
-  "basePath": "/",
-  "paths": {
-    "/bids/allow": {
+    "/recordings": {
       "get": {
         "security": [
           {
             "Bearer": []
           }
         ],
-        "description": "Get a list of all currently-allowed bids (booking ids) with an ongoing or recent live connection",
+        "description": "List the recordings of session topics, optionally only those of one topic, with messages from a bid (booking id), or with messages between from and to (unix times in seconds). Needs a relay:admin token.",
         "produces": [
           "application/json"
         ],
-        "summary": "Get a list of all currently-allowed bids",
-        "operationId": "listAllowed",
+        "summary": "List recordings",
+        "operationId": "listRecordings",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          }
+        ],
         "responses": {
           "200": {
-            "description": "Current or recently in-use allowed bids",
+            "description": "Recordings with messages matching the query",
             "schema": {
-              "$ref": "#/definitions/BookingIDs"
+              "$ref": "#/definitions/Recordings"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
             }
           },
           "401": {
@@ -400,36 +362,691 @@ func init() {
             }
           }
         }
-      },
-      "post": {
+      }
+    },
+    "/recordings/download": {
+      "get": {
         "security": [
           {
             "Bearer": []
           }
         ],
-        "description": "Undo the denial of a booking id",
-        "consumes": [
-          "application/json"
+        "description": "Download the messages recorded on a topic, optionally only those from a bid (booking id), or between from and to (unix times in seconds), as newline-delimited JSON with one record per line. Needs a relay:admin token.",
+        "produces": [
+          "application/x-ndjson"
         ],
-        "summary": "Undo the denial of a booking id",
-        "operationId": "allow",
+        "summary": "Download recorded messages",
+        "operationId": "downloadRecording",
         "parameters": [
           {
             "type": "string",
-            "name": "bid",
+            "name": "topic",
             "in": "query",
             "required": true
           },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
           {
             "type": "integer",
-            "name": "exp",
-            "in": "query",
-            "required": true
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
           }
         ],
         "responses": {
-          "204": {
-            "description": "The bid was allowed successfully."
+          "200": {
+            "description": "Recorded messages, one per line",
+            "schema": {
+              "type": "file"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/recordings/replay": {
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Publish the messages recorded on a topic, optionally only those from a bid (booking id), or between from and to (unix times in seconds), into the into topic (default the recorded topic) at their original timing. The replay runs in the background. Needs a relay:admin token.",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Replay recorded messages into a topic",
+        "operationId": "replayRecording",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "into",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "202": {
+            "description": "The replay has started."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "404": {
+            "description": "No recorded messages match the query",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/session/{session_id}": {
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "access the specified session",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "session",
+        "operationId": "session",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "Session identification code",
+            "name": "session_id",
+            "in": "path",
+            "required": true
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "uri": {
+                  "type": "string"
+                }
+              }
+            },
+            "examples": {
+              "application/json": {
+                "code": "b142eb22-1f16-4af1-ba14-e70a7afcbcc2"
+              }
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {}
+          }
+        }
+      }
+    },
+    "/status": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Get a list of all current connections",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Get a list of all current connections",
+        "operationId": "getStatus",
+        "responses": {
+          "200": {
+            "description": "List of current connections",
+            "schema": {
+              "$ref": "#/definitions/Status"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/topics/allow": {
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Undo the denial of a topic, or topic pattern, so that connections to it are no longer refused. Needs a relay:admin token.",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Undo the denial of a topic",
+        "operationId": "allowTopic",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The topic was allowed successfully."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/topics/deny": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Get a list of all currently-denied topics and topic patterns, with their expiry, exempt scopes and reason",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Get a list of all currently-denied topics",
+        "operationId": "listDeniedTopics",
+        "responses": {
+          "200": {
+            "description": "List of current denied topics",
+            "schema": {
+              "$ref": "#/definitions/DeniedTopics"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      },
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Refuse connections to the topic, or to topics matching it if it is a pattern e.g. pend00/**, whoever has booked them, and disconnect any current connections immediately, until exp (unix time in seconds). Tokens with any of the exempt scopes (comma separated, e.g. relay:admin,host) are still let through. The reason is given to clients that are refused or disconnected. Needs a relay:admin token.",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Refuse connections to a topic, and disconnect any current connections immediately",
+        "operationId": "denyTopic",
+        "parameters": [
+          {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "name": "exempt",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "exp",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "string",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The topic was denied successfully."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    }
+  },
+  "definitions": {
+    "BookingIDs": {
+      "type": "object",
+      "title": "Set of booking IDs (bids)",
+      "required": [
+        "booking_ids"
+      ],
+      "properties": {
+        "booking_ids": {
+          "description": "list bids in string format",
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        }
+      }
+    },
+    "DeniedTopic": {
+      "type": "object",
+      "title": "a topic, or topic pattern, that connections are refused to",
+      "properties": {
+        "exempt": {
+          "description": "scopes of tokens that are still let through e.g. relay:admin, host",
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "exp": {
+          "description": "unix time in seconds when the denial expires",
+          "type": "integer"
+        },
+        "reason": {
+          "description": "reason given to clients that are refused or disconnected",
+          "type": "string"
+        },
+        "topic": {
+          "description": "topic or topic pattern",
+          "type": "string"
+        }
+      }
+    },
+    "DeniedTopics": {
+      "type": "array",
+      "items": {
+        "$ref": "#/definitions/DeniedTopic"
+      }
+    },
+    "Details": {
+      "description": "Connection details",
+      "type": "object",
+      "properties": {
+        "fps": {
+          "type": "number",
+          "format": "float"
+        },
+        "last": {
+          "type": "string"
+        },
+        "size": {
+          "type": "number",
+          "format": "float"
+        }
+      }
+    },
+    "Dropped": {
+      "description": "messages a connection has missed because it could not keep up",
+      "type": "object",
+      "properties": {
+        "newest": {
+          "type": "integer"
+        },
+        "oldest": {
+          "type": "integer"
+        },
+        "stale": {
+          "type": "integer"
+        }
+      }
+    },
+    "Error": {
+      "type": "object",
+      "required": [
+        "code",
+        "message"
+      ],
+      "properties": {
+        "code": {
+          "type": "string"
+        },
+        "message": {
+          "type": "string"
+        }
+      }
+    },
+    "Recording": {
+      "description": "a file of messages recorded on a topic",
+      "type": "object",
+      "properties": {
+        "booking_ids": {
+          "description": "bids (booking ids) of the senders of the messages",
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "end": {
+          "description": "time of the last message",
+          "type": "string"
+        },
+        "messages": {
+          "type": "integer"
+        },
+        "name": {
+          "type": "string"
+        },
+        "size": {
+          "description": "size of the file in bytes",
+          "type": "integer"
+        },
+        "start": {
+          "description": "time of the first message",
+          "type": "string"
+        },
+        "topic": {
+          "type": "string"
+        }
+      }
+    },
+    "Recordings": {
+      "type": "array",
+      "title": "recordings",
+      "items": {
+        "$ref": "#/definitions/Recording"
+      }
+    },
+    "Report": {
+      "type": "object",
+      "properties": {
+        "booking_id": {
+          "type": "string"
+        },
+        "can_read": {
+          "type": "boolean"
+        },
+        "can_write": {
+          "type": "boolean"
+        },
+        "connected_at": {
+          "type": "string"
+        },
+        "dropped": {
+          "$ref": "#/definitions/Dropped"
+        },
+        "expires_at": {
+          "type": "string"
+        },
+        "id": {
+          "description": "identifies the connection, e.g. to disconnect it",
+          "type": "string"
+        },
+        "node": {
+          "description": "node the connection is on, if clustered",
+          "type": "string"
+        },
+        "remote_addr": {
+          "type": "string"
+        },
+        "scopes": {
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "stats": {
+          "$ref": "#/definitions/Stats"
+        },
+        "topic": {
+          "type": "string"
+        },
+        "user_agent": {
+          "type": "string"
+        }
+      }
+    },
+    "Stats": {
+      "description": "connection statistics",
+      "type": "object",
+      "properties": {
+        "rx": {
+          "$ref": "#/definitions/Details"
+        },
+        "tx": {
+          "$ref": "#/definitions/Details"
+        }
+      }
+    },
+    "Status": {
+      "type": "array",
+      "title": "status reports",
+      "items": {
+        "$ref": "#/definitions/Report"
+      }
+    }
+  },
+  "securityDefinitions": {
+    "Bearer": {
+      "type": "apiKey",
+      "name": "Authorization",
+      "in": "header"
+    }
+  }
+}`))
+	FlatSwaggerJSON = json.RawMessage([]byte(`{
+  "consumes": [
+    "application/json"
+  ],
+  "produces": [
+    "application/json"
+  ],
+  "schemes": [
+    "http"
+  ],
+  "swagger": "2.0",
+  "info": {
+    "description": "API for accessing github.com/practable/relay websocket relay. Note scheme http and host localhost due to running behind proxy",
+    "title": "RelayAccess",
+    "contact": {
+      "name": "Timothy Drysdale",
+      "url": "https://github.com/timdrysdale",
+      "email": "timothy.d.drysdale@gmail.com"
+    },
+    "version": "0.4"
+  },
+  "host": "localhost",
+  "basePath": "/",
+  "paths": {
+    "/audit": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Query the audit log of connections, and of deny and allow calls, optionally only the events for a bid (booking id) or topic, or between from and to (unix times in seconds), as newline-delimited JSON with one event per line. Needs a relay:admin token.",
+        "produces": [
+          "application/x-ndjson"
+        ],
+        "summary": "Query the audit log",
+        "operationId": "queryAudit",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Audit events, one per line",
+            "schema": {
+              "type": "file"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/bids/allow": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Get a list of all currently-allowed bids (booking ids) with an ongoing or recent live connection",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Get a list of all currently-allowed bids",
+        "operationId": "listAllowed",
+        "responses": {
+          "200": {
+            "description": "Current or recently in-use allowed bids",
+            "schema": {
+              "$ref": "#/definitions/BookingIDs"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      },
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Undo the denial of a booking id",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Undo the denial of a booking id",
+        "operationId": "allow",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "integer",
+            "name": "exp",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The bid was allowed successfully."
           },
           "400": {
             "description": "BadRequest",
@@ -453,17 +1070,239 @@ func init() {
             "Bearer": []
           }
         ],
-        "description": "Get a list of all currently-denied bids",
+        "description": "Get a list of all currently-denied bids",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Get a list of all currently-denied bids",
+        "operationId": "listDenied",
+        "responses": {
+          "200": {
+            "description": "List of current denied bids",
+            "schema": {
+              "$ref": "#/definitions/BookingIDs"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      },
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Refuse sessions to new connections using tokens with the bid (booking id), and disconnect any current sessions immediately. The exp term is the unix time in UTC when the booking finishes (i.e. the earliest time it is safe to remove the bid from the deny list)",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Refuse sessions to new connections using tokens with the bid(s) (booking ids), and disconnect any current sessions immediately.",
+        "operationId": "deny",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "integer",
+            "name": "exp",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The bid was denied successfully."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/connections/disconnect": {
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Close the connection with the id from the status report, or every connection on a topic, or both, on this node, with an optional reason. If deny is true, the booking ids of the closed connections are also denied until their tokens expire, as if they had been posted to /bids/deny. Needs a relay:admin token.",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Disconnect connections",
+        "operationId": "disconnect",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "id",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "boolean",
+            "name": "deny",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The connections that were closed",
+            "schema": {
+              "$ref": "#/definitions/Status"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "404": {
+            "description": "No connections match the query",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/recordings": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "List the recordings of session topics, optionally only those of one topic, with messages from a bid (booking id), or with messages between from and to (unix times in seconds). Needs a relay:admin token.",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "List recordings",
+        "operationId": "listRecordings",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Recordings with messages matching the query",
+            "schema": {
+              "$ref": "#/definitions/Recordings"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/recordings/download": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Download the messages recorded on a topic, optionally only those from a bid (booking id), or between from and to (unix times in seconds), as newline-delimited JSON with one record per line. Needs a relay:admin token.",
         "produces": [
-          "application/json"
+          "application/x-ndjson"
+        ],
+        "summary": "Download recorded messages",
+        "operationId": "downloadRecording",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          }
         ],
-        "summary": "Get a list of all currently-denied bids",
-        "operationId": "listDenied",
         "responses": {
           "200": {
-            "description": "List of current denied bids",
+            "description": "Recorded messages, one per line",
             "schema": {
-              "$ref": "#/definitions/BookingIDs"
+              "type": "file"
+            }
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
             }
           },
           "401": {
@@ -473,36 +1312,52 @@ func init() {
             }
           }
         }
-      },
+      }
+    },
+    "/recordings/replay": {
       "post": {
         "security": [
           {
             "Bearer": []
           }
         ],
-        "description": "Refuse sessions to new connections using tokens with the bid (booking id), and disconnect any current sessions immediately. The exp term is the unix time in UTC when the booking finishes (i.e. the earliest time it is safe to remove the bid from the deny list)",
-        "consumes": [
+        "description": "Publish the messages recorded on a topic, optionally only those from a bid (booking id), or between from and to (unix times in seconds), into the into topic (default the recorded topic) at their original timing. The replay runs in the background. Needs a relay:admin token.",
+        "produces": [
           "application/json"
         ],
-        "summary": "Refuse sessions to new connections using tokens with the bid(s) (booking ids), and disconnect any current sessions immediately.",
-        "operationId": "deny",
+        "summary": "Replay recorded messages into a topic",
+        "operationId": "replayRecording",
         "parameters": [
           {
             "type": "string",
-            "name": "bid",
+            "name": "topic",
             "in": "query",
             "required": true
           },
+          {
+            "type": "string",
+            "name": "bid",
+            "in": "query"
+          },
           {
             "type": "integer",
-            "name": "exp",
-            "in": "query",
-            "required": true
+            "name": "from",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "to",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "into",
+            "in": "query"
           }
         ],
         "responses": {
-          "204": {
-            "description": "The bid was denied successfully."
+          "202": {
+            "description": "The replay has started."
           },
           "400": {
             "description": "BadRequest",
@@ -515,6 +1370,12 @@ func init() {
             "schema": {
               "$ref": "#/definitions/Error"
             }
+          },
+          "404": {
+            "description": "No recorded messages match the query",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
           }
         }
       }
@@ -599,6 +1460,132 @@ func init() {
           }
         }
       }
+    },
+    "/topics/allow": {
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Undo the denial of a topic, or topic pattern, so that connections to it are no longer refused. Needs a relay:admin token.",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Undo the denial of a topic",
+        "operationId": "allowTopic",
+        "parameters": [
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The topic was allowed successfully."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
+    "/topics/deny": {
+      "get": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Get a list of all currently-denied topics and topic patterns, with their expiry, exempt scopes and reason",
+        "produces": [
+          "application/json"
+        ],
+        "summary": "Get a list of all currently-denied topics",
+        "operationId": "listDeniedTopics",
+        "responses": {
+          "200": {
+            "description": "List of current denied topics",
+            "schema": {
+              "$ref": "#/definitions/DeniedTopics"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      },
+      "post": {
+        "security": [
+          {
+            "Bearer": []
+          }
+        ],
+        "description": "Refuse connections to the topic, or to topics matching it if it is a pattern e.g. pend00/**, whoever has booked them, and disconnect any current connections immediately, until exp (unix time in seconds). Tokens with any of the exempt scopes (comma separated, e.g. relay:admin,host) are still let through. The reason is given to clients that are refused or disconnected. Needs a relay:admin token.",
+        "consumes": [
+          "application/json"
+        ],
+        "summary": "Refuse connections to a topic, and disconnect any current connections immediately",
+        "operationId": "denyTopic",
+        "parameters": [
+          {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "name": "exempt",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "name": "exp",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "string",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "name": "topic",
+            "in": "query",
+            "required": true
+          }
+        ],
+        "responses": {
+          "204": {
+            "description": "The topic was denied successfully."
+          },
+          "400": {
+            "description": "BadRequest",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "401": {
+            "description": "Unauthorized",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
     }
   },
   "definitions": {
@@ -618,6 +1605,69 @@ func init() {
         }
       }
     },
+    "DeniedTopic": {
+      "type": "object",
+      "title": "a topic, or topic pattern, that connections are refused to",
+      "properties": {
+        "exempt": {
+          "description": "scopes of tokens that are still let through e.g. relay:admin, host",
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "exp": {
+          "description": "unix time in seconds when the denial expires",
+          "type": "integer"
+        },
+        "reason": {
+          "description": "reason given to clients that are refused or disconnected",
+          "type": "string"
+        },
+        "topic": {
+          "description": "topic or topic pattern",
+          "type": "string"
+        }
+      }
+    },
+    "DeniedTopics": {
+      "type": "array",
+      "items": {
+        "$ref": "#/definitions/DeniedTopic"
+      }
+    },
+    "Details": {
+      "description": "Connection details",
+      "type": "object",
+      "properties": {
+        "fps": {
+          "type": "number",
+          "format": "float"
+        },
+        "last": {
+          "type": "string"
+        },
+        "size": {
+          "type": "number",
+          "format": "float"
+        }
+      }
+    },
+    "Dropped": {
+      "description": "messages a connection has missed because it could not keep up",
+      "type": "object",
+      "properties": {
+        "newest": {
+          "type": "integer"
+        },
+        "oldest": {
+          "type": "integer"
+        },
+        "stale": {
+          "type": "integer"
+        }
+      }
+    },
     "Error": {
       "type": "object",
       "required": [
@@ -633,9 +1683,53 @@ func init() {
         }
       }
     },
+    "Recording": {
+      "description": "a file of messages recorded on a topic",
+      "type": "object",
+      "properties": {
+        "booking_ids": {
+          "description": "bids (booking ids) of the senders of the messages",
+          "type": "array",
+          "items": {
+            "type": "string"
+          }
+        },
+        "end": {
+          "description": "time of the last message",
+          "type": "string"
+        },
+        "messages": {
+          "type": "integer"
+        },
+        "name": {
+          "type": "string"
+        },
+        "size": {
+          "description": "size of the file in bytes",
+          "type": "integer"
+        },
+        "start": {
+          "description": "time of the first message",
+          "type": "string"
+        },
+        "topic": {
+          "type": "string"
+        }
+      }
+    },
+    "Recordings": {
+      "type": "array",
+      "title": "recordings",
+      "items": {
+        "$ref": "#/definitions/Recording"
+      }
+    },
     "Report": {
       "type": "object",
       "properties": {
+        "booking_id": {
+          "type": "string"
+        },
         "can_read": {
           "type": "boolean"
         },
@@ -645,9 +1739,20 @@ func init() {
         "connected_at": {
           "type": "string"
         },
+        "dropped": {
+          "$ref": "#/definitions/Dropped"
+        },
         "expires_at": {
           "type": "string"
         },
+        "id": {
+          "description": "identifies the connection, e.g. to disconnect it",
+          "type": "string"
+        },
+        "node": {
+          "description": "node the connection is on, if clustered",
+          "type": "string"
+        },
         "remote_addr": {
           "type": "string"
         },
@@ -657,6 +1762,9 @@ func init() {
             "type": "string"
           }
         },
+        "stats": {
+          "$ref": "#/definitions/Stats"
+        },
         "topic": {
           "type": "string"
         },
@@ -665,6 +1773,18 @@ func init() {
         }
       }
     },
+    "Stats": {
+      "description": "connection statistics",
+      "type": "object",
+      "properties": {
+        "rx": {
+          "$ref": "#/definitions/Details"
+        },
+        "tx": {
+          "$ref": "#/definitions/Details"
+        }
+      }
+    },
     "Status": {
       "type": "array",
       "title": "status reports",
@@ -45,9 +45,21 @@ func NewAccessAPI(spec *loads.Document) *AccessAPI {
 		AllowHandler: AllowHandlerFunc(func(params AllowParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation Allow has not yet been implemented")
 		}),
+		AllowTopicHandler: AllowTopicHandlerFunc(func(params AllowTopicParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation AllowTopic has not yet been implemented")
+		}),
 		DenyHandler: DenyHandlerFunc(func(params DenyParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation Deny has not yet been implemented")
 		}),
+		DenyTopicHandler: DenyTopicHandlerFunc(func(params DenyTopicParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation DenyTopic has not yet been implemented")
+		}),
+		DisconnectHandler: DisconnectHandlerFunc(func(params DisconnectParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation Disconnect has not yet been implemented")
+		}),
+		DownloadRecordingHandler: DownloadRecordingHandlerFunc(func(params DownloadRecordingParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation DownloadRecording has not yet been implemented")
+		}),
 		GetStatusHandler: GetStatusHandlerFunc(func(params GetStatusParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation GetStatus has not yet been implemented")
 		}),
@@ -57,6 +69,18 @@ func NewAccessAPI(spec *loads.Document) *AccessAPI {
 		ListDeniedHandler: ListDeniedHandlerFunc(func(params ListDeniedParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation ListDenied has not yet been implemented")
 		}),
+		ListDeniedTopicsHandler: ListDeniedTopicsHandlerFunc(func(params ListDeniedTopicsParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation ListDeniedTopics has not yet been implemented")
+		}),
+		ListRecordingsHandler: ListRecordingsHandlerFunc(func(params ListRecordingsParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation ListRecordings has not yet been implemented")
+		}),
+		QueryAuditHandler: QueryAuditHandlerFunc(func(params QueryAuditParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation QueryAudit has not yet been implemented")
+		}),
+		ReplayRecordingHandler: ReplayRecordingHandlerFunc(func(params ReplayRecordingParams, principal interface{}) middleware.Responder {
+			return middleware.NotImplemented("operation ReplayRecording has not yet been implemented")
+		}),
 		SessionHandler: SessionHandlerFunc(func(params SessionParams, principal interface{}) middleware.Responder {
 			return middleware.NotImplemented("operation Session has not yet been implemented")
 		}),
@@ -101,6 +125,7 @@ type AccessAPI struct {
 
 	// JSONProducer registers a producer for the following mime types:
 	//   - application/json
+	//   - application/x-ndjson
 	JSONProducer runtime.Producer
 
 	// BearerAuth registers a function that takes a token and returns a principal
@@ -112,14 +137,30 @@ type AccessAPI struct {
 
 	// AllowHandler sets the operation handler for the allow operation
 	AllowHandler AllowHandler
+	// AllowTopicHandler sets the operation handler for the allow topic operation
+	AllowTopicHandler AllowTopicHandler
 	// DenyHandler sets the operation handler for the deny operation
 	DenyHandler DenyHandler
+	// DenyTopicHandler sets the operation handler for the deny topic operation
+	DenyTopicHandler DenyTopicHandler
+	// DisconnectHandler sets the operation handler for the disconnect operation
+	DisconnectHandler DisconnectHandler
+	// DownloadRecordingHandler sets the operation handler for the download recording operation
+	DownloadRecordingHandler DownloadRecordingHandler
 	// GetStatusHandler sets the operation handler for the get status operation
 	GetStatusHandler GetStatusHandler
 	// ListAllowedHandler sets the operation handler for the list allowed operation
 	ListAllowedHandler ListAllowedHandler
 	// ListDeniedHandler sets the operation handler for the list denied operation
 	ListDeniedHandler ListDeniedHandler
+	// ListDeniedTopicsHandler sets the operation handler for the list denied topics operation
+	ListDeniedTopicsHandler ListDeniedTopicsHandler
+	// ListRecordingsHandler sets the operation handler for the list recordings operation
+	ListRecordingsHandler ListRecordingsHandler
+	// QueryAuditHandler sets the operation handler for the query audit operation
+	QueryAuditHandler QueryAuditHandler
+	// ReplayRecordingHandler sets the operation handler for the replay recording operation
+	ReplayRecordingHandler ReplayRecordingHandler
 	// SessionHandler sets the operation handler for the session operation
 	SessionHandler SessionHandler
 
@@ -206,9 +247,21 @@ func (o *AccessAPI) Validate() error {
 	if o.AllowHandler == nil {
 		unregistered = append(unregistered, "AllowHandler")
 	}
+	if o.AllowTopicHandler == nil {
+		unregistered = append(unregistered, "AllowTopicHandler")
+	}
 	if o.DenyHandler == nil {
 		unregistered = append(unregistered, "DenyHandler")
 	}
+	if o.DenyTopicHandler == nil {
+		unregistered = append(unregistered, "DenyTopicHandler")
+	}
+	if o.DisconnectHandler == nil {
+		unregistered = append(unregistered, "DisconnectHandler")
+	}
+	if o.DownloadRecordingHandler == nil {
+		unregistered = append(unregistered, "DownloadRecordingHandler")
+	}
 	if o.GetStatusHandler == nil {
 		unregistered = append(unregistered, "GetStatusHandler")
 	}
@@ -218,6 +271,18 @@ func (o *AccessAPI) Validate() error {
 	if o.ListDeniedHandler == nil {
 		unregistered = append(unregistered, "ListDeniedHandler")
 	}
+	if o.ListDeniedTopicsHandler == nil {
+		unregistered = append(unregistered, "ListDeniedTopicsHandler")
+	}
+	if o.ListRecordingsHandler == nil {
+		unregistered = append(unregistered, "ListRecordingsHandler")
+	}
+	if o.QueryAuditHandler == nil {
+		unregistered = append(unregistered, "QueryAuditHandler")
+	}
+	if o.ReplayRecordingHandler == nil {
+		unregistered = append(unregistered, "ReplayRecordingHandler")
+	}
 	if o.SessionHandler == nil {
 		unregistered = append(unregistered, "SessionHandler")
 	}
@@ -278,6 +343,8 @@ func (o *AccessAPI) ProducersFor(mediaTypes []string) map[string]runtime.Produce
 		switch mt {
 		case "application/json":
 			result["application/json"] = o.JSONProducer
+		case "application/x-ndjson":
+			result["application/x-ndjson"] = o.JSONProducer
 		}
 
 		if p, ok := o.customProducers[mt]; ok {
@@ -325,7 +392,23 @@ func (o *AccessAPI) initHandlerCache() {
 	if o.handlers["POST"] == nil {
 		o.handlers["POST"] = make(map[string]http.Handler)
 	}
+	o.handlers["POST"]["/topics/allow"] = NewAllowTopic(o.context, o.AllowTopicHandler)
+	if o.handlers["POST"] == nil {
+		o.handlers["POST"] = make(map[string]http.Handler)
+	}
 	o.handlers["POST"]["/bids/deny"] = NewDeny(o.context, o.DenyHandler)
+	if o.handlers["POST"] == nil {
+		o.handlers["POST"] = make(map[string]http.Handler)
+	}
+	o.handlers["POST"]["/topics/deny"] = NewDenyTopic(o.context, o.DenyTopicHandler)
+	if o.handlers["POST"] == nil {
+		o.handlers["POST"] = make(map[string]http.Handler)
+	}
+	o.handlers["POST"]["/connections/disconnect"] = NewDisconnect(o.context, o.DisconnectHandler)
+	if o.handlers["GET"] == nil {
+		o.handlers["GET"] = make(map[string]http.Handler)
+	}
+	o.handlers["GET"]["/recordings/download"] = NewDownloadRecording(o.context, o.DownloadRecordingHandler)
 	if o.handlers["GET"] == nil {
 		o.handlers["GET"] = make(map[string]http.Handler)
 	}
@@ -338,6 +421,22 @@ func (o *AccessAPI) initHandlerCache() {
 		o.handlers["GET"] = make(map[string]http.Handler)
 	}
 	o.handlers["GET"]["/bids/deny"] = NewListDenied(o.context, o.ListDeniedHandler)
+	if o.handlers["GET"] == nil {
+		o.handlers["GET"] = make(map[string]http.Handler)
+	}
+	o.handlers["GET"]["/topics/deny"] = NewListDeniedTopics(o.context, o.ListDeniedTopicsHandler)
+	if o.handlers["GET"] == nil {
+		o.handlers["GET"] = make(map[string]http.Handler)
+	}
+	o.handlers["GET"]["/recordings"] = NewListRecordings(o.context, o.ListRecordingsHandler)
+	if o.handlers["GET"] == nil {
+		o.handlers["GET"] = make(map[string]http.Handler)
+	}
+	o.handlers["GET"]["/audit"] = NewQueryAudit(o.context, o.QueryAuditHandler)
+	if o.handlers["POST"] == nil {
+		o.handlers["POST"] = make(map[string]http.Handler)
+	}
+	o.handlers["POST"]["/recordings/replay"] = NewReplayRecording(o.context, o.ReplayRecordingHandler)
 	if o.handlers["POST"] == nil {
 		o.handlers["POST"] = make(map[string]http.Handler)
 	}
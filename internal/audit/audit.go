@@ -0,0 +1,373 @@
+// Package audit keeps an append-only log of connections to the relay, and of
+// changes to the deny list, separately from the general log, so that questions
+// such as "why was I disconnected?" can be answered afterwards.
+//
+// The log is kept in a directory, with one file per day named after the
+// day it started in UTC, e.g.
+//
+//	<dir>/2022-11-03.ndjson
+//
+// Each file is newline-delimited JSON, with one Event per line e.g.
+//
+//	{"t":"2022-11-03T14:05:01.123456789Z","event":"connect","bid":"b123","topic":"pend00-data","name":"5c1f...","connectionType":"session","scopes":["read","write"],"remoteAddr":"192.0.2.1","userAgent":"Mozilla/5.0","expiresAt":1667487600}
+//	{"t":"2022-11-03T14:35:01.2Z","event":"disconnect","bid":"b123","topic":"pend00-data","name":"5c1f...","reason":"session expired","duration":1800}
+//
+// Files older than the retention period are removed when the log moves to a new day.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/practable/relay/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of Event
+const (
+	// Connect is a websocket connection to the crossbar being accepted
+	Connect = "connect"
+
+	// Disconnect is a websocket connection ending, for Reason
+	Disconnect = "disconnect"
+
+	// Refused is a websocket connection being refused, usually because its code could not
+	// be exchanged, or the token it was issued for does not permit the connection
+	Refused = "refused"
+
+	// Deny is a booking ID, or a Topic, being denied at access, by Caller
+	Deny = "deny"
+
+	// Allow is a booking ID, or a Topic, being allowed at access, by Caller
+	Allow = "allow"
+
+	// Close is connections being disconnected at access, by Caller, for Reason. The
+	// Name or Topic selects the connections, each of which is also logged as a Disconnect
+	Close = "close"
+)
+
+// dayFormat names the file for each day of the log
+const dayFormat = "2006-01-02"
+
+// Event is one entry in the audit log
+type Event struct {
+
+	// Time the event happened
+	Time time.Time `json:"t"`
+
+	// Event is the kind of event, e.g. Connect
+	Event string `json:"event"`
+
+	// BookingID of the connection's token, or that was denied or allowed
+	BookingID string `json:"bid,omitempty"`
+
+	Topic string `json:"topic,omitempty"`
+
+	// Name identifies a connection, so that its Connect and Disconnect can be matched up
+	Name string `json:"name,omitempty"`
+
+	// ConnectionType is session or shell
+	ConnectionType string `json:"connectionType,omitempty"`
+
+	Scopes []string `json:"scopes,omitempty"`
+
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	UserAgent string `json:"userAgent,omitempty"`
+
+	// ExpiresAt is when the connection, or the deny or allow listing, expires, in unix seconds
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+
+	// Reason a connection was refused or ended
+	Reason string `json:"reason,omitempty"`
+
+	// Duration of the connection in seconds, for Disconnect
+	Duration int64 `json:"duration,omitempty"`
+
+	// Caller identifies who denied or allowed a booking: the booking ID of their
+	// admin token, and the kid it was signed with, if any
+	Caller string `json:"caller,omitempty"`
+}
+
+// Filter selects events. Empty fields match everything.
+type Filter struct {
+	Topic     string
+	BookingID string
+	From      time.Time
+	To        time.Time
+}
+
+// Match reports whether an event is selected by the filter
+func (f Filter) Match(e Event) bool {
+	switch {
+	case f.Topic != "" && e.Topic != f.Topic:
+		return false
+	case f.BookingID != "" && e.BookingID != f.BookingID:
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	}
+	return true
+}
+
+// Log writes events to, and reads them from, a directory
+type Log struct {
+	dir   string
+	keep  time.Duration
+	queue chan Event
+
+	// the file for the current day, used only by Run
+	day string
+	f   *os.File
+	w   *bufio.Writer
+}
+
+// New returns a Log that keeps events in dir for the keep duration (or forever,
+// if keep is zero), queuing up to size events to be written. Call Run to write the events.
+func New(dir string, size int, keep time.Duration) *Log {
+	return &Log{
+		dir:   dir,
+		keep:  keep,
+		queue: make(chan Event, size),
+	}
+}
+
+// Add queues an event to be written, without blocking, setting its time if it
+// is not set. If the queue is full, the event is dropped, so that auditing
+// cannot hold up the relay. Add does nothing if the Log is nil, so that
+// callers do not need to check whether auditing is enabled.
+func (l *Log) Add(e Event) {
+
+	if l == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	e.Time = e.Time.UTC()
+
+	select {
+	case l.queue <- e:
+	default:
+		metrics.AuditDropped.Inc()
+	}
+}
+
+// Run writes queued events until closed, then writes any events
+// still queued, and closes the file.
+func (l *Log) Run(closed <-chan struct{}) {
+
+	defer l.close()
+
+	err := os.MkdirAll(l.dir, 0755)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "dir": l.dir}).Error("audit directory not created")
+	}
+
+	l.prune(time.Now())
+
+	for {
+		select {
+		case <-closed:
+			for len(l.queue) > 0 {
+				l.write(<-l.queue)
+			}
+			return
+		case e := <-l.queue:
+			l.write(e)
+			// write out the buffer once the queue is clear, so that
+			// events can be read soon after they happen
+			if len(l.queue) == 0 {
+				l.flush()
+			}
+		}
+	}
+}
+
+// write appends an event to the file for its day, moving to a new file if needed
+func (l *Log) write(e Event) {
+
+	day := e.Time.Format(dayFormat)
+
+	if l.f != nil && l.day != day {
+		l.close()
+		l.prune(e.Time)
+	}
+
+	if l.f == nil {
+
+		name := filepath.Join(l.dir, day+".ndjson")
+
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "file": name}).Error("audit file not opened")
+			metrics.AuditDropped.Inc()
+			return
+		}
+
+		l.day = day
+		l.f = f
+		l.w = bufio.NewWriter(f)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "event": e.Event}).Error("audit event not marshalled")
+		metrics.AuditDropped.Inc()
+		return
+	}
+
+	_, err = l.w.Write(append(data, '\n'))
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "event": e.Event}).Error("audit event not written")
+		metrics.AuditDropped.Inc()
+	}
+}
+
+func (l *Log) flush() {
+	if l.w == nil {
+		return
+	}
+	if err := l.w.Flush(); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "day": l.day}).Error("audit log not flushed")
+	}
+}
+
+func (l *Log) close() {
+	if l.f == nil {
+		return
+	}
+	l.flush()
+	if err := l.f.Close(); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "day": l.day}).Error("audit log not closed")
+	}
+	l.f = nil
+	l.w = nil
+}
+
+// prune removes the files for days that ended longer than keep before now
+func (l *Log) prune(now time.Time) {
+
+	if l.keep == 0 {
+		return
+	}
+
+	err := l.walk(Filter{}, func(name string, start time.Time) error {
+		if start.Add(24 * time.Hour).Before(now.Add(-l.keep)) {
+			if err := os.Remove(filepath.Join(l.dir, name)); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{"file": name}).Info("audit file removed")
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "dir": l.dir}).Error("audit files not pruned")
+	}
+}
+
+// Read calls fn with each event selected by the filter, in time order.
+// It stops at the first error from fn, and returns it.
+func (l *Log) Read(f Filter, fn func(Event) error) error {
+	return l.walk(f, func(name string, start time.Time) error {
+		return readFile(filepath.Join(l.dir, name), func(e Event) error {
+			if !f.Match(e) {
+				return nil
+			}
+			return fn(e)
+		})
+	})
+}
+
+// walk calls fn with the name and start of each file that might hold events selected by the filter
+func (l *Log) walk(f Filter, fn func(name string, start time.Time) error) error {
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	names := []string{}
+
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".ndjson") {
+			names = append(names, e.Name())
+		}
+	}
+
+	// names are in time order
+	sort.Strings(names)
+
+	for _, name := range names {
+
+		start, err := time.Parse(dayFormat, strings.TrimSuffix(name, ".ndjson"))
+		if err != nil {
+			continue
+		}
+
+		end := start.Add(24 * time.Hour)
+
+		if !f.From.IsZero() && end.Before(f.From) {
+			continue
+		}
+
+		if !f.To.IsZero() && start.After(f.To) {
+			continue
+		}
+
+		if err := fn(name, start); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readFile calls fn with each event in a file. Lines that are not events,
+// such as one that is still being written, are skipped.
+func readFile(name string, fn func(Event) error) error {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var e Event
+			if json.Unmarshal(line, &e) == nil {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
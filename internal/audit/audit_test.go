@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run starts writing the log, and returns a func that stops it and waits for the events to be written
+func run(l *Log) func() {
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		l.Run(closed)
+		close(done)
+	}()
+
+	return func() {
+		close(closed)
+		<-done
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	l.Add(Event{Event: Connect})
+}
+
+func TestLog(t *testing.T) {
+
+	dir := t.TempDir()
+
+	l := New(dir, 16, 0)
+	stop := run(l)
+
+	t0 := time.Date(2022, 11, 3, 23, 59, 0, 0, time.UTC)
+
+	l.Add(Event{Time: t0, Event: Connect, BookingID: "b0", Topic: "pend00-data", Name: "n0", Scopes: []string{"read", "write"}})
+	l.Add(Event{Time: t0.Add(30 * time.Second), Event: Refused, BookingID: "b1", Topic: "pend00-data", Reason: "expired"})
+	l.Add(Event{Time: t0.Add(90 * time.Second), Event: Disconnect, BookingID: "b0", Topic: "pend00-data", Name: "n0", Reason: "session expired", Duration: 90})
+	l.Add(Event{Time: t0.Add(2 * time.Minute), Event: Deny, BookingID: "b1", Caller: "booking kid=k0"})
+
+	stop()
+
+	// files are split by the day
+	_, err := os.Stat(filepath.Join(dir, "2022-11-03.ndjson"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "2022-11-04.ndjson"))
+	assert.NoError(t, err)
+
+	read := func(f Filter) []string {
+		var got []string
+		err := l.Read(f, func(e Event) error {
+			got = append(got, e.Event)
+			return nil
+		})
+		assert.NoError(t, err)
+		return got
+	}
+
+	assert.Equal(t, []string{Connect, Refused, Disconnect, Deny}, read(Filter{}))
+	assert.Equal(t, []string{Connect, Disconnect}, read(Filter{BookingID: "b0"}))
+	assert.Equal(t, []string{Refused, Deny}, read(Filter{BookingID: "b1"}))
+	assert.Equal(t, []string{Connect, Refused, Disconnect}, read(Filter{Topic: "pend00-data"}))
+	assert.Equal(t, []string{Disconnect, Deny}, read(Filter{From: t0.Add(time.Minute)}))
+	assert.Equal(t, []string{Connect, Refused}, read(Filter{To: t0.Add(time.Minute)}))
+	assert.Equal(t, 0, len(read(Filter{Topic: "nothing"})))
+
+	var last Event
+	err = l.Read(Filter{BookingID: "b0", From: t0.Add(time.Minute)}, func(e Event) error {
+		last = e
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "n0", last.Name)
+	assert.Equal(t, "session expired", last.Reason)
+	assert.Equal(t, int64(90), last.Duration)
+	assert.Equal(t, t0.Add(90*time.Second), last.Time)
+
+	// the log is appended to after a restart
+	l = New(dir, 16, 0)
+	stop = run(l)
+	l.Add(Event{Time: t0.Add(3 * time.Minute), Event: Allow, BookingID: "b1"})
+	stop()
+
+	assert.Equal(t, []string{Refused, Deny, Allow}, read(Filter{BookingID: "b1"}))
+}
+
+func TestPrune(t *testing.T) {
+
+	dir := t.TempDir()
+
+	now := time.Now().UTC()
+	old := now.Add(-72*time.Hour).Format(dayFormat) + ".ndjson"
+	recent := now.Add(-24*time.Hour).Format(dayFormat) + ".ndjson"
+
+	for _, name := range []string{old, recent, "notes.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0640))
+	}
+
+	l := New(dir, 16, 36*time.Hour)
+	stop := run(l)
+	l.Add(Event{Event: Connect})
+	stop()
+
+	_, err := os.Stat(filepath.Join(dir, old))
+	assert.True(t, os.IsNotExist(err))
+
+	for _, name := range []string{recent, "notes.txt", now.Format(dayFormat) + ".ndjson"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+}
@@ -0,0 +1,162 @@
+// Package cert serves a TLS certificate from files, reloading it when the files
+// change, so that a renewed certificate is used for new connections without
+// restarting, and without affecting connections that are already open.
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reloader holds the certificate loaded from a pair of PEM encoded files
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// New returns a Reloader with the certificate and key loaded from the files
+func New(certFile, keyFile string) (*Reloader, error) {
+
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	err := r.Reload()
+
+	return r, err
+}
+
+// Reload loads the certificate and key again. If they cannot be loaded,
+// e.g. because only one of the pair has been replaced so far, the
+// previous certificate is kept, and an error is returned.
+func (r *Reloader) Reload() error {
+
+	modTime, err := r.latest()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate whenever either file is modified, checking
+// every interval, until closed is closed
+func (r *Reloader) Watch(closed <-chan struct{}, every time.Duration) {
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-time.After(every):
+		}
+
+		modTime, err := r.latest()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "cert": r.certFile, "key": r.keyFile}).Warn("certificate files not checked")
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		err = r.Reload()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "cert": r.certFile, "key": r.keyFile}).Error("certificate not reloaded")
+			continue
+		}
+
+		log.WithFields(log.Fields{"cert": r.certFile, "key": r.keyFile}).Info("certificate reloaded")
+	}
+}
+
+// latest returns the latest modification time of the files
+func (r *Reloader) latest() (time.Time, error) {
+
+	var latest time.Time
+
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Config returns a TLS config that serves the current certificate. If
+// clientCAs is not nil, clients may present a certificate, which is
+// verified against clientCAs; see HasClientCert.
+func (r *Reloader) Config(clientCAs *x509.CertPool) *tls.Config {
+
+	c := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if clientCAs != nil {
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.ClientCAs = clientCAs
+	}
+
+	return c
+}
+
+// LoadCAs returns a pool of the certificates in a PEM encoded file
+func LoadCAs(caFile string) (*x509.CertPool, error) {
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + caFile)
+	}
+
+	return pool, nil
+}
+
+// HasClientCert reports whether a connection presented a verified client certificate
+func HasClientCert(cs *tls.ConnectionState) bool {
+	return cs != nil && len(cs.VerifiedChains) > 0
+}
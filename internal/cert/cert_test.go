@@ -0,0 +1,223 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pair is a certificate and its key, PEM encoded
+type pair struct {
+	cert []byte
+	key  []byte
+}
+
+// makeCert returns a certificate for 127.0.0.1 with the serial number, signed by
+// the parent, or self-signed if parent is nil
+func makeCert(t *testing.T, serial int64, isCA bool, parent *tls.Certificate) (pair, tls.Certificate) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "relay test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, interface{}(key)
+
+	if parent != nil {
+		signer = parent.Leaf
+		signerKey = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	p := pair{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+
+	c, err := tls.X509KeyPair(p.cert, p.key)
+	assert.NoError(t, err)
+
+	c.Leaf, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return p, c
+}
+
+// write saves the pair, with a modification time of at, so that changes are
+// seen regardless of the resolution of the file system's timestamps
+func write(t *testing.T, p pair, certFile, keyFile string, at time.Time) {
+	assert.NoError(t, os.WriteFile(certFile, p.cert, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, p.key, 0600))
+	assert.NoError(t, os.Chtimes(certFile, at, at))
+	assert.NoError(t, os.Chtimes(keyFile, at, at))
+}
+
+// serial returns the serial number of the certificate the reloader is serving
+func serial(t *testing.T, r *Reloader) int64 {
+	c, err := r.GetCertificate(nil)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	_, err := New(certFile, keyFile)
+	assert.Error(t, err)
+
+	first, _ := makeCert(t, 1, false, nil)
+	second, _ := makeCert(t, 2, false, nil)
+
+	now := time.Now()
+	write(t, first, certFile, keyFile, now.Add(-time.Minute))
+
+	r, err := New(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), serial(t, r))
+
+	// a certificate that does not match the key is not loaded
+	assert.NoError(t, os.WriteFile(certFile, second.cert, 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, int64(1), serial(t, r))
+
+	write(t, second, certFile, keyFile, now)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, int64(2), serial(t, r))
+}
+
+func TestWatch(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	first, _ := makeCert(t, 1, false, nil)
+	second, _ := makeCert(t, 2, false, nil)
+
+	now := time.Now()
+	write(t, first, certFile, keyFile, now.Add(-time.Minute))
+
+	r, err := New(certFile, keyFile)
+	assert.NoError(t, err)
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go r.Watch(closed, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), serial(t, r))
+
+	write(t, second, certFile, keyFile, now)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(2), serial(t, r))
+}
+
+func TestClientCert(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	server, serverCert := makeCert(t, 1, false, nil)
+	write(t, server, certFile, keyFile, time.Now())
+
+	ca, caCert := makeCert(t, 10, true, nil)
+	assert.NoError(t, os.WriteFile(caFile, ca.cert, 0600))
+
+	_, admin := makeCert(t, 11, false, &caCert)
+	_, other := makeCert(t, 12, false, nil)
+
+	r, err := New(certFile, keyFile)
+	assert.NoError(t, err)
+
+	cas, err := LoadCAs(caFile)
+	assert.NoError(t, err)
+
+	_, err = LoadCAs(certFile + ".missing")
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "empty.pem"), []byte("no certs here"), 0600))
+	_, err = LoadCAs(filepath.Join(dir, "empty.pem"))
+	assert.Error(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if HasClientCert(r.TLS) {
+			_, _ = w.Write([]byte("verified"))
+			return
+		}
+		_, _ = w.Write([]byte("anonymous"))
+	})}
+
+	go func() { _ = s.Serve(tls.NewListener(l, r.Config(cas))) }()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+
+	get := func(certs ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: certs,
+			RootCAs:      roots,
+		}}}
+		resp, err := client.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	body, err := get()
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", body)
+
+	body, err = get(admin)
+	assert.NoError(t, err)
+	assert.Equal(t, "verified", body)
+
+	// certificates from other CAs are refused during the handshake
+	_, err = get(other)
+	assert.Error(t, err)
+
+	assert.False(t, HasClientCert(nil))
+}
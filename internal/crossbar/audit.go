@@ -0,0 +1,111 @@
+package crossbar
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/audit"
+)
+
+// ending records why a connection ended, for the audit log.
+// The first reason given is kept, because whatever closes the connection
+// first causes the other pump to fail with an error of its own.
+type ending struct {
+	mu     sync.Mutex
+	reason string
+}
+
+// end records why the client's connection is ending, unless a reason has already been recorded
+func (c *Client) end(reason string) {
+
+	if c.ending == nil {
+		return
+	}
+
+	c.ending.mu.Lock()
+	defer c.ending.mu.Unlock()
+
+	if c.ending.reason == "" {
+		c.ending.reason = reason
+	}
+}
+
+// endReason returns why the client's connection ended
+func (c *Client) endReason() string {
+
+	if c.ending == nil {
+		return ""
+	}
+
+	c.ending.mu.Lock()
+	defer c.ending.mu.Unlock()
+
+	return c.ending.reason
+}
+
+// closeReason returns the reason in a close message, e.g. "too slow"
+func closeReason(closeMessage []byte) string {
+	if len(closeMessage) <= 2 {
+		return "closed by relay"
+	}
+	// the first two bytes are the close code
+	return string(closeMessage[2:])
+}
+
+// readErrorReason returns why a connection ended, given the error from reading it
+func readErrorReason(err error) string {
+
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return "closed by client"
+	}
+
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return "message too big"
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "client stopped responding"
+	}
+
+	return "connection lost"
+}
+
+// auditConnect adds the client's connection to the audit log, with the address it came from
+func (c *Client) auditConnect(connectionType, remoteAddr string) {
+	c.audit.Add(audit.Event{
+		Event:          audit.Connect,
+		BookingID:      c.bookingID,
+		Topic:          c.topic,
+		Name:           c.name,
+		ConnectionType: connectionType,
+		Scopes:         c.scopes,
+		RemoteAddr:     remoteAddr,
+		UserAgent:      c.userAgent,
+		ExpiresAt:      atomic.LoadInt64(&c.expiresAt),
+	})
+}
+
+// auditDisconnect adds the end of the client's connection to the audit log
+func (c *Client) auditDisconnect() {
+
+	reason := c.endReason()
+
+	if reason == "" {
+		reason = "connection lost"
+	}
+
+	c.audit.Add(audit.Event{
+		Event:     audit.Disconnect,
+		BookingID: c.bookingID,
+		Topic:     c.topic,
+		Name:      c.name,
+		Reason:    reason,
+		Duration:  time.Now().Unix() - c.connectedAt,
+	})
+}
@@ -0,0 +1,148 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/audit"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseReason(t *testing.T) {
+	assert.Equal(t, "too slow", closeReason(websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow")))
+	assert.Equal(t, "closed by relay", closeReason(nil))
+}
+
+func TestReadErrorReason(t *testing.T) {
+	assert.Equal(t, "closed by client", readErrorReason(&websocket.CloseError{Code: websocket.CloseNormalClosure}))
+	assert.Equal(t, "client stopped responding", readErrorReason(&net.OpError{Op: "read", Err: timeoutError{}}))
+	assert.Equal(t, "connection lost", readErrorReason(errors.New("broken pipe")))
+	assert.Equal(t, "message too big", readErrorReason(websocket.ErrReadLimit))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestAudit(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+	denied := make(chan string)
+
+	al := audit.New(t.TempDir(), 64, 0)
+	auditClosed := make(chan struct{})
+	auditDone := make(chan struct{})
+
+	go func() {
+		al.Run(auditClosed)
+		close(auditDone)
+	}()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		Audit:      al,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  ds,
+		Hub:        New(),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, denied, &wg)
+	time.Sleep(time.Second)
+
+	dial := func(bid string) *websocket.Conn {
+		token := MakeTestToken(audience, "session", "audit00", []string{"read", "write"}, 10)
+		token.SetBookingID(bid)
+		conn, _, err := websocket.DefaultDialer.Dial(audience+"/session/audit00?code="+cs.SubmitToken(token), nil)
+		assert.NoError(t, err)
+		return conn
+	}
+
+	// closed by the client
+	c0 := dial("bid0")
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, c0.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	time.Sleep(100 * time.Millisecond)
+	c0.Close()
+
+	// closed by the relay when the booking is cancelled
+	c1 := dial("bid1")
+	defer c1.Close()
+	time.Sleep(100 * time.Millisecond)
+	ds.Deny("bid1", time.Now().Unix()+60)
+	denied <- "bid1"
+	time.Sleep(100 * time.Millisecond)
+
+	// refused
+	_, _, err = websocket.DefaultDialer.Dial(audience+"/session/audit00?code=nonsense", nil)
+	assert.Error(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	close(closed)
+	wg.Wait()
+
+	close(auditClosed)
+	<-auditDone
+
+	var events []audit.Event
+	assert.NoError(t, al.Read(audit.Filter{}, func(e audit.Event) error {
+		events = append(events, e)
+		return nil
+	}))
+
+	if assert.Equal(t, 5, len(events)) {
+
+		connect0, disconnect0 := events[0], events[1]
+		assert.Equal(t, audit.Connect, connect0.Event)
+		assert.Equal(t, "bid0", connect0.BookingID)
+		assert.Equal(t, "audit00", connect0.Topic)
+		assert.Equal(t, "session", connect0.ConnectionType)
+		assert.Equal(t, []string{"read", "write"}, connect0.Scopes)
+		assert.Equal(t, "127.0.0.1", connect0.RemoteAddr[:9])
+		assert.Equal(t, "Go-http-client/1.1", connect0.UserAgent)
+		assert.NotEqual(t, "", connect0.Name)
+
+		// the connection is identified by the same name when it ends
+		assert.Equal(t, audit.Disconnect, disconnect0.Event)
+		assert.Equal(t, connect0.Name, disconnect0.Name)
+		assert.Equal(t, "closed by client", disconnect0.Reason)
+
+		assert.Equal(t, audit.Connect, events[2].Event)
+		assert.Equal(t, audit.Disconnect, events[3].Event)
+		assert.Equal(t, "bid1", events[3].BookingID)
+		assert.Equal(t, "booking cancelled", events[3].Reason)
+
+		assert.Equal(t, audit.Refused, events[4].Event)
+		assert.Equal(t, "invalid code", events[4].Reason)
+		assert.Equal(t, "audit00", events[4].Topic)
+	}
+}
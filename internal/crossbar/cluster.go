@@ -0,0 +1,583 @@
+package crossbar
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	"github.com/practable/relay/internal/metrics"
+	"github.com/practable/relay/internal/permission"
+	log "github.com/sirupsen/logrus"
+)
+
+// ClusterConfig represents the configuration for linking crossbar nodes into a cluster.
+// Messages sent by clients on session topics at any node are delivered to subscribers
+// on every node. Messages are only forwarded one hop, so every node must be linked to
+// every other node (a link dialled in either direction is sufficient). Shell connections
+// and the stats topic are local to each node.
+type ClusterConfig struct {
+
+	// NodeID uniquely identifies this node in the cluster
+	NodeID string
+
+	// Peers are the base URLs of the nodes to link to e.g. wss://relay1.example.io
+	// each must match the Audience configured on that node
+	Peers []string
+
+	// ReportEvery sets how often this node sends its client reports to its peers
+	ReportEvery time.Duration
+
+	// Secret is shared by all nodes, and used to sign and verify link tokens
+	Secret string
+}
+
+// frame kinds sent over links between nodes
+const (
+	frameHello byte = iota
+	frameMessage
+	frameReport
+)
+
+// frameHeaderSize is the fixed part of the frame header, see frame.marshal
+const frameHeaderSize = 1 + 1 + 8 + 2 + 2
+
+// frame represents one websocket message on a link between nodes
+type frame struct {
+	kind   byte
+	mt     int
+	seq    uint64
+	origin string
+	topic  string
+	data   []byte
+}
+
+// marshal encodes the frame as
+// [kind:1][mt:1][seq:8][len(origin):2][len(topic):2][origin][topic][data]
+// so that binary payloads can be relayed without re-encoding
+func (f frame) marshal() []byte {
+	b := make([]byte, frameHeaderSize+len(f.origin)+len(f.topic)+len(f.data))
+	b[0] = f.kind
+	b[1] = byte(f.mt)
+	binary.BigEndian.PutUint64(b[2:10], f.seq)
+	binary.BigEndian.PutUint16(b[10:12], uint16(len(f.origin)))
+	binary.BigEndian.PutUint16(b[12:14], uint16(len(f.topic)))
+	n := frameHeaderSize
+	n += copy(b[n:], f.origin)
+	n += copy(b[n:], f.topic)
+	copy(b[n:], f.data)
+	return b
+}
+
+func unmarshalFrame(b []byte) (frame, error) {
+
+	if len(b) < frameHeaderSize {
+		return frame{}, errors.New("frame too short")
+	}
+
+	f := frame{
+		kind: b[0],
+		mt:   int(b[1]),
+		seq:  binary.BigEndian.Uint64(b[2:10]),
+	}
+
+	lo := int(binary.BigEndian.Uint16(b[10:12]))
+	lt := int(binary.BigEndian.Uint16(b[12:14]))
+
+	if len(b) < frameHeaderSize+lo+lt {
+		return frame{}, errors.New("frame header longer than frame")
+	}
+
+	n := frameHeaderSize
+	f.origin = string(b[n : n+lo])
+	n += lo
+	f.topic = string(b[n : n+lt])
+	n += lt
+	f.data = b[n:]
+
+	return f, nil
+}
+
+// Cluster links this node's hub to the hubs on other nodes
+type Cluster struct {
+	config ClusterConfig
+
+	// audience that incoming link tokens must have, i.e. this node's URL
+	audience string
+
+	hub *Hub
+
+	// seq numbers messages from this node; it starts at the time the node started
+	// so that peers don't mistake messages after a restart for duplicates
+	seq uint64
+
+	mu *sync.Mutex
+
+	// peers holds the links and latest reports, by node ID
+	peers map[string]*peer
+
+	// forwarding holds the link that messages are forwarded on to each peer, by node ID,
+	// as a map[string]*link that is replaced, not changed, while holding mu whenever
+	// links are added or removed, so that forwarding never waits for mu
+	forwarding atomic.Value
+
+	// seen holds the latest seqs received from each origin, to drop duplicates
+	seen map[string]*replayWindow
+}
+
+// windowSize is how many of the latest seqs from each origin are remembered
+const windowSize = 1024
+
+// replayWindow remembers which of the latest seqs from an origin have been received. Messages
+// on different topics are forwarded concurrently, so they may arrive a little out of order,
+// and a seq below the highest received so far is not necessarily a duplicate.
+type replayWindow struct {
+	top   uint64
+	slots [windowSize]uint64
+}
+
+// add records the seq, and returns false if it has already been received, or is
+// too old to tell, so that the message must be dropped
+func (w *replayWindow) add(seq uint64) bool {
+
+	if w.top >= windowSize && seq <= w.top-windowSize {
+		return false
+	}
+
+	slot := &w.slots[seq%windowSize]
+
+	if *slot == seq {
+		return false
+	}
+
+	*slot = seq
+
+	if seq > w.top {
+		w.top = seq
+	}
+
+	return true
+}
+
+// peer represents another node in the cluster
+type peer struct {
+	links   []*link
+	reports []*ClientReport
+}
+
+// link is a websocket connection to a peer
+type link struct {
+	conn *websocket.Conn
+	send chan []byte
+	peer string
+}
+
+// NewCluster returns a cluster for the hub; call Run to start linking to peers
+func NewCluster(config ClusterConfig, audience string, hub *Hub) *Cluster {
+	if config.ReportEvery <= 0 {
+		config.ReportEvery = 5 * time.Second
+	}
+	return &Cluster{
+		config:   config,
+		audience: audience,
+		hub:      hub,
+		seq:      uint64(time.Now().UnixNano()),
+		mu:       &sync.Mutex{},
+		peers:    make(map[string]*peer),
+		seen:     make(map[string]*replayWindow),
+	}
+}
+
+// Run dials each of the configured peers, redialling as needed, and
+// sends reports to the peers, until closed
+func (c *Cluster) Run(closed <-chan struct{}) {
+
+	for _, p := range c.config.Peers {
+		go c.dial(closed, p)
+	}
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-time.After(c.config.ReportEvery):
+			c.sendReports()
+		}
+	}
+}
+
+// Members returns the node IDs of the peers that are currently linked
+func (c *Cluster) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := []string{}
+	for id := range c.peers {
+		m = append(m, id)
+	}
+	return m
+}
+
+// reports returns the latest reports from all the peers
+func (c *Cluster) reports() []*ClientReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var r []*ClientReport
+	for _, p := range c.peers {
+		r = append(r, p.reports...)
+	}
+	return r
+}
+
+// forward sends a message from a local client to every peer, without blocking,
+// and without waiting for the lock, so that topics do not hold each other up
+func (c *Cluster) forward(m message) {
+
+	data := frame{
+		kind:   frameMessage,
+		mt:     m.mt,
+		seq:    atomic.AddUint64(&c.seq, 1),
+		origin: c.config.NodeID,
+		topic:  m.sender.topic,
+		data:   m.data,
+	}.marshal()
+
+	for id, l := range c.links() {
+		select {
+		case l.send <- data:
+		default:
+			metrics.ClusterDropped.WithLabelValues(id).Inc()
+			log.WithFields(log.Fields{"peer": id, "topic": m.sender.topic}).Error("message not forwarded because link to peer was blocked")
+		}
+	}
+}
+
+// sendReports sends this node's client reports to every peer
+func (c *Cluster) sendReports() {
+
+	reports, err := json.Marshal(c.hub.localReports())
+	if err != nil {
+		log.WithField("error", err.Error()).Error("cluster could not marshal reports")
+		return
+	}
+
+	data := frame{kind: frameReport, origin: c.config.NodeID, data: reports}.marshal()
+
+	for _, l := range c.links() {
+		select {
+		case l.send <- data:
+		default:
+		}
+	}
+}
+
+// links returns the link to each peer that frames are sent on, by node ID
+func (c *Cluster) links() map[string]*link {
+	links, _ := c.forwarding.Load().(map[string]*link)
+	return links
+}
+
+// relink replaces the links that frames are sent on, after links are added
+// or removed; one link per peer is enough, and any others are spares.
+// The caller must hold the lock.
+func (c *Cluster) relink() {
+	links := make(map[string]*link, len(c.peers))
+	for id, p := range c.peers {
+		links[id] = p.links[0]
+	}
+	c.forwarding.Store(links)
+}
+
+// token returns a token for dialling a peer
+func (c *Cluster) token(audience string) (string, error) {
+	now := time.Now()
+	t := permission.NewToken(audience, "cluster", c.config.NodeID, []string{"cluster"}, now.Unix(), now.Unix(), now.Add(time.Minute).Unix())
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, t).SignedString([]byte(c.config.Secret))
+}
+
+// verify checks a link token from a peer, and returns the peer's node ID
+func (c *Cluster) verify(bearer string) (string, error) {
+
+	claims := &permission.Token{}
+
+	token, err := jwt.ParseWithClaims(bearer, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method was %v", token.Header["alg"])
+		}
+		return []byte(c.config.Secret), nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if !token.Valid || !permission.HasRequiredClaims(*claims) {
+		return "", errors.New("token invalid")
+	}
+
+	if claims.ConnectionType != "cluster" {
+		return "", errors.New("token not for cluster")
+	}
+
+	if !claims.VerifyAudience(c.audience, true) {
+		return "", fmt.Errorf("aud %s does not match this node %s", claims.Audience, c.audience)
+	}
+
+	return claims.Topic, nil
+}
+
+// dial keeps a link open to a peer, until closed
+func (c *Cluster) dial(closed <-chan struct{}, to string) {
+
+	b := &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    10 * time.Second,
+		Factor: 2,
+		Jitter: true,
+	}
+
+	for {
+
+		token, err := c.token(to)
+
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "peer": to}).Error("cluster could not make token for peer")
+		} else {
+			conn, _, err := websocket.DefaultDialer.Dial(strings.TrimSuffix(to, "/")+"/cluster/", http.Header{"Authorization": {token}})
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "peer": to}).Debug("cluster could not dial peer")
+			} else {
+				b.Reset()
+				c.handleLink(closed, conn)
+			}
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+// serveCluster accepts links from peers
+func (c *Cluster) serveCluster(closed <-chan struct{}, w http.ResponseWriter, r *http.Request) {
+
+	id, err := c.verify(r.Header.Get("Authorization"))
+
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "remote_addr": r.RemoteAddr}).Error("cluster link rejected")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "peer": id}).Error("cluster link failed to upgrade to websocket")
+		return
+	}
+
+	c.handleLink(closed, conn)
+}
+
+// handleLink exchanges hellos with the peer, then relays frames until the link closes
+func (c *Cluster) handleLink(closed <-chan struct{}, conn *websocket.Conn) {
+
+	defer conn.Close()
+
+	hello := frame{kind: frameHello, origin: c.config.NodeID}.marshal()
+
+	err := conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err == nil {
+		err = conn.WriteMessage(websocket.BinaryMessage, hello)
+	}
+	if err != nil {
+		log.WithField("error", err.Error()).Error("cluster could not send hello")
+		return
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(pongWait))
+	if err != nil {
+		return
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		log.WithField("error", err.Error()).Error("cluster did not receive hello")
+		return
+	}
+
+	f, err := unmarshalFrame(data)
+
+	if err != nil || f.kind != frameHello || f.origin == "" {
+		log.Error("cluster link closed because first frame was not hello")
+		return
+	}
+
+	if f.origin == c.config.NodeID {
+		log.WithField("node", f.origin).Error("cluster link closed because it connects to this node")
+		return
+	}
+
+	l := &link{
+		conn: conn,
+		send: make(chan []byte, 1024),
+		peer: f.origin,
+	}
+
+	c.add(l)
+	defer c.remove(l)
+
+	done := make(chan struct{})
+	go l.writePump(closed, done)
+	defer close(done)
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "peer": l.peer}).Debug("cluster link read error")
+			return
+		}
+
+		f, err := unmarshalFrame(data)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "peer": l.peer}).Error("cluster link bad frame")
+			continue
+		}
+
+		switch f.kind {
+		case frameMessage:
+			c.receive(f)
+		case frameReport:
+			var reports []*ClientReport
+			if err := json.Unmarshal(f.data, &reports); err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "peer": l.peer}).Error("cluster link bad report")
+				continue
+			}
+			c.mu.Lock()
+			if p, ok := c.peers[l.peer]; ok {
+				p.reports = reports
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// receive passes a message from a peer to the local hub, unless it is a duplicate or
+// one of our own, and without forwarding it any further, so that messages cannot loop
+func (c *Cluster) receive(f frame) {
+
+	if f.origin == c.config.NodeID {
+		return
+	}
+
+	c.mu.Lock()
+	w, ok := c.seen[f.origin]
+	if !ok {
+		w = &replayWindow{}
+		c.seen[f.origin] = w
+	}
+	fresh := w.add(f.seq)
+	c.mu.Unlock()
+
+	if !fresh {
+		return
+	}
+
+	c.hub.publish(message{
+		sender:  source{topic: f.topic, name: "cluster:" + f.origin},
+		mt:      f.mt,
+		data:    f.data,
+		sent:    time.Now(),
+		session: true,
+		remote:  true,
+	}, nil)
+}
+
+// add registers a link, making its peer a member of the cluster if it wasn't already
+func (c *Cluster) add(l *link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.peers[l.peer]
+	if !ok {
+		p = &peer{}
+		c.peers[l.peer] = p
+		log.WithFields(log.Fields{"node": c.config.NodeID, "peer": l.peer}).Info("cluster peer joined")
+	}
+	p.links = append(p.links, l)
+	c.relink()
+	metrics.ClusterPeers.Set(float64(len(c.peers)))
+}
+
+// remove deregisters a link, removing its peer from the cluster if it was the last link
+func (c *Cluster) remove(l *link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.peers[l.peer]
+	if !ok {
+		return
+	}
+
+	for i, pl := range p.links {
+		if pl == l {
+			p.links = append(p.links[:i], p.links[i+1:]...)
+			break
+		}
+	}
+
+	if len(p.links) == 0 {
+		delete(c.peers, l.peer)
+		log.WithFields(log.Fields{"node": c.config.NodeID, "peer": l.peer}).Info("cluster peer left")
+	}
+	c.relink()
+	metrics.ClusterPeers.Set(float64(len(c.peers)))
+}
+
+// writePump sends frames and pings to the peer until the link is done
+func (l *link) writePump(closed <-chan struct{}, done <-chan struct{}) {
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-l.send:
+			err := l.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err == nil {
+				err = l.conn.WriteMessage(websocket.BinaryMessage, data)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "peer": l.peer}).Debug("cluster link write error")
+				l.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			err := l.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err == nil {
+				err = l.conn.WriteMessage(websocket.PingMessage, nil)
+			}
+			if err != nil {
+				l.conn.Close()
+				return
+			}
+		case <-closed:
+			l.conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
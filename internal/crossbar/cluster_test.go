@@ -0,0 +1,257 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/reconws"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrame(t *testing.T) {
+
+	f := frame{
+		kind:   frameMessage,
+		mt:     websocket.BinaryMessage,
+		seq:    1234567890123,
+		origin: "node-a",
+		topic:  "some/topic",
+		data:   []byte{0, 1, 2, 3},
+	}
+
+	g, err := unmarshalFrame(f.marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, f, g)
+
+	h, err := unmarshalFrame(frame{kind: frameHello, origin: "node-b"}.marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, frameHello, h.kind)
+	assert.Equal(t, "node-b", h.origin)
+	assert.Equal(t, "", h.topic)
+	assert.Equal(t, 0, len(h.data))
+
+	_, err = unmarshalFrame([]byte{frameMessage, 1, 2})
+	assert.Error(t, err)
+
+	// header claims a longer origin than the frame holds
+	b := f.marshal()
+	_, err = unmarshalFrame(b[:frameHeaderSize+2])
+	assert.Error(t, err)
+}
+
+func TestReplayWindow(t *testing.T) {
+
+	w := &replayWindow{}
+
+	assert.True(t, w.add(10))
+	assert.False(t, w.add(10))
+
+	// topics are forwarded concurrently, so an earlier seq may arrive late
+	assert.True(t, w.add(12))
+	assert.True(t, w.add(11))
+	assert.False(t, w.add(11))
+	assert.False(t, w.add(12))
+
+	// too old to tell whether it is a duplicate
+	assert.True(t, w.add(12+windowSize))
+	assert.False(t, w.add(12))
+	assert.True(t, w.add(13))
+}
+
+func TestClusterToken(t *testing.T) {
+
+	a := NewCluster(ClusterConfig{NodeID: "a", Secret: "somesecret"}, "ws://a", New())
+	b := NewCluster(ClusterConfig{NodeID: "b", Secret: "somesecret"}, "ws://b", New())
+	c := NewCluster(ClusterConfig{NodeID: "c", Secret: "othersecret"}, "ws://c", New())
+
+	token, err := a.token("ws://b")
+	assert.NoError(t, err)
+
+	id, err := b.verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", id)
+
+	// wrong audience
+	token, err = a.token("ws://c")
+	assert.NoError(t, err)
+	_, err = b.verify(token)
+	assert.Error(t, err)
+
+	// wrong secret
+	token, err = c.token("ws://b")
+	assert.NoError(t, err)
+	_, err = b.verify(token)
+	assert.Error(t, err)
+}
+
+func TestCluster(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	ids := []string{"a", "b", "c"}
+	audiences := make(map[string]string)
+	ports := make(map[string]int)
+
+	for _, id := range ids {
+		port, err := freeport.GetFreePort()
+		assert.NoError(t, err)
+		ports[id] = port
+		audiences[id] = "ws://127.0.0.1:" + strconv.Itoa(port)
+	}
+
+	// a full mesh needs only one link between each pair; c dials nobody
+	peers := map[string][]string{
+		"a": {audiences["b"], audiences["c"]},
+		"b": {audiences["c"]},
+		"c": {},
+	}
+
+	stores := make(map[string]*ttlcode.CodeStore)
+	hubs := make(map[string]*Hub)
+
+	for _, id := range ids {
+
+		stores[id] = ttlcode.NewDefaultCodeStore()
+		hubs[id] = New().WithCluster(ClusterConfig{
+			NodeID:      id,
+			Peers:       peers[id],
+			ReportEvery: 100 * time.Millisecond,
+			Secret:      "clustersecret",
+		}, audiences[id])
+
+		config := Config{
+			Listen:     ports[id],
+			Audience:   audiences[id],
+			CodeStore:  stores[id],
+			DenyStore:  deny.New(),
+			Hub:        hubs[id],
+			Secret:     "somesecret",
+			StatsEvery: time.Second,
+		}
+
+		wg.Add(1)
+		go Crossbar(config, closed, make(chan string), &wg)
+	}
+
+	// wait for the links to come up
+	for i := 0; i < 50; i++ {
+		up := true
+		for _, id := range ids {
+			if len(hubs[id].cluster.Members()) != 2 {
+				up = false
+			}
+		}
+		if up {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, id := range ids {
+		members := hubs[id].cluster.Members()
+		sort.Strings(members)
+		expected := []string{}
+		for _, other := range ids {
+			if other != id {
+				expected = append(expected, other)
+			}
+		}
+		assert.Equal(t, expected, members)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	session := "cluster-session"
+	clients := make(map[string]*reconws.ReconWs)
+
+	for _, id := range ids {
+		token := MakeTestToken(audiences[id], "session", session, []string{"read", "write"}, 10)
+		code := stores[id].SubmitToken(token)
+		clients[id] = reconws.New()
+		go func(r *reconws.ReconWs, url string) {
+			err := r.Dial(ctx, url)
+			assert.NoError(t, err)
+		}(clients[id], audiences[id]+"/session/"+session+"?code="+code)
+	}
+
+	// a client on another topic must not receive anything
+	token := MakeTestToken(audiences["b"], "session", "other-session", []string{"read", "write"}, 10)
+	other := reconws.New()
+	go func() {
+		err := other.Dial(ctx, audiences["b"]+"/session/other-session?code="+stores["b"].SubmitToken(token))
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	timeout := 200 * time.Millisecond
+
+	for _, from := range ids {
+
+		data := []byte("hello from " + from)
+
+		clients[from].Out <- reconws.WsMessage{Data: data, Type: websocket.TextMessage}
+
+		for _, to := range ids {
+
+			if to == from {
+				continue
+			}
+
+			select {
+			case msg := <-clients[to].In:
+				assert.Equal(t, data, msg.Data)
+			case <-time.After(timeout):
+				t.Errorf("message from %s not received at %s", from, to)
+			}
+		}
+
+		// check nobody gets a duplicate, including the sender getting an echo
+		for _, to := range ids {
+			select {
+			case msg := <-clients[to].In:
+				t.Errorf("unexpected message at %s: %s", to, string(msg.Data))
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	select {
+	case msg := <-other.In:
+		t.Errorf("message leaked to other topic: %s", string(msg.Data))
+	default:
+	}
+
+	// each node reports on the clients at every node
+	time.Sleep(300 * time.Millisecond)
+
+	for _, id := range ids {
+		nodes := make(map[string]int)
+		for _, r := range hubs[id].GetClientReports() {
+			if r.Topic == session {
+				nodes[r.Node]++
+			}
+		}
+		assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, nodes, "reports at node "+id)
+	}
+
+	cancel()
+	close(closed)
+	wg.Wait()
+}
@@ -4,22 +4,33 @@ package crossbar
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	gopath "path"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/audit"
 	"github.com/practable/relay/internal/chanmap"
 	"github.com/practable/relay/internal/deny"
-	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/framing"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/metrics"
+	"github.com/practable/relay/internal/record"
 	"github.com/practable/relay/internal/ttlcode"
 	"github.com/practable/relay/internal/util"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 // Config represents configuration options for a crossbar instance
@@ -32,23 +43,71 @@ type Config struct {
 	//BufferSize sets the buffer size for client communications channels
 	BufferSize int64
 
+	// Drain, when closed, stops new connections, and closes existing ones with
+	// going away, so that clients reconnect to another relay; nil never drains
+	Drain <-chan struct{}
+
+	// FloorControl lists patterns for session topics on which only one writer at a time
+	// holds control, see FloorCommand
+	FloorControl []string
+
 	// ExchangeCode swaps a code for the associated Token
-	CodeStore *ttlcode.CodeStore
+	CodeStore ttlcode.Backend
+
+	// ExpiryWarning is how long before its token expires that a client is sent
+	// a SessionStatus, if it requested SessionProtocol; zero sends no warning
+	ExpiryWarning time.Duration
 
 	//DenyStore holds deny-listed bookingIDs
-	DenyStore *deny.Store
+	DenyStore deny.Backend
+
+	// Audit logs connections, and refused connections, if set
+	Audit *audit.Log
 
 	//Hub holds the clients and topics and manages message distribution
 	Hub *Hub
 
+	// Keys verifies tokens sent to refresh a connection; if nil, only tokens signed with Secret are accepted
+	Keys *keyset.Keyset
+
+	// LimitBytes limits the bytes per write connection; its Count must exceed the largest message
+	LimitBytes limit.Rate
+
+	// LimitConnect limits new connections per source address
+	LimitConnect limit.Rate
+
+	// LimitMessages limits the messages per write connection
+	LimitMessages limit.Rate
+
 	// Listen is the listening port
 	Listen int
 
-	// Secret is used to validating statsTokens
+	// Listener is used instead of listening on Listen, if set, e.g. one handed over by another process
+	Listener net.Listener
+
+	// Policies set buffer sizes, message limits and connection caps for session topics, if set
+	Policies *Policies
+
+	// Proxies are trusted to say where connections came from in X-Forwarded-For; if
+	// nil, connections are taken to come from the address that connected
+	Proxies *forwarded.Proxies
+
+	// Secret is used to validate tokens sent to refresh a connection, if Keys is not set
 	Secret string
 
+	// SlowConsumer sets what happens to messages for clients that cannot keep up,
+	// by topic. The first matching rule applies, else messages are dropped (DropNewest)
+	SlowConsumer []SlowConsumerRule
+
 	//StatsEvery sets how often stats are reported
 	StatsEvery time.Duration
+
+	// TokenAudience is the audience of the tokens that clients present to access,
+	// which they can also send to refresh a connection; if empty, only codes can be sent
+	TokenAudience string
+
+	// connects tracks new connections by source address, for LimitConnect
+	connects *limit.Keyed
 }
 
 // Client is a middleperson between the websocket connection and the hub.
@@ -63,7 +122,8 @@ type Client struct {
 	// hub closes this channel if connection is curtailed
 	denied chan struct{}
 
-	// when the client's authorization token expires
+	// when the client's authorization token expires, which changes if the client
+	// refreshes its token, so use atomic
 	expiresAt int64
 
 	hub *Hub
@@ -74,9 +134,15 @@ type Client struct {
 	// Buffered channel of outbound messages.
 	send chan message
 
+	// guards send, so that dispatchers do not send to it after it is closed
+	guard *sendGuard
+
 	// string representing the path the client connected to
 	topic string
 
+	// whether the client made a session or shell connection, which have separate topics
+	ct ConnectionType
+
 	audience string
 
 	scopes []string
@@ -89,22 +155,117 @@ type Client struct {
 
 	// existence of scopes to read, write
 	canRead, canWrite bool
+
+	// shell connections only: the ID assigned to a client's connection,
+	// which tags its messages to and from the host; empty for the host
+	connectionID string
+
+	// shell connections only: whether the host scope was granted
+	isHost bool
+
+	// session connections only: whether the topic is a pattern, so that the client
+	// receives messages from every matching topic, each prefixed by its topic
+	watching bool
+
+	// session connections only: whether the topic is under floor control,
+	// and if so, whether the client holds control (1) or not (0)
+	floored bool
+	holding int32
+
+	// whether the admin scope was granted, so the client can seize floor control
+	isAdmin bool
+
+	// rate limits for messages from the client, nil if unlimited
+	messages, bytes *rate.Limiter
+
+	// limits for the client's topic
+	policy Policy
+
+	// slot held under the policy while the client is connecting, freed when it registers
+	slot *slot
+
+	// whether the hub counted the connection when it registered, and added its deny
+	// channel, which are undone when it unregisters; only used by the hub's run goroutine
+	registered bool
+
+	// how queued messages are written, FramingStream or FramingMessage
+	framing string
+
+	// batch holds the messages being written by writeQueued, to save allocating each time
+	batch []framing.Item
+
+	// whether messages to the client are compressed, so are worth preparing
+	compress bool
+
+	// recent message activity
+	stats *Stats
+
+	// what to do when the client can't keep up
+	slow SlowConsumerRule
+
+	// messages missed because the client couldn't keep up
+	drops *drops
+
+	// sent to the client when the hub closes the send channel
+	closeMessage []byte
+
+	// sent to the client when the connection is cancelled, because the
+	// token expired or the booking was cancelled; set before cancelling
+	cancelMessage []byte
+
+	// closed when the connection is cancelled
+	cancelled <-chan struct{}
+
+	// closed when the read pump stops, because the connection has ended,
+	// so that expire stops too; nil for internal clients
+	gone chan struct{}
+
+	// whether the client requested SessionProtocol, so it can be sent
+	// SessionStatus messages on status, and send SessionCommands to refreshes
+	sessionProtocol bool
+	status          chan SessionStatus
+	refreshes       chan SessionCommand
+
+	// whether the client requested PresenceProtocol, so it is sent PresenceEvents on presences
+	presence  bool
+	presences chan PresenceEvent
+
+	// the latest FloorStatus not yet sent, for clients on floor-controlled topics
+	floorStatus chan FloorStatus
+
+	// audit logs the connection, if set, with why it ended
+	audit  *audit.Log
+	ending *ending
 }
 
 // ClientReport represents information about a client's connection, permissions, and statistics
 type ClientReport struct {
+
+	// BookingID from the client's token, if any
+	BookingID string `json:"bookingID,omitempty"`
+
 	CanRead bool `json:"canRead"`
 
 	CanWrite bool `json:"canWrite"`
 
 	ConnectedAt string `json:"connected"`
 
+	Dropped DropReport `json:"dropped"`
+
 	ExpiresAt string `json:"expiresAt"`
 
+	// ID identifies the connection for as long as it lasts, e.g. so that it can be disconnected
+	ID string `json:"id"`
+
+	// Node identifies the node the client is connected to, if clustered
+	Node string `json:"node,omitempty"`
+
 	RemoteAddr string `json:"remoteAddr"`
 
 	Scopes []string `json:"scopes"`
 
+	Stats RxTx `json:"stats"`
+
 	Topic string `json:"topic"`
 
 	UserAgent string `json:"userAgent"`
@@ -115,21 +276,53 @@ type StatsCommand struct {
 	Command string `json:"cmd"`
 }
 
+// ConnectionAction represents a shell connection event that is sent to the host's connection.
+// On "connect", a client has connected, and its messages will follow, tagged with its ID.
+// On "disconnect", the client has gone, and the host should tidy up any resources it was
+// using for that connection. The host sends "disconnect" to close a client's connection.
+type ConnectionAction struct {
+	Action string `json:"action"`
+	URI    string `json:"uri,omitempty"`
+	ID     string `json:"uuid"`
+}
+
 // messages will be wrapped in this struct for muxing
 type message struct {
-	sender Client
+	sender source
 	mt     int
 	data   []byte //text data are converted to/from bytes as needed
+	sent   time.Time
+
+	// to is the connection ID of the shell client that a message from the host is for;
+	// messages from shell clients, and connection actions, are for the host
+	to string
+
+	// prepared holds the message framed, and compressed, for websocket connections
+	// that compress messages, so that it is compressed once for all the readers
+	// rather than by each; nil if not worth it
+	prepared *websocket.PreparedMessage
+
+	// session is true for messages from clients on session topics, which
+	// are delivered to matching watchers, and forwarded to other nodes in the cluster
+	session bool
+
+	// remote is true for messages that came from another node in the cluster,
+	// which must not be forwarded again
+	remote bool
+
+	// replay is true for messages published from a recording
+	replay bool
 }
 
 // NewDefaultConfig returns a pointer to a Config struct with default parameters
 func NewDefaultConfig() *Config {
 	c := &Config{}
 	c.Listen = 3000
-	c.CodeStore = ttlcode.NewDefaultCodeStore()
+	cs := ttlcode.NewDefaultCodeStore()
+	c.CodeStore = cs
 	c.BufferSize = 128
 	c.StatsEvery = time.Duration(5 * time.Second)
-	log.WithFields(log.Fields{"BufferSize": c.BufferSize, "listen": c.Listen, "ttl": c.CodeStore.GetTTL()}).Info("crossbar default config")
+	log.WithFields(log.Fields{"BufferSize": c.BufferSize, "listen": c.Listen, "ttl": cs.GetTTL()}).Info("crossbar default config")
 	return c
 }
 
@@ -198,15 +391,23 @@ func Crossbar(config Config, closed <-chan struct{}, denied chan string, parentw
 func (c *Client) readPump() {
 
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.leave(c)
+		close(c.gone)
 		err := c.conn.Close()
 		if err != nil {
 			log.Errorf("readPump connection close error: %v", err)
 		}
+		c.auditDisconnect()
 		log.Trace("readpump closed")
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
+	readLimit := int64(maxMessageSize)
+
+	if c.policy.MaxMessageSize > 0 {
+		readLimit = c.policy.MaxMessageSize
+	}
+
+	c.conn.SetReadLimit(readLimit)
 
 	err := c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
@@ -222,18 +423,67 @@ func (c *Client) readPump() {
 
 	for {
 
-		mt, data, err := c.conn.ReadMessage()
+		mt, data, err := c.readMessage()
 
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Tracef("readPump error: %v", err)
 			}
+			c.end(readErrorReason(err))
 			break
 		}
 
-		if c.canWrite {
+		c.stats.rx.add(len(data))
+		metrics.MessagesRx.Inc()
+		metrics.BytesRx.Add(float64(len(data)))
+
+		if c.canWrite && !c.withinLimits(len(data)) {
+			log.WithFields(log.Fields{"topic": c.topic, "name": c.name, "remote_address": c.remoteAddr}).Warn("client disconnected because it exceeded its message rate limit")
+			c.end("message rate limit exceeded")
+			err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "message rate limit exceeded"), time.Now().Add(writeWait))
+			if err != nil {
+				log.Tracef("readPump close error: %v", err)
+			}
+			break
+		}
+
+		if c.sessionProtocol && isSessionCommand(mt, data) {
+			c.readSessionCommand(data)
+			continue
+		}
+
+		if c.floored && isFloorCommand(mt, data) {
+			var cmd FloorCommand
+			if err := json.Unmarshal(data, &cmd); err != nil {
+				log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic}).Warn("floor command not understood")
+				continue
+			}
+			select {
+			case c.hub.floor <- floorRequest{client: c, command: cmd}:
+			case <-c.hub.stopped:
+			}
+			continue
+		}
+
+		if c.mayWrite() {
+
+			if !c.policy.allows(mt) {
+				log.WithFields(log.Fields{"topic": c.topic, "name": c.name, "message_type": mt}).Warn("client disconnected because its topic's policy does not allow the message type")
+				c.end("message type not allowed")
+				err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "message type not allowed"), time.Now().Add(writeWait))
+				if err != nil {
+					log.Tracef("readPump close error: %v", err)
+				}
+				break
+			}
+
+			m := message{sender: c.from(), data: data, mt: mt, sent: time.Now(), session: c.ct == Session}
 
-			c.hub.broadcast <- message{sender: *c, data: data, mt: mt}
+			if c.ct == Shell && !c.address(&m) {
+				continue
+			}
+
+			c.hub.publish(m, nil)
 
 		}
 	}
@@ -267,7 +517,7 @@ func (c *Client) writePump(closed <-chan struct{}, cancelled <-chan struct{}) {
 
 			if !ok {
 				// The hub closed the channel.
-				err := c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				err := c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage)
 				if err != nil {
 					// this error not important as channel is closed or closing anyway
 					log.Tracef("writePump closeMessage error: %s", err.Error())
@@ -275,51 +525,28 @@ func (c *Client) writePump(closed <-chan struct{}, cancelled <-chan struct{}) {
 				return
 			}
 
-			if c.canRead { //only send if authorised to read
-
-				w, err := c.conn.NextWriter(message.mt)
-				if err != nil {
-					return
-				}
-
-				n, err := w.Write(message.data)
-
-				if err != nil {
-					log.Tracef("writePump writing error: %v", err)
-				}
-
-				size := len(message.data)
-
-				if err == nil && n != size {
-					log.Errorf("writePump incomplete write %d of %d", n, size) //don't log this if already a writing error
-				}
-
-				// Add queued chunks to the current websocket message, without delimiter.
-				// TODO check what impact, if any, this has on jsmpeg memory requirements
-				// when crossbar is loaded enough to cause message queuing
-				// TODO benchmark effect of loading on message queuing
-				m := len(c.send)
-				for i := 0; i < m; i++ {
-					followOnMessage := <-c.send
-
-					n, err := w.Write(followOnMessage.data)
-					if err != nil {
-						log.WithField("error", err.Error()).Error("writePump writing error for follow on message")
-					}
-
-					if err == nil && n != len(followOnMessage.data) {
-						log.WithFields(log.Fields{"wanted": size, "actual": n}).Error("writePump incomplete write")
-					}
-
-					size += n
-				}
-
-				log.Tracef("writePump wrote %d bytes", size)
+			if c.stale(message) {
+				continue
+			}
 
-				if err := w.Close(); err != nil {
+			if c.canRead { //only send if authorised to read
+				if err := c.writeQueued(message); err != nil {
 					return
 				}
 			}
+		case status := <-c.status:
+			// sent whether or not the client can read the topic
+			if err := c.writeJSON(status); err != nil {
+				return
+			}
+		case e := <-c.presences:
+			if err := c.writeJSON(e); err != nil {
+				return
+			}
+		case s := <-c.floorStatus:
+			if err := c.writeJSON(s); err != nil {
+				return
+			}
 		case <-ticker.C:
 			err := c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err != nil {
@@ -330,8 +557,13 @@ func (c *Client) writePump(closed <-chan struct{}, cancelled <-chan struct{}) {
 				return
 			}
 		case <-closed:
+			c.end("relay stopped")
 			return
 		case <-cancelled:
+			err := c.conn.WriteControl(websocket.CloseMessage, c.cancelMessage, time.Now().Add(writeWait))
+			if err != nil {
+				log.Tracef("writePump cancelMessage error: %s", err.Error())
+			}
 			return
 		}
 	}
@@ -340,73 +572,159 @@ func (c *Client) writePump(closed <-chan struct{}, cancelled <-chan struct{}) {
 // Hub maintains the set of active clients and broadcasts messages to the
 // clients.
 type Hub struct {
-	// Registered clients.
-	clients map[string]map[*Client]bool
+	// Registered clients, by connection type and topic.
+	clients map[topicKey]map[*Client]bool
 
 	// deny channel store
 	dcs *chanmap.Store
 
 	mu *sync.RWMutex
 
-	// Inbound messages from the clients.
-	broadcast chan message
+	// routes guards the dispatchers, and the snapshots of the clients on each topic
+	// and of the watchers that they distribute messages to. The snapshots are
+	// replaced, not changed, while holding mu, whenever clients are added or removed.
+	routes      *sync.RWMutex
+	dispatchers map[topicKey]*dispatcher
+	subscribers map[topicKey][]*Client
+	watching    []*Client
 
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// cluster links this hub to hubs on other nodes, if set
+	cluster *Cluster
+
+	// watchers are read-only clients subscribed to a topic pattern
+	watchers map[*Client]bool
+
+	// floors tracks control of floor-controlled topics, by topic
+	floors map[topicKey]*floor
+
+	// slots held under policies for session clients that are connecting, by topic
+	slots map[string]map[*slot]bool
+
+	// Floor commands from clients.
+	floor chan floorRequest
+
+	// recorder stores messages on topics matching the record patterns, if set
+	recorder *record.Store
+	record   []string
+
+	// recorded caches whether each topic is recorded
+	recorded sync.Map
+
+	// Drain requests, and whether the hub is draining
+	drain    chan struct{}
+	draining bool
+
+	// stopped is closed when the hub stops, so that nothing waits for it after
+	stopped chan struct{}
 }
 
 func New() *Hub {
 	return newHub()
 }
 
+// WithCluster links the hub to hubs on other nodes so they share one topic space.
+// It must be called before the hub is shared, because the hub reads its cluster
+// without locking.
+func (h *Hub) WithCluster(config ClusterConfig, audience string) *Hub {
+	h.cluster = NewCluster(config, audience, h)
+	return h
+}
+
+// WithRecorder records the messages on session topics matching the record patterns
+// to the store. It must be called before the hub is shared, because the hub reads
+// its recorder without locking.
+func (h *Hub) WithRecorder(rs *record.Store, record []string) *Hub {
+	h.recorder = rs
+	h.record = record
+	return h
+}
+
 func newHub() *Hub {
 	return &Hub{
-		mu:         &sync.RWMutex{},
-		broadcast:  make(chan message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[string]map[*Client]bool),
+		mu:          &sync.RWMutex{},
+		routes:      &sync.RWMutex{},
+		dispatchers: make(map[topicKey]*dispatcher),
+		subscribers: make(map[topicKey][]*Client),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		clients:     make(map[topicKey]map[*Client]bool),
+		watchers:    make(map[*Client]bool),
+		floors:      make(map[topicKey]*floor),
+		slots:       make(map[string]map[*slot]bool),
+		floor:       make(chan floorRequest),
+		drain:       make(chan struct{}),
+		stopped:     make(chan struct{}),
 	}
 }
 
+// GetClientReports returns reports on the clients connected to this node,
+// and the latest reports from the other nodes, if clustered
 func (h *Hub) GetClientReports() []*ClientReport {
 
+	reports := h.localReports()
+
+	if h.cluster != nil {
+		reports = append(reports, h.cluster.reports()...)
+	}
+
+	return reports
+}
+
+// localReports returns reports on the clients connected to this node
+func (h *Hub) localReports() []*ClientReport {
+
 	var reports []*ClientReport
 
+	node := ""
+	if h.cluster != nil {
+		node = h.cluster.config.NodeID
+	}
+
 	h.mu.RLock()
 	for _, topic := range h.clients {
 		for client := range topic {
+			reports = append(reports, client.report(node))
+		}
+	}
+	h.mu.RUnlock()
+	return reports
 
-			ca, err := time.Unix(client.connectedAt, 0).UTC().MarshalText()
-			if err != nil {
-				log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "connectedAt": client.connectedAt}).Error("stats cannot marshal connectedAt time to string")
-			}
-			ea, err := time.Unix(client.expiresAt, 0).UTC().MarshalText()
-			if err != nil {
-				log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "expiresAt": client.expiresAt}).Error("stats cannot marshal expiresAt time to string")
-			}
-
-			report := &ClientReport{
-				Topic:       client.topic,
-				CanRead:     client.canRead,
-				CanWrite:    client.canWrite,
-				ConnectedAt: string(ca),
-				ExpiresAt:   string(ea),
-				RemoteAddr:  client.remoteAddr,
-				Scopes:      client.scopes,
-				UserAgent:   client.userAgent,
-			}
+}
 
-			reports = append(reports, report)
+// report returns a report on the client's connection to the node
+func (c *Client) report(node string) *ClientReport {
 
-		} //for client in topic
-	} // for topic in hub
-	h.mu.RUnlock()
-	return reports
+	ca, err := time.Unix(c.connectedAt, 0).UTC().MarshalText()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic, "connectedAt": c.connectedAt}).Error("stats cannot marshal connectedAt time to string")
+	}
+	expiresAt := atomic.LoadInt64(&c.expiresAt)
+	ea, err := time.Unix(expiresAt, 0).UTC().MarshalText()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic, "expiresAt": expiresAt}).Error("stats cannot marshal expiresAt time to string")
+	}
 
+	return &ClientReport{
+		BookingID:   c.bookingID,
+		Topic:       c.topic,
+		CanRead:     c.canRead,
+		CanWrite:    c.canWrite,
+		ConnectedAt: string(ca),
+		Dropped:     c.drops.report(),
+		ExpiresAt:   string(ea),
+		ID:          c.name,
+		Node:        node,
+		RemoteAddr:  c.remoteAddr,
+		Scopes:      c.scopes,
+		Stats:       c.stats.Report(),
+		UserAgent:   c.userAgent,
+	}
 }
 
 // SetDenyChannelStore adds a pointer to the channel map store to the hub
@@ -414,53 +732,264 @@ func (h *Hub) SetDenyChannelStore(dcs *chanmap.Store) {
 	h.dcs = dcs
 }
 
-func (h *Hub) run() {
+// run handles the hub's requests until closed, then stops the dispatchers
+func (h *Hub) run(closed <-chan struct{}) {
+
+	defer close(h.stopped)
+
 	for {
 		select {
+		case <-closed:
+			return
 		case client := <-h.register:
 			h.mu.Lock()
-			if _, ok := h.clients[client.topic]; !ok {
-				h.clients[client.topic] = make(map[*Client]bool)
+			h.freeSlot(client.slot)
+			key := client.key()
+			if _, ok := h.clients[key]; !ok {
+				h.clients[key] = make(map[*Client]bool)
+			}
+			if client.isHost {
+				h.replaceShellHost(key)
+			}
+			h.clients[key][client] = true
+			if client.ct == Shell && !client.isHost && !h.shellHost(key) {
+				// the host left while the client was connecting, so it does not join
+				h.evict(client, hostGoneCloseMessage)
+				h.mu.Unlock()
+				continue
+			}
+			if client.watching {
+				h.watchers[client] = true
+			}
+			h.route(client)
+			h.joinFloor(client)
+			if h.draining && client.conn != nil {
+				// connected while the relay was draining
+				h.evict(client, drainCloseMessage)
+			} else {
+				h.announcePresence(client, "join")
 			}
-			h.clients[client.topic][client] = true
 			h.mu.Unlock()
+			client.registered = true
+			client.countConnection(1)
 			err := h.dcs.Add(client.bookingID, client.name, client.denied)
 			if err != nil {
 				log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "booking_id": client.bookingID}).Warning("deny channel not added on client register")
 			}
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client.topic]; ok {
-				delete(h.clients[client.topic], client)
-				close(client.send)
+			key := client.key()
+			if _, ok := h.clients[key][client]; ok {
+				delete(h.clients[key], client)
+				delete(h.watchers, client)
+				h.route(client)
+				client.closeSend()
+				h.announcePresence(client, "leave")
+				h.leaveShell(client)
 			}
+			if len(h.clients[key]) == 0 {
+				delete(h.clients, key)
+			}
+			h.leaveFloor(client)
 			h.mu.Unlock()
+			if !client.registered {
+				continue // it was turned away when it registered
+			}
+			client.registered = false
+			client.countConnection(-1)
 			err := h.dcs.DeleteChild(client.name) // no need to close, not denied
 			if err != nil {
 				log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "booking_id": client.bookingID}).Warning("deny channel not deleted on client unregister")
 			}
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			topic := message.sender.topic
-			for client := range h.clients[topic] {
-				if client.name != message.sender.name {
-					select {
-					case client.send <- message:
-					default:
-						log.WithFields(log.Fields{
-							"topic":          client.topic,
-							"name":           client.name,
-							"remote address": client.remoteAddr,
-							"user agent":     client.userAgent,
-						}).Error("message not sent because client.send was blocked")
+		case <-h.drain:
+			h.mu.Lock()
+			h.draining = true
+			for _, topic := range h.clients {
+				for client := range topic {
+					if client.conn != nil { // internal clients have no connection
+						h.evict(client, drainCloseMessage)
 					}
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+		case r := <-h.floor:
+			h.mu.Lock()
+			h.handleFloor(r)
+			h.mu.Unlock()
 		}
 	}
 }
 
+// distribute sends a message to every client on the sender's topic, except the sender,
+// and to any watchers whose pattern matches a session topic, using the snapshots of
+// the clients so that the caller need not hold the lock. It returns any clients that
+// must be disconnected because they could not keep up, which the caller must evict
+// while holding the lock.
+func (h *Hub) distribute(message message) []*Client {
+	var slow []*Client
+	topic := message.sender.topic
+
+	h.routes.RLock()
+	clients := h.subscribers[message.sender.key()]
+	watchers := h.watching
+	h.routes.RUnlock()
+
+	h.prepare(&message, clients)
+
+	for _, client := range clients {
+		if client.receives(message) {
+			if h.deliver(client, message) {
+				slow = append(slow, client)
+			}
+		}
+	}
+
+	if !message.session || len(watchers) == 0 {
+		return slow
+	}
+
+	var matched []*Client
+
+	for _, client := range watchers {
+		if MatchTopic(client.topic, topic) {
+			matched = append(matched, client)
+		}
+	}
+
+	if len(matched) == 0 {
+		return slow
+	}
+
+	// prefix once, for all the watchers
+	watched := message
+	watched.data = make([]byte, 0, len(topic)+1+len(message.data))
+	watched.data = append(append(append(watched.data, topic...), '\n'), message.data...)
+	watched.prepared = nil
+
+	h.prepare(&watched, matched)
+
+	for _, client := range matched {
+		if h.deliver(client, watched) {
+			slow = append(slow, client)
+		}
+	}
+
+	return slow
+}
+
+// receives reports whether the client is sent the message: on session topics, every
+// client but the sender is; on shell topics, the host is sent the messages from clients,
+// and each client only the messages that the host sends to its connection ID
+func (c *Client) receives(m message) bool {
+	switch {
+	case c.ct == Session:
+		return c.name != m.sender.name
+	case m.to == "":
+		return c.isHost
+	default:
+		return c.connectionID == m.to
+	}
+}
+
+// deliver queues a message for a client, and returns true if the
+// client must be disconnected because it could not keep up
+func (h *Hub) deliver(client *Client, message message) bool {
+	client.guard.RLock()
+	defer client.guard.RUnlock()
+
+	if client.guard.closed {
+		return false // the client has left
+	}
+
+	select {
+	case client.send <- message:
+		return false
+	default:
+		return h.full(client, message)
+	}
+}
+
+// evict removes a client from the hub, and closes its send channel so that
+// its writePump closes the websocket connection with the closeMessage, which
+// may be nil, then announces that it has left. The caller must hold the lock.
+func (h *Hub) evict(client *Client, closeMessage []byte) {
+	if _, ok := h.clients[client.key()][client]; ok {
+		delete(h.clients[client.key()], client)
+		delete(h.watchers, client)
+		h.route(client)
+		client.end(closeReason(closeMessage))
+		client.closeMessage = closeMessage
+		client.closeSend()
+		h.announcePresence(client, "leave")
+		h.leaveShell(client)
+	}
+}
+
+// drainCloseMessage is sent to clients when the relay is draining, so they reconnect elsewhere
+var drainCloseMessage = websocket.FormatCloseMessage(websocket.CloseGoingAway, "relay restarting, please reconnect")
+
+// Drain closes every client's connection with going away, and closes any
+// connections registered afterwards, so that clients reconnect to another relay.
+func (h *Hub) Drain() {
+	select {
+	case h.drain <- struct{}{}:
+	case <-h.stopped:
+	}
+}
+
+// join registers the client with the hub, unless the hub has stopped
+func (h *Hub) join(c *Client) {
+	select {
+	case h.register <- c:
+	case <-h.stopped:
+	}
+}
+
+// leave unregisters the client from the hub, unless the hub has stopped
+func (h *Hub) leave(c *Client) {
+	select {
+	case h.unregister <- c:
+	case <-h.stopped:
+	}
+}
+
+// countConnection adjusts the connection metrics for each of the client's scopes
+func (c *Client) countConnection(delta float64) {
+
+	var scopes []string
+
+	switch {
+	case c.isHost:
+		scopes = []string{"host"}
+	case c.ct == Shell:
+		scopes = []string{"client"}
+	default:
+		if c.canRead {
+			scopes = append(scopes, "read")
+		}
+		if c.canWrite {
+			scopes = append(scopes, "write")
+		}
+	}
+
+	for _, scope := range scopes {
+		metrics.Connections.WithLabelValues(c.topic, scope).Add(delta)
+	}
+}
+
+// Close codes sent to clients whose connection is closed by the relay during a session,
+// in the range for applications, following the HTTP status for the same reason
+const (
+	// CloseExpired is sent when the client's token expires
+	CloseExpired = 4000 + http.StatusUnauthorized
+
+	// CloseDenied is sent when the client's booking is cancelled
+	CloseDenied = 4000 + http.StatusForbidden
+
+	// CloseDisconnected is sent when an admin disconnects the client
+	CloseDisconnected = 4000 + http.StatusGone
+)
+
 // ConnectionType represents whether the connection is session, shell, or unsupported
 type ConnectionType int
 
@@ -471,6 +1000,18 @@ const (
 	Unsupported
 )
 
+// topicKey identifies a topic in the hub by connection type as well as name, so that
+// session and shell connections to a topic of the same name never exchange messages
+type topicKey struct {
+	ct    ConnectionType
+	topic string
+}
+
+// key returns the client's topic in the hub
+func (c *Client) key() topicKey {
+	return topicKey{ct: c.ct, topic: c.topic}
+}
+
 // serveWs handles websocket requests from clients.
 func serveWs(closed <-chan struct{}, w http.ResponseWriter, r *http.Request, config Config) {
 
@@ -486,7 +1027,7 @@ func serveWs(closed <-chan struct{}, w http.ResponseWriter, r *http.Request, con
 		ct = Session
 	}
 	if prefix == "shell" {
-		ct = Unsupported //TODO implement shell!
+		ct = Shell
 	}
 
 	if ct == Unsupported {
@@ -495,13 +1036,28 @@ func serveWs(closed <-chan struct{}, w http.ResponseWriter, r *http.Request, con
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.WithFields(log.Fields{"path": path, "error": err.Error()}).Error("new connection failed to upgrade to websocket")
+	remoteAddr := config.Proxies.RemoteAddr(r)
+
+	if !config.connects.Allow(remoteAddr) {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		log.WithFields(log.Fields{"source": remoteAddr, "path": path}).Warn("new connection rejected because source exceeded connection rate limit")
+		metrics.RateLimited.WithLabelValues("connect").Inc()
 		return
 	}
 
-	log.WithFields(log.Fields{"topic": topic}).Debug("new connection upgraded to websocket") //Cannot return any http responses from here on
+	// refuse counts and audits connections that are refused for their code or token
+	refuse := func(bookingID, reason string) {
+		metrics.ExchangeFailed(reason)
+		config.Audit.Add(audit.Event{
+			Event:          audit.Refused,
+			BookingID:      bookingID,
+			Topic:          topic,
+			ConnectionType: prefix,
+			RemoteAddr:     remoteAddr,
+			UserAgent:      r.UserAgent(),
+			Reason:         reason,
+		})
+	}
 
 	// Enforce permissions by exchanging the authcode for a connection ticket
 	// which contains expiry time, route, and permissions
@@ -513,6 +1069,8 @@ func serveWs(closed <-chan struct{}, w http.ResponseWriter, r *http.Request, con
 	// if no code or empty, return 401
 	if code == "" {
 		log.WithFields(log.Fields{"topic": topic}).Error("unauthorized because no code")
+		refuse("", "no code")
+		http.Error(w, "no code", http.StatusUnauthorized)
 		return
 	}
 
@@ -522,128 +1080,247 @@ func serveWs(closed <-chan struct{}, w http.ResponseWriter, r *http.Request, con
 
 	if err != nil {
 		log.WithFields(log.Fields{"error": err.Error(), "topic": topic, "booking_id": token.BookingID}).Error("unauthorized because invalid code")
+		refuse(token.BookingID, "invalid code")
+		http.Error(w, "invalid code", http.StatusUnauthorized)
 		return
 	}
 
 	// if debugging, we want to show the token
 	log.WithFields(log.Fields{"topic": topic, "token": util.Compact(token)}).Debug("code exchanged ok")
 
-	// check token is a permission token so we can process it properly
-	// It's been validated so we don't need to re-do that
-	if !permission.HasRequiredClaims(token) {
-		log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID}).Error("unauthorized because token missing claims")
+	if refused := checkToken(config, token, config.Audience, prefix, topic); refused != nil {
+		log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "reason": refused.reason}).Error("unauthorized because " + refused.message)
+		refuse(token.BookingID, refused.reason)
+		http.Error(w, refused.message, refused.status)
 		return
 	}
 
-	now := config.CodeStore.GetTime()
+	// check permissions
 
-	if token.NotBefore.After(time.Unix(now, 0)) {
-		log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID}).Error("unauthorized because too early")
-		return
+	var canRead, canWrite, isAdmin, isHost, isClient bool
+
+	for _, scope := range token.Scopes {
+		switch scope {
+		case "read":
+			canRead = true
+		case "write":
+			canWrite = true
+		case "host":
+			isHost = true
+		case "client":
+			isClient = true
+		case "admin":
+			isAdmin = true
+		}
 	}
 
-	ttl := token.ExpiresAt.Unix() - now
+	var connectionID string
+	var watching bool
+
+	policy := Policy{Pattern: "**"}
 
-	audok := false
+	// held is the slot held for the client under the policy, if any
+	var held *slot
 
-	for _, aud := range token.Audience {
-		if aud == config.Audience {
-			audok = true
+	switch ct {
+
+	case Session:
+
+		isHost = false
+
+		if !canRead && !canWrite {
+			log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "scopes": token.Scopes}).Error("unauthorized because no valid scopes in token")
+			refuse(token.BookingID, "no valid scopes")
+			http.Error(w, "no valid scopes", http.StatusForbidden)
+			return
 		}
-	}
 
-	topicBad := (topic != token.Topic)
-	expired := ttl < 0
+		if IsPattern(topic) {
+			if canWrite || !canRead {
+				log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "scopes": token.Scopes}).Error("unauthorized because topic patterns need a read-only token")
+				refuse(token.BookingID, "write to pattern")
+				http.Error(w, "topic patterns need a read-only token", http.StatusForbidden)
+				return
+			}
+			watching = true
+		}
 
-	if (!audok) || topicBad || expired {
-		log.WithFields(log.Fields{"audience_ok": audok, "topic_ok": !topicBad, "expired": expired, "topic": topic, "booking_id": token.BookingID}).Error("unauthorized because token invalid")
-		return
-	}
+		if !watching {
 
-	// we must check the booking is not denied here, else a user could request access, get a code, cancel booking, then use code to start a connection
-	if config.DenyStore.IsDenied(token.BookingID) {
-		log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID}).Error("unauthorized because booking_id is deny listed")
-		return
-	}
+			policy = config.Policies.For(topic)
 
-	// check permissions
+			var reason string
+			if held, reason = config.Hub.admit(policy, topic, token.BookingID, canWrite); reason != "" {
+				log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "policy": policy.Pattern}).Warn("new connection rejected because " + reason)
+				refuse(token.BookingID, reason)
+				http.Error(w, reason, http.StatusTooManyRequests)
+				return
+			}
+		}
 
-	var canRead, canWrite bool
+	case Shell:
 
-	for _, scope := range token.Scopes {
-		if scope == "read" {
-			canRead = true
+		if isHost == isClient {
+			log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "scopes": token.Scopes}).Error("unauthorized because shell token needs one of host or client scope")
+			refuse(token.BookingID, "no valid scopes")
+			http.Error(w, "shell token needs one of host or client scope", http.StatusForbidden)
+			return
 		}
-		if scope == "write" {
-			canWrite = true
+
+		if isClient {
+
+			if !config.Hub.hasShellHost(topic) {
+				log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID}).Error("shell client rejected because no host is connected")
+				refuse(token.BookingID, "no host")
+				http.Error(w, "no host connected", http.StatusNotFound)
+				return
+			}
+
+			// each client's messages are tagged with its own connection ID,
+			// so the host can keep the clients' streams separate
+			connectionID = uuid.New().String()
 		}
+
+		canRead = true
+		canWrite = true
 	}
 
-	if !canRead && !canWrite {
-		log.WithFields(log.Fields{"topic": topic, "booking_id": token.BookingID, "scopes": token.Scopes}).Error("unauthorized because no valid scopes in token")
+	metrics.ExchangeOK()
+
+	u := upgrader
+	u.EnableCompression = policy.Compress
+
+	conn, err := framing.Upgrade(&u, w, r, nil)
+	if err != nil {
+		log.WithFields(log.Fields{"path": path, "error": err.Error()}).Error("new connection failed to upgrade to websocket")
+		config.Hub.cancelSlot(held)
 		return
 	}
 
+	log.WithFields(log.Fields{"topic": topic}).Debug("new connection upgraded to websocket") //Cannot return any http responses from here on
+
 	cancelled := make(chan struct{})
 	denied := make(chan struct{})
 
-	if ct == Session {
-
-		// Create a client
-		client := &Client{hub: config.Hub,
-			bookingID:   token.BookingID,
-			conn:        conn,
-			denied:      denied,
-			connectedAt: time.Now().Unix(),
-			expiresAt:   (*token.ExpiresAt).Unix(), // jwt.NumericDate underlying type is time.Time
-			send:        make(chan message, int(config.BufferSize)),
-			topic:       topic,
-			name:        uuid.New().String(),
-			userAgent:   r.UserAgent(),
-			remoteAddr:  r.Header.Get("X-Forwarded-For"),
-			audience:    config.Audience,
-			canRead:     canRead,
-			canWrite:    canWrite,
-			scopes:      token.Scopes,
-		}
-		client.hub.register <- client
-
-		cf := log.Fields{
-			"booking_id":   token.BookingID,
-			"connected_at": time.Unix(client.connectedAt, 0).String(),
-			"expires_at":   time.Unix(client.expiresAt, 0).String(),
-			"topic":        topic,
-			"stats":        true,
-			"buffer_size":  config.BufferSize,
-			"name":         uuid.New().String(),
-			"user_agent":   r.UserAgent(),
-			"remote_addr":  r.Header.Get("X-Forwarded-For"),
-			"audience":     config.Audience,
-			"can_read":     canRead,
-			"can_write":    canWrite,
-			"scopes":       token.Scopes,
-		}
+	bufferSize := config.BufferSize
 
-		log.WithFields(cf).Infof("new connection")
+	if policy.BufferSize > 0 {
+		bufferSize = policy.BufferSize
+	}
 
-		// cancel the connection when the token has expired or when session is curtailed
-		go func() {
+	// Create a client
+	client := &Client{hub: config.Hub,
+		bookingID:    token.BookingID,
+		conn:         conn,
+		denied:       denied,
+		connectedAt:  time.Now().Unix(),
+		expiresAt:    (*token.ExpiresAt).Unix(), // jwt.NumericDate underlying type is time.Time
+		send:         make(chan message, int(bufferSize)),
+		guard:        &sendGuard{},
+		topic:        topic,
+		ct:           ct,
+		name:         uuid.New().String(),
+		userAgent:    r.UserAgent(),
+		remoteAddr:   remoteAddr,
+		audience:     config.Audience,
+		canRead:      canRead,
+		canWrite:     canWrite,
+		scopes:       token.Scopes,
+		connectionID: connectionID,
+		isHost:       isHost,
+		watching:     watching,
+		floored:      ct == Session && !watching && matchAny(config.FloorControl, topic),
+		isAdmin:      isAdmin,
+		policy:       policy,
+		slot:         held,
+		messages:     config.LimitMessages.NewLimiter(),
+		bytes:        config.LimitBytes.NewLimiter(),
+		stats:        NewStats(),
+		drops:        &drops{},
+		cancelled:    cancelled,
+		gone:         make(chan struct{}),
+		status:       make(chan SessionStatus, 4),
+		refreshes:    make(chan SessionCommand),
+		presences:    make(chan PresenceEvent, int(config.BufferSize)),
+		floorStatus:  make(chan FloorStatus, 1),
+	}
 
-			select {
-			case <-time.After(time.Duration(ttl) * time.Second):
-				log.WithFields(cf).WithField("reason", "token expired").Info("connection closed")
-			case <-denied:
-				log.WithFields(cf).WithField("reason", "token denied").Info("connection closed")
-			}
+	// only clients that requested it are sent session status, or can refresh,
+	// or are told about other clients joining and leaving
+	client.presence = conn.Subprotocol() == PresenceProtocol
+	client.sessionProtocol = conn.Subprotocol() == SessionProtocol || client.presence
 
-			close(cancelled)
-		}()
+	client.compress = policy.Compress && offersCompression(r)
 
-		go client.writePump(closed, cancelled)
-		go client.readPump()
-		return
+	// clients can ask for message framing, whatever their topic's policy
+	client.framing = policy.framing()
+	if conn.Subprotocol() == MessageProtocol {
+		client.framing = FramingMessage
+	}
+
+	client.audit = config.Audit
+	client.ending = &ending{}
+
+	client.slow = slowConsumerRule(config.SlowConsumer, client.topic)
+
+	if isHost {
+		// the host's messages from each client must stay apart, whatever the policy
+		client.framing = FramingMessage
+	}
+
+	client.hub.join(client)
+
+	if ct == Shell && isClient {
+		// tell the host about the client before any of its messages
+		client.hub.publish(client.connectionAction("connect"), nil)
+	}
+
+	cf := log.Fields{
+		"booking_id":    token.BookingID,
+		"connected_at":  time.Unix(client.connectedAt, 0).String(),
+		"expires_at":    time.Unix(client.expiresAt, 0).String(),
+		"topic":         topic,
+		"stats":         true,
+		"buffer_size":   bufferSize,
+		"name":          client.name,
+		"user_agent":    r.UserAgent(),
+		"remote_addr":   remoteAddr,
+		"audience":      config.Audience,
+		"can_read":      canRead,
+		"can_write":     canWrite,
+		"scopes":        token.Scopes,
+		"connection_id": connectionID,
+	}
+
+	log.WithFields(cf).Infof("new connection")
+
+	client.auditConnect(prefix, remoteAddr)
+
+	// cancel the connection when the token has expired or when session is curtailed
+	go client.expire(config, cancelled, cf)
+
+	go client.writePump(closed, cancelled)
+	go client.readPump()
+
+}
+
+// writeJSON writes v to the websocket connection as a text message, returning an
+// error if the connection has failed. It must only be called from writePump.
+func (c *Client) writeJSON(v interface{}) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic}).Errorf("%T not marshalled", v)
+		return nil
+	}
+
+	err = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err != nil {
+		log.Errorf("writePump deadline error: %s", err.Error())
+		return err
 	}
 
+	return c.conn.WriteMessage(websocket.TextMessage, data)
 }
 
 // StatsClient starts a routine which sends stats reports on demand.
@@ -652,6 +1329,7 @@ func statsClient(closed <-chan struct{}, wg *sync.WaitGroup, config Config) {
 	client := &Client{hub: config.Hub,
 		connectedAt: time.Now().Unix(),
 		send:        make(chan message, 256),
+		guard:       &sendGuard{},
 		topic:       "stats",
 		name:        "stats-generator-" + uuid.New().String(),
 		audience:    config.Audience,
@@ -660,8 +1338,10 @@ func statsClient(closed <-chan struct{}, wg *sync.WaitGroup, config Config) {
 		canRead:     true,
 		canWrite:    true,
 		scopes:      []string{"read", "stats", "write"},
+		stats:       NewStats(),
+		drops:       &drops{},
 	}
-	client.hub.register <- client
+	client.hub.join(client)
 
 	go client.statsReporter(closed, wg, config.StatsEvery)
 
@@ -692,6 +1372,8 @@ func (c *Client) statsReporter(closed <-chan struct{}, wg *sync.WaitGroup, stats
 				return //send is closed, so we are finished
 			}
 
+			c.stats.tx.add(len(msg.data))
+
 			err := json.Unmarshal(msg.data, &sc)
 
 			if err != nil {
@@ -713,6 +1395,8 @@ func (c *Client) statsReporter(closed <-chan struct{}, wg *sync.WaitGroup, stats
 					return //send is closed, so we are finished
 				}
 
+				c.stats.tx.add(len(msg.data))
+
 				err = json.Unmarshal(msg.data, &sc)
 
 				if err != nil {
@@ -736,47 +1420,34 @@ func (c *Client) statsReporter(closed <-chan struct{}, wg *sync.WaitGroup, stats
 			log.Trace("StatsReporter routine send...")
 		}
 
-		var reports []*ClientReport
-		c.hub.mu.RLock()
-		for _, topic := range c.hub.clients {
-			for client := range topic {
+		reports := c.hub.GetClientReports()
 
-				ca, err := time.Unix(client.connectedAt, 0).UTC().MarshalText()
-				if err != nil {
-					log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "connectedAt": client.connectedAt}).Error("stats cannot marshal connectedAt time to string")
-				}
-				ea, err := time.Unix(client.expiresAt, 0).UTC().MarshalText()
-				if err != nil {
-					log.WithFields(log.Fields{"error": err.Error(), "topic": client.topic, "expiresAt": client.expiresAt}).Error("stats cannot marshal expiresAt time to string")
-				}
-
-				report := &ClientReport{
-					Topic:       client.topic,
-					CanRead:     client.canRead,
-					CanWrite:    client.canWrite,
-					ConnectedAt: string(ca),
-					ExpiresAt:   string(ea),
-					RemoteAddr:  client.remoteAddr,
-					Scopes:      client.scopes,
-					UserAgent:   client.userAgent,
-				}
-
-				reports = append(reports, report)
-
-			} //for client in topic
-		} // for topic in hub
-		c.hub.mu.RUnlock()
 		reportsData, err := json.Marshal(reports)
 		if err != nil {
 			log.WithField("error", err).Error("statsReporter marshalling JSON")
 			return
 		}
 		// broadcast stats back to the hub (i.e. and anyone listening to this topic)
-		c.hub.broadcast <- message{sender: *c, data: reportsData, mt: websocket.TextMessage}
+		c.stats.rx.add(len(reportsData))
+		c.hub.publish(message{sender: c.from(), data: reportsData, mt: websocket.TextMessage, sent: time.Now()}, nil)
 
 	}
 }
 
+// withinLimits reports whether a message of the given size is within the client's rate limits
+func (c *Client) withinLimits(size int) bool {
+	now := time.Now()
+	if c.messages != nil && !c.messages.AllowN(now, 1) {
+		metrics.RateLimited.WithLabelValues("messages").Inc()
+		return false
+	}
+	if c.bytes != nil && !c.bytes.AllowN(now, size) {
+		metrics.RateLimited.WithLabelValues("bytes").Inc()
+		return false
+	}
+	return true
+}
+
 func slashify(path string) string {
 
 	//remove trailing slash (that's for directories)
@@ -790,55 +1461,76 @@ func slashify(path string) string {
 
 }
 
-func getConnectionTypeFromPath(path string) string {
+// MatchTopic reports whether a topic matches a pattern. Topics and patterns are
+// split into segments at slashes. Each segment of the pattern is matched using
+// path.Match, so * matches any part of a single segment, and the special
+// segment ** matches any number of segments (including none)
+// e.g. "pend00/*" matches "pend00/data", and "spinner/**" matches "spinner/a/b"
+func MatchTopic(pattern, topic string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(topic, "/"))
+}
 
-	re := regexp.MustCompile(`^\/([\w\%-]*)`)
+// IsPattern reports whether a topic contains any of the wildcards used by MatchTopic
+func IsPattern(topic string) bool {
+	return strings.ContainsAny(topic, "*?[")
+}
 
-	matches := re.FindStringSubmatch(path)
+func matchSegments(pattern, topic []string) bool {
 
-	if len(matches) < 2 {
-		return ""
-	}
+	for len(pattern) > 0 {
 
-	// matches[0] = "/{prefix}/"
-	// matches[1] = "{prefix}"
-	return matches[1]
-}
+		if pattern[0] == "**" {
+			// try every possible number of segments for ** to consume
+			for i := 0; i <= len(topic); i++ {
+				if matchSegments(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		}
 
-func getTopicFromPath(path string) string {
+		if len(topic) == 0 {
+			return false
+		}
 
-	re := regexp.MustCompile(`^\/[\w\%-]*\/([\w\%-\/]*)`)
-	matches := re.FindStringSubmatch(path)
+		ok, err := gopath.Match(pattern[0], topic[0])
 
-	if len(matches) < 2 {
-		return ""
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		topic = topic[1:]
 	}
 
-	return matches[1]
+	return len(topic) == 0
 }
 
-func getSessionIDFromPath(path string) string {
+func getConnectionTypeFromPath(path string) string {
+
+	re := regexp.MustCompile(`^\/([\w\%-]*)`)
 
-	re := regexp.MustCompile(`^\/[\w\%-]*\/([\w\%-]*)`)
 	matches := re.FindStringSubmatch(path)
 
 	if len(matches) < 2 {
 		return ""
 	}
 
+	// matches[0] = "/{prefix}/"
+	// matches[1] = "{prefix}"
 	return matches[1]
 }
 
-func getConnectionIDFromPath(path string) string {
+func getTopicFromPath(path string) string {
 
-	re := regexp.MustCompile(`^\/(?:([\w\%-]*)\/){2}([\w\%-]*)`)
+	re := regexp.MustCompile(`^\/[\w\%-]*\/([\w\%-\/]*)`)
 	matches := re.FindStringSubmatch(path)
 
 	if len(matches) < 2 {
 		return ""
 	}
 
-	return matches[2]
+	return matches[1]
 }
 
 const (
@@ -869,17 +1561,72 @@ const (
 // 4096 Bytes is the approx average message size
 // this number does not limit message size
 // So for key frames we just make a few more syscalls
-// null subprotocol required by Chrome
+// null subprotocol required by Chrome; PresenceProtocol, SessionProtocol or MessageProtocol is chosen for clients that request it
 // TODO restrict CheckOrigin
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
-	Subprotocols:    []string{"null"},
+	Subprotocols:    []string{PresenceProtocol, SessionProtocol, MessageProtocol, "null"},
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// shutdown stops the server accepting connections. Websocket connections
+// are hijacked, so they are not affected.
+func shutdown(h *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := h.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("ListenAndServe.Shutdown(): %s", err.Error())
+	}
+}
+
 func handleConnections(closed <-chan struct{}, parentwg *sync.WaitGroup, messagesFromMe chan message, deny chan string, config Config) {
 
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	mux := Handler(closed, &wg, deny, config)
+
+	addr := ":" + strconv.Itoa(config.Listen)
+
+	h := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		var err error
+		if config.Listener != nil {
+			err = h.Serve(config.Listener)
+		} else {
+			err = h.ListenAndServe()
+		}
+		// the listener is closed once handed over to a new process
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Errorf("ListenAndServe: %s ", err.Error()) //TODO upgrade to fatal once httptest is supported
+		}
+	}()
+
+	select {
+	case <-config.Drain:
+		// stop accepting connections first, so that none are missed
+		shutdown(h)
+		config.Hub.Drain()
+		log.Info("crossbar draining")
+		<-closed
+	case <-closed:
+		shutdown(h)
+	}
+
+	wg.Wait()
+	parentwg.Done()
+	log.Debug("handleConnections is done")
+}
+
+// Handler returns the handler for websocket connections to the crossbar, and for links
+// from other nodes if clustered, so that it can be served by the caller, e.g. on its own
+// mux. Connections with a booking ID sent on deny are closed. The caller must wg.Add(1)
+// beforehand, and wg.Done is called once the crossbar has stopped, after closed is closed.
+func Handler(closed <-chan struct{}, wg *sync.WaitGroup, deny chan string, config Config) http.Handler {
+
 	dcs := chanmap.New() // this is where the denied channels are stored, so we can close them if we get deny requests
 
 	go func() {
@@ -899,37 +1646,29 @@ func handleConnections(closed <-chan struct{}, parentwg *sync.WaitGroup, message
 
 	//hub := newHub() // shift this initialisation outside this function so we can share hub with access server for handling /status endpoint
 	config.Hub.SetDenyChannelStore(dcs)
-	go config.Hub.run()
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(closed, w, r, config)
-	})
-
-	var wg sync.WaitGroup
-	wg.Add(1)
 
-	go statsClient(closed, &wg, config)
+	config.connects = limit.NewKeyed(config.LimitConnect)
 
-	addr := ":" + strconv.Itoa(config.Listen)
+	mux := http.NewServeMux()
 
-	h := &http.Server{Addr: addr, Handler: nil}
+	if cluster := config.Hub.cluster; cluster != nil {
+		go cluster.Run(closed)
+		mux.HandleFunc("/cluster/", func(w http.ResponseWriter, r *http.Request) {
+			cluster.serveCluster(closed, w, r)
+		})
+	}
 
+	wg.Add(1)
 	go func() {
-		if err := h.ListenAndServe(); err != nil {
-			log.Errorf("ListenAndServe: %s ", err.Error()) //TODO upgrade to fatal once httptest is supported
-		}
+		defer wg.Done()
+		config.Hub.run(closed)
 	}()
 
-	<-closed
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		serveWs(closed, w, r, config)
+	})
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := h.Shutdown(ctx)
-	if err != nil {
-		log.Errorf("ListenAndServe.Shutdown(): %s", err.Error())
-	}
+	go statsClient(closed, wg, config)
 
-	wg.Wait()
-	parentwg.Done()
-	log.Debug("handleConnections is done")
+	return mux
 }
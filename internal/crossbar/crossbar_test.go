@@ -6,19 +6,29 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/chanmap"
 	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/metrics"
 	"github.com/practable/relay/internal/permission"
 	"github.com/practable/relay/internal/reconws"
 	"github.com/practable/relay/internal/ttlcode"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -116,6 +126,9 @@ func TestCrossbar(t *testing.T) {
 
 	time.Sleep(timeout)
 
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.Connections.WithLabelValues(session, "read")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.Connections.WithLabelValues(session, "write")))
+
 	data := []byte("foo")
 
 	s0.Out <- reconws.WsMessage{Data: data, Type: websocket.TextMessage}
@@ -157,8 +170,12 @@ func TestCrossbar(t *testing.T) {
 		assert.NoError(t, err)
 
 		agents := make(map[string]int)
+		sent := false
 
 		for _, report := range reports {
+			if report.Topic == session && report.Stats.Rx.Last != "never" {
+				sent = true
+			}
 			count, ok := agents[report.Topic]
 			if !ok {
 				agents[report.Topic] = 1
@@ -168,6 +185,8 @@ func TestCrossbar(t *testing.T) {
 			agents[report.Topic] = count + 1
 		}
 
+		assert.True(t, sent, "stats should show a message was received from a client")
+
 		if agents[session] == 2 {
 			t.Log("TestGetClientReports...PASS")
 		} else {
@@ -207,26 +226,20 @@ func TestCrossbar(t *testing.T) {
 	// *** TestCannotConnectWithReusedCode ***
 	// try the last test again, without getting new codes
 
-	ctx, cancel = context.WithCancel(context.Background())
+	invalid := testutil.ToFloat64(metrics.CodeExchanges.WithLabelValues("failed", "invalid code"))
 
-	go func() {
-		err := s0.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code0)
-		assert.NoError(t, err)
-	}()
+	ctx, cancel = context.WithCancel(context.Background())
 
-	go func() {
-		err := s1.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code1)
-		assert.NoError(t, err)
-	}()
+	// codes are checked before upgrading, so the dial fails
+	err = s0.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code0)
+	assert.Error(t, err)
 
-	time.Sleep(timeout)
+	err = s1.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code1)
+	assert.Error(t, err)
 
-	s0.Out <- reconws.WsMessage{Data: data, Type: websocket.TextMessage}
+	assert.Equal(t, invalid+2, testutil.ToFloat64(metrics.CodeExchanges.WithLabelValues("failed", "invalid code")))
 
-	select {
-	case <-s1.In:
-		t.Fatal("TestCannotConnectWithReusedCode...FAIL")
-	case <-time.After(timeout):
+	if err != nil {
 		t.Log("TestCannotConnectWithReusedCode...PASS")
 	}
 	cancel()
@@ -337,10 +350,9 @@ func TestCrossbar(t *testing.T) {
 		assert.NoError(t, err)
 	}()
 
-	go func() {
-		err := s1.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code1)
-		assert.NoError(t, err)
-	}()
+	// the audience is checked before upgrading, so the dial fails
+	err = s1.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+code1)
+	assert.Error(t, err)
 
 	time.Sleep(timeout)
 
@@ -365,22 +377,14 @@ func TestCrossbar(t *testing.T) {
 
 	ctx, cancel = context.WithCancel(context.Background())
 
-	go func() {
-		err := s0.Dial(ctx, audience+"/"+ct+"/notMySession?code="+code0)
-		assert.NoError(t, err)
-	}()
+	// the topic is checked before upgrading, so neither dial succeeds
+	err = s0.Dial(ctx, audience+"/"+ct+"/notMySession?code="+code0)
+	assert.Error(t, err)
 
-	go func() {
-		err := s1.Dial(ctx, audience+"/"+ct+"/notMySession?code="+code1)
-		assert.NoError(t, err)
-	}()
+	err = s1.Dial(ctx, audience+"/"+ct+"/notMySession?code="+code1)
+	assert.Error(t, err)
 
-	data = []byte("notgoingtogetthis")
-	s0.Out <- reconws.WsMessage{Data: data, Type: websocket.TextMessage}
-	select {
-	case <-s1.In:
-		t.Fatal("TestEnforceSessionID...FAIL")
-	case <-time.After(timeout):
+	if err != nil {
 		t.Log("TestEnforceSessionID...PASS")
 	}
 
@@ -442,6 +446,272 @@ func TestCrossbar(t *testing.T) {
 
 }
 
+func TestShell(t *testing.T) {
+
+	// Setup logging
+
+	debug := false
+	if debug {
+		log.SetLevel(log.TraceLevel)
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true, DisableColors: true})
+		defer log.SetOutput(os.Stdout)
+
+	} else {
+		var ignore bytes.Buffer
+		logignore := bufio.NewWriter(&ignore)
+		log.SetOutput(logignore)
+	}
+
+	// Setup crossbar
+
+	http.DefaultServeMux = new(http.ServeMux)
+
+	// setup crossbar on local (free) port
+	closed := make(chan struct{})
+	denied := make(chan string)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  ds,
+		Hub:        New(),
+		StatsEvery: time.Duration(time.Second),
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, denied, &wg)
+	// safety margin to get crossbar running
+	time.Sleep(time.Second)
+
+	var timeout = 100 * time.Millisecond
+
+	ct := "shell"
+	session := "ssh00"
+
+	// *** TestShellClientNeedsHost
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	clientToken := MakeTestToken(audience, ct, session, []string{"client"}, 5)
+
+	// the dial is refused before upgrading, because there is no host
+	c0 := reconws.New()
+	err = c0.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+cs.SubmitToken(clientToken))
+	assert.Error(t, err)
+
+	for _, r := range config.Hub.GetClientReports() {
+		assert.NotContains(t, r.Topic, session, "client should not be registered without a host")
+	}
+
+	cancel()
+	time.Sleep(timeout)
+
+	// *** TestShellHostGetsConnectAction
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	hostToken := MakeTestToken(audience, ct, session, []string{"host"}, 5)
+
+	h := reconws.New()
+	go func() {
+		err := h.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+cs.SubmitToken(hostToken))
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(timeout)
+
+	// a session connection to a topic of the same name is kept apart from the shell
+	sessionToken := MakeTestToken(audience, "session", session, []string{"read", "write"}, 5)
+
+	s0 := reconws.New()
+	go func() {
+		err := s0.Dial(ctx, audience+"/session/"+session+"?code="+cs.SubmitToken(sessionToken))
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(timeout)
+
+	c1 := reconws.New()
+	go func() {
+		err := c1.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+cs.SubmitToken(clientToken))
+		assert.NoError(t, err)
+	}()
+
+	var connect ConnectionAction
+
+	select {
+	case msg := <-h.In:
+		err := json.Unmarshal(msg.Data, &connect)
+		assert.NoError(t, err)
+		assert.Equal(t, "connect", connect.Action)
+		assert.NotEqual(t, "", connect.ID)
+		assert.Equal(t, "", connect.URI)
+	case <-time.After(timeout):
+		t.Fatal("TestShellHostGetsConnectAction...FAIL")
+	}
+
+	// *** TestShellSessionCannotJoinShell
+
+	select {
+	case <-s0.In:
+		t.Fatal("TestShellSessionCannotJoinShell...FAIL: session connection received the connect action")
+	case <-time.After(timeout):
+	}
+
+	s0.Out <- reconws.WsMessage{Data: []byte(connect.ID + "\ninjected"), Type: websocket.TextMessage}
+
+	select {
+	case <-h.In:
+		t.Fatal("TestShellSessionCannotJoinShell...FAIL: host received a session message")
+	case <-c1.In:
+		t.Fatal("TestShellSessionCannotJoinShell...FAIL: client received a session message")
+	case <-time.After(timeout):
+	}
+
+	// *** TestShellClientToHost
+
+	data := []byte("ls -la")
+	c1.Out <- reconws.WsMessage{Data: data, Type: websocket.BinaryMessage}
+
+	select {
+	case msg := <-h.In:
+		assert.Equal(t, connect.ID+"\nls -la", string(msg.Data))
+		assert.Equal(t, websocket.BinaryMessage, msg.Type)
+	case <-time.After(timeout):
+		t.Fatal("TestShellClientToHost...FAIL")
+	}
+
+	// *** TestShellHostToClient
+
+	h.Out <- reconws.WsMessage{Data: []byte(connect.ID + "\ntotal 0"), Type: websocket.BinaryMessage}
+
+	select {
+	case msg := <-c1.In:
+		assert.Equal(t, "total 0", string(msg.Data))
+	case <-time.After(timeout):
+		t.Fatal("TestShellHostToClient...FAIL")
+	}
+
+	select {
+	case <-s0.In:
+		t.Fatal("TestShellHostToClient...FAIL: session connection received a shell message")
+	case <-time.After(timeout):
+	}
+
+	// *** TestShellHostMessageWithoutID
+
+	h.Out <- reconws.WsMessage{Data: []byte("ignored"), Type: websocket.BinaryMessage}
+
+	select {
+	case <-c1.In:
+		t.Fatal("TestShellHostMessageWithoutID...FAIL")
+	case <-time.After(timeout):
+	}
+
+	// *** TestShellSeparateConnections
+
+	c2 := reconws.New()
+	go func() {
+		err := c2.Dial(ctx, audience+"/"+ct+"/"+session+"?code="+cs.SubmitToken(clientToken))
+		assert.NoError(t, err)
+	}()
+
+	var connect2 ConnectionAction
+
+	select {
+	case msg := <-h.In:
+		err := json.Unmarshal(msg.Data, &connect2)
+		assert.NoError(t, err)
+		assert.Equal(t, "connect", connect2.Action)
+		assert.NotEqual(t, connect.ID, connect2.ID)
+	case <-time.After(timeout):
+		t.Fatal("TestShellSeparateConnections...FAIL")
+	}
+
+	h.Out <- reconws.WsMessage{Data: []byte(connect2.ID + "\nwhoami"), Type: websocket.BinaryMessage}
+
+	select {
+	case msg := <-c2.In:
+		assert.Equal(t, "whoami", string(msg.Data))
+	case <-c1.In:
+		t.Fatal("TestShellSeparateConnections...FAIL")
+	case <-time.After(timeout):
+		t.Fatal("TestShellSeparateConnections...FAIL")
+	}
+
+	// *** TestShellHostMessagesStayApart
+
+	c1.Out <- reconws.WsMessage{Data: []byte("a"), Type: websocket.BinaryMessage}
+	c2.Out <- reconws.WsMessage{Data: []byte("b"), Type: websocket.BinaryMessage}
+
+	var got []string
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-h.In:
+			got = append(got, string(msg.Data))
+		case <-time.After(timeout):
+			t.Fatal("TestShellHostMessagesStayApart...FAIL")
+		}
+	}
+
+	assert.ElementsMatch(t, []string{connect.ID + "\na", connect2.ID + "\nb"}, got)
+
+	// *** TestShellHostGetsDisconnectAction
+
+	c1.Out <- reconws.WsMessage{Data: websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), Type: websocket.CloseMessage}
+
+	select {
+	case msg := <-h.In:
+		var disconnect ConnectionAction
+		err := json.Unmarshal(msg.Data, &disconnect)
+		assert.NoError(t, err)
+		assert.Equal(t, "disconnect", disconnect.Action)
+		assert.Equal(t, connect.ID, disconnect.ID)
+	case <-time.After(timeout):
+		t.Fatal("TestShellHostGetsDisconnectAction...FAIL")
+	}
+
+	// *** TestShellHostDisconnectsClient
+
+	h.Out <- reconws.WsMessage{Data: []byte(`{"action":"disconnect","uuid":"` + connect2.ID + `"}`), Type: websocket.TextMessage}
+
+	select {
+	case msg := <-h.In:
+		var disconnect ConnectionAction
+		err := json.Unmarshal(msg.Data, &disconnect)
+		assert.NoError(t, err)
+		assert.Equal(t, "disconnect", disconnect.Action)
+		assert.Equal(t, connect2.ID, disconnect.ID)
+	case <-time.After(timeout):
+		t.Fatal("TestShellHostDisconnectsClient...FAIL")
+	}
+
+	for _, r := range config.Hub.GetClientReports() {
+		assert.NotContains(t, r.Scopes, "client", "client should be disconnected")
+	}
+
+	cancel()
+	time.Sleep(timeout)
+
+	// Teardown crossbar
+	close(closed)
+	wg.Wait()
+
+}
+
 func BenchmarkSmallMessage(b *testing.B) {
 
 	// Setup logging
@@ -801,60 +1071,940 @@ func BenchmarkLargeRandomMessage(b *testing.B) {
 
 }
 
-func TestSlashify(t *testing.T) {
+func TestShellClientAfterHostLeft(t *testing.T) {
 
-	if slashify("foo") != "/foo" {
-		t.Errorf("Slashify not prefixing slash ")
-	}
-	if slashify("/foo") == "//foo" {
-		t.Errorf("Slashify prefixing additional slash")
-	}
-	if slashify("/foo/") != "/foo" {
-		t.Errorf("Slashify not removing trailing slash")
-	}
-	if slashify("foo/") != "/foo" {
-		t.Errorf("Slashify not both removing trailing slash AND prefixing slash")
+	closed := make(chan struct{})
+	defer close(closed)
+
+	h := New()
+	dcs := chanmap.New()
+	h.SetDenyChannelStore(dcs)
+	go h.run(closed)
+
+	topic := "shellgone00"
+
+	c := &Client{
+		ct:           Shell,
+		topic:        topic,
+		name:         "client0",
+		bookingID:    "bid0",
+		connectionID: "conn0",
+		denied:       make(chan struct{}),
+		send:         make(chan message, 1),
+		guard:        &sendGuard{},
+		drops:        &drops{},
+		stats:        NewStats(),
 	}
 
-	b := "foo/bar/rab/oof/"
-	if slashify(b) != "/foo/bar/rab/oof" {
-		t.Errorf("Slashify not coping with internal slashes %s -> %s", b, slashify(b))
-	}
+	// a client that registers after its host has left is closed, and does not join
+	h.register <- c
 
-}
+	_, ok := <-c.send
+	assert.False(t, ok)
 
-func TestGetConnectionTypeFromPath(t *testing.T) {
+	// once the hub has received the next request, it has finished with the last
+	h.unregister <- c
+	h.unregister <- c
 
-	assert.Equal(t, "connectionType", getConnectionTypeFromPath("/connectionType/sessionID"))
-	assert.Equal(t, "", getConnectionTypeFromPath("NoLeadingSlash/A/B/C"))
-	assert.Equal(t, "foo%20bar", getConnectionTypeFromPath("/foo%20bar/glum"))
-	assert.Equal(t, "", getConnectionTypeFromPath("ooops/foo%20bar/glum"))
+	h.mu.RLock()
+	assert.Empty(t, h.clients[c.key()])
+	assert.Empty(t, h.watchers)
+	assert.Empty(t, h.floors)
+	h.mu.RUnlock()
 
-}
-func TestGetTopicFromPath(t *testing.T) {
+	dcs.Lock()
+	assert.Empty(t, dcs.ParentByChild)
+	dcs.Unlock()
 
-	assert.Equal(t, "sessionID", getTopicFromPath("/connectionType/sessionID"))
-	assert.Equal(t, "", getTopicFromPath("NoLeadingSlash/A/B/C"))
-	assert.Equal(t, "session%20ID/connection%20ID", getTopicFromPath("/connectionType/session%20ID/connection%20ID"))
-	assert.Equal(t, "sessionID/connectionID", getTopicFromPath("/connectionType/sessionID/connectionID?QueryParams=Something"))
-	assert.Equal(t, "sessionID/connectionID", getTopicFromPath("/connectionType/sessionID/connectionID?QueryParams=Something&SomeThing=Else"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.Connections.WithLabelValues(topic, "client")))
 }
 
-func TestGetSessionIDFromPath(t *testing.T) {
+func TestWatch(t *testing.T) {
 
-	assert.Equal(t, "sessionID", getSessionIDFromPath("/connectionType/sessionID"))
-	assert.Equal(t, "", getSessionIDFromPath("NoLeadingSlash/A/B/C"))
-	assert.Equal(t, "session%20ID", getSessionIDFromPath("/connectionType/session%20ID/connection%20ID"))
-	assert.Equal(t, "sessionID", getSessionIDFromPath("/connectionType/sessionID/connectionID?QueryParams=Something"))
-	assert.Equal(t, "sessionID", getSessionIDFromPath("/connectionType/sessionID/connectionID?QueryParams=Something&SomeThing=Else"))
-}
+	// Setup logging
+
+	debug := false
+	if debug {
+		log.SetLevel(log.TraceLevel)
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true, DisableColors: true})
+		defer log.SetOutput(os.Stdout)
 
-func TestGetConnectionIDFromPath(t *testing.T) {
+	} else {
+		var ignore bytes.Buffer
+		logignore := bufio.NewWriter(&ignore)
+		log.SetOutput(logignore)
+	}
 
-	assert.Equal(t, "", getConnectionIDFromPath("/connectionType/sessionID"))
-	assert.Equal(t, "", getConnectionIDFromPath("NoLeadingSlash/A/B/C"))
-	assert.Equal(t, "connection%20ID", getConnectionIDFromPath("/connectionType/session%20ID/connection%20ID  "))
-	assert.Equal(t, "connectionID", getConnectionIDFromPath("/connectionType/sessionID/connectionID?QueryParams=Something"))
-	assert.Equal(t, "connectionID", getConnectionIDFromPath("/connectionType/sessionID/connectionID?QueryParams=Something&SomeThing=Else"))
+	// setup crossbar on local (free) port
+	closed := make(chan struct{})
+	denied := make(chan string)
+	var wg sync.WaitGroup
 
-}
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        New(),
+		StatsEvery: time.Duration(time.Second),
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, denied, &wg)
+	// safety margin to get crossbar running
+	time.Sleep(time.Second)
+
+	var timeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dial := func(topic string, scopes []string) *reconws.ReconWs {
+		token := MakeTestToken(audience, "session", topic, scopes, 5)
+		r := reconws.New()
+		go func() {
+			err := r.Dial(ctx, audience+"/session/"+topic+"?code="+cs.SubmitToken(token))
+			assert.NoError(t, err)
+		}()
+		return r
+	}
+
+	data := dial("pend00/data", []string{"read", "write"})
+	video := dial("pend00/video", []string{"read", "write"})
+	other := dial("spinner/a/data", []string{"read", "write"})
+	pend := dial("pend00/*", []string{"read"})
+	spinner := dial("spinner/**", []string{"read"})
+
+	time.Sleep(timeout)
+
+	// *** TestWatcherNeedsReadOnlyToken
+	writer := reconws.New()
+	token := MakeTestToken(audience, "session", "pend00/**", []string{"read", "write"}, 5)
+	err = writer.Dial(ctx, audience+"/session/pend00/**?code="+cs.SubmitToken(token))
+	assert.Error(t, err, "pattern with write scope should be refused before upgrading")
+
+	for _, r := range config.Hub.GetClientReports() {
+		assert.NotEqual(t, "pend00/**", r.Topic, "pattern with write scope should be rejected")
+	}
+
+	// *** TestWatcherGetsPrefixedMessages
+	data.Out <- reconws.WsMessage{Data: []byte("foo"), Type: websocket.TextMessage}
+
+	select {
+	case msg := <-pend.In:
+		assert.Equal(t, "pend00/data\nfoo", string(msg.Data))
+		assert.Equal(t, websocket.TextMessage, msg.Type)
+	case <-time.After(timeout):
+		t.Error("TestWatcherGetsPrefixedMessages...FAIL")
+	}
+
+	video.Out <- reconws.WsMessage{Data: []byte{0, 1, 2}, Type: websocket.BinaryMessage}
+
+	select {
+	case msg := <-pend.In:
+		assert.Equal(t, append([]byte("pend00/video\n"), 0, 1, 2), msg.Data)
+		assert.Equal(t, websocket.BinaryMessage, msg.Type)
+	case <-time.After(timeout):
+		t.Error("TestWatcherGetsPrefixedMessages...FAIL")
+	}
+
+	other.Out <- reconws.WsMessage{Data: []byte("bar"), Type: websocket.TextMessage}
+
+	select {
+	case msg := <-spinner.In:
+		assert.Equal(t, "spinner/a/data\nbar", string(msg.Data))
+	case <-time.After(timeout):
+		t.Error("TestWatcherGetsPrefixedMessages...FAIL")
+	}
+
+	// *** TestWatcherOnlyGetsMatchingTopics
+	select {
+	case msg := <-pend.In:
+		t.Errorf("pend00/* should not get %s", string(msg.Data))
+	case msg := <-spinner.In:
+		t.Errorf("spinner/** should not get %s", string(msg.Data))
+	case msg := <-writer.In:
+		t.Errorf("rejected watcher should not get %s", string(msg.Data))
+	case msg := <-data.In:
+		t.Errorf("pend00/data should not get %s", string(msg.Data))
+	case msg := <-video.In:
+		t.Errorf("pend00/video should not get %s", string(msg.Data))
+	case <-time.After(timeout):
+	}
+
+	cancel()
+	time.Sleep(timeout)
+	close(closed)
+	wg.Wait()
+}
+
+func TestRateLimits(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	// the test connects as if through a proxy on the same host
+	proxies, err := forwarded.Parse("127.0.0.1")
+	assert.NoError(t, err)
+
+	config := Config{
+		Listen:        port,
+		Audience:      audience,
+		BufferSize:    128,
+		CodeStore:     cs,
+		DenyStore:     deny.New(),
+		Hub:           New(),
+		LimitBytes:    limit.Rate{Count: 10, Per: time.Minute},
+		LimitConnect:  limit.Rate{Count: 2, Per: time.Minute},
+		LimitMessages: limit.Rate{Count: 3, Per: time.Minute},
+		Proxies:       proxies,
+		StatsEvery:    time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	topic := "limits"
+
+	dial := func(source string) (*websocket.Conn, *http.Response, error) {
+		token := MakeTestToken(audience, "session", topic, []string{"read", "write"}, 10)
+		return websocket.DefaultDialer.Dial(audience+"/session/"+topic+"?code="+cs.SubmitToken(token), http.Header{"X-Forwarded-For": {source}})
+	}
+
+	// *** TestConnectLimitPerSource
+	before := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("connect"))
+
+	a, _, err := dial("10.0.0.1")
+	assert.NoError(t, err)
+	b, _, err := dial("10.0.0.2")
+	assert.NoError(t, err)
+	c, _, err := dial("10.0.0.2")
+	assert.NoError(t, err)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	_, resp, err := dial("10.0.0.2")
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RateLimited.WithLabelValues("connect")))
+
+	// *** TestConnectLimitNotSpoofed
+	// the client cannot get round the limit by making up the addresses
+	// before the one that the proxy added for it
+	for i := 0; i < 3; i++ {
+		_, resp, err = dial("203.0.113." + strconv.Itoa(i) + ", 10.0.0.2")
+		assert.Error(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestMessageLimitPerConnection
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.WriteMessage(websocket.TextMessage, []byte("x")))
+	}
+
+	assert.Equal(t, "xxx", readString(t, b, 3))
+
+	assert.NoError(t, a.WriteMessage(websocket.TextMessage, []byte("x")))
+
+	assert.NoError(t, a.SetReadDeadline(time.Now().Add(time.Second)))
+	_, _, err = a.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "expected policy violation but got %v", err)
+
+	// *** TestByteLimitPerConnection
+	assert.Equal(t, "xxx", readString(t, c, 3))
+
+	assert.NoError(t, b.WriteMessage(websocket.TextMessage, []byte("0123456789")))
+	assert.Equal(t, "0123456789", readString(t, c, 10))
+
+	assert.NoError(t, b.WriteMessage(websocket.TextMessage, []byte("y")))
+	assert.NoError(t, b.SetReadDeadline(time.Now().Add(time.Second)))
+	for {
+		_, _, err = b.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "expected policy violation but got %v", err)
+
+	close(closed)
+	wg.Wait()
+}
+
+func TestDrain(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	drain := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Drain:      drain,
+		Hub:        New(),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dial := func(topic string) (*websocket.Conn, error) {
+		token := MakeTestToken(audience, "session", topic, []string{"read", "write"}, 10)
+		conn, _, err := websocket.DefaultDialer.Dial(audience+"/session/"+topic+"?code="+cs.SubmitToken(token), nil)
+		return conn, err
+	}
+
+	a, err := dial("drain00")
+	assert.NoError(t, err)
+	b, err := dial("drain01")
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestDrainClosesWithGoingAway
+	close(drain)
+
+	for _, conn := range []*websocket.Conn{a, b} {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "expected going away but got %v", err)
+		conn.Close()
+	}
+
+	// *** TestDrainRefusesNewConnections
+	_, err = dial("drain00")
+	assert.Error(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// only the internal stats client is left
+	for _, r := range config.Hub.GetClientReports() {
+		assert.Equal(t, "stats", r.Topic)
+	}
+
+	close(closed)
+	wg.Wait()
+}
+
+func TestRefuseBeforeUpgrade(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  ds,
+		Hub:        New(),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	now := time.Now().Unix()
+
+	early := permission.NewToken(audience, "session", "refuse00", []string{"read"}, now, now+60, now+120)
+
+	cancelled := MakeTestToken(audience, "session", "refuse00", []string{"read"}, 10)
+	cancelled.SetBookingID("bid-cancelled")
+	ds.Deny("bid-cancelled", now+60)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  *permission.Token
+		status int
+	}{
+		{"no code", "/session/refuse00", nil, http.StatusUnauthorized},
+		{"invalid code", "/session/refuse00?code=not-a-code", nil, http.StatusUnauthorized},
+		{"too early", "/session/refuse00", &early, http.StatusTooEarly},
+		{"wrong audience", "/session/refuse00", tokenPtr(MakeTestToken("ws://wrong.server.io", "session", "refuse00", []string{"read"}, 10)), http.StatusForbidden},
+		{"wrong topic", "/session/refuse01", tokenPtr(MakeTestToken(audience, "session", "refuse00", []string{"read"}, 10)), http.StatusForbidden},
+		{"expired", "/session/refuse00", tokenPtr(MakeTestToken(audience, "session", "refuse00", []string{"read"}, -5)), http.StatusUnauthorized},
+		{"denied", "/session/refuse00", &cancelled, http.StatusForbidden},
+		{"no valid scopes", "/session/refuse00", tokenPtr(MakeTestToken(audience, "session", "refuse00", []string{"host"}, 10)), http.StatusForbidden},
+		{"write to pattern", "/session/refuse/*", tokenPtr(MakeTestToken(audience, "session", "refuse/*", []string{"read", "write"}, 10)), http.StatusForbidden},
+		{"no host", "/shell/refuse00", tokenPtr(MakeTestToken(audience, "shell", "refuse00", []string{"client"}, 10)), http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+
+		u := audience + test.path
+
+		if test.token != nil {
+			u = u + "?code=" + cs.SubmitToken(*test.token)
+		}
+
+		conn, resp, err := websocket.DefaultDialer.Dial(u, nil)
+
+		if !assert.Error(t, err, test.name) {
+			conn.Close()
+			continue
+		}
+
+		if assert.NotNil(t, resp, test.name) {
+			assert.Equal(t, test.status, resp.StatusCode, test.name)
+		}
+	}
+
+	close(closed)
+	wg.Wait()
+}
+
+func TestCloseReasons(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	denied := make(chan string)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        New(),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, denied, &wg)
+	time.Sleep(time.Second)
+
+	// *** TestCloseExpired
+	token := MakeTestToken(audience, "session", "reason00", []string{"read"}, 2)
+
+	conn, _, err := websocket.DefaultDialer.Dial(audience+"/session/reason00?code="+cs.SubmitToken(token), nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, CloseExpired), "expected expired but got %v", err)
+	var ce *websocket.CloseError
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, "session expired", ce.Text)
+	}
+	conn.Close()
+
+	// *** TestCloseDenied
+	token = MakeTestToken(audience, "session", "reason00", []string{"read"}, 10)
+	token.SetBookingID("bid-reason")
+
+	conn, _, err = websocket.DefaultDialer.Dial(audience+"/session/reason00?code="+cs.SubmitToken(token), nil)
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	denied <- "bid-reason"
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, CloseDenied), "expected denied but got %v", err)
+	if assert.ErrorAs(t, err, &ce) {
+		assert.Equal(t, "booking cancelled", ce.Text)
+	}
+	conn.Close()
+
+	close(closed)
+	wg.Wait()
+}
+
+func tokenPtr(token permission.Token) *permission.Token {
+	return &token
+}
+
+// readString reads from the connection until it has n bytes, because
+// the writePump may have combined several messages into one frame
+func readString(t *testing.T, conn *websocket.Conn, n int) string {
+	received := ""
+	for len(received) < n {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, data, err := conn.ReadMessage()
+		if !assert.NoError(t, err) {
+			break
+		}
+		received += string(data)
+	}
+	return received
+}
+
+func TestSlashify(t *testing.T) {
+
+	if slashify("foo") != "/foo" {
+		t.Errorf("Slashify not prefixing slash ")
+	}
+	if slashify("/foo") == "//foo" {
+		t.Errorf("Slashify prefixing additional slash")
+	}
+	if slashify("/foo/") != "/foo" {
+		t.Errorf("Slashify not removing trailing slash")
+	}
+	if slashify("foo/") != "/foo" {
+		t.Errorf("Slashify not both removing trailing slash AND prefixing slash")
+	}
+
+	b := "foo/bar/rab/oof/"
+	if slashify(b) != "/foo/bar/rab/oof" {
+		t.Errorf("Slashify not coping with internal slashes %s -> %s", b, slashify(b))
+	}
+
+}
+
+func TestMatchTopic(t *testing.T) {
+
+	assert.True(t, MatchTopic("pend00", "pend00"))
+	assert.False(t, MatchTopic("pend00", "pend01"))
+	assert.True(t, MatchTopic("pend*", "pend01"))
+	assert.False(t, MatchTopic("pend*", "pend01/data"))
+	assert.True(t, MatchTopic("pend00/*", "pend00/data"))
+	assert.False(t, MatchTopic("pend00/*", "pend00"))
+	assert.False(t, MatchTopic("pend00/*", "pend00/data/raw"))
+	assert.True(t, MatchTopic("spinner/**", "spinner"))
+	assert.True(t, MatchTopic("spinner/**", "spinner/a"))
+	assert.True(t, MatchTopic("spinner/**", "spinner/a/b"))
+	assert.False(t, MatchTopic("spinner/**", "spinnerX/a"))
+	assert.True(t, MatchTopic("**/data", "spinner/a/data"))
+	assert.True(t, MatchTopic("**", "anything/at/all"))
+	assert.True(t, MatchTopic("*-data", "pend00-data"))
+	assert.False(t, MatchTopic("[", "["))
+}
+
+func TestIsPattern(t *testing.T) {
+
+	assert.False(t, IsPattern("pend00/data"))
+	assert.True(t, IsPattern("pend00/*"))
+	assert.True(t, IsPattern("spinner/**"))
+	assert.True(t, IsPattern("pend0?"))
+	assert.True(t, IsPattern("pend0[0-9]"))
+}
+
+func TestGetConnectionTypeFromPath(t *testing.T) {
+
+	assert.Equal(t, "connectionType", getConnectionTypeFromPath("/connectionType/sessionID"))
+	assert.Equal(t, "", getConnectionTypeFromPath("NoLeadingSlash/A/B/C"))
+	assert.Equal(t, "foo%20bar", getConnectionTypeFromPath("/foo%20bar/glum"))
+	assert.Equal(t, "", getConnectionTypeFromPath("ooops/foo%20bar/glum"))
+
+}
+func TestGetTopicFromPath(t *testing.T) {
+
+	assert.Equal(t, "sessionID", getTopicFromPath("/connectionType/sessionID"))
+	assert.Equal(t, "", getTopicFromPath("NoLeadingSlash/A/B/C"))
+	assert.Equal(t, "session%20ID/connection%20ID", getTopicFromPath("/connectionType/session%20ID/connection%20ID"))
+	assert.Equal(t, "sessionID/connectionID", getTopicFromPath("/connectionType/sessionID/connectionID?QueryParams=Something"))
+	assert.Equal(t, "sessionID/connectionID", getTopicFromPath("/connectionType/sessionID/connectionID?QueryParams=Something&SomeThing=Else"))
+}
+
+// serveWsPairs returns a server that upgrades connections to websockets, and a function
+// that dials it, returning the client and server ends of a connection, which compress
+// messages if compress is true
+func serveWsPairs(tb testing.TB, compress bool) (*httptest.Server, func() (*websocket.Conn, *websocket.Conn)) {
+
+	conns := make(chan *websocket.Conn)
+
+	u := upgrader
+	u.EnableCompression = compress
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+
+	dialer := websocket.Dialer{EnableCompression: compress}
+
+	dial := func() (*websocket.Conn, *websocket.Conn) {
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return conn, <-conns
+	}
+
+	return s, dial
+}
+
+// fanOutHub returns a running hub with readers on the topic camera, connected by websocket with
+// message framing, and a count of the messages they have received, which stops when closed is
+func fanOutHub(tb testing.TB, readers int, compress bool, closed chan struct{}) (*Hub, *int64) {
+
+	h := New()
+	h.SetDenyChannelStore(chanmap.New())
+	go h.run(closed)
+
+	s, dial := serveWsPairs(tb, compress)
+
+	var received int64
+
+	for i := 0; i < readers; i++ {
+
+		conn, sc := dial()
+
+		c := &Client{
+			hub:      h,
+			conn:     sc,
+			topic:    "camera",
+			name:     "reader" + strconv.Itoa(i),
+			send:     make(chan message, 256),
+			guard:    &sendGuard{},
+			drops:    &drops{},
+			stats:    NewStats(),
+			canRead:  true,
+			framing:  FramingMessage,
+			compress: compress,
+		}
+
+		h.register <- c
+		go c.writePump(closed, make(chan struct{}))
+
+		go func() {
+			for {
+				_, r, err := conn.NextReader()
+				if err != nil {
+					return
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					return
+				}
+				atomic.AddInt64(&received, 1)
+			}
+		}()
+	}
+
+	go func() {
+		<-closed
+		s.Close()
+	}()
+
+	for {
+		h.mu.RLock()
+		n := len(h.clients[topicKey{topic: "camera"}])
+		h.mu.RUnlock()
+		if n == readers {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return h, &received
+}
+
+// waitReceived waits until the readers have received n messages, or the timeout passes
+func waitReceived(received *int64, n int64, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(received) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+func TestFanOut(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	h, received := fanOutHub(t, 3, true, closed)
+
+	// an internal client, to see the message as the hub passes it on
+	internal := &Client{topic: "camera", name: "internal", send: make(chan message, 8), guard: &sendGuard{}, drops: &drops{}, canRead: true}
+	h.register <- internal
+
+	assert.Eventually(t, func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return h.clients[topicKey{topic: "camera"}][internal]
+	}, time.Second, time.Millisecond)
+
+	data := bytes.Repeat([]byte("x"), 188*7)
+	assert.True(t, h.publish(message{sender: source{topic: "camera", name: "camera"}, mt: websocket.BinaryMessage, data: data, sent: time.Now()}, nil))
+	assert.True(t, waitReceived(received, 3, time.Second))
+
+	select {
+	case m := <-internal.send:
+		assert.NotNil(t, m.prepared, "message to several readers that compress messages should be prepared")
+		assert.Equal(t, data, m.data)
+	case <-time.After(time.Second):
+		t.Error("internal client did not receive message")
+	}
+
+	// *** TestFanOutNotPrepared
+	// a message that is going to one reader that compresses messages is not worth preparing
+	m := message{sender: source{topic: "camera", name: "camera"}, mt: websocket.BinaryMessage, data: data}
+	h.prepare(&m, []*Client{{name: "reader", conn: &websocket.Conn{}, canRead: true, compress: true}, internal})
+	assert.Nil(t, m.prepared)
+	h.prepare(&m, []*Client{{name: "reader0", conn: &websocket.Conn{}, canRead: true}, {name: "reader1", conn: &websocket.Conn{}, canRead: true}})
+	assert.Nil(t, m.prepared, "readers that do not compress do not count")
+	h.prepare(&m, []*Client{{name: "camera", conn: &websocket.Conn{}, canRead: true, compress: true}, {name: "reader", conn: &websocket.Conn{}, canRead: true, compress: true}})
+	assert.Nil(t, m.prepared, "the sender does not count")
+}
+
+func TestOffersCompression(t *testing.T) {
+	r := httptest.NewRequest("GET", "/session/abc", nil)
+	assert.False(t, offersCompression(r))
+	r.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
+	assert.True(t, offersCompression(r))
+}
+
+func TestReadMessage(t *testing.T) {
+
+	s, dial := serveWsPairs(t, false)
+	defer s.Close()
+
+	conn, sc := dial()
+	defer conn.Close()
+	defer sc.Close()
+
+	c := &Client{conn: sc}
+
+	for _, size := range []int{0, 10, 188 * 7, 64 * 1024, maxPooledReadBuffer + 1, 100} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+
+		mt, got, err := c.readMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, mt)
+		assert.Equal(t, data, got)
+	}
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	mt, got, err := c.readMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, mt)
+	assert.Equal(t, "hello", string(got))
+
+	sc.SetReadLimit(4)
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("too long")))
+	_, _, err = c.readMessage()
+	assert.Equal(t, websocket.ErrReadLimit, err)
+}
+
+// benchmarkFanOut sends messages to 30 readers connected by websocket, as if 30 students were
+// watching one camera, or one experiment's data, with messages compressed if compress is true
+func benchmarkFanOut(b *testing.B, compress bool) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	const readers = 30
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	h, received := fanOutHub(b, readers, compress, closed)
+
+	mt := websocket.BinaryMessage
+	data := bytes.Repeat([]byte("x"), 188*7) // a typical MPEG-TS video message
+
+	if compress {
+		mt = websocket.TextMessage
+		data = bytes.Repeat([]byte(`{"t":1667487600123,"theta":0.5236,"omega":-1.047},`), 20) // typical data
+	}
+
+	sender := source{topic: "camera", name: "camera"}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		h.publish(message{sender: sender, mt: mt, data: data, sent: time.Now()}, nil)
+		if i%64 == 63 {
+			// let the readers catch up, so that no messages are dropped
+			waitReceived(received, int64(readers*(i+1)), time.Minute)
+		}
+	}
+
+	if !waitReceived(received, int64(readers*b.N), time.Minute) {
+		b.Error("readers did not receive every message")
+	}
+}
+
+func BenchmarkFanOut(b *testing.B) { benchmarkFanOut(b, false) }
+
+func BenchmarkFanOutCompressed(b *testing.B) { benchmarkFanOut(b, true) }
+
+// benchmarkPrepare writes messages to 30 readers that compress them, as their writePumps
+// would, either prepared by the hub so that they are compressed once, or compressed for
+// each reader, to show what prepare saves
+func benchmarkPrepare(b *testing.B, prepare bool) {
+
+	const readers = 30
+
+	s, dial := serveWsPairs(b, true)
+	defer s.Close()
+
+	h := New()
+
+	var clients []*Client
+
+	for i := 0; i < readers; i++ {
+
+		conn, sc := dial()
+		defer conn.Close()
+		defer sc.Close()
+
+		go func() {
+			for {
+				_, r, err := conn.NextReader()
+				if err != nil {
+					return
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					return
+				}
+			}
+		}()
+
+		clients = append(clients, &Client{conn: sc, name: "reader" + strconv.Itoa(i), canRead: true, compress: true})
+	}
+
+	data := bytes.Repeat([]byte(`{"t":1667487600123,"theta":0.5236,"omega":-1.047},`), 20) // typical data
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		m := message{sender: source{topic: "camera", name: "camera"}, mt: websocket.TextMessage, data: data}
+
+		if prepare {
+			h.prepare(&m, clients)
+		}
+
+		for _, c := range clients {
+			var err error
+			if m.prepared != nil {
+				err = c.conn.WritePreparedMessage(m.prepared)
+			} else {
+				err = c.conn.WriteMessage(m.mt, m.data)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWritePrepared(b *testing.B) { benchmarkPrepare(b, true) }
+
+func BenchmarkWriteUnprepared(b *testing.B) { benchmarkPrepare(b, false) }
+
+// benchmarkReadMessage reads 64kB messages from a websocket connection, as a writer of large messages would send them
+func benchmarkReadMessage(b *testing.B, pooled bool) {
+
+	s, dial := serveWsPairs(b, false)
+	defer s.Close()
+
+	conn, sc := dial()
+	defer conn.Close()
+	defer sc.Close()
+
+	c := &Client{conn: sc}
+
+	data := bytes.Repeat([]byte("x"), 64*1024)
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var err error
+		if pooled {
+			_, _, err = c.readMessage()
+		} else {
+			_, _, err = sc.ReadMessage()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadMessagePooled(b *testing.B) { benchmarkReadMessage(b, true) }
+
+func BenchmarkReadMessageUnpooled(b *testing.B) { benchmarkReadMessage(b, false) }
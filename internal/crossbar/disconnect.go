@@ -0,0 +1,61 @@
+package crossbar
+
+import (
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxCloseReason is the longest reason that fits in a close message, after the close code
+const MaxCloseReason = 123
+
+// Disconnect closes the connections to this node with the id, or on the topic, or both,
+// sending the reason (default "disconnected by admin") with CloseDisconnected, and returns
+// reports on the connections it closed. The clients may reconnect unless their booking is
+// denied as well. Connections to other nodes in a cluster are not closed.
+func (h *Hub) Disconnect(id, topic, reason string) []*ClientReport {
+
+	if id == "" && topic == "" {
+		return nil
+	}
+
+	if reason == "" {
+		reason = "disconnected by admin"
+	}
+
+	if len(reason) > MaxCloseReason {
+		reason = reason[:MaxCloseReason]
+	}
+
+	closeMessage := websocket.FormatCloseMessage(CloseDisconnected, reason)
+
+	node := ""
+	if h.cluster != nil {
+		node = h.cluster.config.NodeID
+	}
+
+	var reports []*ClientReport
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for t, clients := range h.clients {
+
+		if topic != "" && t.topic != topic {
+			continue
+		}
+
+		for client := range clients {
+
+			if client.conn == nil || (id != "" && client.name != id) {
+				continue // internal clients have no connection
+			}
+
+			reports = append(reports, client.report(node))
+			h.evict(client, closeMessage)
+
+			log.WithFields(log.Fields{"topic": client.topic, "name": client.name, "booking_id": client.bookingID, "reason": reason}).Info("connection disconnected by admin")
+		}
+	}
+
+	return reports
+}
@@ -0,0 +1,130 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisconnect(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+	hub := New()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        hub,
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dial := func(topic, bid string) *websocket.Conn {
+		token := MakeTestToken(audience, "session", topic, []string{"read", "write"}, 10)
+		token.SetBookingID(bid)
+		conn, _, err := websocket.DefaultDialer.Dial(audience+"/session/"+topic+"?code="+cs.SubmitToken(token), nil)
+		assert.NoError(t, err)
+		return conn
+	}
+
+	// connected reports whether a connection with the booking ID is in the hub
+	connected := func(bid string) bool {
+		for _, r := range hub.GetClientReports() {
+			if r.BookingID == bid {
+				return true
+			}
+		}
+		return false
+	}
+
+	// closeCode returns the code and reason the connection was closed with, if it was
+	closeCode := func(conn *websocket.Conn) (int, string) {
+		err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		assert.NoError(t, err)
+		_, _, err = conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			return ce.Code, ce.Text
+		}
+		return 0, ""
+	}
+
+	c0 := dial("disc00", "bid0")
+	defer c0.Close()
+	c1 := dial("disc00", "bid1")
+	defer c1.Close()
+	c2 := dial("disc01", "bid2")
+	defer c2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// the ID in the status report identifies the connection
+	var id string
+	for _, r := range hub.GetClientReports() {
+		if r.BookingID == "bid1" {
+			id = r.ID
+		}
+	}
+	assert.NotEqual(t, "", id)
+
+	// *** TestDisconnectNothing
+	assert.Equal(t, 0, len(hub.Disconnect("", "", "")))
+	assert.Equal(t, 0, len(hub.Disconnect("nobody", "", "")))
+	assert.Equal(t, 0, len(hub.Disconnect(id, "disc01", "")))
+
+	// *** TestDisconnectID
+	reports := hub.Disconnect(id, "", "please reload the page")
+	if assert.Equal(t, 1, len(reports)) {
+		assert.Equal(t, id, reports[0].ID)
+		assert.Equal(t, "bid1", reports[0].BookingID)
+		assert.Equal(t, "disc00", reports[0].Topic)
+	}
+
+	code, reason := closeCode(c1)
+	assert.Equal(t, CloseDisconnected, code)
+	assert.Equal(t, "please reload the page", reason)
+
+	assert.True(t, connected("bid0"))
+
+	// *** TestDisconnectTopic
+	reports = hub.Disconnect("", "disc00", "")
+	if assert.Equal(t, 1, len(reports)) {
+		assert.Equal(t, "bid0", reports[0].BookingID)
+	}
+
+	code, reason = closeCode(c0)
+	assert.Equal(t, CloseDisconnected, code)
+	assert.Equal(t, "disconnected by admin", reason)
+
+	assert.True(t, connected("bid2")) // on another topic
+
+	// the internal stats client cannot be disconnected
+	assert.Equal(t, 0, len(hub.Disconnect("", "stats", "")))
+}
@@ -0,0 +1,182 @@
+package crossbar
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dispatcherIdle is how long a dispatcher waits for a message before it stops,
+// so that topics that are no longer used do not keep a goroutine
+const dispatcherIdle = time.Minute
+
+// dispatcher distributes the messages sent to a topic, so that a busy topic does not
+// hold up the others. It finds the clients on the topic in the hub's snapshots, so
+// it never waits for the hub's lock while clients register and unregister.
+type dispatcher struct {
+	messages chan message
+
+	// pending counts the messages being sent to the dispatcher, so
+	// that it does not stop while they are on their way; use atomic
+	pending int64
+}
+
+// sendGuard stops dispatchers from sending to a client after the hub has
+// closed its send channel, because they do not hold the hub's lock
+type sendGuard struct {
+	sync.RWMutex
+	closed bool
+}
+
+// publish passes the message to the dispatcher for its topic, starting one if needed.
+// It returns false if stop is closed, or the hub stops, before the dispatcher takes
+// the message; stop may be nil to wait until it does.
+func (h *Hub) publish(m message, stop <-chan struct{}) bool {
+
+	d := h.dispatcher(m.sender.key())
+
+	select {
+	case d.messages <- m:
+		return true
+	case <-stop:
+		atomic.AddInt64(&d.pending, -1)
+		return false
+	case <-h.stopped:
+		atomic.AddInt64(&d.pending, -1)
+		return false
+	}
+}
+
+// dispatcher returns the dispatcher for the topic, counting a pending message
+// so that it keeps running until the caller has sent it
+func (h *Hub) dispatcher(key topicKey) *dispatcher {
+
+	h.routes.RLock()
+	d, ok := h.dispatchers[key]
+	if ok {
+		atomic.AddInt64(&d.pending, 1)
+	}
+	h.routes.RUnlock()
+
+	if ok {
+		return d
+	}
+
+	h.routes.Lock()
+	defer h.routes.Unlock()
+
+	d, ok = h.dispatchers[key]
+	if !ok {
+		d = &dispatcher{messages: make(chan message)}
+		h.dispatchers[key] = d
+		go h.dispatch(key, d)
+	}
+	atomic.AddInt64(&d.pending, 1)
+
+	return d
+}
+
+// dispatch handles the messages sent to a dispatcher, until it has been idle for dispatcherIdle,
+// or the hub stops
+func (h *Hub) dispatch(key topicKey, d *dispatcher) {
+
+	ticker := time.NewTicker(dispatcherIdle)
+	defer ticker.Stop()
+
+	busy := false
+
+	for {
+		select {
+		case m := <-d.messages:
+			atomic.AddInt64(&d.pending, -1)
+			busy = true
+			h.handle(m)
+		case <-ticker.C:
+			if !busy && h.retire(key, d) {
+				return
+			}
+			busy = false
+		case <-h.stopped:
+			return
+		}
+	}
+}
+
+// retire removes the dispatcher from the hub, and returns true, unless a message is on its way to it
+func (h *Hub) retire(key topicKey, d *dispatcher) bool {
+
+	h.routes.Lock()
+	defer h.routes.Unlock()
+
+	// messages are only counted while holding routes, so none can be counted now
+	if atomic.LoadInt64(&d.pending) > 0 {
+		return false
+	}
+
+	delete(h.dispatchers, key)
+
+	return true
+}
+
+// handle distributes a message to the clients on its topic, forwards it to the other nodes
+// in the cluster and records it, and disconnects any clients that could not keep up
+func (h *Hub) handle(m message) {
+
+	slow := h.distribute(m)
+
+	if h.cluster != nil && m.session && !m.remote {
+		h.cluster.forward(m)
+	}
+
+	if h.recorder != nil && m.session && h.recording(m.sender.topic) {
+		h.recorder.Add(m.record())
+	}
+
+	if len(slow) > 0 {
+		h.mu.Lock()
+		for _, client := range slow {
+			h.evict(client, slowCloseMessage)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// route replaces the snapshot of the clients on the client's topic, and of the watchers
+// if the client is one, after the client is added or removed. The caller must hold the lock.
+func (h *Hub) route(client *Client) {
+
+	key := client.key()
+
+	clients := make([]*Client, 0, len(h.clients[key]))
+	for c := range h.clients[key] {
+		clients = append(clients, c)
+	}
+
+	var watchers []*Client
+	if client.watching {
+		for c := range h.watchers {
+			watchers = append(watchers, c)
+		}
+	}
+
+	h.routes.Lock()
+	defer h.routes.Unlock()
+
+	if len(clients) == 0 {
+		delete(h.subscribers, key)
+	} else {
+		h.subscribers[key] = clients
+	}
+
+	if client.watching {
+		h.watching = watchers
+	}
+}
+
+// closeSend closes the client's send channel, after any dispatcher sending to it has finished
+func (c *Client) closeSend() {
+	c.guard.Lock()
+	defer c.guard.Unlock()
+	c.guard.closed = true
+	close(c.send)
+}
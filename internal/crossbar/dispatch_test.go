@@ -0,0 +1,306 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/chanmap"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// dispatchHub returns a running hub, with readers registered on each topic, which stops when the test ends
+func dispatchHub(tb testing.TB, topics []string, readers int, buffer int) (*Hub, []*Client) {
+
+	closed := make(chan struct{})
+	tb.Cleanup(func() { close(closed) })
+
+	h := New()
+	h.SetDenyChannelStore(chanmap.New())
+	go h.run(closed)
+
+	return h, addReaders(h, topics, readers, buffer)
+}
+
+// addReaders registers readers on each topic, with send channels that buffer messages
+func addReaders(h *Hub, topics []string, readers int, buffer int) []*Client {
+
+	var clients []*Client
+
+	for _, topic := range topics {
+		for i := 0; i < readers; i++ {
+			c := &Client{
+				topic: topic,
+				name:  topic + "-reader" + strconv.Itoa(i),
+				send:  make(chan message, buffer),
+				guard: &sendGuard{},
+				drops: &drops{},
+				stats: NewStats(),
+			}
+			h.register <- c
+			clients = append(clients, c)
+		}
+	}
+
+	// wait for the last client to be registered
+	for {
+		h.mu.RLock()
+		n := len(h.clients[topicKey{topic: topics[len(topics)-1]}])
+		h.mu.RUnlock()
+		if n == readers {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return clients
+}
+
+func TestDispatch(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	h, clients := dispatchHub(t, []string{"a", "b"}, 1, 8)
+	a, b := clients[0], clients[1]
+
+	receive := func(c *Client) string {
+		select {
+		case m := <-c.send:
+			return string(m.data)
+		case <-time.After(100 * time.Millisecond):
+			return ""
+		}
+	}
+
+	// *** TestDispatchTopic
+	assert.True(t, h.publish(message{sender: source{topic: "a", name: "sender"}, data: []byte("to a"), sent: time.Now()}, nil))
+	assert.Equal(t, "to a", receive(a))
+	assert.Equal(t, "", receive(b))
+
+	// *** TestDispatchNotBlockedByLock
+	// clients registering on other topics do not hold up messages
+	h.mu.Lock()
+	assert.True(t, h.publish(message{sender: source{topic: "b", name: "sender"}, data: []byte("to b"), sent: time.Now()}, nil))
+	assert.Equal(t, "to b", receive(b))
+	h.mu.Unlock()
+
+	// *** TestDispatchAfterUnregister
+	h.unregister <- a
+	_, ok := <-a.send
+	assert.False(t, ok, "send should be closed")
+	assert.True(t, h.publish(message{sender: source{topic: "a", name: "sender"}, data: []byte("gone"), sent: time.Now()}, nil))
+
+	// a client that has left is not sent to, even if a dispatcher still has it
+	assert.False(t, h.deliver(a, message{sender: source{topic: "a", name: "sender"}, data: []byte("gone"), sent: time.Now()}))
+
+	// *** TestDispatchRetire
+	d := h.dispatcher(topicKey{topic: "c"}) // counts a message that has not been sent yet
+	assert.False(t, h.retire(topicKey{topic: "c"}, d))
+	d.messages <- message{sender: source{topic: "c", name: "sender"}, data: []byte("nobody")}
+	assert.True(t, h.retire(topicKey{topic: "c"}, d))
+
+	h.routes.RLock()
+	_, ok = h.dispatchers[topicKey{topic: "c"}]
+	h.routes.RUnlock()
+	assert.False(t, ok)
+
+	// a new dispatcher is started for the next message
+	assert.True(t, h.publish(message{sender: source{topic: "b", name: "sender"}, data: []byte("again"), sent: time.Now()}, nil))
+	assert.Equal(t, "again", receive(b))
+
+	// *** TestDispatchStop
+	// the dispatcher cannot take another message while it waits for the lock to evict a slow client
+	h.mu.Lock()
+	slow := &Client{topic: "d", name: "slow", send: make(chan message), guard: &sendGuard{}, drops: &drops{}, slow: SlowConsumerRule{Policy: Disconnect}}
+	h.clients[topicKey{topic: "d"}] = map[*Client]bool{slow: true}
+	h.route(slow)
+
+	assert.True(t, h.publish(message{sender: source{topic: "d", name: "sender"}, sent: time.Now()}, nil))
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, h.publish(message{sender: source{topic: "d", name: "sender"}, sent: time.Now()}, stop))
+
+	h.routes.RLock()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&h.dispatchers[topicKey{topic: "d"}].pending))
+	h.routes.RUnlock()
+	h.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	_, ok = <-slow.send
+	assert.False(t, ok, "slow client should be evicted")
+}
+
+// publisher returns how the benchmarks publish messages to the hub: through its dispatchers,
+// one for each topic, or else through one goroutine for every topic, as the hub did before
+// it had dispatchers, so that the two can be compared. It stops when the benchmark ends.
+func publisher(b *testing.B, h *Hub, single bool) func(message, <-chan struct{}) bool {
+
+	if !single {
+		return h.publish
+	}
+
+	messages := make(chan message)
+	done := make(chan struct{})
+	b.Cleanup(func() { close(done) })
+
+	go func() {
+		for {
+			select {
+			case m := <-messages:
+				h.handle(m)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func(m message, stop <-chan struct{}) bool {
+		select {
+		case messages <- m:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// drain empties the clients' send channels until they are closed
+func drain(clients []*Client) *sync.WaitGroup {
+
+	var wg sync.WaitGroup
+
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			for range c.send {
+			}
+		}(c)
+	}
+
+	return &wg
+}
+
+// benchmarkHubThroughput sends messages to 128 topics, each with four readers,
+// as fast as the hub takes them, and reports the messages sent each second
+func benchmarkHubThroughput(b *testing.B, single bool) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	var topics []string
+	for i := 0; i < 128; i++ {
+		topics = append(topics, "bench"+strconv.Itoa(i))
+	}
+
+	h, clients := dispatchHub(b, topics, 4, 4096)
+	publish := publisher(b, h, single)
+	wg := drain(clients)
+
+	data := bytes.Repeat([]byte("x"), 188*7) // a typical MPEG-TS video message
+	var next int64
+
+	b.ResetTimer()
+	start := time.Now()
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := int(atomic.AddInt64(&next, 1))
+		for pb.Next() {
+			sender := source{topic: topics[n%len(topics)], name: "sender"}
+			publish(message{sender: sender, data: data, mt: websocket.BinaryMessage, sent: time.Now()}, nil)
+			n++
+		}
+	})
+
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+	b.StopTimer()
+
+	for _, c := range clients {
+		h.unregister <- c
+	}
+
+	wg.Wait()
+}
+
+// benchmarkHubLatency sends messages to 127 quiet topics, one at a time, while a video topic
+// with 30 readers is kept busy, and reports the 99th percentile time for each message to
+// reach the quiet topic's reader, which is how much the busy topic holds up the others
+func benchmarkHubLatency(b *testing.B, single bool) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	var topics []string
+	for i := 0; i < 127; i++ {
+		topics = append(topics, "quiet"+strconv.Itoa(i))
+	}
+
+	h, quiet := dispatchHub(b, topics, 1, 8)
+	publish := publisher(b, h, single)
+	busy := addReaders(h, []string{"video"}, 30, 4096)
+	wg := drain(busy)
+
+	data := bytes.Repeat([]byte("x"), 188*7)
+
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for {
+				if !publish(message{sender: source{topic: "video", name: "camera"}, data: data, mt: websocket.BinaryMessage, sent: time.Now()}, stop) {
+					return
+				}
+			}
+		}()
+	}
+
+	latencies := make([]time.Duration, 0, b.N)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := quiet[i%len(quiet)]
+		publish(message{sender: source{topic: c.topic, name: "sender"}, data: []byte("{}"), mt: websocket.TextMessage, sent: time.Now()}, nil)
+		m := <-c.send
+		latencies = append(latencies, time.Since(m.sent))
+	}
+
+	b.StopTimer()
+
+	close(stop)
+	senders.Wait()
+
+	for _, c := range append(quiet, busy...) {
+		h.unregister <- c
+	}
+
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+	b.ReportMetric(float64(latencies[len(latencies)*999/1000].Microseconds()), "p999-µs")
+}
+
+func BenchmarkHubThroughputSharded(b *testing.B) { benchmarkHubThroughput(b, false) }
+
+func BenchmarkHubThroughputSingle(b *testing.B) { benchmarkHubThroughput(b, true) }
+
+func BenchmarkHubLatencySharded(b *testing.B) { benchmarkHubLatency(b, false) }
+
+func BenchmarkHubLatencySingle(b *testing.B) { benchmarkHubLatency(b, true) }
@@ -0,0 +1,115 @@
+package crossbar
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// source identifies the client that sent a message, so that messages
+// do not carry a copy of the client
+type source struct {
+	ct        ConnectionType
+	topic     string
+	name      string
+	bookingID string
+}
+
+// from returns the client as the source of a message
+func (c *Client) from() source {
+	return source{ct: c.ct, topic: c.topic, name: c.name, bookingID: c.bookingID}
+}
+
+// key returns the topic in the hub that the message was sent to
+func (s source) key() topicKey {
+	return topicKey{ct: s.ct, topic: s.topic}
+}
+
+// minPrepared is the fewest readers that compress messages that a message is
+// prepared for. Preparing a message frames it an extra time, which costs more than
+// writing it to each reader unless it saves compressing it for more than one.
+const minPrepared = 2
+
+// prepare frames and compresses the message for websocket connections, if it is going to
+// enough clients that compress messages to be worth it, so that the readers' writePumps
+// write the same compressed frame, instead of each compressing the message
+func (h *Hub) prepare(m *message, clients []*Client) {
+
+	if m.prepared != nil {
+		return
+	}
+
+	readers := 0
+	for _, c := range clients {
+		if c.compress && c.conn != nil && c.canRead && c.receives(*m) {
+			readers++
+		}
+	}
+
+	if readers < minPrepared {
+		return
+	}
+
+	pm, err := websocket.NewPreparedMessage(m.mt, m.data)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": m.sender.topic}).Error("message not prepared")
+		return
+	}
+
+	m.prepared = pm
+}
+
+// offersCompression reports whether the client offered to compress messages when it
+// asked to upgrade the connection, so that it will if the upgrader enables compression
+func offersCompression(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// maxPooledReadBuffer is the largest read buffer that is returned to the pool,
+// so that an occasional large message does not keep a large buffer
+const maxPooledReadBuffer = 1024 * 1024
+
+// readBuffers holds buffers for reading messages from clients
+var readBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// readMessage reads the next message from the connection like conn.ReadMessage, but
+// into a pooled buffer that is usually big enough already, so that the message is
+// copied once into a slice of the right size instead of into ever larger slices
+func (c *Client) readMessage() (int, []byte, error) {
+
+	mt, r, err := c.conn.NextReader()
+	if err != nil {
+		return mt, nil, err
+	}
+
+	buf := readBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledReadBuffer {
+			readBuffers.Put(buf)
+		}
+	}()
+
+	buf.Reset()
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return mt, nil, err
+	}
+
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+
+	return mt, data, nil
+}
@@ -0,0 +1,259 @@
+package crossbar
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// FloorCommand represents a request from a client to change which writer holds
+// control of a floor-controlled topic. Floor is one of request, release, grant or seize.
+// To is the ID of the requester being granted control, for grant only.
+type FloorCommand struct {
+	Floor string `json:"floor"`
+	To    string `json:"to,omitempty"`
+}
+
+// FloorStatus is sent to every client on a floor-controlled topic when control changes.
+// Floor is always "status", Holder is the ID of the writer in control (or empty),
+// Queue lists the IDs of writers waiting for control, and You is the recipient's ID.
+type FloorStatus struct {
+	Floor  string   `json:"floor"`
+	Holder string   `json:"holder"`
+	Queue  []string `json:"queue"`
+	You    string   `json:"you"`
+}
+
+// floorPrefix identifies floor commands, which are not relayed to other clients
+var floorPrefix = []byte(`{"floor"`)
+
+// isFloorCommand reports whether a message is a floor command
+func isFloorCommand(mt int, data []byte) bool {
+	return mt == websocket.TextMessage && bytes.HasPrefix(bytes.TrimSpace(data), floorPrefix)
+}
+
+// floor tracks which writer controls a topic, and who is waiting
+type floor struct {
+	holder *Client
+	queue  []*Client
+}
+
+// floorRequest carries a floor command from a client's readPump to the hub
+type floorRequest struct {
+	client  *Client
+	command FloorCommand
+}
+
+// matchAny reports whether the topic matches any of the patterns
+func matchAny(patterns []string, topic string) bool {
+	for _, p := range patterns {
+		if MatchTopic(p, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayWrite reports whether the client's messages should be relayed
+func (c *Client) mayWrite() bool {
+	return c.canWrite && (!c.floored || atomic.LoadInt32(&c.holding) == 1)
+}
+
+// setHolder passes control to the client, which may be nil
+func (f *floor) setHolder(c *Client) {
+	if f.holder != nil {
+		atomic.StoreInt32(&f.holder.holding, 0)
+	}
+	f.holder = c
+	if c != nil {
+		atomic.StoreInt32(&c.holding, 1)
+		f.dequeue(c)
+	}
+}
+
+// dequeue removes the client from the queue, if present
+func (f *floor) dequeue(c *Client) {
+	for i, q := range f.queue {
+		if q == c {
+			f.queue = append(f.queue[:i], f.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// next passes control to the longest-waiting client, if any
+func (f *floor) next() {
+	if len(f.queue) == 0 {
+		f.setHolder(nil)
+		return
+	}
+	f.setHolder(f.queue[0])
+}
+
+// joinFloor gives control to a writer joining a topic where nobody has it,
+// and tells everyone who holds control. The caller must hold the lock.
+func (h *Hub) joinFloor(client *Client) {
+
+	if !client.floored {
+		return
+	}
+
+	f, ok := h.floors[client.key()]
+
+	if !ok {
+		f = &floor{}
+		h.floors[client.key()] = f
+	}
+
+	if f.holder == nil && client.canWrite {
+		f.setHolder(client)
+	}
+
+	h.announceFloor(client.key())
+}
+
+// leaveFloor passes control on from a client that is leaving.
+// The caller must hold the lock.
+func (h *Hub) leaveFloor(client *Client) {
+
+	f, ok := h.floors[client.key()]
+
+	if !ok {
+		return
+	}
+
+	f.dequeue(client)
+
+	if f.holder == client {
+		f.next()
+	}
+
+	if len(h.clients[client.key()]) == 0 {
+		delete(h.floors, client.key())
+		return
+	}
+
+	h.announceFloor(client.key())
+}
+
+// handleFloor applies a floor command. The caller must hold the lock.
+func (h *Hub) handleFloor(r floorRequest) {
+
+	c := r.client
+
+	f, ok := h.floors[c.key()]
+
+	if !ok || !c.canWrite {
+		return
+	}
+
+	if _, ok := h.clients[c.key()][c]; !ok {
+		return // client has left
+	}
+
+	switch r.command.Floor {
+
+	case "request":
+		if f.holder == nil {
+			f.setHolder(c)
+			break
+		}
+		if f.holder == c {
+			return
+		}
+		for _, q := range f.queue {
+			if q == c {
+				return
+			}
+		}
+		f.queue = append(f.queue, c)
+
+	case "release":
+		if f.holder != c {
+			return
+		}
+		f.next()
+
+	case "grant":
+		if f.holder != c {
+			return
+		}
+		var to *Client
+		for _, q := range f.queue {
+			if q.name == r.command.To {
+				to = q
+			}
+		}
+		if to == nil {
+			return
+		}
+		f.setHolder(to)
+
+	case "seize":
+		if !c.isAdmin {
+			log.WithFields(log.Fields{"topic": c.topic, "name": c.name}).Warn("floor not seized because client is not admin")
+			return
+		}
+		f.setHolder(c)
+
+	default:
+		return
+	}
+
+	log.WithFields(log.Fields{"topic": c.topic, "name": c.name, "command": r.command.Floor}).Info("floor control changed")
+
+	h.announceFloor(c.key())
+}
+
+// announceFloor tells every client on the topic who holds control, in a websocket
+// message of its own, so that it is not joined to the topic's data.
+// The caller must hold the lock.
+func (h *Hub) announceFloor(key topicKey) {
+
+	f, ok := h.floors[key]
+
+	if !ok {
+		return
+	}
+
+	status := FloorStatus{Floor: "status", Queue: []string{}}
+
+	if f.holder != nil {
+		status.Holder = f.holder.name
+	}
+
+	for _, q := range f.queue {
+		status.Queue = append(status.Queue, q.name)
+	}
+
+	for client := range h.clients[key] {
+		status.You = client.name
+		client.tellFloor(status)
+	}
+}
+
+// tellFloor queues the floor status to be sent to the client. Only the latest status
+// matters, so one that has not yet been sent is replaced rather than the client
+// being evicted for not keeping up. Internal clients are not told.
+func (c *Client) tellFloor(s FloorStatus) {
+
+	if c.floorStatus == nil {
+		return
+	}
+
+	for {
+		select {
+		case c.floorStatus <- s:
+			return
+		default:
+		}
+
+		// drop the status that has not been sent
+		select {
+		case <-c.floorStatus:
+		default:
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/reconws"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsFloorCommand(t *testing.T) {
+	assert.True(t, isFloorCommand(websocket.TextMessage, []byte(`{"floor":"request"}`)))
+	assert.True(t, isFloorCommand(websocket.TextMessage, []byte(` {"floor": "release"}`)))
+	assert.False(t, isFloorCommand(websocket.BinaryMessage, []byte(`{"floor":"request"}`)))
+	assert.False(t, isFloorCommand(websocket.TextMessage, []byte(`{"set":"floor"}`)))
+}
+
+// awaitFloor returns the next floor status received, skipping any other messages
+func awaitFloor(t *testing.T, r *reconws.ReconWs) FloorStatus {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-r.In:
+			var s FloorStatus
+			if json.Unmarshal(msg.Data, &s) == nil && s.Floor == "status" {
+				return s
+			}
+		case <-timeout:
+			t.Fatal("no floor status received")
+			return FloorStatus{}
+		}
+	}
+}
+
+// expectData checks that the next message received is data
+func expectData(t *testing.T, r *reconws.ReconWs, data string) {
+	select {
+	case msg := <-r.In:
+		assert.Equal(t, data, string(msg.Data))
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("did not receive %s", data)
+	}
+}
+
+// expectNothing checks that no message is received
+func expectNothing(t *testing.T, r *reconws.ReconWs) {
+	select {
+	case msg := <-r.In:
+		t.Errorf("unexpected message %s", string(msg.Data))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFloor(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:       port,
+		Audience:     audience,
+		BufferSize:   128,
+		CodeStore:    cs,
+		DenyStore:    deny.New(),
+		FloorControl: []string{"pend*-data"},
+		Hub:          New(),
+		StatsEvery:   time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	topic := "pend00-data"
+
+	dial := func(ctx context.Context, scopes []string) *reconws.ReconWs {
+		token := MakeTestToken(audience, "session", topic, scopes, 10)
+		r := reconws.New()
+		go func() {
+			err := r.Dial(ctx, audience+"/session/"+topic+"?code="+cs.SubmitToken(token))
+			assert.NoError(t, err)
+		}()
+		return r
+	}
+
+	send := func(r *reconws.ReconWs, data string) {
+		r.Out <- reconws.WsMessage{Data: []byte(data), Type: websocket.TextMessage}
+	}
+
+	// first writer takes control
+	w0 := dial(ctx, []string{"read", "write"})
+	s := awaitFloor(t, w0)
+	assert.Equal(t, s.You, s.Holder)
+	id0 := s.You
+
+	w1 := dial(ctx, []string{"read", "write"})
+	s = awaitFloor(t, w1)
+	assert.Equal(t, id0, s.Holder)
+	id1 := s.You
+	awaitFloor(t, w0)
+
+	r0 := dial(ctx, []string{"read"})
+	s = awaitFloor(t, r0)
+	assert.Equal(t, id0, s.Holder)
+	awaitFloor(t, w0)
+	awaitFloor(t, w1)
+
+	// only the holder's messages are relayed
+	send(w1, "ignored")
+	expectNothing(t, r0)
+
+	send(w0, "a")
+	expectData(t, r0, "a")
+	expectData(t, w1, "a")
+
+	// readers can't request control
+	send(r0, `{"floor":"request"}`)
+	expectNothing(t, w0)
+
+	// floor commands are not relayed, and changes are announced
+	send(w1, `{"floor":"request"}`)
+	for _, r := range []*reconws.ReconWs{w0, w1, r0} {
+		s = awaitFloor(t, r)
+		assert.Equal(t, id0, s.Holder)
+		assert.Equal(t, []string{id1}, s.Queue)
+	}
+
+	send(w0, `{"floor":"grant","to":"`+id1+`"}`)
+	for _, r := range []*reconws.ReconWs{w0, w1, r0} {
+		s = awaitFloor(t, r)
+		assert.Equal(t, id1, s.Holder)
+		assert.Equal(t, []string{}, s.Queue)
+	}
+
+	send(w0, "ignored")
+	expectNothing(t, r0)
+	send(w1, "b")
+	expectData(t, r0, "b")
+
+	// writers without admin can't seize
+	send(w0, `{"floor":"seize"}`)
+	expectNothing(t, r0)
+
+	// admin can seize
+	actx, acancel := context.WithCancel(context.Background())
+	a0 := dial(actx, []string{"read", "write", "admin"})
+	s = awaitFloor(t, a0)
+	assert.Equal(t, id1, s.Holder)
+	ida := s.You
+	awaitFloor(t, r0)
+	awaitFloor(t, w0)
+	awaitFloor(t, w1)
+
+	send(w0, `{"floor":"request"}`)
+	s = awaitFloor(t, r0)
+	assert.Equal(t, []string{id0}, s.Queue)
+	awaitFloor(t, a0)
+	awaitFloor(t, w1)
+	awaitFloor(t, w0)
+
+	send(a0, `{"floor":"seize"}`)
+	s = awaitFloor(t, r0)
+	assert.Equal(t, ida, s.Holder)
+	assert.Equal(t, []string{id0}, s.Queue)
+	awaitFloor(t, w0)
+	awaitFloor(t, w1)
+
+	send(w1, "ignored")
+	expectNothing(t, r0)
+
+	// when the holder leaves, control passes to the longest-waiting writer
+	acancel()
+	s = awaitFloor(t, r0)
+	assert.Equal(t, id0, s.Holder)
+	assert.Equal(t, []string{}, s.Queue)
+
+	// status is sent in a message of its own, not joined to the data
+	send(w0, "c")
+	send(w1, `{"floor":"request"}`)
+	expectData(t, r0, "c")
+	s = awaitFloor(t, r0)
+	assert.Equal(t, []string{id1}, s.Queue)
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	close(closed)
+	wg.Wait()
+}
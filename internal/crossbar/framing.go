@@ -0,0 +1,78 @@
+package crossbar
+
+import (
+	"github.com/practable/relay/internal/framing"
+	"github.com/practable/relay/internal/metrics"
+)
+
+// Framing is how messages that have queued for a client are written to its connection.
+const (
+	// FramingStream appends queued messages to one websocket message, without delimiter,
+	// which suits byte streams such as MPEG-TS video, and is the default
+	FramingStream = framing.Stream
+
+	// FramingMessage writes each queued message as its own websocket message, so that
+	// discrete messages such as JSON can still be parsed by clients that fall behind
+	FramingMessage = framing.Message
+)
+
+// MessageProtocol is the websocket subprotocol that clients request to be sent each
+// message in its own websocket message, whatever the framing of their topic's policy.
+const MessageProtocol = framing.Protocol
+
+// writeQueued writes the message, and any others already queued for the client, to the
+// connection, framed according to the client's framing, and sends them together. Messages
+// of different types are never written to the same websocket message. A message that is
+// not joined to others is written as it was compressed for all the readers, if it was
+// prepared. It must only be called from writePump, and returns an error if the connection
+// has failed.
+func (c *Client) writeQueued(first message) error {
+
+	items := append(c.batch[:0], c.item(first))
+
+	m := len(c.send)
+	for i := 0; i < m; i++ {
+		next, ok := <-c.send
+
+		if !ok {
+			break // hub closed the channel, so write what we have; we'll find out next time round
+		}
+
+		if c.stale(next) {
+			continue
+		}
+
+		items = append(items, c.item(next))
+	}
+
+	err := framing.Write(c.conn, c.framing, items)
+
+	if err == nil {
+		for _, item := range items {
+			c.stats.tx.add(len(item.Data))
+			metrics.MessagesTx.Inc()
+			metrics.BytesTx.Add(float64(len(item.Data)))
+		}
+	}
+
+	// keep the slice for next time, but not the messages
+	for i := range items {
+		items[i] = framing.Item{}
+	}
+	c.batch = items[:0]
+
+	return err
+}
+
+// item returns the message to be written by framing.Write, as prepared for all the
+// readers if it was, and the client accepts compression
+func (c *Client) item(m message) framing.Item {
+
+	item := framing.Item{Type: m.mt, Data: m.data}
+
+	if m.prepared != nil && c.compress {
+		item.Prepared = m.prepared
+	}
+
+	return item
+}
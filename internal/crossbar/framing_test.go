@@ -0,0 +1,79 @@
+package crossbar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteQueued(t *testing.T) {
+
+	conns := make(chan *websocket.Conn)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		conns <- conn
+	}))
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sc := <-conns
+	defer sc.Close()
+
+	// queue sends the messages, with all but the first queued behind it
+	queue := func(framing string, messages ...message) {
+		c := &Client{
+			conn:    sc,
+			framing: framing,
+			send:    make(chan message, len(messages)),
+			stats:   NewStats(),
+		}
+		for _, m := range messages[1:] {
+			c.send <- m
+		}
+		assert.NoError(t, c.writeQueued(messages[0]))
+		assert.Equal(t, 0, len(c.send))
+	}
+
+	// read returns the websocket messages received, until none arrive
+	read := func() []string {
+		var got []string
+		for {
+			err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			assert.NoError(t, err)
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return got
+			}
+			got = append(got, string(data))
+		}
+	}
+
+	text := func(s string) message {
+		return message{data: []byte(s), mt: websocket.TextMessage}
+	}
+
+	// *** TestWriteQueuedMessage
+	queue(FramingMessage, text(`{"a":1}`), text(`{"b":2}`), text(`{"c":3}`))
+	assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}, read())
+
+	// A read that times out breaks a gorilla connection, so make a new one for each case.
+	conn.Close()
+	sc.Close()
+	conn, _, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	assert.NoError(t, err)
+	sc = <-conns
+
+	// *** TestWriteQueuedStream
+	queue(FramingStream, text("ab"), text("cd"), message{data: []byte("ef"), mt: websocket.BinaryMessage}, text("gh"))
+	assert.Equal(t, []string{"abcd", "ef", "gh"}, read()) // a change of type starts a new message
+}
@@ -0,0 +1,299 @@
+package crossbar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Policy sets limits for session connections to topics matching Pattern, so that
+// e.g. video topics can have larger messages, and smaller buffers, than data topics.
+// Zero values leave the relay's defaults, which are unlimited for the connection caps.
+type Policy struct {
+
+	// Pattern is matched against topics with MatchTopic
+	Pattern string `json:"pattern"`
+
+	// BufferSize is the number of messages buffered for each connection, instead of the relay's
+	BufferSize int64 `json:"bufferSize,omitempty"`
+
+	// MaxMessageSize is the largest message, in bytes, that a client may send
+	MaxMessageSize int64 `json:"maxMessageSize,omitempty"`
+
+	// MaxReaders limits the read-only connections to each topic
+	MaxReaders int `json:"maxReaders,omitempty"`
+
+	// MaxWriters limits the connections that can write to each topic
+	MaxWriters int `json:"maxWriters,omitempty"`
+
+	// MaxPerBooking limits the connections to each topic with the same booking ID
+	MaxPerBooking int `json:"maxPerBooking,omitempty"`
+
+	// MessageTypes lists the types of message that clients may send, text and/or binary,
+	// or all types if empty
+	MessageTypes []string `json:"messageTypes,omitempty"`
+
+	// Framing is how messages queued for a client are written, FramingStream
+	// or FramingMessage, or FramingStream if empty
+	Framing string `json:"framing,omitempty"`
+
+	// Compress compresses messages to clients that offer permessage-deflate,
+	// which suits text such as JSON, but not video
+	Compress bool `json:"compress,omitempty"`
+}
+
+// Policies holds the policies loaded from a file, which can be reloaded
+// so that limits can be changed without a restart. The first matching policy applies.
+type Policies struct {
+	file string
+
+	mu       sync.RWMutex
+	policies []Policy
+}
+
+// maxPolicyBufferSize is the largest BufferSize a policy can set, so that a typo
+// cannot allocate a huge buffer for every connection
+const maxPolicyBufferSize = 65536
+
+// policyFile is the format of the file that policies are loaded from
+type policyFile struct {
+	Topics []Policy `json:"topics"`
+}
+
+// NewPolicies returns Policies with no policies, until they are loaded
+func NewPolicies() *Policies {
+	return &Policies{}
+}
+
+// Load reads the policies from a JSON file, and remembers the file so that
+// Reload can read it again. If the file cannot be read, or any policy in it
+// is not valid, the policies are not changed and an error is returned.
+func (p *Policies) Load(file string) error {
+	p.file = file
+	return p.Reload()
+}
+
+// Reload reads the policies again from the file given to Load
+func (p *Policies) Reload() error {
+
+	if p.file == "" {
+		return errors.New("no policy file loaded")
+	}
+
+	data, err := os.ReadFile(p.file)
+	if err != nil {
+		return err
+	}
+
+	policies, err := ParsePolicies(data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.policies = policies
+
+	return nil
+}
+
+// ParsePolicies parses policies in the form
+// {"topics":[{"pattern":"*-video","bufferSize":32,"maxMessageSize":1048576,"messageTypes":["binary"]}]}
+func ParsePolicies(data []byte) ([]Policy, error) {
+
+	var f policyFile
+
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	for _, policy := range f.Topics {
+
+		if policy.Pattern == "" {
+			return nil, errors.New("policy has no pattern")
+		}
+
+		if policy.BufferSize < 0 || policy.MaxMessageSize < 0 || policy.MaxReaders < 0 || policy.MaxWriters < 0 || policy.MaxPerBooking < 0 {
+			return nil, fmt.Errorf("policy %s has a negative limit", policy.Pattern)
+		}
+
+		if policy.BufferSize > maxPolicyBufferSize {
+			return nil, fmt.Errorf("policy %s has a buffer size larger than %d", policy.Pattern, maxPolicyBufferSize)
+		}
+
+		for _, t := range policy.MessageTypes {
+			if t != "text" && t != "binary" {
+				return nil, fmt.Errorf("policy %s has unknown message type %s", policy.Pattern, t)
+			}
+		}
+
+		if policy.Framing != "" && policy.Framing != FramingStream && policy.Framing != FramingMessage {
+			return nil, fmt.Errorf("policy %s has unknown framing %s", policy.Pattern, policy.Framing)
+		}
+	}
+
+	return f.Topics, nil
+}
+
+// Len returns the number of policies loaded
+func (p *Policies) Len() int {
+
+	if p == nil {
+		return 0
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.policies)
+}
+
+// For returns the first policy that matches the topic, or a policy with
+// no limits if there is no match, or no policies are set
+func (p *Policies) For(topic string) Policy {
+
+	if p == nil {
+		return Policy{Pattern: "**"}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, policy := range p.policies {
+		if MatchTopic(policy.Pattern, topic) {
+			return policy
+		}
+	}
+
+	return Policy{Pattern: "**"}
+}
+
+// allows reports whether clients may send messages of the websocket message type
+func (p Policy) allows(mt int) bool {
+
+	if len(p.MessageTypes) == 0 {
+		return true
+	}
+
+	name := "binary"
+	if mt == websocket.TextMessage {
+		name = "text"
+	}
+
+	for _, t := range p.MessageTypes {
+		if t == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// framing returns how messages queued for clients on the topic are written
+func (p Policy) framing() string {
+
+	if p.Framing == "" {
+		return FramingStream
+	}
+
+	return p.Framing
+}
+
+// slot holds a place under a topic's policy for a client that is connecting,
+// from when it is admitted until it registers, so that clients connecting
+// at the same time cannot all take the last place
+type slot struct {
+	topic     string
+	bookingID string
+	canWrite  bool
+}
+
+// admit returns why a client cannot connect to the topic under the policy,
+// or an empty string if it can, counting the clients already connected and
+// the slots held for those connecting. If the client can connect, and the
+// policy has caps, it holds a slot for the client, which must be freed
+// when the client registers, or cancelled if it does not.
+func (h *Hub) admit(p Policy, topic, bookingID string, canWrite bool) (*slot, string) {
+
+	if p.MaxReaders == 0 && p.MaxWriters == 0 && p.MaxPerBooking == 0 {
+		return nil, ""
+	}
+
+	var readers, writers, booked int
+
+	count := func(w bool, bid string) {
+		if w {
+			writers++
+		} else {
+			readers++
+		}
+		if bookingID != "" && bid == bookingID {
+			booked++
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients[topicKey{ct: Session, topic: topic}] {
+		if client.conn == nil {
+			continue // internal clients have no connection
+		}
+		count(client.canWrite, client.bookingID)
+	}
+
+	for s := range h.slots[topic] {
+		count(s.canWrite, s.bookingID)
+	}
+
+	switch {
+	case canWrite && p.MaxWriters > 0 && writers >= p.MaxWriters:
+		return nil, "too many writers"
+	case !canWrite && p.MaxReaders > 0 && readers >= p.MaxReaders:
+		return nil, "too many readers"
+	case bookingID != "" && p.MaxPerBooking > 0 && booked >= p.MaxPerBooking:
+		return nil, "too many connections for booking"
+	}
+
+	s := &slot{topic: topic, bookingID: bookingID, canWrite: canWrite}
+
+	if _, ok := h.slots[topic]; !ok {
+		h.slots[topic] = make(map[*slot]bool)
+	}
+	h.slots[topic][s] = true
+
+	return s, ""
+}
+
+// freeSlot frees a slot once its client has registered, or will not.
+// The caller must hold the lock.
+func (h *Hub) freeSlot(s *slot) {
+
+	if s == nil {
+		return
+	}
+
+	delete(h.slots[s.topic], s)
+
+	if len(h.slots[s.topic]) == 0 {
+		delete(h.slots, s.topic)
+	}
+}
+
+// cancelSlot frees a slot for a client that failed to connect
+func (h *Hub) cancelSlot(s *slot) {
+
+	if s == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.freeSlot(s)
+}
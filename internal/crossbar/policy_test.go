@@ -0,0 +1,248 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicies(t *testing.T) {
+
+	p, err := ParsePolicies([]byte(`{"topics":[{"pattern":"*-video","bufferSize":32,"maxMessageSize":1024,"maxReaders":2,"maxWriters":1,"maxPerBooking":1,"messageTypes":["binary"]}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Policy{{Pattern: "*-video", BufferSize: 32, MaxMessageSize: 1024, MaxReaders: 2, MaxWriters: 1, MaxPerBooking: 1, MessageTypes: []string{"binary"}}}, p)
+
+	for _, bad := range []string{
+		`{"topics":[{"bufferSize":32}]}`,
+		`{"topics":[{"pattern":"*","maxReaders":-1}]}`,
+		`{"topics":[{"pattern":"*","bufferSize":100000}]}`,
+		`{"topics":[{"pattern":"*","messageTypes":["ping"]}]}`,
+		`{"topics":[{"pattern":"*","framing":"packet"}]}`,
+		`not json`,
+	} {
+		_, err := ParsePolicies([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPoliciesFor(t *testing.T) {
+
+	var none *Policies
+	assert.Equal(t, "**", none.For("pend00-data").Pattern)
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"topics":[{"pattern":"*-video","bufferSize":32},{"pattern":"**","bufferSize":8}]}`), 0600))
+
+	p := NewPolicies()
+	assert.Error(t, p.Reload())
+	assert.NoError(t, p.Load(file))
+	assert.Equal(t, 2, p.Len())
+
+	assert.Equal(t, int64(32), p.For("pend00-video").BufferSize)
+	assert.Equal(t, int64(8), p.For("pend00-data").BufferSize)
+
+	// the policies are not changed if the file is bad
+	assert.NoError(t, os.WriteFile(file, []byte(`{"topics":[{"bufferSize":16}]}`), 0600))
+	assert.Error(t, p.Reload())
+	assert.Equal(t, int64(32), p.For("pend00-video").BufferSize)
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"topics":[{"pattern":"*-video","bufferSize":16}]}`), 0600))
+	assert.NoError(t, p.Reload())
+	assert.Equal(t, int64(16), p.For("pend00-video").BufferSize)
+	assert.Equal(t, int64(0), p.For("pend00-data").BufferSize)
+}
+
+func TestPolicyAllows(t *testing.T) {
+	assert.True(t, Policy{}.allows(websocket.TextMessage))
+	assert.True(t, Policy{MessageTypes: []string{"binary"}}.allows(websocket.BinaryMessage))
+	assert.False(t, Policy{MessageTypes: []string{"binary"}}.allows(websocket.TextMessage))
+	assert.True(t, Policy{MessageTypes: []string{"text", "binary"}}.allows(websocket.TextMessage))
+}
+
+func TestPolicyFraming(t *testing.T) {
+	assert.Equal(t, FramingStream, Policy{}.framing())
+	assert.Equal(t, FramingMessage, Policy{Framing: FramingMessage}.framing())
+
+	p, err := ParsePolicies([]byte(`{"topics":[{"pattern":"*-data","framing":"message"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, FramingMessage, p[0].Framing)
+
+	p, err = ParsePolicies([]byte(`{"topics":[{"pattern":"*-data","compress":true}]}`))
+	assert.NoError(t, err)
+	assert.True(t, p[0].Compress)
+}
+
+func TestPolicy(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"topics":[{"pattern":"pol00-video","maxMessageSize":16,"maxReaders":1,"maxWriters":1,"messageTypes":["binary"]},{"pattern":"pol00-data","maxPerBooking":1},{"pattern":"pol02-data","maxWriters":2}]}`), 0600))
+
+	policies := NewPolicies()
+	assert.NoError(t, policies.Load(file))
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        New(),
+		Policies:   policies,
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dial := func(topic, bid string, scopes []string) (*websocket.Conn, *http.Response, error) {
+		token := MakeTestToken(audience, "session", topic, scopes, 10)
+		token.SetBookingID(bid)
+		return websocket.DefaultDialer.Dial(audience+"/session/"+topic+"?code="+cs.SubmitToken(token), nil)
+	}
+
+	// closeCode returns the code the connection was closed with, if it was
+	closeCode := func(conn *websocket.Conn) int {
+		err := conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		for {
+			_, _, err = conn.ReadMessage()
+			if ce, ok := err.(*websocket.CloseError); ok {
+				return ce.Code
+			}
+			if err != nil {
+				return 0
+			}
+		}
+	}
+
+	// *** TestPolicyCaps
+	writer, _, err := dial("pol00-video", "bid0", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer writer.Close()
+
+	reader, _, err := dial("pol00-video", "bid1", []string{"read"})
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, resp, err := dial("pol00-video", "bid2", []string{"read", "write"})
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	_, resp, err = dial("pol00-video", "bid3", []string{"read"})
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	d0, _, err := dial("pol00-data", "bid0", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer d0.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, resp, err = dial("pol00-data", "bid0", []string{"read"})
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	// other bookings, and topics without a policy, are not limited
+	d1, _, err := dial("pol00-data", "bid1", []string{"read"})
+	assert.NoError(t, err)
+	defer d1.Close()
+	for i := 0; i < 3; i++ {
+		c, _, err := dial("pol01-data", "bid0", []string{"read", "write"})
+		assert.NoError(t, err)
+		defer c.Close()
+	}
+
+	// *** TestPolicyConcurrentDials
+	// clients connecting at the same time cannot all take the last places
+	var mu sync.Mutex
+	var dials sync.WaitGroup
+	var admitted []*websocket.Conn
+	refused := 0
+
+	for i := 0; i < 20; i++ {
+		dials.Add(1)
+		go func(i int) {
+			defer dials.Done()
+			c, resp, err := dial("pol02-data", "bid"+strconv.Itoa(i), []string{"read", "write"})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				admitted = append(admitted, c)
+				return
+			}
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				refused++
+			}
+		}(i)
+	}
+	dials.Wait()
+
+	assert.Equal(t, 2, len(admitted))
+	assert.Equal(t, 18, refused)
+
+	for _, c := range admitted {
+		c.Close()
+	}
+
+	// places are given back when clients leave
+	time.Sleep(100 * time.Millisecond)
+	c, _, err := dial("pol02-data", "bid20", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// *** TestPolicyMessages
+	assert.NoError(t, writer.WriteMessage(websocket.BinaryMessage, []byte("frame")))
+	err = reader.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	mt, data, err := reader.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, mt)
+	assert.Equal(t, "frame", string(data))
+
+	assert.NoError(t, writer.WriteMessage(websocket.TextMessage, []byte("text")))
+	assert.Equal(t, websocket.CloseUnsupportedData, closeCode(writer))
+
+	time.Sleep(100 * time.Millisecond) // for the writer to leave
+
+	w2, _, err := dial("pol00-video", "bid4", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer w2.Close()
+	assert.NoError(t, w2.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte("x"), 17)))
+	assert.Equal(t, websocket.CloseMessageTooBig, closeCode(w2))
+}
@@ -0,0 +1,106 @@
+package crossbar
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// PresenceProtocol is the websocket subprotocol that clients request to be sent
+// PresenceEvents when other clients join or leave their topic, e.g. so that an
+// experiment can park its hardware when the last student leaves. Clients that
+// request it are also treated as if they had requested SessionProtocol.
+const PresenceProtocol = "presence.relay.practable.io"
+
+// PresenceEvent is sent to clients that requested PresenceProtocol about another
+// client on their topic. Presence is join or leave, or here for the clients already on
+// the topic when the recipient joins. ID identifies the other client's connection, as
+// in the status reports. Clients and Writers count the clients on the topic after the
+// event, and those that can write, not including the recipient, so a leave with no
+// Writers means that the last writer has left. Only clients on the same node are counted.
+type PresenceEvent struct {
+	Presence  string   `json:"presence"`
+	ID        string   `json:"id"`
+	BookingID string   `json:"bookingID,omitempty"`
+	Scopes    []string `json:"scopes"`
+	CanWrite  bool     `json:"canWrite"`
+	Clients   int      `json:"clients"`
+	Writers   int      `json:"writers"`
+}
+
+// present reports whether other clients are told when the client joins or leaves,
+// which is only for session connections; internal clients have no connection
+func (c *Client) present() bool {
+	return c.conn != nil && c.ct == Session && !c.watching
+}
+
+// announcePresence tells the clients on the topic that asked for presence events that
+// the client has joined or left, and tells a joining client that asked for them who is
+// already there. The caller must hold the lock, and have already added or removed the client.
+func (h *Hub) announcePresence(client *Client, presence string) {
+
+	if !client.present() {
+		return
+	}
+
+	var others []*Client
+
+	for c := range h.clients[client.key()] {
+		if c != client && c.present() {
+			others = append(others, c)
+		}
+	}
+
+	event := func(to *Client, about *Client, presence string) PresenceEvent {
+
+		e := PresenceEvent{
+			Presence:  presence,
+			ID:        about.name,
+			BookingID: about.bookingID,
+			Scopes:    about.scopes,
+			CanWrite:  about.canWrite,
+		}
+
+		// count the clients on the topic, other than the recipient
+		for _, c := range others {
+			if c == to {
+				continue
+			}
+			e.Clients++
+			if c.canWrite {
+				e.Writers++
+			}
+		}
+
+		if presence == "join" && to != client {
+			// the client that joined is on the topic, but not in others
+			e.Clients++
+			if client.canWrite {
+				e.Writers++
+			}
+		}
+
+		return e
+	}
+
+	for _, c := range others {
+		if c.presence {
+			c.tell(event(c, client, presence))
+		}
+	}
+
+	if presence == "join" && client.presence {
+		for _, c := range others {
+			client.tell(event(client, c, "here"))
+		}
+	}
+
+	log.WithFields(log.Fields{"topic": client.topic, "name": client.name, "booking_id": client.bookingID, "presence": presence}).Debug("presence announced")
+}
+
+// tell sends a presence event to the client
+func (c *Client) tell(e PresenceEvent) {
+	select {
+	case c.presences <- e:
+	default:
+		log.WithFields(log.Fields{"topic": c.topic, "name": c.name, "presence": e.Presence}).Warn("presence event not sent because client is not reading")
+	}
+}
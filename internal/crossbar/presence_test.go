@@ -0,0 +1,144 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresence(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        New(),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dial := func(bid string, scopes []string, subprotocols []string) *websocket.Conn {
+		token := MakeTestToken(audience, "session", "pres00", scopes, 10)
+		token.SetBookingID(bid)
+		dialer := websocket.Dialer{Subprotocols: subprotocols}
+		conn, _, err := dialer.Dial(audience+"/session/pres00?code="+cs.SubmitToken(token), nil)
+		assert.NoError(t, err)
+		return conn
+	}
+
+	// next returns the next presence event sent to the connection
+	next := func(conn *websocket.Conn) PresenceEvent {
+		var e PresenceEvent
+		err := conn.SetReadDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		_, data, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &e))
+		return e
+	}
+
+	// the experiment asks for presence events
+	host := dial("", []string{"read", "write"}, []string{PresenceProtocol})
+	defer host.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestPresenceJoin
+	s0 := dial("bid0", []string{"read", "write"}, nil)
+	defer s0.Close()
+
+	e := next(host)
+	assert.Equal(t, "join", e.Presence)
+	assert.Equal(t, "bid0", e.BookingID)
+	assert.Equal(t, []string{"read", "write"}, e.Scopes)
+	assert.True(t, e.CanWrite)
+	assert.NotEqual(t, "", e.ID)
+	assert.Equal(t, 1, e.Clients)
+	assert.Equal(t, 1, e.Writers)
+	id0 := e.ID
+
+	s1 := dial("bid1", []string{"read"}, nil)
+	defer s1.Close()
+
+	e = next(host)
+	assert.Equal(t, "join", e.Presence)
+	assert.Equal(t, "bid1", e.BookingID)
+	assert.False(t, e.CanWrite)
+	assert.Equal(t, 2, e.Clients)
+	assert.Equal(t, 1, e.Writers)
+
+	// *** TestPresenceHere
+	// a client that asks for presence events when it joins is told who is there
+	w := dial("bid2", []string{"read"}, []string{PresenceProtocol})
+	defer w.Close()
+
+	here := make(map[string]PresenceEvent)
+	for i := 0; i < 3; i++ {
+		e = next(w)
+		assert.Equal(t, "here", e.Presence)
+		assert.Equal(t, 3, e.Clients)
+		assert.Equal(t, 2, e.Writers)
+		here[e.BookingID] = e
+	}
+	assert.Equal(t, id0, here["bid0"].ID)
+	assert.Contains(t, here, "bid1")
+	assert.Contains(t, here, "")
+
+	e = next(host)
+	assert.Equal(t, "join", e.Presence)
+	assert.Equal(t, "bid2", e.BookingID)
+
+	// *** TestPresenceLeave
+	assert.NoError(t, s0.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+
+	e = next(host)
+	assert.Equal(t, "leave", e.Presence)
+	assert.Equal(t, id0, e.ID)
+	assert.Equal(t, 2, e.Clients)
+	assert.Equal(t, 0, e.Writers) // the last writer, other than the host, has left
+
+	e = next(w)
+	assert.Equal(t, "leave", e.Presence)
+	assert.Equal(t, id0, e.ID)
+	assert.Equal(t, 2, e.Clients)
+	assert.Equal(t, 1, e.Writers) // the host
+
+	// *** TestPresenceNotSentWithoutProtocol
+	// clients that did not ask for presence events only get messages
+	assert.NoError(t, host.WriteMessage(websocket.TextMessage, []byte("hello")))
+	err = s1.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	_, data, err := s1.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
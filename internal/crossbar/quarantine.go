@@ -0,0 +1,82 @@
+package crossbar
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/deny"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeniedTopic returns the denial that refuses a token with the scopes
+// a connection to the topic, if there is one. If the denials cannot be got,
+// e.g. because the store is unreachable, every topic is denied, as booking
+// IDs are, so that a denied topic is not let through.
+func DeniedTopic(ds deny.Backend, topic string, scopes []string) (deny.Topic, bool) {
+
+	denials, err := ds.GetDeniedTopics()
+
+	if err != nil {
+		return deny.Topic{Pattern: topic, Reason: UncheckedTopicReason}, true
+	}
+
+	for _, d := range denials {
+		if MatchTopic(d.Pattern, topic) && !d.Exempts(scopes) {
+			return d, true
+		}
+	}
+	return deny.Topic{}, false
+}
+
+// UncheckedTopicReason is given to clients refused because the topic denials could not be checked
+const UncheckedTopicReason = "topic could not be checked, please try again"
+
+// TopicDeniedReason returns the reason given to clients refused or disconnected by the denial
+func TopicDeniedReason(d deny.Topic) string {
+	if d.Reason == "" {
+		return "topic unavailable"
+	}
+	return d.Reason
+}
+
+// Quarantine closes the connections to this node on topics matching the denial, except
+// those with exempt scopes, sending its reason with CloseDenied, and returns reports
+// on the connections it closed. Shell connections are matched by their session.
+func (h *Hub) Quarantine(d deny.Topic) []*ClientReport {
+
+	reason := TopicDeniedReason(d)
+
+	if len(reason) > MaxCloseReason {
+		reason = reason[:MaxCloseReason]
+	}
+
+	closeMessage := websocket.FormatCloseMessage(CloseDenied, reason)
+
+	node := ""
+	if h.cluster != nil {
+		node = h.cluster.config.NodeID
+	}
+
+	var reports []*ClientReport
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, clients := range h.clients {
+		for client := range clients {
+
+			if client.conn == nil || d.Exempts(client.scopes) {
+				continue // internal clients have no connection
+			}
+
+			if !MatchTopic(d.Pattern, client.topic) {
+				continue
+			}
+
+			reports = append(reports, client.report(node))
+			h.evict(client, closeMessage)
+
+			log.WithFields(log.Fields{"topic": client.topic, "name": client.name, "booking_id": client.bookingID, "pattern": d.Pattern}).Info("connection closed because topic denied")
+		}
+	}
+
+	return reports
+}
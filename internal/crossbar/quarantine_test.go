@@ -0,0 +1,164 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+	hub := New()
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  ds,
+		Hub:        hub,
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dial := func(topic, bid string, scopes []string) (*websocket.Conn, *http.Response, error) {
+		token := MakeTestToken(audience, "session", topic, scopes, 10)
+		token.SetBookingID(bid)
+		return websocket.DefaultDialer.Dial(audience+"/session/"+topic+"?code="+cs.SubmitToken(token), nil)
+	}
+
+	// connected reports whether a connection with the booking ID is in the hub
+	connected := func(bid string) bool {
+		for _, r := range hub.GetClientReports() {
+			if r.BookingID == bid {
+				return true
+			}
+		}
+		return false
+	}
+
+	c0, _, err := dial("quar00-data", "bid0", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer c0.Close()
+	c1, _, err := dial("quar00-video", "bid1", []string{"read", "write", "relay:admin"})
+	assert.NoError(t, err)
+	defer c1.Close()
+	c2, _, err := dial("quar01-data", "bid2", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer c2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestQuarantineNothing
+	assert.Equal(t, 0, len(hub.Quarantine(deny.Topic{Pattern: "quar02*", Exp: time.Now().Unix() + 60})))
+
+	// *** TestQuarantinePattern
+	d := deny.Topic{
+		Pattern: "quar00*",
+		Exp:     time.Now().Unix() + 60,
+		Exempt:  []string{"relay:admin"},
+		Reason:  "pendulum under repair",
+	}
+
+	ds.DenyTopic(d)
+
+	reports := hub.Quarantine(d)
+	if assert.Equal(t, 1, len(reports)) {
+		assert.Equal(t, "bid0", reports[0].BookingID)
+		assert.Equal(t, "quar00-data", reports[0].Topic)
+	}
+
+	err = c0.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	assert.NoError(t, err)
+	_, _, err = c0.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); assert.True(t, ok) {
+		assert.Equal(t, CloseDenied, ce.Code)
+		assert.Equal(t, "pendulum under repair", ce.Text)
+	}
+
+	assert.True(t, connected("bid1")) // exempt
+	assert.True(t, connected("bid2")) // on another topic
+
+	// *** TestQuarantineRefused
+	_, resp, err := dial("quar00-data", "bid3", []string{"read", "write"})
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+
+	// exempt tokens are still let through
+	c4, _, err := dial("quar00-data", "bid4", []string{"read", "write", "relay:admin"})
+	assert.NoError(t, err)
+	defer c4.Close()
+
+	// *** TestQuarantineAllowed
+	ds.AllowTopic("quar00*")
+
+	c5, _, err := dial("quar00-data", "bid5", []string{"read", "write"})
+	assert.NoError(t, err)
+	defer c5.Close()
+}
+
+func TestDeniedTopic(t *testing.T) {
+
+	ds := deny.New()
+
+	_, ok := DeniedTopic(ds, "pend00-data", nil)
+	assert.False(t, ok)
+
+	ds.DenyTopic(deny.Topic{Pattern: "pend00-*", Exp: ds.GetTime() + 60, Exempt: []string{"host"}})
+
+	d, ok := DeniedTopic(ds, "pend00-data", []string{"read"})
+	assert.True(t, ok)
+	assert.Equal(t, "pend00-*", d.Pattern)
+	assert.Equal(t, "topic unavailable", TopicDeniedReason(d))
+
+	_, ok = DeniedTopic(ds, "pend00-data", []string{"read", "host"})
+	assert.False(t, ok)
+
+	_, ok = DeniedTopic(ds, "pend01-data", []string{"read"})
+	assert.False(t, ok)
+
+	// every topic is denied when the denials cannot be got
+	d, ok = DeniedTopic(unreachable{ds}, "pend01-data", []string{"read", "host"})
+	assert.True(t, ok)
+	assert.Equal(t, UncheckedTopicReason, TopicDeniedReason(d))
+}
+
+// unreachable is a deny backend whose topic denials cannot be got, like a store that is down
+type unreachable struct {
+	*deny.Store
+}
+
+func (u unreachable) GetDeniedTopics() ([]deny.Topic, error) {
+	return nil, errors.New("store unreachable")
+}
@@ -0,0 +1,87 @@
+package crossbar
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/record"
+	log "github.com/sirupsen/logrus"
+)
+
+// errStopped stops reading a recording when a replay is closed
+var errStopped = errors.New("replay stopped")
+
+// recording reports whether messages on a topic are recorded
+func (h *Hub) recording(topic string) bool {
+	rec, ok := h.recorded.Load(topic)
+	if !ok {
+		rec = matchAny(h.record, topic)
+		h.recorded.Store(topic, rec)
+	}
+	return rec.(bool)
+}
+
+// record returns the message as a record for the recorder
+func (m message) record() record.Record {
+	direction := record.Received
+	if m.replay {
+		direction = record.Sent
+	}
+	return record.NewRecord(m.sent, direction, m.sender.bookingID, m.sender.topic, m.mt == websocket.BinaryMessage, m.data)
+}
+
+// Replay publishes the records selected by the filter, which must name a topic, into
+// a topic at their original timing. Clients on the topic receive them as if they were
+// sent by another client. It returns when the records are finished or closed is closed.
+func (h *Hub) Replay(closed <-chan struct{}, store *record.Store, f record.Filter, topic string) error {
+
+	if f.Topic == "" {
+		return errors.New("replay needs a recording topic")
+	}
+
+	sender := source{topic: topic, name: "replay-" + uuid.New().String()}
+
+	var first time.Time
+	start := time.Now()
+	count := 0
+
+	err := store.Read(f, func(r record.Record) error {
+
+		if first.IsZero() {
+			first = r.Time
+		}
+
+		// wait until the same time has passed since the start as in the recording
+		if wait := r.Time.Sub(first) - time.Since(start); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-closed:
+				return errStopped
+			}
+		}
+
+		mt := websocket.TextMessage
+		if r.Type == record.Binary {
+			mt = websocket.BinaryMessage
+		}
+
+		sender.bookingID = r.BookingID
+
+		if !h.publish(message{sender: sender, mt: mt, data: r.Payload(), sent: time.Now(), session: true, replay: true}, closed) {
+			return errStopped
+		}
+
+		count++
+		return nil
+	})
+
+	if errors.Is(err, errStopped) {
+		err = nil
+	}
+
+	log.WithFields(log.Fields{"from": f.Topic, "topic": topic, "booking_id": f.BookingID, "messages": count}).Info("replay finished")
+
+	return err
+}
@@ -0,0 +1,135 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/reconws"
+	"github.com/practable/relay/internal/record"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	cs := ttlcode.NewDefaultCodeStore()
+
+	rs := record.New(t.TempDir(), 64)
+	go rs.Run(closed)
+
+	config := Config{
+		Listen:     port,
+		Audience:   audience,
+		BufferSize: 128,
+		CodeStore:  cs,
+		DenyStore:  deny.New(),
+		Hub:        New().WithRecorder(rs, []string{"pend*-data"}),
+		StatsEvery: time.Second,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dial := func(topic, bid string) *reconws.ReconWs {
+		token := MakeTestToken(audience, "session", topic, []string{"read", "write"}, 10)
+		token.SetBookingID(bid)
+		r := reconws.New()
+		go func() {
+			err := r.Dial(ctx, audience+"/session/"+topic+"?code="+cs.SubmitToken(token))
+			assert.NoError(t, err)
+		}()
+		return r
+	}
+
+	user := dial("pend00-data", "b0")
+	expt := dial("pend00-data", "")
+	other := dial("pend00-video", "b0")
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestRecordsSessionMessages
+	user.Out <- reconws.WsMessage{Data: []byte("start"), Type: websocket.TextMessage}
+	expectData(t, expt, "start")
+	time.Sleep(300 * time.Millisecond)
+	expt.Out <- reconws.WsMessage{Data: []byte{0, 1, 2}, Type: websocket.BinaryMessage}
+	expectData(t, user, string([]byte{0, 1, 2}))
+	other.Out <- reconws.WsMessage{Data: []byte("not recorded"), Type: websocket.TextMessage}
+
+	var records []record.Record
+	f := record.Filter{Topic: "pend00-data"}
+
+	assert.Eventually(t, func() bool {
+		records = nil
+		err := rs.Read(f, func(r record.Record) error {
+			records = append(records, r)
+			return nil
+		})
+		return err == nil && len(records) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, record.Received, records[0].Direction)
+		assert.Equal(t, "b0", records[0].BookingID)
+		assert.Equal(t, record.Text, records[0].Type)
+		assert.Equal(t, "start", records[0].Text)
+		assert.Equal(t, "", records[1].BookingID)
+		assert.Equal(t, record.Binary, records[1].Type)
+		assert.Equal(t, []byte{0, 1, 2}, records[1].Data)
+	}
+
+	list, err := rs.List(record.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	// *** TestReplayKeepsTiming
+	viewer := dial("replay00", "")
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan error)
+	start := time.Now()
+	go func() {
+		done <- config.Hub.Replay(closed, rs, f, "replay00")
+	}()
+
+	expectData(t, viewer, "start")
+
+	select {
+	case msg := <-viewer.In:
+		assert.Equal(t, []byte{0, 1, 2}, msg.Data)
+		assert.Equal(t, websocket.BinaryMessage, msg.Type)
+		assert.True(t, time.Since(start) > 250*time.Millisecond, "replay too fast")
+	case <-time.After(time.Second):
+		t.Error("replayed binary message not received")
+	}
+
+	assert.NoError(t, <-done)
+
+	assert.Error(t, config.Hub.Replay(closed, rs, record.Filter{}, "replay00"))
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	close(closed)
+	wg.Wait()
+}
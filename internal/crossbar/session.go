@@ -0,0 +1,303 @@
+package crossbar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/permission"
+	log "github.com/sirupsen/logrus"
+)
+
+// SessionProtocol is the websocket subprotocol that clients request to receive
+// SessionStatus messages, and to send SessionCommands. Clients that do not request
+// it are not sent anything other than messages from the topic, as before.
+const SessionProtocol = "session.relay.practable.io"
+
+// SessionCommand is sent by a client to extend its connection when its booking is
+// extended. Session is always refresh, with either a Code obtained from access for
+// the same topic, or the Token that the client uses to request access.
+type SessionCommand struct {
+	Session string `json:"session"`
+	Code    string `json:"code,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// SessionStatus is sent to a client about its connection. Session is expiring when
+// the connection is about to expire, refreshed when a SessionCommand has been accepted,
+// or refused (with the Reason) when it has not. ExpiresAt is when the connection
+// expires, in seconds since the unix epoch.
+type SessionStatus struct {
+	Session   string `json:"session"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// sessionPrefix identifies session commands, which are not relayed to other clients
+var sessionPrefix = []byte(`{"session"`)
+
+// isSessionCommand reports whether a message is a session command
+func isSessionCommand(mt int, data []byte) bool {
+	return mt == websocket.TextMessage && bytes.HasPrefix(bytes.TrimSpace(data), sessionPrefix)
+}
+
+// refusal explains why a token was refused
+type refusal struct {
+	// status is the HTTP status for refusing a new connection
+	status int
+	// reason is the label for the failed code exchanges metric
+	reason string
+	// message is sent to the client
+	message string
+}
+
+// checkToken applies the checks that a token must pass to connect to the topic with
+// the connection type, or to extend a connection to it, returning nil if it passes.
+func checkToken(config Config, token permission.Token, audience, connectionType, topic string) *refusal {
+
+	// check token is a permission token so we can process it properly
+	// It's been validated so we don't need to re-do that
+	if !permission.HasRequiredClaims(token) {
+		return &refusal{http.StatusUnauthorized, "missing claims", "token missing claims"}
+	}
+
+	now := config.CodeStore.GetTime()
+
+	if token.NotBefore.After(time.Unix(now, 0)) {
+		return &refusal{http.StatusTooEarly, "too early", "too early"}
+	}
+
+	audok := false
+
+	for _, aud := range token.Audience {
+		if aud == audience {
+			audok = true
+		}
+	}
+
+	switch {
+	case !audok:
+		return &refusal{http.StatusForbidden, "wrong audience", "wrong audience"}
+	case topic != token.Topic:
+		return &refusal{http.StatusForbidden, "wrong topic", "wrong topic"}
+	case connectionType != token.ConnectionType:
+		return &refusal{http.StatusForbidden, "wrong connection type", "wrong connection type"}
+	case token.ExpiresAt.Unix() < now:
+		return &refusal{http.StatusUnauthorized, "expired", "token expired"}
+	}
+
+	// we must check the booking is not denied here, else a user could request access, get a code, cancel booking, then use code to start a connection
+	if config.DenyStore.IsDenied(token.BookingID) {
+		return &refusal{http.StatusForbidden, "denied", "booking cancelled"}
+	}
+
+	if d, ok := DeniedTopic(config.DenyStore, topic, token.Scopes); ok {
+		return &refusal{http.StatusForbidden, "topic denied", TopicDeniedReason(d)}
+	}
+
+	return nil
+}
+
+// refresh checks the token or code in a session command, and returns
+// when the client's connection should now expire
+func (c *Client) refresh(config Config, cmd SessionCommand) (int64, error) {
+
+	if cmd.Session != "refresh" {
+		return 0, errors.New("unknown session command " + cmd.Session)
+	}
+
+	if c.ct == Shell {
+		return 0, errors.New("shell connections cannot be refreshed")
+	}
+
+	var token permission.Token
+	var audience string
+
+	switch {
+
+	case cmd.Code != "":
+
+		var err error
+
+		token, err = config.CodeStore.ExchangeCode(cmd.Code)
+
+		if err != nil {
+			return 0, errors.New("invalid code")
+		}
+
+		audience = config.Audience
+
+	case cmd.Token != "":
+
+		keys := config.Keys
+
+		if keys == nil && config.Secret != "" {
+			keys = keyset.New(config.Secret)
+		}
+
+		if keys == nil || config.TokenAudience == "" {
+			return 0, errors.New("refreshing with a token is not enabled, use a code")
+		}
+
+		_, err := jwt.ParseWithClaims(cmd.Token, &token, keys.Keyfunc)
+
+		// the claims are dereferenced when they are checked
+		if err != nil || token.ExpiresAt == nil || token.NotBefore == nil {
+			return 0, errors.New("invalid token")
+		}
+
+		audience = config.TokenAudience
+
+	default:
+		return 0, errors.New("refresh needs a code or token")
+	}
+
+	if r := checkToken(config, token, audience, "session", c.topic); r != nil {
+		return 0, errors.New(r.message)
+	}
+
+	// the connection can be cancelled with its booking, and keeps its permissions
+	if token.BookingID != c.bookingID {
+		return 0, errors.New("token is for a different booking")
+	}
+
+	if !sameScopes(token.Scopes, c.scopes) {
+		return 0, errors.New("token has different scopes, reconnect to change scopes")
+	}
+
+	if cmd.Token != "" {
+		// track the booking as access does, since it did not see this token
+		config.DenyStore.Allow(token.BookingID, token.ExpiresAt.Unix())
+	}
+
+	return token.ExpiresAt.Unix(), nil
+}
+
+// sameScopes reports whether a and b hold the same scopes, in any order
+func sameScopes(a, b []string) bool {
+	as := append([]string{}, a...)
+	bs := append([]string{}, b...)
+	sort.Strings(as)
+	sort.Strings(bs)
+	return strings.Join(as, " ") == strings.Join(bs, " ")
+}
+
+// notify sends a session status to the client, if it asked for them
+func (c *Client) notify(status SessionStatus) {
+
+	if !c.sessionProtocol {
+		return
+	}
+
+	select {
+	case c.status <- status:
+	default:
+		log.WithFields(log.Fields{"topic": c.topic, "name": c.name, "session": status.Session}).Warn("session status not sent because client is not reading")
+	}
+}
+
+// expire cancels the client's connection when its token expires, or when its booking
+// is cancelled, warning the client before it expires and extending the connection
+// if the client refreshes its token. It returns once the connection is cancelled,
+// or has ended for another reason.
+func (c *Client) expire(config Config, cancelled chan struct{}, cf log.Fields) {
+
+	defer close(cancelled)
+
+	ttl := func() time.Duration {
+		return time.Duration(atomic.LoadInt64(&c.expiresAt)-config.CodeStore.GetTime()) * time.Second
+	}
+
+	expiry := time.NewTimer(ttl())
+	defer expiry.Stop()
+
+	// warnings are only for clients that can read them
+	var warning *time.Timer
+	var warn <-chan time.Time
+
+	if c.sessionProtocol && config.ExpiryWarning > 0 {
+		warning = time.NewTimer(ttl() - config.ExpiryWarning)
+		defer warning.Stop()
+		warn = warning.C
+	}
+
+	for {
+		select {
+
+		case <-warn:
+			c.notify(SessionStatus{Session: "expiring", ExpiresAt: atomic.LoadInt64(&c.expiresAt)})
+
+		case cmd := <-c.refreshes:
+
+			expiresAt, err := c.refresh(config, cmd)
+
+			if err != nil {
+				log.WithFields(cf).WithField("error", err.Error()).Warn("connection not refreshed")
+				c.notify(SessionStatus{Session: "refused", ExpiresAt: atomic.LoadInt64(&c.expiresAt), Reason: err.Error()})
+				continue
+			}
+
+			atomic.StoreInt64(&c.expiresAt, expiresAt)
+
+			reset(expiry, ttl())
+
+			if warning != nil {
+				reset(warning, ttl()-config.ExpiryWarning)
+			}
+
+			log.WithFields(cf).WithField("expires_at", time.Unix(expiresAt, 0).String()).Info("connection refreshed")
+			c.notify(SessionStatus{Session: "refreshed", ExpiresAt: expiresAt})
+
+		case <-expiry.C:
+			log.WithFields(cf).WithField("reason", "token expired").Info("connection closed")
+			c.end("session expired")
+			c.cancelMessage = websocket.FormatCloseMessage(CloseExpired, "session expired")
+			return
+
+		case <-c.denied:
+			log.WithFields(cf).WithField("reason", "token denied").Info("connection closed")
+			c.end("booking cancelled")
+			c.cancelMessage = websocket.FormatCloseMessage(CloseDenied, "booking cancelled")
+			return
+
+		case <-c.gone:
+			return
+		}
+	}
+}
+
+// reset restarts a timer, discarding any expiry that has not been received
+func reset(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// readSessionCommand passes a session command from the client to expire
+func (c *Client) readSessionCommand(data []byte) {
+
+	var cmd SessionCommand
+
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic}).Warn("session command not understood")
+		c.notify(SessionStatus{Session: "refused", ExpiresAt: atomic.LoadInt64(&c.expiresAt), Reason: "session command not understood"})
+		return
+	}
+
+	select {
+	case c.refreshes <- cmd:
+	case <-c.cancelled:
+	}
+}
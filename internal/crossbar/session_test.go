@@ -0,0 +1,233 @@
+package crossbar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/websocket"
+	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSessionCommand(t *testing.T) {
+	assert.True(t, isSessionCommand(websocket.TextMessage, []byte(`{"session":"refresh","code":"abc"}`)))
+	assert.True(t, isSessionCommand(websocket.TextMessage, []byte(` {"session": "refresh"}`)))
+	assert.False(t, isSessionCommand(websocket.BinaryMessage, []byte(`{"session":"refresh"}`)))
+	assert.False(t, isSessionCommand(websocket.TextMessage, []byte(`{"floor":"request"}`)))
+}
+
+func TestSameScopes(t *testing.T) {
+	assert.True(t, sameScopes([]string{"read", "write"}, []string{"write", "read"}))
+	assert.False(t, sameScopes([]string{"read"}, []string{"read", "write"}))
+	assert.False(t, sameScopes([]string{"read", "admin"}, []string{"read", "write"}))
+}
+
+func TestCheckToken(t *testing.T) {
+
+	audience := "ws://relay.example.io"
+	ds := deny.New()
+
+	config := Config{
+		Audience:  audience,
+		CodeStore: ttlcode.NewDefaultCodeStore(),
+		DenyStore: ds,
+	}
+
+	now := time.Now().Unix()
+
+	ok := MakeTestToken(audience, "session", "check00", []string{"read"}, 10)
+	assert.Nil(t, checkToken(config, ok, audience, "session", "check00"))
+
+	early := permission.NewToken(audience, "session", "check00", []string{"read"}, now, now+60, now+120)
+
+	denied := MakeTestToken(audience, "session", "check00", []string{"read"}, 10)
+	denied.SetBookingID("bid-denied")
+	ds.Deny("bid-denied", now+60)
+
+	tests := []struct {
+		name   string
+		token  permission.Token
+		topic  string
+		status int
+		reason string
+	}{
+		{"missing claims", permission.NewToken(audience, "", "check00", []string{"read"}, now, now, now+10), "check00", http.StatusUnauthorized, "missing claims"},
+		{"too early", early, "check00", http.StatusTooEarly, "too early"},
+		{"wrong audience", MakeTestToken("ws://other.example.io", "session", "check00", []string{"read"}, 10), "check00", http.StatusForbidden, "wrong audience"},
+		{"wrong topic", ok, "check01", http.StatusForbidden, "wrong topic"},
+		{"wrong connection type", MakeTestToken(audience, "shell", "check00", []string{"read"}, 10), "check00", http.StatusForbidden, "wrong connection type"},
+		{"expired", MakeTestToken(audience, "session", "check00", []string{"read"}, -5), "check00", http.StatusUnauthorized, "expired"},
+		{"denied", denied, "check00", http.StatusForbidden, "denied"},
+	}
+
+	for _, test := range tests {
+		r := checkToken(config, test.token, audience, "session", test.topic)
+		if assert.NotNil(t, r, test.name) {
+			assert.Equal(t, test.status, r.status, test.name)
+			assert.Equal(t, test.reason, r.reason, test.name)
+		}
+	}
+}
+
+// awaitSession returns the next session status received, skipping any other messages
+func awaitSession(t *testing.T, conn *websocket.Conn, wait time.Duration) SessionStatus {
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(wait)))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no session status received because %s", err.Error())
+			return SessionStatus{}
+		}
+		var s SessionStatus
+		if json.Unmarshal(data, &s) == nil && s.Session != "" {
+			return s
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	port, err := freeport.GetFreePort()
+	assert.NoError(t, err)
+
+	audience := "ws://127.0.0.1:" + strconv.Itoa(port)
+	accessAudience := "http://127.0.0.1:" + strconv.Itoa(port) + "/access"
+	secret := "somesecret"
+	cs := ttlcode.NewDefaultCodeStore()
+
+	config := Config{
+		Listen:        port,
+		Audience:      audience,
+		BufferSize:    128,
+		CodeStore:     cs,
+		DenyStore:     deny.New(),
+		ExpiryWarning: 2 * time.Second,
+		Hub:           New(),
+		Secret:        secret,
+		StatsEvery:    time.Second,
+		TokenAudience: accessAudience,
+	}
+
+	wg.Add(1)
+	go Crossbar(config, closed, make(chan string), &wg)
+	time.Sleep(time.Second)
+
+	dialer := websocket.Dialer{Subprotocols: []string{SessionProtocol}}
+
+	token := MakeTestToken(audience, "session", "refresh00", []string{"read", "write"}, 3)
+	token.SetBookingID("bid0")
+
+	conn, resp, err := dialer.Dial(audience+"/session/refresh00?code="+cs.SubmitToken(token), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, SessionProtocol, resp.Header.Get("Sec-Websocket-Protocol"))
+	defer conn.Close()
+
+	// *** TestSessionWarnsBeforeExpiry
+	s := awaitSession(t, conn, 2*time.Second)
+	assert.Equal(t, "expiring", s.Session)
+	assert.Equal(t, token.ExpiresAt.Unix(), s.ExpiresAt)
+
+	refresh := func(cmd SessionCommand) SessionStatus {
+		data, err := json.Marshal(cmd)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+		return awaitSession(t, conn, time.Second)
+	}
+
+	// *** TestSessionRefusesWrongTopic
+	other := MakeTestToken(audience, "session", "refresh01", []string{"read", "write"}, 10)
+	other.SetBookingID("bid0")
+	s = refresh(SessionCommand{Session: "refresh", Code: cs.SubmitToken(other)})
+	assert.Equal(t, "refused", s.Session)
+	assert.Equal(t, "wrong topic", s.Reason)
+	assert.Equal(t, token.ExpiresAt.Unix(), s.ExpiresAt)
+
+	// *** TestSessionRefusesOtherBooking
+	other = MakeTestToken(audience, "session", "refresh00", []string{"read", "write"}, 10)
+	other.SetBookingID("bid1")
+	s = refresh(SessionCommand{Session: "refresh", Code: cs.SubmitToken(other)})
+	assert.Equal(t, "refused", s.Session)
+	assert.Equal(t, "token is for a different booking", s.Reason)
+
+	// *** TestSessionRefusesOtherScopes
+	other = MakeTestToken(audience, "session", "refresh00", []string{"read", "write", "admin"}, 10)
+	other.SetBookingID("bid0")
+	s = refresh(SessionCommand{Session: "refresh", Code: cs.SubmitToken(other)})
+	assert.Equal(t, "refused", s.Session)
+
+	// *** TestSessionRefreshWithCode
+	longer := MakeTestToken(audience, "session", "refresh00", []string{"write", "read"}, 4)
+	longer.SetBookingID("bid0")
+	s = refresh(SessionCommand{Session: "refresh", Code: cs.SubmitToken(longer)})
+	assert.Equal(t, "refreshed", s.Session)
+	assert.Equal(t, longer.ExpiresAt.Unix(), s.ExpiresAt)
+
+	// *** TestSessionRefreshWithToken
+	access := MakeTestToken(accessAudience, "session", "refresh00", []string{"read", "write"}, 10)
+	access.SetBookingID("bid0")
+	bearer, err := jwt.NewWithClaims(jwt.SigningMethodHS256, access).SignedString([]byte(secret))
+	assert.NoError(t, err)
+
+	wrong, err := jwt.NewWithClaims(jwt.SigningMethodHS256, access).SignedString([]byte("wrongsecret"))
+	assert.NoError(t, err)
+	s = refresh(SessionCommand{Session: "refresh", Token: wrong})
+	assert.Equal(t, "refused", s.Session)
+	assert.Equal(t, "invalid token", s.Reason)
+
+	s = refresh(SessionCommand{Session: "refresh", Token: bearer})
+	assert.Equal(t, "refreshed", s.Session)
+	assert.Equal(t, access.ExpiresAt.Unix(), s.ExpiresAt)
+
+	// *** TestSessionStaysOpenAfterOriginalExpiry
+	// the original token has expired, so a message is only relayed if the connection is open
+	time.Sleep(time.Until(time.Unix(token.ExpiresAt.Unix()+1, 0)))
+
+	r, _, err := websocket.DefaultDialer.Dial(audience+"/session/refresh00?code="+cs.SubmitToken(MakeTestToken(audience, "session", "refresh00", []string{"read"}, 10)), nil)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("still here")))
+	assert.NoError(t, r.SetReadDeadline(time.Now().Add(time.Second)))
+	_, data, err := r.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "still here", string(data))
+
+	// *** TestSessionCommandRelayedWithoutProtocol
+	// clients that did not request the protocol are not sent status, and their
+	// messages are relayed as before, even if they look like session commands
+	r2, resp, err := websocket.DefaultDialer.Dial(audience+"/session/refresh00?code="+cs.SubmitToken(MakeTestToken(audience, "session", "refresh00", []string{"read", "write"}, 10)), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Header.Get("Sec-Websocket-Protocol"))
+	defer r2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := `{"session":"refresh","code":"abc"}`
+	assert.NoError(t, r2.WriteMessage(websocket.TextMessage, []byte(cmd)))
+	_, data, err = r.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, cmd, string(data))
+
+	close(closed)
+	wg.Wait()
+}
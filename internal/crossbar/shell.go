@@ -0,0 +1,128 @@
+package crossbar
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Close messages sent to shell connections that are closed because of the other end
+var (
+	hostReplacedCloseMessage = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replaced by new host connection")
+	hostGoneCloseMessage     = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shell host disconnected")
+	hostClosedCloseMessage   = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "closed by shell host")
+)
+
+// replaceShellHost evicts any existing host connection for a shell topic, and so its
+// clients, so that a reconnecting host takes over from its stale connection. The caller
+// must hold the lock.
+func (h *Hub) replaceShellHost(key topicKey) {
+	for client := range h.clients[key] {
+		if client.isHost {
+			log.WithFields(log.Fields{"topic": key.topic, "name": client.name}).Info("shell host connection replaced")
+			h.evict(client, hostReplacedCloseMessage)
+		}
+	}
+}
+
+// hasShellHost reports whether a host is connected to a shell topic
+func (h *Hub) hasShellHost(topic string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.shellHost(topicKey{ct: Shell, topic: topic})
+}
+
+// shellHost reports whether a host is connected to a shell topic. The caller must hold the lock.
+func (h *Hub) shellHost(key topicKey) bool {
+	for client := range h.clients[key] {
+		if client.isHost {
+			return true
+		}
+	}
+	return false
+}
+
+// leaveShell closes the clients' connections when the host of a shell topic has left,
+// and lets the host know when a client has gone. The caller must hold the lock, and
+// have already removed the client.
+func (h *Hub) leaveShell(client *Client) {
+
+	if client.ct != Shell {
+		return
+	}
+
+	if client.isHost {
+		if h.shellHost(client.key()) {
+			return // a new host has taken over
+		}
+		for other := range h.clients[client.key()] {
+			h.evict(other, hostGoneCloseMessage)
+		}
+		return
+	}
+
+	for _, slow := range h.distribute(client.connectionAction("disconnect")) {
+		h.evict(slow, slowCloseMessage)
+	}
+}
+
+// connectionAction returns a message to the host of the client's shell topic
+func (c *Client) connectionAction(action string) message {
+
+	data, err := json.Marshal(ConnectionAction{Action: action, ID: c.connectionID})
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": c.topic}).Error("shell connection action not marshalled")
+	}
+
+	return message{sender: c.from(), mt: websocket.TextMessage, data: data, sent: time.Now()}
+}
+
+// closeShellClient closes the connection of the client with the connection ID on a
+// shell topic, because its host asked for it to be closed
+func (h *Hub) closeShellClient(topic, connectionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[topicKey{ct: Shell, topic: topic}] {
+		if !client.isHost && client.connectionID == connectionID {
+			h.evict(client, hostClosedCloseMessage)
+		}
+	}
+}
+
+// address tags a message from a shell client with its connection ID, so that the host
+// can tell the clients' streams apart, or sends a message from the host to the client
+// whose connection ID it is tagged with, without the tag. The host may instead send a
+// ConnectionAction to disconnect a client. It returns false if the message is not to be sent.
+func (c *Client) address(m *message) bool {
+
+	if !c.isHost {
+		data := make([]byte, 0, len(c.connectionID)+1+len(m.data))
+		m.data = append(append(append(data, c.connectionID...), '\n'), m.data...)
+		return true
+	}
+
+	if m.mt == websocket.TextMessage && bytes.HasPrefix(m.data, []byte("{")) {
+		var action ConnectionAction
+		if err := json.Unmarshal(m.data, &action); err != nil || action.Action != "disconnect" {
+			log.WithFields(log.Fields{"topic": c.topic, "name": c.name}).Warn("shell host connection action not understood")
+			return false
+		}
+		c.hub.closeShellClient(c.topic, action.ID)
+		return false
+	}
+
+	i := bytes.IndexByte(m.data, '\n')
+
+	if i < 1 {
+		log.WithFields(log.Fields{"topic": c.topic, "name": c.name}).Warn("shell host message dropped because it has no connection ID")
+		return false
+	}
+
+	m.to = string(m.data[:i])
+	m.data = m.data[i+1:]
+
+	return true
+}
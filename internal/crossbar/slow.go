@@ -0,0 +1,219 @@
+package crossbar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// SlowPolicy represents what the hub does when a client's send buffer is full
+type SlowPolicy int
+
+// DropNewest, DropOldest, DropStale and Disconnect are enumerated values of SlowPolicy
+const (
+	// DropNewest discards the message that did not fit in the buffer
+	DropNewest SlowPolicy = iota
+
+	// DropOldest discards the longest-queued message to make room
+	DropOldest
+
+	// DropStale discards messages that have been queued longer than MaxAge,
+	// and makes room by discarding the oldest message if the buffer fills
+	DropStale
+
+	// Disconnect closes the connection, so the client knows it has missed messages
+	Disconnect
+)
+
+var slowPolicyNames = map[SlowPolicy]string{
+	DropNewest: "drop-newest",
+	DropOldest: "drop-oldest",
+	DropStale:  "drop-stale",
+	Disconnect: "disconnect",
+}
+
+func (p SlowPolicy) String() string {
+	if name, ok := slowPolicyNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// SlowConsumerRule applies a SlowPolicy to topics matching Pattern
+type SlowConsumerRule struct {
+
+	// Pattern is matched against topics with MatchTopic
+	Pattern string
+
+	// Policy is applied to matching topics
+	Policy SlowPolicy
+
+	// MaxAge is how long a message may be queued under DropStale
+	MaxAge time.Duration
+}
+
+// ParseSlowConsumerRules parses a comma-separated list of rules in the form
+// pattern=policy, where policy is one of drop-newest, drop-oldest, disconnect,
+// or drop-stale:duration e.g. "*-data=disconnect,*-video=drop-stale:500ms"
+func ParseSlowConsumerRules(s string) ([]SlowConsumerRule, error) {
+
+	rules := []SlowConsumerRule{}
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("slow consumer rule %s is not in the form pattern=policy", item)
+		}
+
+		rule := SlowConsumerRule{Pattern: parts[0]}
+
+		policy := parts[1]
+
+		if strings.HasPrefix(policy, "drop-stale:") {
+			age, err := time.ParseDuration(strings.TrimPrefix(policy, "drop-stale:"))
+			if err != nil {
+				return nil, fmt.Errorf("slow consumer rule %s has bad max age: %s", item, err.Error())
+			}
+			if age <= 0 {
+				return nil, fmt.Errorf("slow consumer rule %s needs a positive max age", item)
+			}
+			rule.Policy = DropStale
+			rule.MaxAge = age
+			rules = append(rules, rule)
+			continue
+		}
+
+		switch policy {
+		case "drop-newest":
+			rule.Policy = DropNewest
+		case "drop-oldest":
+			rule.Policy = DropOldest
+		case "disconnect":
+			rule.Policy = Disconnect
+		case "drop-stale":
+			return nil, errors.New("slow consumer rule " + item + " needs a max age e.g. drop-stale:500ms")
+		default:
+			return nil, errors.New("slow consumer rule " + item + " has unknown policy " + policy)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// slowConsumerRule returns the first rule that matches the topic,
+// or DropNewest if there is no match
+func slowConsumerRule(rules []SlowConsumerRule, topic string) SlowConsumerRule {
+	for _, rule := range rules {
+		if MatchTopic(rule.Pattern, topic) {
+			return rule
+		}
+	}
+	return SlowConsumerRule{Pattern: "**", Policy: DropNewest}
+}
+
+// drops counts the messages a client has missed because it could not keep up
+type drops struct {
+	newest int64
+	oldest int64
+	stale  int64
+}
+
+// DropReport represents the number of messages a client has missed, by outcome
+type DropReport struct {
+	Newest int64 `json:"newest"`
+	Oldest int64 `json:"oldest"`
+	Stale  int64 `json:"stale"`
+}
+
+func (d *drops) report() DropReport {
+	return DropReport{
+		Newest: atomic.LoadInt64(&d.newest),
+		Oldest: atomic.LoadInt64(&d.oldest),
+		Stale:  atomic.LoadInt64(&d.stale),
+	}
+}
+
+// stale reports whether a message has been queued for too long under the client's policy
+func (c *Client) stale(m message) bool {
+	if c.slow.Policy != DropStale || m.sent.IsZero() {
+		return false
+	}
+	if time.Since(m.sent) <= c.slow.MaxAge {
+		return false
+	}
+	atomic.AddInt64(&c.drops.stale, 1)
+	metrics.Dropped.WithLabelValues(c.topic, "stale").Inc()
+	return true
+}
+
+// full handles a message that did not fit in a client's send buffer. It returns
+// true if the client should be disconnected, which is left to the caller. It does
+// not need the hub's lock, because dispatchers deliver from their snapshots without
+// it, but the caller must hold the client's guard, so that send is not closed meanwhile.
+func (h *Hub) full(client *Client, m message) bool {
+
+	switch client.slow.Policy {
+
+	case Disconnect:
+		log.WithFields(log.Fields{
+			"topic":          client.topic,
+			"name":           client.name,
+			"remote address": client.remoteAddr,
+			"user agent":     client.userAgent,
+		}).Warn("client disconnected because client.send was blocked")
+		metrics.SlowDisconnects.WithLabelValues(client.topic).Inc()
+		return true
+
+	case DropOldest, DropStale:
+		// make room by discarding the longest-queued message; the writePump may
+		// have emptied the buffer since we tried, so don't wait
+		select {
+		case <-client.send:
+			reason := "oldest"
+			count := &client.drops.oldest
+			if client.slow.Policy == DropStale {
+				reason = "stale"
+				count = &client.drops.stale
+			}
+			atomic.AddInt64(count, 1)
+			metrics.Dropped.WithLabelValues(client.topic, reason).Inc()
+		default:
+		}
+
+		select {
+		case client.send <- m:
+			return false
+		default:
+		}
+	}
+
+	atomic.AddInt64(&client.drops.newest, 1)
+	metrics.Dropped.WithLabelValues(client.topic, "newest").Inc()
+	log.WithFields(log.Fields{
+		"topic":          client.topic,
+		"name":           client.name,
+		"remote address": client.remoteAddr,
+		"user agent":     client.userAgent,
+	}).Error("message not sent because client.send was blocked")
+
+	return false
+}
+
+// slowCloseMessage is sent to clients disconnected under the Disconnect policy
+var slowCloseMessage = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client too slow to receive messages")
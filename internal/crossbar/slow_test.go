@@ -0,0 +1,136 @@
+package crossbar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSlowConsumerRules(t *testing.T) {
+
+	rules, err := ParseSlowConsumerRules("*-data=disconnect, *-video=drop-stale:500ms,stats=drop-oldest,**=drop-newest")
+	assert.NoError(t, err)
+	assert.Equal(t, []SlowConsumerRule{
+		{Pattern: "*-data", Policy: Disconnect},
+		{Pattern: "*-video", Policy: DropStale, MaxAge: 500 * time.Millisecond},
+		{Pattern: "stats", Policy: DropOldest},
+		{Pattern: "**", Policy: DropNewest},
+	}, rules)
+
+	rules, err = ParseSlowConsumerRules("")
+	assert.NoError(t, err)
+	assert.Equal(t, []SlowConsumerRule{}, rules)
+
+	_, err = ParseSlowConsumerRules("*-data")
+	assert.Error(t, err)
+
+	_, err = ParseSlowConsumerRules("*-data=explode")
+	assert.Error(t, err)
+
+	_, err = ParseSlowConsumerRules("*-video=drop-stale")
+	assert.Error(t, err)
+
+	_, err = ParseSlowConsumerRules("*-video=drop-stale:soon")
+	assert.Error(t, err)
+
+	_, err = ParseSlowConsumerRules("*-video=drop-stale:-1s")
+	assert.Error(t, err)
+}
+
+func TestSlowConsumerRuleFirstMatchWins(t *testing.T) {
+
+	rules := []SlowConsumerRule{
+		{Pattern: "pend00-data", Policy: DropOldest},
+		{Pattern: "*-data", Policy: Disconnect},
+	}
+
+	assert.Equal(t, DropOldest, slowConsumerRule(rules, "pend00-data").Policy)
+	assert.Equal(t, Disconnect, slowConsumerRule(rules, "pend01-data").Policy)
+	assert.Equal(t, DropNewest, slowConsumerRule(rules, "pend01-video").Policy)
+}
+
+// slowHub returns a hub with one client whose buffer holds two messages,
+// after three messages have been sent to it
+func slowHub(policy SlowPolicy) (*Hub, *Client, []*Client) {
+
+	h := newHub()
+
+	c := &Client{
+		topic: "t",
+		name:  "slow",
+		send:  make(chan message, 2),
+		guard: &sendGuard{},
+		drops: &drops{},
+		slow:  SlowConsumerRule{Policy: policy, MaxAge: time.Minute},
+	}
+
+	h.clients[topicKey{topic: "t"}] = map[*Client]bool{c: true}
+	h.route(c)
+
+	sender := source{topic: "t", name: "sender"}
+
+	var slow []*Client
+
+	for i := 0; i < 3; i++ {
+		slow = append(slow, h.distribute(message{sender: sender, data: []byte{byte(i)}, sent: time.Now()})...)
+	}
+
+	return h, c, slow
+}
+
+func queued(c *Client) []byte {
+	var q []byte
+	for len(c.send) > 0 {
+		m := <-c.send
+		q = append(q, m.data...)
+	}
+	return q
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+
+	_, c, slow := slowHub(DropNewest)
+	assert.Empty(t, slow)
+	assert.Equal(t, DropReport{Newest: 1}, c.drops.report())
+	assert.Equal(t, []byte{0, 1}, queued(c))
+
+	_, c, slow = slowHub(DropOldest)
+	assert.Empty(t, slow)
+	assert.Equal(t, DropReport{Oldest: 1}, c.drops.report())
+	assert.Equal(t, []byte{1, 2}, queued(c))
+
+	_, c, slow = slowHub(DropStale)
+	assert.Empty(t, slow)
+	assert.Equal(t, DropReport{Stale: 1}, c.drops.report())
+	assert.Equal(t, []byte{1, 2}, queued(c))
+
+	h, c, slow := slowHub(Disconnect)
+	assert.Equal(t, []*Client{c}, slow)
+	assert.Equal(t, DropReport{}, c.drops.report())
+
+	h.evict(c, slowCloseMessage)
+	_, ok := h.clients[topicKey{topic: "t"}][c]
+	assert.False(t, ok)
+	assert.Equal(t, []byte{0, 1}, queued(c))
+	_, ok = <-c.send
+	assert.False(t, ok, "send should be closed")
+	assert.Equal(t, slowCloseMessage, c.closeMessage)
+}
+
+func TestStale(t *testing.T) {
+
+	c := &Client{
+		topic: "t",
+		drops: &drops{},
+		slow:  SlowConsumerRule{Policy: DropStale, MaxAge: time.Second},
+	}
+
+	assert.False(t, c.stale(message{sent: time.Now()}))
+	assert.True(t, c.stale(message{sent: time.Now().Add(-2 * time.Second)}))
+	assert.False(t, c.stale(message{}), "messages without a sent time are never stale")
+	assert.Equal(t, DropReport{Stale: 1}, c.drops.report())
+
+	c.slow = SlowConsumerRule{Policy: DropOldest}
+	assert.False(t, c.stale(message{sent: time.Now().Add(-2 * time.Second)}))
+}
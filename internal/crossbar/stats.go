@@ -0,0 +1,135 @@
+package crossbar
+
+import (
+	"sync"
+	"time"
+)
+
+// statsWindow is the number of whole seconds over which message rates are averaged
+const statsWindow = 10
+
+// RxTx represents statistics for messages received from (rx), and transmitted to (tx), a client
+type RxTx struct {
+	Tx Statistics `json:"tx"`
+	Rx Statistics `json:"rx"`
+}
+
+// Statistics represents recent message activity in one direction
+type Statistics struct {
+	// Last is the time since the last message, or "never"
+	Last string `json:"last"`
+
+	// Size is the mean message size in bytes
+	Size float64 `json:"size"`
+
+	// FPS is the number of messages per second
+	FPS float64 `json:"fps"`
+}
+
+// Stats holds the receive and transmit windows for a client
+type Stats struct {
+	rx *window
+	tx *window
+}
+
+// NewStats returns a Stats with empty windows
+func NewStats() *Stats {
+	return &Stats{
+		rx: newWindow(time.Now),
+		tx: newWindow(time.Now),
+	}
+}
+
+// Report returns the current statistics for both directions
+func (s *Stats) Report() RxTx {
+	return RxTx{
+		Rx: s.rx.report(),
+		Tx: s.tx.report(),
+	}
+}
+
+// bucket holds the message count and total size for one second
+type bucket struct {
+	second int64
+	count  int64
+	bytes  int64
+}
+
+// window accumulates messages in one-second buckets, so that rates
+// reflect recent activity rather than the lifetime of the connection
+type window struct {
+	mu      sync.Mutex
+	buckets [statsWindow + 1]bucket
+	last    time.Time
+	now     func() time.Time
+	since   time.Time
+}
+
+func newWindow(now func() time.Time) *window {
+	return &window{
+		now:   now,
+		since: now(),
+	}
+}
+
+// add records a message of the given size
+func (w *window) add(size int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	s := now.Unix()
+
+	b := &w.buckets[s%int64(len(w.buckets))]
+
+	if b.second != s {
+		*b = bucket{second: s}
+	}
+
+	b.count++
+	b.bytes += int64(size)
+	w.last = now
+}
+
+// report summarises the completed seconds in the window;
+// the current second is excluded because it is still filling up
+func (w *window) report() Statistics {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	s := now.Unix()
+
+	if w.last.IsZero() {
+		return Statistics{Last: "never"}
+	}
+
+	var count, bytes int64
+
+	for _, b := range w.buckets {
+		if b.second >= s-statsWindow && b.second < s {
+			count += b.count
+			bytes += b.bytes
+		}
+	}
+
+	// don't under-report connections that are younger than the window
+	seconds := s - w.since.Unix()
+	if seconds > statsWindow {
+		seconds = statsWindow
+	}
+
+	st := Statistics{
+		Last: now.Sub(w.last).Round(time.Millisecond).String(),
+	}
+
+	if seconds > 0 {
+		st.FPS = float64(count) / float64(seconds)
+	}
+
+	if count > 0 {
+		st.Size = float64(bytes) / float64(count)
+	}
+
+	return st
+}
@@ -0,0 +1,67 @@
+package crossbar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+
+	w := newWindow(func() time.Time { return now })
+
+	assert.Equal(t, Statistics{Last: "never"}, w.report())
+
+	// 5 messages of 100 bytes each second for 20 seconds
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 5; j++ {
+			w.add(100)
+		}
+		now = now.Add(time.Second)
+	}
+
+	st := w.report()
+	assert.Equal(t, 5.0, st.FPS)
+	assert.Equal(t, 100.0, st.Size)
+	assert.Equal(t, "1s", st.Last)
+
+	// go quiet for half the window, then send bigger messages
+	now = now.Add(5 * time.Second)
+	w.add(400)
+	now = now.Add(time.Second)
+
+	st = w.report()
+	assert.Equal(t, 2.1, st.FPS) // 21 messages in last 10s
+	assert.Equal(t, 2400.0/21, st.Size)
+
+	// activity older than the window is forgotten
+	now = now.Add(time.Minute)
+	st = w.report()
+	assert.Equal(t, 0.0, st.FPS)
+	assert.Equal(t, 0.0, st.Size)
+	assert.Equal(t, "1m1s", st.Last)
+
+}
+
+func TestWindowYoungConnection(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+
+	w := newWindow(func() time.Time { return now })
+
+	// 4 messages per second for 2 seconds
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 4; j++ {
+			w.add(10)
+		}
+		now = now.Add(time.Second)
+	}
+
+	st := w.report()
+	assert.Equal(t, 4.0, st.FPS)
+	assert.Equal(t, 10.0, st.Size)
+
+}
@@ -1,10 +1,47 @@
 package deny
 
 import (
+	"os"
 	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// Backend holds the lists of booking IDs that are allowed and denied, and the topics
+// that are denied. Store keeps the lists in memory; other implementations let
+// separate processes share lists.
+type Backend interface {
+
+	// Allow reverts a denied ID back to being allowed
+	Allow(ID string, expiresAt int64)
+
+	// Deny adds an ID to the deny list
+	Deny(ID string, expiresAt int64)
+
+	// IsDenied checks if an ID is on the deny list
+	IsDenied(ID string) bool
+
+	// GetAllowList returns the entire allow list
+	GetAllowList() []string
+
+	// GetDenyList returns the entire deny list
+	GetDenyList() []string
+
+	// GetTime returns the current Unix time in seconds, as used by the backend
+	GetTime() int64
+
+	// DenyTopic adds or replaces the denial of a topic pattern
+	DenyTopic(t Topic)
+
+	// AllowTopic removes the denial of a topic pattern
+	AllowTopic(pattern string)
+
+	// GetDeniedTopics returns the topic denials that have not expired, or an error
+	// if they could not be got, in which case connections must be refused
+	GetDeniedTopics() ([]Topic, error)
+}
+
 // Store tracks current connections, and those that have been denied (cancelled)
 type Store struct {
 	sync.Mutex
@@ -18,19 +55,28 @@ type Store struct {
 	// bookingIDs currently denied, with expiry time
 	DenyList map[string]int64
 
+	// topics currently denied, by pattern
+	Topics map[string]Topic
+
 	// Now is a function for getting the time - useful for mocking in test
 	// note time is in int64 format
 	Now func() int64 `json:"-" yaml:"-"`
+
+	// directory where the lists are persisted, if set
+	dir string
+
+	// log of changes since the last snapshot, if persisting
+	file *os.File
 }
 
 // New returns a store with the maps initialised
 func New() *Store {
 	return &Store{
-		sync.Mutex{},
-		make(map[string]int64),
-		make(chan struct{}),
-		make(map[string]int64),
-		SystemNow,
+		AllowList: make(map[string]int64),
+		closed:    make(chan struct{}),
+		DenyList:  make(map[string]int64),
+		Now:       SystemNow,
+		Topics:    make(map[string]Topic),
 	}
 }
 
@@ -39,6 +85,11 @@ func (s *Store) SetNowFunc(nf func() int64) {
 	s.Now = nf
 }
 
+// GetTime returns the current time, from the store's Now function
+func (s *Store) GetTime() int64 {
+	return s.Now()
+}
+
 // SystemNow returns the current system time
 func SystemNow() int64 {
 	return time.Now().Unix()
@@ -49,10 +100,19 @@ func (s *Store) Allow(ID string, expiresAt int64) {
 	s.Lock()
 	defer s.Unlock()
 
+	_, denied := s.DenyList[ID]
+
+	// avoid growing the log when the same booking requests repeated sessions
+	if exp, ok := s.AllowList[ID]; ok && exp == expiresAt && !denied {
+		return
+	}
+
 	//remove from Deny list (no gaurd needed SCC-1033)
 	delete(s.DenyList, ID)
 
 	s.AllowList[ID] = expiresAt
+
+	s.record("allow", ID, expiresAt)
 }
 
 // Deny adds and ID to the deny list
@@ -63,6 +123,8 @@ func (s *Store) Deny(ID string, expiresAt int64) {
 	//remove from Allow list (no gaurd needed SCC-1033)
 	delete(s.AllowList, ID)
 	s.DenyList[ID] = expiresAt
+
+	s.record("deny", ID, expiresAt)
 }
 
 // IsDenied checks if an ID is on the denied list
@@ -97,12 +159,17 @@ func (s *Store) GetAllowList() []string {
 	return a
 }
 
-// Prune removes stale entries from the Allow, Deny lists
+// Prune removes stale entries from the Allow, Deny lists and denied topics
+// and compacts the persisted lists, if there are any
 func (s *Store) Prune() {
 	s.Lock()
 	defer s.Unlock()
 
 	s.prune()
+
+	if err := s.compact(); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "dir": s.dir}).Error("deny store could not compact persisted lists")
+	}
 }
 
 // prune removes stale entries from the Allow, Deny lists
@@ -134,4 +201,10 @@ func (s *Store) prune() {
 	for _, ID := range stale {
 		delete(s.DenyList, ID)
 	}
+
+	for k, v := range s.Topics {
+		if v.Exp < now {
+			delete(s.Topics, k)
+		}
+	}
 }
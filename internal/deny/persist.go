@@ -0,0 +1,228 @@
+package deny
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// snapshotFile holds the lists as they were at the last compaction
+	snapshotFile = "deny.snapshot.json"
+
+	// logFile holds one record per line for each change since the last compaction
+	logFile = "deny.log"
+)
+
+// record represents one change to the store, as written to the log
+// with the exemptions and reason for topic denials
+type record struct {
+	Op     string   `json:"op"`
+	ID     string   `json:"id"`
+	Exp    int64    `json:"exp"`
+	Exempt []string `json:"exempt,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// snapshot represents the entire contents of the store
+type snapshot struct {
+	AllowList map[string]int64 `json:"allow"`
+	DenyList  map[string]int64 `json:"deny"`
+	Topics    map[string]Topic `json:"topics,omitempty"`
+}
+
+// Persist loads any lists previously saved in dir, then records all subsequent
+// changes there, so that denied bookings stay denied across restarts and crashes.
+// Entries which expired while the relay was stopped are pruned on loading.
+func (s *Store) Persist(dir string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	if err := s.load(dir); err != nil {
+		return err
+	}
+
+	s.dir = dir
+
+	s.prune()
+
+	return s.compact()
+}
+
+// Close stops recording changes to disk
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// load reads the snapshot, then replays the log over it
+func (s *Store) load(dir string) error {
+
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// nothing saved yet
+	case err != nil:
+		return err
+	default:
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return err
+		}
+		for k, v := range snap.AllowList {
+			s.AllowList[k] = v
+		}
+		for k, v := range snap.DenyList {
+			s.DenyList[k] = v
+		}
+		for k, v := range snap.Topics {
+			s.Topics[k] = v
+		}
+	}
+
+	f, err := os.Open(filepath.Join(dir, logFile))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a crash can leave a partly-written final line
+			log.WithFields(log.Fields{"error": err.Error(), "line": scanner.Text()}).Warn("deny store skipping unreadable log entry")
+			continue
+		}
+		switch r.Op {
+		case "allow":
+			delete(s.DenyList, r.ID)
+			s.AllowList[r.ID] = r.Exp
+		case "deny":
+			delete(s.AllowList, r.ID)
+			s.DenyList[r.ID] = r.Exp
+		case "denytopic":
+			s.Topics[r.ID] = Topic{Pattern: r.ID, Exp: r.Exp, Exempt: r.Exempt, Reason: r.Reason}
+		case "allowtopic":
+			delete(s.Topics, r.ID)
+		default:
+			log.WithFields(log.Fields{"op": r.Op, "id": r.ID}).Warn("deny store skipping unknown log entry")
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact writes the current lists to a new snapshot, and starts a fresh log.
+// Internal usage only as does not take the lock.
+func (s *Store) compact() error {
+
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(snapshot{AllowList: s.AllowList, DenyList: s.DenyList, Topics: s.Topics})
+	if err != nil {
+		return err
+	}
+
+	// write then rename, so that a crash cannot leave a partial snapshot
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	// the snapshot now holds everything in the log, so we can start afresh
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.file, err = os.OpenFile(filepath.Join(s.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+
+	return err
+}
+
+// record appends a change to the log, if the store is being persisted.
+// Denials are synced to disk immediately because losing one would let a
+// cancelled booking reconnect after a crash.
+// Internal usage only as does not take the lock.
+func (s *Store) record(op, ID string, expiresAt int64) {
+	s.write(record{Op: op, ID: ID, Exp: expiresAt})
+}
+
+// recordTopic appends a change to the denied topics to the log, if the store is being persisted.
+// Internal usage only as does not take the lock.
+func (s *Store) recordTopic(op string, t Topic) {
+	s.write(record{Op: op, ID: t.Pattern, Exp: t.Exp, Exempt: t.Exempt, Reason: t.Reason})
+}
+
+// write appends a record to the log, if the store is being persisted.
+// Internal usage only as does not take the lock.
+func (s *Store) write(r record) {
+
+	if s.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "id": r.ID}).Error("deny store could not marshal log entry")
+		return
+	}
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "id": r.ID}).Error("deny store could not write log entry")
+		return
+	}
+
+	if r.Op == "deny" || r.Op == "denytopic" {
+		if err := s.file.Sync(); err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "id": r.ID}).Error("deny store could not sync log entry")
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package deny
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersist(t *testing.T) {
+
+	dir := t.TempDir()
+
+	now := func() int64 { return 1673952000 }
+
+	ds := New()
+	ds.SetNowFunc(now)
+
+	err := ds.Persist(dir)
+	assert.NoError(t, err)
+
+	ds.Allow("id0", 1673952010)
+	ds.Allow("id1", 1673952020)
+	ds.Deny("id2", 1673952010)
+	ds.Deny("id3", 1673952020)
+	ds.Deny("id1", 1673952020)
+	ds.Allow("id2", 1673952010)
+	ds.DenyTopic(Topic{Pattern: "pend00/**", Exp: 1673952020, Exempt: []string{"host"}, Reason: "broken"})
+	ds.DenyTopic(Topic{Pattern: "pend01", Exp: 1673952020})
+	ds.AllowTopic("pend01")
+
+	// simulate a crash by not closing, and leaving a partial line
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0600)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(`{"op":"deny","id":"id`))
+	assert.NoError(t, err)
+	f.Close()
+
+	// restart
+	rs := New()
+	rs.SetNowFunc(now)
+
+	err = rs.Persist(dir)
+	assert.NoError(t, err)
+
+	al := rs.GetAllowList()
+	sort.Strings(al)
+	assert.Equal(t, []string{"id0", "id2"}, al)
+
+	dl := rs.GetDenyList()
+	sort.Strings(dl)
+	assert.Equal(t, []string{"id1", "id3"}, dl)
+
+	assert.True(t, rs.IsDenied("id1"))
+	assert.False(t, rs.IsDenied("id2"))
+
+	assert.Equal(t, []Topic{{Pattern: "pend00/**", Exp: 1673952020, Exempt: []string{"host"}, Reason: "broken"}}, deniedTopics(t, rs))
+
+	// prune compacts the log into the snapshot
+	rs.SetNowFunc(func() int64 { return 1673952011 })
+	rs.Prune()
+
+	fi, err := os.Stat(filepath.Join(dir, logFile))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), fi.Size())
+
+	rs.Deny("id4", 1673952030)
+	rs.DenyTopic(Topic{Pattern: "pend02", Exp: 1673952030})
+
+	err = rs.Close()
+	assert.NoError(t, err)
+
+	// restart after entries expired while stopped
+	ls := New()
+	ls.SetNowFunc(func() int64 { return 1673952025 })
+
+	err = ls.Persist(dir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{}, ls.GetAllowList())
+	assert.Equal(t, []string{"id4"}, ls.GetDenyList())
+	assert.Equal(t, []Topic{{Pattern: "pend02", Exp: 1673952030}}, deniedTopics(t, ls))
+
+	err = ls.Close()
+	assert.NoError(t, err)
+}
+
+func TestPersistBadSnapshot(t *testing.T) {
+
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte("not json"), 0600)
+	assert.NoError(t, err)
+
+	ds := New()
+	err = ds.Persist(dir)
+	assert.Error(t, err)
+
+}
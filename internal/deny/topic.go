@@ -0,0 +1,79 @@
+package deny
+
+import "sort"
+
+// Topic denies connections to topics matching Pattern until Exp, e.g. to take an
+// experiment offline when its hardware breaks, whoever has booked it. Tokens with
+// any of the Exempt scopes, e.g. relay:admin or host, are still let through, so
+// that maintainers can reach the experiment while students are kept out.
+type Topic struct {
+
+	// Pattern is a topic, or a topic pattern as used by crossbar.MatchTopic, e.g. pend00/**
+	Pattern string `json:"topic"`
+
+	// Exp is when the denial expires, in unix seconds
+	Exp int64 `json:"exp"`
+
+	// Exempt are the scopes of tokens that are let through, e.g. relay:admin
+	Exempt []string `json:"exempt,omitempty"`
+
+	// Reason is given to clients that are refused or disconnected, e.g. "under maintenance"
+	Reason string `json:"reason,omitempty"`
+}
+
+// Exempts reports whether a token with the scopes is let through the denial
+func (t Topic) Exempts(scopes []string) bool {
+	for _, e := range t.Exempt {
+		for _, s := range scopes {
+			if e == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DenyTopic adds or replaces the denial of a topic pattern
+func (s *Store) DenyTopic(t Topic) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Topics[t.Pattern] = t
+
+	s.recordTopic("denytopic", t)
+}
+
+// AllowTopic removes the denial of a topic pattern, if there is one
+func (s *Store) AllowTopic(pattern string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.Topics[pattern]; !ok {
+		return
+	}
+
+	delete(s.Topics, pattern)
+
+	s.recordTopic("allowtopic", Topic{Pattern: pattern})
+}
+
+// GetDeniedTopics returns the topic denials that have not expired, in order of their pattern.
+// It never returns an error.
+func (s *Store) GetDeniedTopics() ([]Topic, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.Now()
+
+	d := []Topic{}
+
+	for _, t := range s.Topics {
+		if t.Exp >= now {
+			d = append(d, t)
+		}
+	}
+
+	sort.Slice(d, func(i, j int) bool { return d[i].Pattern < d[j].Pattern })
+
+	return d, nil
+}
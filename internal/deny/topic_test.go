@@ -0,0 +1,53 @@
+package deny
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// deniedTopics returns the topic denials, which a Store always can
+func deniedTopics(t *testing.T, ds *Store) []Topic {
+	d, err := ds.GetDeniedTopics()
+	assert.NoError(t, err)
+	return d
+}
+
+func TestDenyTopic(t *testing.T) {
+
+	ds := New()
+	ds.SetNowFunc(func() int64 { return 1673952000 })
+
+	ds.DenyTopic(Topic{Pattern: "pend01/**", Exp: 1673952020, Exempt: []string{"relay:admin", "host"}, Reason: "under maintenance"})
+	ds.DenyTopic(Topic{Pattern: "pend00", Exp: 1673952010})
+
+	assert.Equal(t, []Topic{
+		{Pattern: "pend00", Exp: 1673952010},
+		{Pattern: "pend01/**", Exp: 1673952020, Exempt: []string{"relay:admin", "host"}, Reason: "under maintenance"},
+	}, deniedTopics(t, ds))
+
+	// replacing a denial
+	ds.DenyTopic(Topic{Pattern: "pend00", Exp: 1673952030})
+	assert.Equal(t, int64(1673952030), deniedTopics(t, ds)[0].Exp)
+
+	ds.AllowTopic("pend00")
+	ds.AllowTopic("unknown")
+	assert.Equal(t, 1, len(deniedTopics(t, ds)))
+
+	// expired denials are not returned, even before pruning
+	ds.SetNowFunc(func() int64 { return 1673952021 })
+	assert.Equal(t, []Topic{}, deniedTopics(t, ds))
+
+	ds.Prune()
+	assert.Equal(t, 0, len(ds.Topics))
+}
+
+func TestExempts(t *testing.T) {
+
+	d := Topic{Pattern: "pend00", Exempt: []string{"relay:admin", "host"}}
+
+	assert.True(t, d.Exempts([]string{"read", "write", "relay:admin"}))
+	assert.True(t, d.Exempts([]string{"host"}))
+	assert.False(t, d.Exempts([]string{"read", "write"}))
+	assert.False(t, Topic{Pattern: "pend00"}.Exempts([]string{"relay:admin"}))
+}
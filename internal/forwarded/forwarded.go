@@ -0,0 +1,135 @@
+// Package forwarded finds the address that a request came from, trusting
+// X-Forwarded-For only when the request came through a trusted proxy
+package forwarded
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies lists the proxies whose X-Forwarded-For headers are trusted.
+// A nil Proxies trusts none, so the address is always the one that connected.
+type Proxies struct {
+	nets []*net.IPNet
+}
+
+// Parse parses a comma-separated list of proxy addresses and networks
+// e.g. "127.0.0.1,10.0.0.0/8". An empty string trusts no proxies.
+func Parse(s string) (*Proxies, error) {
+
+	p := &Proxies{}
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("proxy %s is not an IP address or network", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s is not an IP address or network", item)
+		}
+
+		p.nets = append(p.nets, n)
+	}
+
+	return p, nil
+}
+
+// Len returns the number of trusted proxy addresses and networks
+func (p *Proxies) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.nets)
+}
+
+// String returns the trusted proxies as a comma-separated list
+func (p *Proxies) String() string {
+	var s []string
+	if p != nil {
+		for _, n := range p.nets {
+			s = append(s, n.String())
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// trusts reports whether the address is a trusted proxy
+func (p *Proxies) trusts(addr string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	ip := net.ParseIP(addr)
+
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoteAddr returns the address that the request came from. If it came from a trusted
+// proxy, that is the right-most X-Forwarded-For address that is not a trusted proxy,
+// because each proxy appends the address that it received the request from, and any
+// addresses to the left of those added by trusted proxies may have been made up by the
+// client. Otherwise it is the address that connected, whatever X-Forwarded-For says.
+func (p *Proxies) RemoteAddr(r *http.Request) string {
+
+	if r == nil {
+		return ""
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	if !p.trusts(addr) {
+		return addr
+	}
+
+	var forwarded []string
+
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, a := range strings.Split(header, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				forwarded = append(forwarded, a)
+			}
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr = forwarded[i]
+		if !p.trusts(addr) {
+			break
+		}
+	}
+
+	return addr
+}
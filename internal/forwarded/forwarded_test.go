@@ -0,0 +1,60 @@
+package forwarded
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+
+	p, err := Parse(" 127.0.0.1, 10.0.0.0/8,::1 ")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, p.Len())
+	assert.Equal(t, "127.0.0.1/32,10.0.0.0/8,::1/128", p.String())
+
+	p, err = Parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.Len())
+
+	_, err = Parse("proxy.example.io")
+	assert.Error(t, err)
+
+	_, err = Parse("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestRemoteAddr(t *testing.T) {
+
+	p, err := Parse("127.0.0.1,10.0.0.0/8")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		proxies    *Proxies
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"no proxies", nil, "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"untrusted proxy", p, "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"no header", p, "127.0.0.1:1234", nil, "127.0.0.1"},
+		{"trusted proxy", p, "127.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed by client", p, "127.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", p, "127.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"repeated headers", p, "127.0.0.1:1234", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", p, "127.0.0.1:1234", []string{"10.1.2.3"}, "10.1.2.3"},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, xff := range tc.xff {
+			r.Header.Add("X-Forwarded-For", xff)
+		}
+		assert.Equal(t, tc.want, tc.proxies.RemoteAddr(r), tc.name)
+	}
+
+	assert.Equal(t, "", p.RemoteAddr(nil))
+}
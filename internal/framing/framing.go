@@ -0,0 +1,196 @@
+// Package framing writes the messages that have queued for a websocket client,
+// either joined into one websocket message, or as a websocket message each, and
+// sends them to the connection together, so that a client that has fallen behind
+// catches up in as few system calls as possible.
+package framing
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Framing is how messages that have queued for a client are written to its connection.
+const (
+	// Stream appends queued messages to one websocket message, without delimiter,
+	// which suits byte streams such as MPEG-TS video, and is the default
+	Stream = "stream"
+
+	// Message writes each queued message as its own websocket message, so that
+	// discrete messages such as JSON can still be parsed by clients that fall behind
+	Message = "message"
+)
+
+// Protocol is the websocket subprotocol that clients request to be sent each
+// message in its own websocket message, whatever the framing would otherwise be.
+const Protocol = "message.relay.practable.io"
+
+// maxBatch is how many bytes are held back before they are sent, even if more are
+// to be written, so that a client far behind does not hold a lot of memory
+const maxBatch = 64 * 1024
+
+// Item is a message to be written. If Prepared is set, and the message is written
+// in a websocket message of its own, it is written as prepared, e.g. compressed once
+// for all the readers, rather than from Type and Data.
+type Item struct {
+	Type     int
+	Data     []byte
+	Prepared *websocket.PreparedMessage
+}
+
+// Upgrade upgrades the HTTP connection to a websocket, as u.Upgrade does, on a
+// connection that can send the frames written by Write together
+func Upgrade(u *websocket.Upgrader, w http.ResponseWriter, r *http.Request, header http.Header) (*websocket.Conn, error) {
+	return u.Upgrade(hijacker{w}, r, header)
+}
+
+// hijacker hands the websocket upgrader a connection that can batch its writes
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &batchConn{Conn: conn}, brw, nil
+}
+
+// batchConn holds back what is written to it while batching, and sends it all at once
+// when the batch is flushed. It is safe for the websocket's control messages, such as
+// pongs from the reading goroutine, to be written during a batch, because each
+// frame is written whole while holding the lock.
+type batchConn struct {
+	net.Conn
+	mu       sync.Mutex
+	batching bool
+	buf      []byte
+}
+
+func (b *batchConn) Write(p []byte) (int, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.batching {
+		return b.Conn.Write(p)
+	}
+
+	b.buf = append(b.buf, p...)
+
+	if len(b.buf) >= maxBatch {
+		if err := b.send(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// begin holds back what is written until flush
+func (b *batchConn) begin() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batching = true
+}
+
+// flush sends what has been held back, and stops batching
+func (b *batchConn) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batching = false
+	return b.send()
+}
+
+// send writes what has been held back. The caller must hold the lock.
+func (b *batchConn) send() error {
+
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	_, err := b.Conn.Write(b.buf)
+
+	b.buf = b.buf[:0]
+
+	return err
+}
+
+// Write writes the items to the connection, framed as Stream or Message. For Stream,
+// consecutive items of the same type are joined into one websocket message; items of
+// different types are never joined. Items written in a websocket message of their own
+// are written as prepared, if they were. If the connection was upgraded by Upgrade,
+// all the frames are sent together. It must only be called by the connection's one
+// writer, and returns an error if the connection has failed.
+func Write(conn *websocket.Conn, framing string, items []Item) error {
+
+	if b, ok := conn.UnderlyingConn().(*batchConn); ok && len(items) > 1 {
+		b.begin()
+		err := write(conn, framing, items)
+		if ferr := b.flush(); err == nil {
+			err = ferr
+		}
+		return err
+	}
+
+	return write(conn, framing, items)
+}
+
+func write(conn *websocket.Conn, framing string, items []Item) error {
+
+	for i := 0; i < len(items); {
+
+		// find the items that are joined to this one
+		j := i + 1
+		for framing != Message && j < len(items) && items[j].Type == items[i].Type {
+			j++
+		}
+
+		if j == i+1 {
+			if err := writeOne(conn, items[i]); err != nil {
+				return err
+			}
+			i = j
+			continue
+		}
+
+		w, err := conn.NextWriter(items[i].Type)
+		if err != nil {
+			return err
+		}
+
+		for ; i < j; i++ {
+			if _, err := w.Write(items[i].Data); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOne writes an item in a websocket message of its own, in one go
+func writeOne(conn *websocket.Conn, item Item) error {
+
+	if item.Prepared != nil {
+		return conn.WritePreparedMessage(item.Prepared)
+	}
+
+	return conn.WriteMessage(item.Type, item.Data)
+}
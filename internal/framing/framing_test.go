@@ -0,0 +1,158 @@
+package framing
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// countConn counts the writes to a connection
+type countConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+// pair returns a connection upgraded by Upgrade, which counts its writes, and the client
+// connected to it. A read that times out breaks a gorilla connection, so make a new pair
+// for each case.
+func pair(t *testing.T) (*websocket.Conn, *countConn, *websocket.Conn) {
+
+	conns := make(chan *websocket.Conn)
+
+	u := &websocket.Upgrader{}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(u, w, r, nil)
+		assert.NoError(t, err)
+		conns <- conn
+	}))
+	t.Cleanup(s.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+
+	b, ok := conn.UnderlyingConn().(*batchConn)
+	assert.True(t, ok)
+
+	cc := &countConn{Conn: b.Conn}
+	b.Conn = cc
+
+	return conn, cc, client
+}
+
+// read returns the websocket messages received, until none arrive
+func read(t *testing.T, conn *websocket.Conn) []string {
+	var got []string
+	for {
+		err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		assert.NoError(t, err)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return got
+		}
+		got = append(got, string(data))
+	}
+}
+
+func text(s string) Item {
+	return Item{Type: websocket.TextMessage, Data: []byte(s)}
+}
+
+func TestWriteMessage(t *testing.T) {
+
+	conn, cc, client := pair(t)
+
+	assert.NoError(t, Write(conn, Message, []Item{text(`{"a":1}`), text(`{"b":2}`), text(`{"c":3}`)}))
+
+	// the messages are separate, but sent together
+	assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}, read(t, client))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cc.writes))
+}
+
+func TestWriteStream(t *testing.T) {
+
+	conn, cc, client := pair(t)
+
+	binary := Item{Type: websocket.BinaryMessage, Data: []byte("ef")}
+
+	assert.NoError(t, Write(conn, Stream, []Item{text("ab"), text("cd"), binary, text("gh")}))
+
+	// a change of type starts a new message
+	assert.Equal(t, []string{"abcd", "ef", "gh"}, read(t, client))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cc.writes))
+}
+
+func TestWritePrepared(t *testing.T) {
+
+	conn, _, client := pair(t)
+
+	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, []byte("prepared"))
+	assert.NoError(t, err)
+
+	// prepared messages are written as prepared when they are not joined to others
+	assert.NoError(t, Write(conn, Message, []Item{{Type: websocket.TextMessage, Data: []byte("ignored"), Prepared: pm}, text("b")}))
+	assert.Equal(t, []string{"prepared", "b"}, read(t, client))
+}
+
+func TestWriteLargeBatch(t *testing.T) {
+
+	conn, cc, client := pair(t)
+
+	var items []Item
+	var want []string
+
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, maxBatch/4)
+		items = append(items, text(string(data)))
+		want = append(want, string(data))
+	}
+
+	assert.NoError(t, Write(conn, Message, items))
+
+	// no more than maxBatch is held back before sending
+	assert.Equal(t, want, read(t, client))
+	writes := atomic.LoadInt64(&cc.writes)
+	assert.Greater(t, writes, int64(1))
+	assert.Less(t, writes, int64(len(items)))
+}
+
+func TestWriteWithoutUpgrade(t *testing.T) {
+
+	conns := make(chan *websocket.Conn)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{}
+		conn, err := u.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		conns <- conn
+	}))
+	defer s.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	conn := <-conns
+	defer conn.Close()
+
+	// connections upgraded elsewhere are written to frame by frame
+	assert.NoError(t, Write(conn, Message, []Item{text("a"), text("b")}))
+	assert.Equal(t, []string{"a", "b"}, read(t, client))
+}
@@ -0,0 +1,172 @@
+// Package handover passes listening sockets from a running relay to a new
+// process, so that a new version can start accepting connections on the same
+// ports before the old one stops, and no connections are refused in between.
+package handover
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// envListeners tells the new process which inherited file descriptors hold
+// which listeners e.g. RELAY_LISTENERS=access:3,relay:4
+const envListeners = "RELAY_LISTENERS"
+
+// first is the file descriptor of the first of exec.Cmd.ExtraFiles
+const first = 3
+
+var (
+	mu        sync.Mutex
+	inherited map[string]int
+)
+
+// Listen returns the listener called name that was handed over by the
+// previous process, if there was one, else it listens on the TCP address.
+func Listen(name, addr string) (net.Listener, error) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if inherited == nil {
+		var err error
+		inherited, err = parse(os.Getenv(envListeners))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fd, ok := inherited[name]
+
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// each listener can only be inherited once
+	delete(inherited, name)
+
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener %s on fd %d not usable: %w", name, fd, err)
+	}
+
+	return l, nil
+}
+
+// Start starts a new copy of this program, with the same arguments and
+// environment, and hands it the listeners. The caller should stop accepting
+// on its listeners with Release once the new process is running, but not before.
+func Start(listeners map[string]net.Listener) (*exec.Cmd, error) {
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*os.File
+	var fds []string
+
+	defer func() {
+		// the new process has its own copies
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i, name := range names {
+
+		fl, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s cannot be handed over", name)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("listener %s cannot be handed over: %w", name, err)
+		}
+
+		files = append(files, f)
+		fds = append(fds, name+":"+strconv.Itoa(first+i))
+	}
+
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envListeners+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, envListeners+"="+strings.Join(fds, ","))
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = env
+	cmd.ExtraFiles = files
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+
+	return cmd, err
+}
+
+// Release stops this process accepting connections on the listeners, once they
+// have been handed over with Start, so that the new process accepts them all.
+// The sockets stay open in the new process, so no connections are refused.
+// Connections already accepted are not affected, and servers using the listeners
+// stop with an error wrapping net.ErrClosed. It returns the first error, if any.
+func Release(listeners map[string]net.Listener) error {
+
+	var failed error
+
+	for name, l := range listeners {
+		if err := l.Close(); err != nil && failed == nil {
+			failed = fmt.Errorf("listener %s not released: %w", name, err)
+		}
+	}
+
+	return failed
+}
+
+// parse parses the list of inherited listeners e.g. access:3,relay:4
+func parse(s string) (map[string]int, error) {
+
+	m := make(map[string]int)
+
+	if s == "" {
+		return m, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+
+		parts := strings.SplitN(item, ":", 2)
+
+		if len(parts) != 2 {
+			return nil, errors.New(envListeners + " item " + item + " is not in the form name:fd")
+		}
+
+		fd, err := strconv.Atoi(parts[1])
+
+		if err != nil || fd < first {
+			return nil, errors.New(envListeners + " item " + item + " does not have a valid fd")
+		}
+
+		m[parts[0]] = fd
+	}
+
+	return m, nil
+}
@@ -0,0 +1,169 @@
+package handover
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+
+	m, err := parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{}, m)
+
+	m, err = parse("access:3,relay:4")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"access": 3, "relay": 4}, m)
+
+	for _, bad := range []string{"access", "access:x", "access:2"} {
+		_, err = parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestListen(t *testing.T) {
+
+	// a listener handed over from another process arrives as a file descriptor
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	f, err := parent.(*net.TCPListener).File()
+	assert.NoError(t, err)
+
+	// Listen closes the descriptor it inherits, so give it one of its own
+	fd, err := syscall.Dup(int(f.Fd()))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	mu.Lock()
+	inherited = map[string]int{"relay": fd}
+	mu.Unlock()
+
+	l, err := Listen("relay", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.Equal(t, parent.Addr().String(), l.Addr().String())
+
+	// the parent can stop accepting without refusing connections
+	assert.NoError(t, parent.Close())
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// each listener is only inherited once, so the next listens afresh
+	l2, err := Listen("relay", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.NotEqual(t, l.Addr().String(), l2.Addr().String())
+	assert.NoError(t, l2.Close())
+}
+
+func TestRelease(t *testing.T) {
+
+	serve := func(l net.Listener, name string) *httptest.Server {
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+		s.Listener = l
+		s.Start()
+		return s
+	}
+
+	old, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	// old keeps serving until after the handover, as it would in the old process
+	so := serve(old, "old")
+	defer so.Close()
+
+	f, err := old.(*net.TCPListener).File()
+	assert.NoError(t, err)
+
+	// Listen closes the descriptor it inherits, so give it one of its own
+	fd, err := syscall.Dup(int(f.Fd()))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	mu.Lock()
+	inherited = map[string]int{"relay": fd}
+	mu.Unlock()
+
+	// every request uses a new connection, to see which process accepts it
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	get := func() (string, error) {
+		resp, err := client.Get("http://" + old.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan []string)
+
+	// connect throughout the handover
+	go func() {
+		var got []string
+		for {
+			select {
+			case <-stop:
+				done <- got
+				return
+			default:
+			}
+			body, err := get()
+			if err != nil {
+				body = err.Error()
+			}
+			got = append(got, body)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	l, err := Listen("relay", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	sn := serve(l, "new")
+	defer sn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, Release(map[string]net.Listener{"relay": old}))
+
+	time.Sleep(100 * time.Millisecond)
+
+	close(stop)
+	got := <-done
+
+	// no connections were refused, and the new process took over
+	assert.Greater(t, len(got), 10)
+	assert.Equal(t, "old", got[0])
+	assert.Equal(t, "new", got[len(got)-1])
+	for _, body := range got {
+		assert.Contains(t, []string{"old", "new"}, body)
+	}
+
+	// once released, only the new process accepts connections
+	for i := 0; i < 10; i++ {
+		body, err := get()
+		assert.NoError(t, err)
+		assert.Equal(t, "new", body)
+	}
+}
@@ -0,0 +1,301 @@
+// Package keyset holds the keys that tokens can be signed with, so that access
+// and the crossbar can accept tokens signed with any of several HMAC secrets, or
+// with the private key of an RS256 or EdDSA key pair, chosen by the token's kid header.
+// Tokens without a kid must be signed with the relay's secret, as before. Keys
+// with a kid are loaded from a JSON Web Key Set (RFC 7517) file, which is re-read
+// when it changes, so that keys can be added and retired without a restart.
+package keyset
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// key is a key for verifying tokens, with the algorithm it is used with
+type key struct {
+	alg    string
+	verify interface{}
+}
+
+// Keyset holds the secret for tokens without a kid, and the keys loaded from a file
+type Keyset struct {
+	secret []byte
+	file   string
+
+	mu      sync.RWMutex
+	keys    map[string]key
+	modTime time.Time
+}
+
+// New returns a Keyset that accepts tokens without a kid that are signed with the secret.
+// If the secret is empty, tokens must have a kid.
+func New(secret string) *Keyset {
+	return &Keyset{
+		secret: []byte(secret),
+		keys:   make(map[string]key),
+	}
+}
+
+// Load reads the keys from a JSON Web Key Set file, and remembers the file so
+// that Reload and Watch can read it again. If the file cannot be read, or any
+// key in it is not understood, the keys are not changed and an error is returned.
+func (k *Keyset) Load(file string) error {
+	k.file = file
+	return k.Reload()
+}
+
+// Reload reads the keys again from the file given to Load
+func (k *Keyset) Reload() error {
+
+	if k.file == "" {
+		return errors.New("no key file loaded")
+	}
+
+	info, err := os.Stat(k.file)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(k.file)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseSet(data)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+
+	return nil
+}
+
+// Watch reloads the keys whenever the file is modified, checking every interval,
+// until closed is closed
+func (k *Keyset) Watch(closed <-chan struct{}, every time.Duration) {
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-time.After(every):
+		}
+
+		info, err := os.Stat(k.file)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "file": k.file}).Warn("key file not checked")
+			continue
+		}
+
+		k.mu.RLock()
+		changed := info.ModTime().After(k.modTime)
+		k.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		err = k.Reload()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "file": k.file}).Error("keys not reloaded")
+			continue
+		}
+
+		log.WithFields(log.Fields{"file": k.file, "kids": k.IDs()}).Info("keys reloaded")
+	}
+}
+
+// IDs returns the kids of the keys loaded from the file
+func (k *Keyset) IDs() []string {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := []string{}
+
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Keyfunc returns the key to verify a token with, for jwt.Parse, according to its kid.
+// The token's algorithm must match the key, so that e.g. a public key cannot be
+// used as an HMAC secret.
+func (k *Keyset) Keyfunc(token *jwt.Token) (interface{}, error) {
+
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+
+		if len(k.secret) == 0 {
+			return nil, errors.New("token has no kid")
+		}
+
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method was %v", token.Header["alg"])
+		}
+
+		return k.secret, nil
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("unexpected signing method was %v for kid %s", token.Header["alg"], kid)
+	}
+
+	return key.verify, nil
+}
+
+// jwk is a JSON Web Key; only the members needed for verifying are read
+type jwk struct {
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	Use string `json:"use"`
+	X   string `json:"x"`
+}
+
+// parseSet returns the keys in a JSON Web Key Set, by kid. Every key must have a kid,
+// and be an HMAC secret (kty oct, alg HS256, HS384 or HS512, default HS256), an RSA
+// public key (kty RSA, alg RS256, RS384 or RS512, default RS256), or an Ed25519 public
+// key (kty OKP, crv Ed25519, alg EdDSA). Keys whose use is not sig are ignored.
+func parseSet(data []byte) (map[string]key, error) {
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]key)
+
+	for i, k := range set.Keys {
+
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if k.Kid == "" {
+			return nil, fmt.Errorf("key %d has no kid", i)
+		}
+
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("kid %s is not unique", k.Kid)
+		}
+
+		parsed, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("kid %s: %s", k.Kid, err.Error())
+		}
+
+		keys[k.Kid] = parsed
+	}
+
+	return keys, nil
+}
+
+// parse returns the key that a jwk describes
+func (k jwk) parse() (key, error) {
+
+	switch k.Kty {
+
+	case "oct":
+
+		if k.Alg == "" {
+			k.Alg = "HS256"
+		}
+
+		if _, ok := jwt.GetSigningMethod(k.Alg).(*jwt.SigningMethodHMAC); !ok {
+			return key{}, errors.New("alg " + k.Alg + " cannot be used with kty oct")
+		}
+
+		secret, err := decode(k.K)
+		if err != nil || len(secret) == 0 {
+			return key{}, errors.New("k is not a base64url encoded secret")
+		}
+
+		return key{k.Alg, secret}, nil
+
+	case "RSA":
+
+		if k.Alg == "" {
+			k.Alg = "RS256"
+		}
+
+		if _, ok := jwt.GetSigningMethod(k.Alg).(*jwt.SigningMethodRSA); !ok {
+			return key{}, errors.New("alg " + k.Alg + " cannot be used with kty RSA")
+		}
+
+		n, err := decode(k.N)
+		if err != nil || len(n) == 0 {
+			return key{}, errors.New("n is not a base64url encoded modulus")
+		}
+
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return key{}, errors.New("e is not a base64url encoded exponent")
+		}
+
+		return key{k.Alg, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+
+	case "OKP":
+
+		if k.Crv != "Ed25519" {
+			return key{}, errors.New("crv " + k.Crv + " is not supported, use Ed25519")
+		}
+
+		if k.Alg != "" && k.Alg != "EdDSA" {
+			return key{}, errors.New("alg " + k.Alg + " cannot be used with crv Ed25519")
+		}
+
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return key{}, errors.New("x is not a base64url encoded Ed25519 public key")
+		}
+
+		return key{"EdDSA", ed25519.PublicKey(x)}, nil
+
+	default:
+		return key{}, errors.New("kty " + k.Kty + " is not supported, use oct, RSA or OKP")
+	}
+}
+
+// decode decodes base64url, with or without padding
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
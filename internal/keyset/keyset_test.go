@@ -0,0 +1,183 @@
+package keyset
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// keys returns an RSA and an Ed25519 key pair, and a JWKS holding
+// their public keys, and an HMAC secret, with the kids rsa0, ed0 and hmac0
+func keys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey, []byte) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	set := map[string][]map[string]string{
+		"keys": {
+			{"kty": "RSA", "kid": "rsa0", "alg": "RS256", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "OKP", "kid": "ed0", "crv": "Ed25519", "x": b64(edPublic)},
+			{"kty": "oct", "kid": "hmac0", "k": b64([]byte("rotatedsecret"))},
+			{"kty": "oct", "kid": "enc0", "use": "enc", "k": b64([]byte("ignored"))},
+		},
+	}
+
+	data, err := json.Marshal(set)
+	assert.NoError(t, err)
+
+	return rsaKey, edKey, data
+}
+
+// sign returns a token for the topic, signed by s
+func sign(t *testing.T, s token.Signer) string {
+	now := time.Now()
+	bearer, err := s.Sign(now, now, now.Add(time.Minute), []string{"read"}, "https://relay.example.io", "bid0", "session", "expt00")
+	assert.NoError(t, err)
+	return bearer
+}
+
+// verify parses the bearer with the keyset, returning the topic
+func verify(k *Keyset, bearer string) (string, error) {
+	claims := &permission.Token{}
+	_, err := jwt.ParseWithClaims(bearer, claims, k.Keyfunc)
+	return claims.Topic, err
+}
+
+func TestParseSet(t *testing.T) {
+
+	_, _, data := keys(t)
+
+	parsed, err := parseSet(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(parsed))
+	assert.Equal(t, "RS256", parsed["rsa0"].alg)
+	assert.Equal(t, "EdDSA", parsed["ed0"].alg)
+	assert.Equal(t, "HS256", parsed["hmac0"].alg)
+	assert.Equal(t, []byte("rotatedsecret"), parsed["hmac0"].verify)
+
+	bad := []string{
+		`not json`,
+		`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`,
+		`{"keys":[{"kty":"oct","kid":"a","k":"c2VjcmV0"},{"kty":"oct","kid":"a","k":"c2VjcmV0"}]}`,
+		`{"keys":[{"kty":"oct","kid":"a","k":""}]}`,
+		`{"keys":[{"kty":"oct","kid":"a","alg":"RS256","k":"c2VjcmV0"}]}`,
+		`{"keys":[{"kty":"RSA","kid":"a","alg":"HS256","n":"AQAB","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"OKP","kid":"a","crv":"X25519","x":"AQAB"}]}`,
+		`{"keys":[{"kty":"OKP","kid":"a","crv":"Ed25519","x":"AQAB"}]}`,
+		`{"keys":[{"kty":"EC","kid":"a","crv":"P-256"}]}`,
+	}
+
+	for _, b := range bad {
+		_, err := parseSet([]byte(b))
+		assert.Error(t, err, b)
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+
+	rsaKey, edKey, data := keys(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys.json")
+	assert.NoError(t, os.WriteFile(file, data, 0600))
+
+	k := New("legacysecret")
+	assert.NoError(t, k.Load(file))
+	assert.ElementsMatch(t, []string{"rsa0", "ed0", "hmac0"}, k.IDs())
+
+	signers := map[string]token.Signer{
+		"rsa":  {ID: "rsa0", Method: jwt.SigningMethodRS256, Key: rsaKey},
+		"ed":   {ID: "ed0", Method: jwt.SigningMethodEdDSA, Key: edKey},
+		"hmac": token.NewHMACSigner("hmac0", "rotatedsecret"),
+	}
+
+	for name, s := range signers {
+		topic, err := verify(k, sign(t, s))
+		assert.NoError(t, err, name)
+		assert.Equal(t, "expt00", topic, name)
+	}
+
+	// tokens without a kid are signed with the secret
+	now := time.Now()
+	legacy, err := token.New(now, now, now.Add(time.Minute), []string{"read"}, "https://relay.example.io", "bid0", "session", "legacysecret", "expt00")
+	assert.NoError(t, err)
+	_, err = verify(k, legacy)
+	assert.NoError(t, err)
+
+	_, err = verify(New(""), legacy)
+	assert.Error(t, err)
+
+	// the wrong key for the kid
+	_, err = verify(k, sign(t, token.NewHMACSigner("hmac0", "legacysecret")))
+	assert.Error(t, err)
+
+	// an unknown kid
+	_, err = verify(k, sign(t, token.NewHMACSigner("hmac1", "rotatedsecret")))
+	assert.Error(t, err)
+
+	// a public key must not be usable as an HMAC secret
+	_, err = verify(k, sign(t, token.Signer{ID: "rsa0", Method: jwt.SigningMethodHS256, Key: []byte("anything")}))
+	assert.Error(t, err)
+
+	// keys removed from the file are no longer accepted, once reloaded
+	assert.NoError(t, os.WriteFile(file, []byte(`{"keys":[]}`), 0600))
+	assert.NoError(t, k.Reload())
+	_, err = verify(k, sign(t, signers["ed"]))
+	assert.Error(t, err)
+
+	// keys are kept if the file cannot be parsed
+	assert.NoError(t, os.WriteFile(file, data, 0600))
+	assert.NoError(t, k.Reload())
+	assert.NoError(t, os.WriteFile(file, []byte(`{"keys":[{"kty":"EC"}]}`), 0600))
+	assert.Error(t, k.Reload())
+	_, err = verify(k, sign(t, signers["ed"]))
+	assert.NoError(t, err)
+}
+
+func TestWatch(t *testing.T) {
+
+	_, edKey, data := keys(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"keys":[]}`), 0600))
+	past := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(file, past, past))
+
+	k := New("")
+	assert.NoError(t, k.Load(file))
+	assert.Equal(t, 0, len(k.IDs()))
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go k.Watch(closed, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(file, data, 0600))
+	now := time.Now()
+	assert.NoError(t, os.Chtimes(file, now, now))
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, err := verify(k, sign(t, token.Signer{ID: "ed0", Method: jwt.SigningMethodEdDSA, Key: edKey}))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,143 @@
+// Package limit provides rate limits, for connections, session requests and messages
+package limit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate allows Count events Per duration, on average, with bursts of up to Count.
+// The zero Rate is unlimited.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseRate parses a rate in the form count/duration e.g. "10/1m". An empty string is unlimited.
+func ParseRate(s string) (Rate, error) {
+
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return Rate{}, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("rate %s is not in the form count/duration", s)
+	}
+
+	count, err := strconv.Atoi(parts[0])
+
+	if err != nil || count < 1 {
+		return Rate{}, fmt.Errorf("rate %s needs a positive whole number count", s)
+	}
+
+	per, err := time.ParseDuration(parts[1])
+
+	if err != nil || per <= 0 {
+		return Rate{}, fmt.Errorf("rate %s needs a positive duration", s)
+	}
+
+	return Rate{Count: count, Per: per}, nil
+}
+
+// Unlimited reports whether the rate places no limit on events
+func (r Rate) Unlimited() bool {
+	return r.Count <= 0 || r.Per <= 0
+}
+
+func (r Rate) String() string {
+	if r.Unlimited() {
+		return "unlimited"
+	}
+	return strconv.Itoa(r.Count) + "/" + r.Per.String()
+}
+
+// NewLimiter returns a limiter for a single source of events, or nil if the rate is unlimited
+func (r Rate) NewLimiter() *rate.Limiter {
+	if r.Unlimited() {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(float64(r.Count)/r.Per.Seconds()), r.Count)
+}
+
+// Keyed limits events separately for each key, such as a source address or booking ID
+type Keyed struct {
+	sync.Mutex
+	rate     Rate
+	limiters map[string]*entry
+	pruned   time.Time
+	now      func() time.Time
+}
+
+type entry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewKeyed returns a Keyed limiter that allows events at rate r, for each key
+func NewKeyed(r Rate) *Keyed {
+	return &Keyed{
+		rate:     r,
+		limiters: make(map[string]*entry),
+		now:      time.Now,
+		pruned:   time.Now(),
+	}
+}
+
+// Allow reports whether an event for the key is within the rate
+func (k *Keyed) Allow(key string) bool {
+
+	if k == nil || k.rate.Unlimited() {
+		return true
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	now := k.now()
+
+	k.prune(now)
+
+	e, ok := k.limiters[key]
+
+	if !ok {
+		e = &entry{limiter: k.rate.NewLimiter()}
+		k.limiters[key] = e
+	}
+
+	e.lastSeen = now
+
+	return e.limiter.AllowN(now, 1)
+}
+
+// Size returns the number of keys being tracked
+func (k *Keyed) Size() int {
+	k.Lock()
+	defer k.Unlock()
+	return len(k.limiters)
+}
+
+// prune forgets keys that have been idle for long enough that their
+// limiter would have refilled. The caller must hold the lock.
+func (k *Keyed) prune(now time.Time) {
+
+	if now.Sub(k.pruned) < k.rate.Per {
+		return
+	}
+
+	for key, e := range k.limiters {
+		if now.Sub(e.lastSeen) > k.rate.Per {
+			delete(k.limiters, key)
+		}
+	}
+
+	k.pruned = now
+}
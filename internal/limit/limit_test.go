@@ -0,0 +1,66 @@
+package limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+
+	r, err := ParseRate("10/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, Rate{Count: 10, Per: time.Minute}, r)
+	assert.Equal(t, "10/1m0s", r.String())
+
+	r, err = ParseRate("")
+	assert.NoError(t, err)
+	assert.True(t, r.Unlimited())
+	assert.Equal(t, "unlimited", r.String())
+
+	for _, bad := range []string{"10", "x/1s", "0/1s", "-1/1s", "10/x", "10/0s"} {
+		_, err = ParseRate(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestKeyed(t *testing.T) {
+
+	now := time.Now()
+
+	k := NewKeyed(Rate{Count: 2, Per: time.Second})
+	k.now = func() time.Time { return now }
+
+	assert.True(t, k.Allow("a"))
+	assert.True(t, k.Allow("a"))
+	assert.False(t, k.Allow("a"))
+
+	// keys are limited separately
+	assert.True(t, k.Allow("b"))
+
+	// tokens refill at Count per Per
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, k.Allow("a"))
+	assert.False(t, k.Allow("a"))
+
+	// idle keys are forgotten
+	assert.Equal(t, 2, k.Size())
+	now = now.Add(2 * time.Second)
+	assert.True(t, k.Allow("a"))
+	assert.Equal(t, 1, k.Size())
+}
+
+func TestUnlimited(t *testing.T) {
+
+	k := NewKeyed(Rate{})
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, k.Allow("a"))
+	}
+
+	var nilKeyed *Keyed
+	assert.True(t, nilKeyed.Allow("a"))
+
+	assert.Nil(t, Rate{}.NewLimiter())
+}
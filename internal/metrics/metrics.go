@@ -0,0 +1,143 @@
+// Package metrics provides prometheus metrics for the relay
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the relay's metrics, separately from the default registry
+// so that only relay metrics (plus go runtime and process metrics) are exposed
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// AuditDropped counts audit events that were not written to the audit log
+	AuditDropped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "audit_dropped_total",
+		Help:      "Audit events that were not written, because the audit log could not keep up or could not write.",
+	})
+
+	// Connections counts active connections by topic and by scope (read, write, host, client)
+	// A connection with both read and write scopes is counted under each
+	Connections = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Name:      "connections",
+		Help:      "Active websocket connections by topic and scope.",
+	}, []string{"topic", "scope"})
+
+	// Messages counts messages received from (rx) and sent to (tx) clients
+	Messages = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "messages_total",
+		Help:      "Messages received from (rx) and sent to (tx) clients.",
+	}, []string{"direction"})
+
+	// Bytes counts bytes received from (rx) and sent to (tx) clients
+	Bytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "bytes_total",
+		Help:      "Bytes received from (rx) and sent to (tx) clients.",
+	}, []string{"direction"})
+
+	// Dropped counts messages that were not sent because a client could not keep up,
+	// by which message was dropped (newest, oldest, stale)
+	Dropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "messages_dropped_total",
+		Help:      "Messages dropped because the client could not keep up, by which message was dropped (newest, oldest, stale).",
+	}, []string{"topic", "reason"})
+
+	// SlowDisconnects counts clients disconnected because they could not keep up
+	SlowDisconnects = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "slow_disconnects_total",
+		Help:      "Clients disconnected because they could not keep up.",
+	}, []string{"topic"})
+
+	// CodeExchanges counts attempts to exchange a code for a connection, by result and reason for failure
+	CodeExchanges = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "code_exchanges_total",
+		Help:      "Code exchanges by result (ok, failed) and reason for failure.",
+	}, []string{"result", "reason"})
+
+	// DenyListSize is the number of booking ids on the deny list
+	DenyListSize = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Name:      "deny_list_size",
+		Help:      "Booking ids currently on the deny list.",
+	})
+
+	// RateLimited counts requests and connections refused because they exceeded a rate limit
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "rate_limited_total",
+		Help:      "Requests and connections refused for exceeding a rate limit, by limit (connect, session, messages, bytes).",
+	}, []string{"limit"})
+
+	// ClusterPeers is the number of other nodes this node is linked to
+	ClusterPeers = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Name:      "cluster_peers",
+		Help:      "Other nodes currently linked to this node.",
+	})
+
+	// ClusterDropped counts messages not forwarded to a peer because the link was blocked
+	ClusterDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "cluster_dropped_total",
+		Help:      "Messages not forwarded to a peer node because the link was blocked.",
+	}, []string{"peer"})
+
+	// RecordDropped counts messages on recorded topics that were not recorded
+	RecordDropped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Name:      "record_dropped_total",
+		Help:      "Messages on recorded topics that were not recorded, because the recorder could not keep up or could not write.",
+	})
+
+	// SessionDuration measures how long the access server takes to handle session requests
+	SessionDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "relay",
+		Name:      "session_request_duration_seconds",
+		Help:      "Time taken to handle requests to the session endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+)
+
+// Pre-resolved counters for the per-message hot path
+var (
+	MessagesRx = Messages.WithLabelValues("rx")
+	MessagesTx = Messages.WithLabelValues("tx")
+	BytesRx    = Bytes.WithLabelValues("rx")
+	BytesTx    = Bytes.WithLabelValues("tx")
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ExchangeOK records a successful code exchange
+func ExchangeOK() {
+	CodeExchanges.WithLabelValues("ok", "").Inc()
+}
+
+// ExchangeFailed records a failed code exchange, with the reason it failed
+func ExchangeFailed(reason string) {
+	CodeExchanges.WithLabelValues("failed", reason).Inc()
+}
+
+// Handler returns an http.Handler that serves the metrics in prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
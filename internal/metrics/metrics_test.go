@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+
+	Connections.WithLabelValues("test00", "read").Inc()
+	Dropped.WithLabelValues("test00", "newest").Inc()
+	SlowDisconnects.WithLabelValues("test00").Inc()
+	ExchangeFailed("invalid code")
+	ExchangeOK()
+	DenyListSize.Set(3)
+	SessionDuration.Observe(0.001)
+
+	s := httptest.NewServer(Handler())
+	defer s.Close()
+
+	resp, err := s.Client().Get(s.URL)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	text := string(body)
+
+	assert.Contains(t, text, `relay_connections{scope="read",topic="test00"} 1`)
+	assert.Contains(t, text, `relay_messages_dropped_total{reason="newest",topic="test00"} 1`)
+	assert.Contains(t, text, `relay_slow_disconnects_total{topic="test00"} 1`)
+	assert.Contains(t, text, `relay_code_exchanges_total{reason="invalid code",result="failed"} 1`)
+	assert.Contains(t, text, `relay_code_exchanges_total{reason="",result="ok"} 1`)
+	assert.Contains(t, text, `relay_deny_list_size 3`)
+	assert.Contains(t, text, `relay_session_request_duration_seconds_count 1`)
+	assert.Contains(t, text, `go_goroutines`)
+
+}
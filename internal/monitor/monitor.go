@@ -23,6 +23,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/practable/relay/internal/permission"
 	"github.com/practable/relay/internal/reconws"
+	"github.com/practable/relay/pkg/token"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -34,6 +35,7 @@ type Config struct {
 	ReconnectEvery     time.Duration
 	RelayAudience      string
 	RelaySecret        string
+	RelaySigner        *token.Signer // used instead of RelaySecret, if set
 	Topic              string
 	TriggerAfterMisses int
 }
@@ -251,6 +253,10 @@ func NewToken(c Config, now time.Time) (string, error) {
 	nbf := now.Add(-1 * time.Second).Unix()
 	exp := now.Add(c.ReconnectEvery).Unix()
 
+	if c.RelaySigner != nil {
+		return c.RelaySigner.Sign(time.Unix(iat, 0), time.Unix(nbf, 0), time.Unix(exp, 0), []string{"read", "write"}, c.RelayAudience, "relay-monitor", "session", c.Topic)
+	}
+
 	claims.IssuedAt = jwt.NewNumericDate(time.Unix(iat, 0))
 	claims.NotBefore = jwt.NewNumericDate(time.Unix(nbf, 0))
 	claims.ExpiresAt = jwt.NewNumericDate(time.Unix(exp, 0))
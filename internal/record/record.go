@@ -0,0 +1,478 @@
+// Package record stores the messages sent on session topics, so that
+// sessions can be reviewed, downloaded and replayed afterwards.
+//
+// Recordings are kept in a directory per topic (path-escaped, so that
+// topics containing slashes are one directory), with one file per hour
+// named after the hour it started in UTC e.g.
+//
+//	<dir>/pend00%2Fdata/2022-11-03T14.ndjson
+//
+// Each file is newline-delimited JSON, with one Record per line e.g.
+//
+//	{"t":"2022-11-03T14:05:01.123456789Z","dir":"rx","bid":"b123","topic":"pend00/data","type":"text","text":"{\"set\":\"speed\"}"}
+//	{"t":"2022-11-03T14:05:01.200000000Z","dir":"rx","topic":"pend00/video","type":"binary","data":"R0lGODlh..."}
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/practable/relay/internal/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Directions of a Record
+const (
+	// Received is a message the relay received from a client
+	Received = "rx"
+
+	// Sent is a message the relay published itself, such as a replay
+	Sent = "tx"
+)
+
+// Types of a Record
+const (
+	Text   = "text"
+	Binary = "binary"
+)
+
+// hourFormat names the file for each hour of a recording
+const hourFormat = "2006-01-02T15"
+
+// ErrTopic is returned for a topic whose recordings could not be kept in a
+// directory of their own inside the store's directory, such as ".."
+var ErrTopic = errors.New("topic cannot be recorded")
+
+// ValidTopic reports whether a topic can be recorded. Escaping leaves "." and ".."
+// unchanged, so those topics would name the store's directory, or its parent.
+func ValidTopic(topic string) bool {
+	escaped := url.PathEscape(topic)
+	return escaped != "" && escaped != "." && escaped != ".."
+}
+
+// Record is one message sent on a topic
+type Record struct {
+
+	// Time the relay received the message
+	Time time.Time `json:"t"`
+
+	// Direction is Received or Sent
+	Direction string `json:"dir"`
+
+	// BookingID of the sender's token, if any
+	BookingID string `json:"bid,omitempty"`
+
+	Topic string `json:"topic"`
+
+	// Type is Text or Binary
+	Type string `json:"type"`
+
+	// Text holds the payload of Text messages
+	Text string `json:"text,omitempty"`
+
+	// Data holds the payload of Binary messages, base64 encoded in JSON
+	Data []byte `json:"data,omitempty"`
+}
+
+// NewRecord returns a Record for a message with the given payload
+func NewRecord(t time.Time, direction, bookingID, topic string, binary bool, payload []byte) Record {
+	r := Record{
+		Time:      t.UTC(),
+		Direction: direction,
+		BookingID: bookingID,
+		Topic:     topic,
+		Type:      Text,
+	}
+	if binary {
+		r.Type = Binary
+		r.Data = payload
+	} else {
+		r.Text = string(payload)
+	}
+	return r
+}
+
+// Payload returns the message payload, whatever its type
+func (r Record) Payload() []byte {
+	if r.Type == Binary {
+		return r.Data
+	}
+	return []byte(r.Text)
+}
+
+// Filter selects records. Empty fields match everything.
+type Filter struct {
+	Topic     string
+	BookingID string
+	From      time.Time
+	To        time.Time
+}
+
+// Match reports whether a record is selected by the filter
+func (f Filter) Match(r Record) bool {
+	switch {
+	case f.Topic != "" && r.Topic != f.Topic:
+		return false
+	case f.BookingID != "" && r.BookingID != f.BookingID:
+		return false
+	case !f.From.IsZero() && r.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && r.Time.After(f.To):
+		return false
+	}
+	return true
+}
+
+// overlaps reports whether any of the period from start to end could be selected by the filter
+func (f Filter) overlaps(start, end time.Time) bool {
+	if !f.From.IsZero() && end.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && start.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// Recording describes one file of records
+type Recording struct {
+	Topic      string    `json:"topic"`
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	BookingIDs []string  `json:"bookingIDs"`
+	Messages   int       `json:"messages"`
+	Size       int64     `json:"size"`
+}
+
+// Store writes records to, and reads them from, a directory
+type Store struct {
+	dir   string
+	queue chan Record
+	files map[string]*file
+}
+
+// file is the open file for a topic's current hour
+type file struct {
+	hour string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// New returns a Store that keeps recordings in dir, queuing up to size records
+// to be written. Call Run to write the records.
+func New(dir string, size int) *Store {
+	return &Store{
+		dir:   dir,
+		queue: make(chan Record, size),
+		files: make(map[string]*file),
+	}
+}
+
+// Add queues a record to be written, without blocking. If the queue is full,
+// the record is dropped, so that recording cannot hold up the relay.
+func (s *Store) Add(r Record) {
+	select {
+	case s.queue <- r:
+	default:
+		metrics.RecordDropped.Inc()
+	}
+}
+
+// Run writes queued records until closed, then writes any records
+// still queued, and closes the files.
+func (s *Store) Run(closed <-chan struct{}) {
+
+	defer s.closeAll()
+
+	for {
+		select {
+		case <-closed:
+			for len(s.queue) > 0 {
+				s.write(<-s.queue)
+			}
+			return
+		case r := <-s.queue:
+			s.write(r)
+			// write out the buffers once the queue is clear, so that
+			// records can be read soon after they are received
+			if len(s.queue) == 0 {
+				s.flush()
+			}
+		}
+	}
+}
+
+// topicDir returns the directory holding a topic's recordings, or ErrTopic
+// if it would not be a directory inside the store's directory
+func (s *Store) topicDir(topic string) (string, error) {
+
+	if !ValidTopic(topic) {
+		return "", ErrTopic
+	}
+
+	dir := filepath.Join(s.dir, url.PathEscape(topic))
+
+	if filepath.Dir(dir) != filepath.Clean(s.dir) {
+		return "", ErrTopic
+	}
+
+	return dir, nil
+}
+
+// write appends a record to the file for its topic and hour
+func (s *Store) write(r Record) {
+
+	hour := r.Time.UTC().Format(hourFormat)
+
+	f, ok := s.files[r.Topic]
+
+	if ok && f.hour != hour {
+		s.close(r.Topic)
+		ok = false
+	}
+
+	if !ok {
+
+		dir, err := s.topicDir(r.Topic)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "topic": r.Topic}).Error("record not written")
+			metrics.RecordDropped.Inc()
+			return
+		}
+
+		// recordings hold session data, so are kept from other users
+		err = os.MkdirAll(dir, 0750)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "dir": dir}).Error("recording directory not created")
+			metrics.RecordDropped.Inc()
+			return
+		}
+
+		name := filepath.Join(dir, hour+".ndjson")
+
+		of, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "file": name}).Error("recording file not opened")
+			metrics.RecordDropped.Inc()
+			return
+		}
+
+		f = &file{hour: hour, f: of, w: bufio.NewWriter(of)}
+		s.files[r.Topic] = f
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": r.Topic}).Error("record not marshalled")
+		metrics.RecordDropped.Inc()
+		return
+	}
+
+	_, err = f.w.Write(append(data, '\n'))
+	if err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": r.Topic}).Error("record not written")
+		metrics.RecordDropped.Inc()
+	}
+}
+
+func (s *Store) flush() {
+	for topic, f := range s.files {
+		if err := f.w.Flush(); err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "topic": topic}).Error("recording not flushed")
+		}
+	}
+}
+
+func (s *Store) close(topic string) {
+	f := s.files[topic]
+	if err := f.w.Flush(); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": topic}).Error("recording not flushed")
+	}
+	if err := f.f.Close(); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "topic": topic}).Error("recording not closed")
+	}
+	delete(s.files, topic)
+}
+
+func (s *Store) closeAll() {
+	for topic := range s.files {
+		s.close(topic)
+	}
+}
+
+// List describes the recordings with records selected by the filter, in order of topic then time.
+// It reads each recording that might match, so it can be slow if there are many.
+func (s *Store) List(f Filter) ([]Recording, error) {
+
+	recordings := []Recording{}
+
+	err := s.walk(f, func(topic, name string) error {
+
+		rec := Recording{Topic: topic, Name: filepath.Base(name)}
+		bids := make(map[string]bool)
+		matched := false
+
+		err := readFile(name, func(r Record) error {
+			if rec.Messages == 0 {
+				rec.Start = r.Time
+			}
+			rec.End = r.Time
+			rec.Messages++
+			if r.BookingID != "" {
+				bids[r.BookingID] = true
+			}
+			if f.Match(r) {
+				matched = true
+			}
+			return nil
+		})
+
+		if err != nil || !matched {
+			return err
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		rec.Size = info.Size()
+
+		rec.BookingIDs = []string{}
+		for bid := range bids {
+			rec.BookingIDs = append(rec.BookingIDs, bid)
+		}
+		sort.Strings(rec.BookingIDs)
+
+		recordings = append(recordings, rec)
+		return nil
+	})
+
+	return recordings, err
+}
+
+// Read calls fn with each record selected by the filter, in order of topic then time.
+// It stops at the first error from fn, and returns it.
+func (s *Store) Read(f Filter, fn func(Record) error) error {
+	return s.walk(f, func(topic, name string) error {
+		return readFile(name, func(r Record) error {
+			if !f.Match(r) {
+				return nil
+			}
+			return fn(r)
+		})
+	})
+}
+
+// walk calls fn with the topic and path of each file that might hold records selected by the filter.
+// It returns ErrTopic if the filter's topic cannot be recorded.
+func (s *Store) walk(f Filter, fn func(topic, name string) error) error {
+
+	var topics []string
+
+	if f.Topic != "" {
+		topics = []string{f.Topic}
+	} else {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			topic, err := url.PathUnescape(e.Name())
+			if err != nil {
+				continue
+			}
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+	}
+
+	for _, topic := range topics {
+
+		dir, err := s.topicDir(topic)
+		if err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		// ReadDir sorts by name, which is in time order
+		for _, e := range entries {
+
+			name := e.Name()
+
+			if e.IsDir() || !strings.HasSuffix(name, ".ndjson") {
+				continue
+			}
+
+			start, err := time.Parse(hourFormat, strings.TrimSuffix(name, ".ndjson"))
+			if err != nil {
+				continue
+			}
+
+			if !f.overlaps(start, start.Add(time.Hour)) {
+				continue
+			}
+
+			if err := fn(topic, filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readFile calls fn with each record in a file. Lines that are not records,
+// such as one that is still being written, are skipped.
+func readFile(name string, fn func(Record) error) error {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var rec Record
+			if json.Unmarshal(line, &rec) == nil {
+				if err := fn(rec); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
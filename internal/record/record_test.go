@@ -0,0 +1,179 @@
+package record
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordJSON(t *testing.T) {
+
+	t0 := time.Date(2022, 11, 3, 14, 5, 1, 0, time.UTC)
+
+	r := NewRecord(t0, Received, "b0", "pend00/data", false, []byte(`{"set":"speed"}`))
+	assert.Equal(t, Text, r.Type)
+	assert.Equal(t, []byte(`{"set":"speed"}`), r.Payload())
+
+	r = NewRecord(t0, Sent, "", "pend00/video", true, []byte{0, 1, 2})
+	assert.Equal(t, Binary, r.Type)
+	assert.Equal(t, []byte{0, 1, 2}, r.Payload())
+}
+
+func TestStore(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s := New(dir, 16)
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		s.Run(closed)
+		close(done)
+	}()
+
+	t0 := time.Date(2022, 11, 3, 14, 59, 59, 0, time.UTC)
+
+	s.Add(NewRecord(t0, Received, "b0", "pend00/data", false, []byte("a")))
+	s.Add(NewRecord(t0.Add(500*time.Millisecond), Received, "", "pend00/data", false, []byte("b")))
+	s.Add(NewRecord(t0.Add(2*time.Second), Received, "b1", "pend00/data", false, []byte("c")))
+	s.Add(NewRecord(t0.Add(3*time.Second), Received, "b1", "pend01/data", true, []byte{1}))
+
+	close(closed)
+	<-done
+
+	// topics are escaped, and files are split by the hour
+	_, err := os.Stat(filepath.Join(dir, "pend00%2Fdata", "2022-11-03T14.ndjson"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "pend00%2Fdata", "2022-11-03T15.ndjson"))
+	assert.NoError(t, err)
+
+	all, err := s.List(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, "pend00/data", all[0].Topic)
+	assert.Equal(t, "2022-11-03T14.ndjson", all[0].Name)
+	assert.Equal(t, 2, all[0].Messages)
+	assert.Equal(t, []string{"b0"}, all[0].BookingIDs)
+	assert.Equal(t, t0, all[0].Start)
+	assert.Equal(t, t0.Add(500*time.Millisecond), all[0].End)
+	assert.Equal(t, "pend01/data", all[2].Topic)
+
+	list, err := s.List(Filter{BookingID: "b1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+
+	list, err = s.List(Filter{Topic: "pend00/data", From: t0.Add(time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "2022-11-03T15.ndjson", list[0].Name)
+
+	list, err = s.List(Filter{Topic: "nothing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	var got []string
+	err = s.Read(Filter{Topic: "pend00/data", To: t0.Add(time.Second)}, func(r Record) error {
+		got = append(got, string(r.Payload()))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	got = nil
+	err = s.Read(Filter{BookingID: "b1"}, func(r Record) error {
+		got = append(got, r.Topic)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pend00/data", "pend01/data"}, got)
+}
+
+func TestReadSkipsPartialLines(t *testing.T) {
+
+	dir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(dir, "a"), 0755)
+	assert.NoError(t, err)
+
+	data := `{"t":"2022-11-03T14:00:00Z","dir":"rx","topic":"a","type":"text","text":"x"}` + "\n" + `{"t":"2022-11-03T14:00:01Z","dir":"rx","to`
+	err = os.WriteFile(filepath.Join(dir, "a", "2022-11-03T14.ndjson"), []byte(data), 0644)
+	assert.NoError(t, err)
+
+	n := 0
+	err = New(dir, 1).Read(Filter{Topic: "a"}, func(r Record) error {
+		n++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestStoreKeepsToDir(t *testing.T) {
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "recordings")
+
+	// a recording outside the store's directory, which must not be read
+	outside := filepath.Join(parent, "2022-11-03T14.ndjson")
+	err := os.WriteFile(outside, []byte(`{"t":"2022-11-03T14:05:01Z","dir":"rx","topic":"x","type":"text","text":"secret"}`+"\n"), 0640)
+	assert.NoError(t, err)
+
+	s := New(dir, 16)
+
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		s.Run(closed)
+		close(done)
+	}()
+
+	t0 := time.Date(2022, 11, 3, 14, 5, 1, 0, time.UTC)
+
+	s.Add(NewRecord(t0, Received, "", "..", false, []byte("a")))
+	s.Add(NewRecord(t0, Received, "", ".", false, []byte("b")))
+	s.Add(NewRecord(t0, Received, "b0", "pend00/data", false, []byte("c")))
+
+	close(closed)
+	<-done
+
+	for _, topic := range []string{"..", ".", ""} {
+		assert.False(t, ValidTopic(topic))
+	}
+	assert.True(t, ValidTopic("pend00/.."))
+
+	for _, topic := range []string{"..", "."} {
+		_, err = s.List(Filter{Topic: topic})
+		assert.ErrorIs(t, err, ErrTopic)
+
+		err = s.Read(Filter{Topic: topic}, func(r Record) error {
+			t.Errorf("read %s from outside the store", r.Text)
+			return nil
+		})
+		assert.ErrorIs(t, err, ErrTopic)
+	}
+
+	// the records on topics that cannot be recorded were dropped
+	data, err := os.ReadFile(outside)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"text":"a"`)
+
+	all, err := s.List(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(all))
+
+	// recordings hold session data, so are kept from other users
+	info, err := os.Stat(filepath.Join(dir, "pend00%2Fdata"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm()&0750)
+	assert.Equal(t, os.FileMode(0), info.Mode().Perm()&0007)
+
+	info, err = os.Stat(filepath.Join(dir, "pend00%2Fdata", "2022-11-03T14.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0), info.Mode().Perm()&0007)
+}
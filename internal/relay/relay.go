@@ -1,53 +1,102 @@
 package relay
 
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/practable/relay/internal/access"
+	"github.com/practable/relay/internal/audit"
 	"github.com/practable/relay/internal/crossbar"
 	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/keyset"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/metrics"
+	"github.com/practable/relay/internal/record"
+	"github.com/practable/relay/internal/store"
 	"github.com/practable/relay/internal/ttlcode"
 	log "github.com/sirupsen/logrus"
 )
 
 // Config holds the relay server paramters
 type Config struct {
+	AccessListener   net.Listener // used instead of AccessPort, if set
 	AccessPort       int
+	AdminClientCert  bool // admin endpoints need a verified client certificate, so AccessListener must be a TLS listener
 	AllowNoBookingID bool
+	AuditDir         string        // audit log is not kept unless set
+	AuditKeep        time.Duration // how long audit files are kept, or forever if zero
 	Audience         string
 	BufferSize       int64
+	ClusterNode      string
+	ClusterPeers     []string
+	ClusterSecret    string
+	Drain            <-chan struct{} // when closed, stop accepting sessions and close connections with going away
+	ExpiryWarning    time.Duration   // how long before expiry to warn clients that use crossbar.SessionProtocol
+	FloorControl     []string
+	Keys             *keyset.Keyset // verifies tokens; if nil, only tokens signed with Secret are accepted
+	LimitBytes       limit.Rate
+	LimitConnect     limit.Rate
+	LimitMessages    limit.Rate
+	LimitSession     limit.Rate
+	Policies         *crossbar.Policies // buffer sizes, message limits and connection caps for session topics, if set
+	Proxies          *forwarded.Proxies // trusted to set X-Forwarded-For; if nil, none are
 	PruneEvery       time.Duration
+	Record           []string
+	RecordDir        string
+	RelayListener    net.Listener // used instead of RelayPort, if set
 	RelayPort        int
 	Secret           string
+	SlowConsumer     []crossbar.SlowConsumerRule
+	StateDir         string
 	StatsEvery       time.Duration
+	StoreListener    net.Listener // used instead of StorePort, if set
+	StorePort        int
+	StoreSecret      string
+	StoreURL         string
 	Target           string
 }
 
-// Relay runs a websocket relay
-func Relay(closed <-chan struct{}, parentwg *sync.WaitGroup, config Config) {
+// Relay runs a websocket relay until closed is closed, or returns an error straight away
+// if it cannot start, e.g. because the deny list in StateDir cannot be loaded.
+// parentwg.Done is called in either case.
+func Relay(closed <-chan struct{}, parentwg *sync.WaitGroup, config Config) error {
 
 	//(closed <-chan struct{}, parentwg *sync.WaitGroup, accessPort, relayPort int, audience, secret, target string, allowNoBookingID bool) {
 
+	defer parentwg.Done()
+
 	var wg sync.WaitGroup
 
 	denied := make(chan string, 64)
 
-	cs := ttlcode.NewDefaultCodeStore()
-	ds := deny.New()
+	var cs ttlcode.Backend
+	var ds deny.Backend
+	var topics chan deny.Topic
 
-	go func() {
-		for {
-			select {
-			case <-closed:
-				return
-			case <-time.After(config.PruneEvery):
-				{
-					ds.Prune()
-				}
-			}
+	if config.StoreURL != "" {
+		client := store.NewClient(config.StoreURL, config.StoreSecret)
+		cs = client
+		ds = client
+		// close connections that were denied via other replicas
+		go client.WatchDenied(closed, time.Second, denied)
+		topics = make(chan deny.Topic, 16)
+		go client.WatchDeniedTopics(closed, time.Second, topics)
+		log.WithField("url", config.StoreURL).Info("using remote code and deny store")
+	} else {
+		// refuse to start without the saved deny list, rather than let cancelled bookings back in
+		lcs, lds, err := localStores(closed, config)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err.Error(), "dir": config.StateDir}).Error("relay not started because deny list not loaded")
+			return err
 		}
-	}()
+		cs, ds = lcs, lds
+	}
 
 	if config.BufferSize < 1 || config.BufferSize > 512 {
 		log.WithFields(log.Fields{"requested": config.BufferSize, "actual": 256}).Warn("Overriding configured buffer size because out of range 1-512")
@@ -59,16 +108,78 @@ func Relay(closed <-chan struct{}, parentwg *sync.WaitGroup, config Config) {
 		config.StatsEvery = time.Duration(time.Second) //we have to balance fast testing vs high CPU load in production if too short
 	}
 
-	hub := crossbar.New()
+	var rs *record.Store
+
+	// recordings can be reviewed whether or not any topics are being recorded now
+	if config.RecordDir != "" {
+		rs = record.New(config.RecordDir, 1024)
+		go rs.Run(closed)
+		log.WithFields(log.Fields{"topics": config.Record, "dir": config.RecordDir}).Info("recording topics")
+	}
+
+	// the hub's options must be set before it is shared
+	hub := crossbar.New().WithRecorder(rs, config.Record)
+
+	if config.ClusterNode != "" {
+		secret := config.ClusterSecret
+		if secret == "" {
+			secret = config.Secret
+		}
+		hub = hub.WithCluster(crossbar.ClusterConfig{
+			NodeID:      config.ClusterNode,
+			Peers:       config.ClusterPeers,
+			ReportEvery: config.StatsEvery,
+			Secret:      secret,
+		}, config.Target)
+	}
+
+	if topics != nil {
+		go func() {
+			for {
+				select {
+				case <-closed:
+					return
+				case d := <-topics:
+					hub.Quarantine(d)
+				}
+			}
+		}()
+	}
+
+	var al *audit.Log
+
+	if config.AuditDir != "" {
+		al = audit.New(config.AuditDir, 1024, config.AuditKeep)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			al.Run(closed)
+		}()
+		log.WithFields(log.Fields{"dir": config.AuditDir, "keep": config.AuditKeep.String()}).Info("auditing connections")
+	}
 
 	crossbarConfig := crossbar.Config{
-		Listen:     config.RelayPort,
-		Audience:   config.Target,
-		BufferSize: config.BufferSize,
-		CodeStore:  cs,
-		DenyStore:  ds,
-		Hub:        hub,
-		StatsEvery: config.StatsEvery,
+		Audit:         al,
+		Listen:        config.RelayPort,
+		Audience:      config.Target,
+		BufferSize:    config.BufferSize,
+		CodeStore:     cs,
+		DenyStore:     ds,
+		Drain:         config.Drain,
+		ExpiryWarning: config.ExpiryWarning,
+		FloorControl:  config.FloorControl,
+		Hub:           hub,
+		Keys:          config.Keys,
+		LimitBytes:    config.LimitBytes,
+		LimitConnect:  config.LimitConnect,
+		LimitMessages: config.LimitMessages,
+		Listener:      config.RelayListener,
+		Policies:      config.Policies,
+		Proxies:       config.Proxies,
+		Secret:        config.Secret,
+		SlowConsumer:  config.SlowConsumer,
+		StatsEvery:    config.StatsEvery,
+		TokenAudience: config.Audience,
 	}
 
 	wg.Add(1)
@@ -77,13 +188,21 @@ func Relay(closed <-chan struct{}, parentwg *sync.WaitGroup, config Config) {
 	wg.Add(1)
 
 	accessConfig := access.Config{
+		AdminClientCert:  config.AdminClientCert,
 		AllowNoBookingID: config.AllowNoBookingID,
+		Audit:            al,
 		CodeStore:        cs,
 		DenyStore:        ds,
 		DenyChannel:      denied,
+		Drain:            config.Drain,
 		Host:             config.Audience,
 		Hub:              hub,
+		Keys:             config.Keys,
+		LimitSession:     config.LimitSession,
+		Listener:         config.AccessListener,
 		Port:             config.AccessPort,
+		Proxies:          config.Proxies,
+		Recordings:       rs,
 		Secret:           config.Secret,
 		Target:           config.Target,
 	}
@@ -91,6 +210,78 @@ func Relay(closed <-chan struct{}, parentwg *sync.WaitGroup, config Config) {
 	go access.API(closed, &wg, accessConfig) //accessPort, audience, secret, target, cs, allowNoBookingID)
 
 	wg.Wait()
-	parentwg.Done()
 	log.Trace("Relay done")
+
+	return nil
+}
+
+// localStores returns in-memory code and deny stores, persisting the deny store if
+// StateDir is set, and serving both to other replicas if StorePort is set. It returns
+// an error if the deny store cannot be loaded from, or saved to, StateDir.
+func localStores(closed <-chan struct{}, config Config) (*ttlcode.CodeStore, *deny.Store, error) {
+
+	ds := deny.New()
+
+	if config.StateDir != "" {
+		if err := ds.Persist(config.StateDir); err != nil {
+			return nil, nil, err
+		}
+		log.WithFields(log.Fields{"dir": config.StateDir, "denied": len(ds.GetDenyList())}).Info("deny list loaded")
+	}
+
+	cs := ttlcode.NewDefaultCodeStore()
+
+	metrics.DenyListSize.Set(float64(len(ds.GetDenyList())))
+
+	go func() {
+		for {
+			select {
+			case <-closed:
+				err := ds.Close()
+				if err != nil {
+					log.WithFields(log.Fields{"error": err.Error(), "dir": config.StateDir}).Error("deny list not closed")
+				}
+				return
+			case <-time.After(config.PruneEvery):
+				{
+					ds.Prune()
+					metrics.DenyListSize.Set(float64(len(ds.GetDenyList())))
+				}
+			}
+		}
+	}()
+
+	if config.StorePort > 0 || config.StoreListener != nil {
+
+		h := &http.Server{
+			Addr:    ":" + strconv.Itoa(config.StorePort),
+			Handler: store.Handler(cs, ds, config.StoreSecret),
+		}
+
+		go func() {
+			var err error
+			if config.StoreListener != nil {
+				err = h.Serve(config.StoreListener)
+			} else {
+				err = h.ListenAndServe()
+			}
+			// the listener is closed once handed over to a new process
+			if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				log.WithFields(log.Fields{"error": err.Error(), "port": config.StorePort}).Error("store server stopped")
+			}
+		}()
+
+		go func() {
+			<-closed
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := h.Shutdown(ctx); err != nil {
+				log.WithField("error", err.Error()).Error("store server shutdown error")
+			}
+		}()
+
+		log.WithField("port", config.StorePort).Info("serving code and deny store")
+	}
+
+	return cs, ds, nil
 }
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,9 +20,12 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/websocket"
 	"github.com/phayes/freeport"
+	"github.com/practable/relay/internal/access/models"
 	"github.com/practable/relay/internal/access/restapi/operations"
+	"github.com/practable/relay/internal/crossbar"
 	"github.com/practable/relay/internal/permission"
 	"github.com/practable/relay/internal/reconws"
+	"github.com/practable/relay/pkg/token"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -212,27 +216,14 @@ func TestRelay(t *testing.T) {
 
 	ctx, cancel = context.WithCancel(context.Background())
 
-	go func() {
-		err := s0.Dial(ctx, strings.Replace(ping.URI, "123", "456", 1))
-		assert.NoError(t, err)
-	}()
-
-	go func() {
-		err := s1.Dial(ctx, strings.Replace(pong.URI, "123", "456", 1))
-		assert.NoError(t, err)
-	}()
-
-	time.Sleep(timeout)
-
-	data = []byte("ping")
+	// the session is checked before upgrading, so neither dial succeeds
+	err = s0.Dial(ctx, strings.Replace(ping.URI, "123", "456", 1))
+	assert.Error(t, err)
 
-	s0.Out <- reconws.WsMessage{Data: data, Type: websocket.TextMessage}
+	err = s1.Dial(ctx, strings.Replace(pong.URI, "123", "456", 1))
+	assert.Error(t, err)
 
-	select {
-	case <-s1.In:
-		t.Fatal("TestPreventValidCodeAtWrongSessionID...FAIL")
-	case <-time.After(timeout):
-		cancel()
+	if err != nil {
 		t.Logf("TestPreventValidCodeAtWrongSessionID...PASS")
 	}
 	cancel()
@@ -420,3 +411,350 @@ func TestRelay(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestDisconnect(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	ports, err := freeport.GetFreePorts(2)
+	assert.NoError(t, err)
+
+	audience := "http://[::]:" + strconv.Itoa(ports[1])
+	target := "ws://127.0.0.1:" + strconv.Itoa(ports[0])
+	secret := "testsecret"
+
+	wg.Add(1)
+
+	go Relay(closed, &wg, Config{
+		AccessPort: ports[1],
+		RelayPort:  ports[0],
+		Audience:   audience,
+		Secret:     secret,
+		Target:     target,
+		PruneEvery: time.Minute,
+	})
+
+	time.Sleep(time.Second)
+
+	client := &http.Client{}
+
+	now := time.Now()
+
+	bearer := func(scopes []string, bid, connectionType, topic string) string {
+		b, err := token.New(now.Add(-time.Second), now.Add(-time.Second), now.Add(time.Minute), scopes, audience, bid, connectionType, secret, topic)
+		assert.NoError(t, err)
+		return b
+	}
+
+	admin := bearer([]string{"relay:admin", "relay:stats"}, "ops", "", "")
+
+	do := func(method, path string, query map[string]string, bearer string) (int, []byte) {
+		req, err := http.NewRequest(method, audience+path, nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		q := req.URL.Query()
+		for k, v := range query {
+			q.Add(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp.StatusCode, body
+	}
+
+	// connect returns a connection to the topic for the booking, and the access status code
+	connect := func(bid, topic string) (*websocket.Conn, int) {
+		code, body := do("POST", "/session/"+topic, nil, bearer([]string{"read", "write"}, bid, "session", topic))
+		if code != http.StatusOK {
+			return nil, code
+		}
+		var s operations.SessionOKBody
+		assert.NoError(t, json.Unmarshal(body, &s))
+		conn, _, err := websocket.DefaultDialer.Dial(s.URI, nil)
+		assert.NoError(t, err)
+		return conn, code
+	}
+
+	// closeCode returns the code the connection was closed with
+	closeCode := func(conn *websocket.Conn) int {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err := conn.ReadMessage()
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			return ce.Code
+		}
+		return 0
+	}
+
+	c0, _ := connect("bid0", "disc00")
+	defer c0.Close()
+	c1, _ := connect("bid1", "disc00")
+	defer c1.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestStatusHasConnectionIDs
+	code, body := do("GET", "/status", nil, admin)
+	assert.Equal(t, http.StatusOK, code)
+	var status models.Status
+	assert.NoError(t, json.Unmarshal(body, &status))
+	ids := make(map[string]string)
+	for _, r := range status {
+		ids[r.BookingID] = r.ID
+	}
+	assert.NotEqual(t, "", ids["bid0"])
+
+	// *** TestDisconnectNeedsAdmin
+	code, _ = do("POST", "/connections/disconnect", map[string]string{"id": ids["bid0"]}, bearer([]string{"relay:stats"}, "", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// *** TestDisconnectBadRequest
+	code, _ = do("POST", "/connections/disconnect", nil, admin)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do("POST", "/connections/disconnect", map[string]string{"id": ids["bid0"], "reason": strings.Repeat("x", 124)}, admin)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// *** TestDisconnectNotFound
+	code, _ = do("POST", "/connections/disconnect", map[string]string{"id": "nobody"}, admin)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// *** TestDisconnectWithoutDeny
+	code, body = do("POST", "/connections/disconnect", map[string]string{"id": ids["bid0"], "reason": "stuck tab"}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &status))
+	if assert.Equal(t, 1, len(status)) {
+		assert.Equal(t, ids["bid0"], status[0].ID)
+	}
+	assert.Equal(t, crossbar.CloseDisconnected, closeCode(c0))
+
+	// the booking can reconnect
+	c0, code = connect("bid0", "disc00")
+	assert.Equal(t, http.StatusOK, code)
+	defer c0.Close()
+
+	// *** TestDisconnectTopicWithDeny
+	code, body = do("POST", "/connections/disconnect", map[string]string{"topic": "disc00", "deny": "true"}, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &status))
+	assert.Equal(t, 2, len(status))
+	assert.Equal(t, crossbar.CloseDisconnected, closeCode(c0))
+	assert.Equal(t, crossbar.CloseDisconnected, closeCode(c1))
+
+	// the bookings cannot reconnect
+	_, code = connect("bid0", "disc00")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = connect("bid1", "disc00")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = do("GET", "/bids/deny", nil, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), "bid0")
+	assert.Contains(t, string(body), "bid1")
+
+	close(closed)
+	wg.Wait()
+
+}
+
+func TestDenyTopic(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+
+	ports, err := freeport.GetFreePorts(2)
+	assert.NoError(t, err)
+
+	audience := "http://[::]:" + strconv.Itoa(ports[1])
+	target := "ws://127.0.0.1:" + strconv.Itoa(ports[0])
+	secret := "testsecret"
+
+	wg.Add(1)
+
+	go Relay(closed, &wg, Config{
+		AccessPort: ports[1],
+		RelayPort:  ports[0],
+		Audience:   audience,
+		Secret:     secret,
+		Target:     target,
+		PruneEvery: time.Minute,
+	})
+
+	time.Sleep(time.Second)
+
+	client := &http.Client{}
+
+	now := time.Now()
+
+	bearer := func(scopes []string, bid, connectionType, topic string) string {
+		b, err := token.New(now.Add(-time.Second), now.Add(-time.Second), now.Add(time.Minute), scopes, audience, bid, connectionType, secret, topic)
+		assert.NoError(t, err)
+		return b
+	}
+
+	admin := bearer([]string{"relay:admin"}, "ops", "", "")
+
+	do := func(method, path string, query map[string]string, bearer string) (int, []byte) {
+		req, err := http.NewRequest(method, audience+path, nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+		q := req.URL.Query()
+		for k, v := range query {
+			q.Add(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp.StatusCode, body
+	}
+
+	// connect returns a connection to the topic for the booking, and the access status code
+	connect := func(bid, topic string, scopes []string) (*websocket.Conn, int) {
+		code, body := do("POST", "/session/"+topic, nil, bearer(scopes, bid, "session", topic))
+		if code != http.StatusOK {
+			return nil, code
+		}
+		var s operations.SessionOKBody
+		assert.NoError(t, json.Unmarshal(body, &s))
+		conn, _, err := websocket.DefaultDialer.Dial(s.URI, nil)
+		assert.NoError(t, err)
+		return conn, code
+	}
+
+	student := []string{"read", "write"}
+	maintainer := []string{"read", "write", "host"}
+
+	c0, _ := connect("bid0", "spin00-data", student)
+	defer c0.Close()
+	c1, _ := connect("bid1", "spin00-data", maintainer)
+	defer c1.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	exp := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	// *** TestDenyTopicNeedsAdmin
+	code, _ := do("POST", "/topics/deny", map[string]string{"topic": "spin00-*", "exp": exp}, bearer([]string{"relay:stats"}, "", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// *** TestDenyTopicBadRequest
+	code, _ = do("POST", "/topics/deny", map[string]string{"topic": "spin00-*"}, admin)
+	assert.Equal(t, http.StatusUnprocessableEntity, code) // exp is required
+	code, _ = do("POST", "/topics/deny", map[string]string{"topic": "spin00-*", "exp": "1"}, admin)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// *** TestDenyTopic
+	code, _ = do("POST", "/topics/deny", map[string]string{"topic": "spin00-*", "exp": exp, "exempt": "host,relay:admin", "reason": "motor replaced"}, admin)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	assert.NoError(t, c0.SetReadDeadline(time.Now().Add(time.Second)))
+	_, _, err = c0.ReadMessage()
+	var ce *websocket.CloseError
+	if assert.True(t, errors.As(err, &ce)) {
+		assert.Equal(t, crossbar.CloseDenied, ce.Code)
+		assert.Equal(t, "motor replaced", ce.Text)
+	}
+
+	// the maintainer is still connected, and can reconnect, but the student cannot
+	code, body := do("GET", "/status", nil, bearer([]string{"relay:stats"}, "", "", ""))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), "bid1")
+	assert.NotContains(t, string(body), "bid0")
+
+	_, code = connect("bid0", "spin00-data", student)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = connect("bid2", "spin00-video", student)
+	assert.Equal(t, http.StatusBadRequest, code)
+	c3, code := connect("bid3", "spin00-video", maintainer)
+	assert.Equal(t, http.StatusOK, code)
+	defer c3.Close()
+	c4, code := connect("bid4", "spin01-data", student)
+	assert.Equal(t, http.StatusOK, code)
+	defer c4.Close()
+
+	// *** TestListDeniedTopics
+	code, body = do("GET", "/topics/deny", nil, admin)
+	assert.Equal(t, http.StatusOK, code)
+	var denied models.DeniedTopics
+	assert.NoError(t, json.Unmarshal(body, &denied))
+	if assert.Equal(t, 1, len(denied)) {
+		assert.Equal(t, "spin00-*", denied[0].Topic)
+		assert.Equal(t, []string{"host", "relay:admin"}, denied[0].Exempt)
+		assert.Equal(t, "motor replaced", denied[0].Reason)
+	}
+
+	// *** TestAllowTopic
+	code, _ = do("POST", "/topics/allow", map[string]string{"topic": "spin00-*"}, admin)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	c0, code = connect("bid0", "spin00-data", student)
+	assert.Equal(t, http.StatusOK, code)
+	defer c0.Close()
+
+	code, body = do("GET", "/topics/deny", nil, admin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]", strings.TrimSpace(string(body)))
+
+	close(closed)
+	wg.Wait()
+
+}
+
+func TestRelayNeedsDenyList(t *testing.T) {
+
+	var ignore bytes.Buffer
+	logignore := bufio.NewWriter(&ignore)
+	log.SetOutput(logignore)
+
+	// a deny list that cannot be read must stop the relay starting,
+	// or cancelled bookings could connect again
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "deny.snapshot.json"), []byte("{corrupt"), 0600))
+
+	ports, err := freeport.GetFreePorts(2)
+	assert.NoError(t, err)
+
+	closed := make(chan struct{})
+	defer close(closed)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	done := make(chan error)
+
+	go func() {
+		done <- Relay(closed, &wg, Config{
+			AccessPort: ports[1],
+			RelayPort:  ports[0],
+			Audience:   "http://[::]:" + strconv.Itoa(ports[1]),
+			Secret:     "testsecret",
+			Target:     "ws://127.0.0.1:" + strconv.Itoa(ports[0]),
+			PruneEvery: time.Minute,
+			StateDir:   dir,
+		})
+	}()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("relay started without its deny list")
+	}
+
+	wg.Wait()
+
+	_, err = http.Get("http://127.0.0.1:" + strconv.Itoa(ports[1]) + "/")
+	assert.Error(t, err, "access should not be listening")
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/permission"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client uses the backends served by Handler on another relay. It implements
+// both ttlcode.Backend and deny.Backend. Because those interfaces do not return
+// errors, failures are logged; codes are not issued, and booking IDs are treated
+// as denied, so that an unreachable store fails closed. Topic denials are the
+// exception: GetDeniedTopics returns an error, so that every topic is refused.
+type Client struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewClient returns a client for the store at url e.g. http://relay-store:3002
+func NewClient(url, secret string) *Client {
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		secret: secret,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// do makes a request, sending in as JSON if not nil, and decoding the response into out if not nil
+func (c *Client) do(method, path string, in, out interface{}) error {
+
+	var body io.Reader
+
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", c.secret)
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New("invalid code")
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("store returned %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SubmitToken returns a code that can be swapped for the token, or "" if the store could not be reached
+func (c *Client) SubmitToken(token permission.Token) string {
+	var code Code
+	if err := c.do(http.MethodPost, "/codes", token, &code); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url}).Error("store could not submit token")
+		return ""
+	}
+	return code.Code
+}
+
+// ExchangeCode swaps a (valid) code for the associated token
+func (c *Client) ExchangeCode(code string) (permission.Token, error) {
+	var token permission.Token
+	err := c.do(http.MethodPost, "/codes/exchange", Code{Code: code}, &token)
+	return token, err
+}
+
+// DeleteByBookingID deletes any codes for tokens with the booking ID
+func (c *Client) DeleteByBookingID(bid string) {
+	if err := c.do(http.MethodDelete, "/codes?bid="+url.QueryEscape(bid), nil, nil); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "booking_id": bid}).Error("store could not delete codes")
+	}
+}
+
+// GetTime returns the current Unix time in seconds
+func (c *Client) GetTime() int64 {
+	return time.Now().Unix()
+}
+
+// Allow reverts a denied ID back to being allowed
+func (c *Client) Allow(ID string, expiresAt int64) {
+	if err := c.do(http.MethodPost, "/allow", Entry{ID: ID, Exp: expiresAt}, nil); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "booking_id": ID}).Error("store could not allow booking ID")
+	}
+}
+
+// Deny adds an ID to the deny list
+func (c *Client) Deny(ID string, expiresAt int64) {
+	if err := c.do(http.MethodPost, "/deny", Entry{ID: ID, Exp: expiresAt}, nil); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "booking_id": ID}).Error("store could not deny booking ID")
+	}
+}
+
+// IsDenied checks if an ID is on the deny list, and returns true if the store could not be reached
+func (c *Client) IsDenied(ID string) bool {
+	var d Denied
+	if err := c.do(http.MethodGet, "/denied?id="+url.QueryEscape(ID), nil, &d); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "booking_id": ID}).Error("store could not check booking ID, so treating it as denied")
+		return true
+	}
+	return d.Denied
+}
+
+// GetAllowList returns the entire allow list, or an empty list if the store could not be reached
+func (c *Client) GetAllowList() []string {
+	l, _ := c.list("/allow")
+	return l
+}
+
+// GetDenyList returns the entire deny list, or an empty list if the store could not be reached
+func (c *Client) GetDenyList() []string {
+	l, _ := c.list("/deny")
+	return l
+}
+
+// DenyTopic adds or replaces the denial of a topic pattern
+func (c *Client) DenyTopic(t deny.Topic) {
+	if err := c.do(http.MethodPost, "/topics", t, nil); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "topic": t.Pattern}).Error("store could not deny topic")
+	}
+}
+
+// AllowTopic removes the denial of a topic pattern
+func (c *Client) AllowTopic(pattern string) {
+	if err := c.do(http.MethodDelete, "/topics?topic="+url.QueryEscape(pattern), nil, nil); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url, "topic": pattern}).Error("store could not allow topic")
+	}
+}
+
+// GetDeniedTopics returns the topic denials, or an error if the store could not be
+// reached, so that connections are refused rather than let through to denied topics
+func (c *Client) GetDeniedTopics() ([]deny.Topic, error) {
+	d := []deny.Topic{}
+	if err := c.do(http.MethodGet, "/topics", nil, &d); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url}).Error("store could not get denied topics")
+		return nil, err
+	}
+	return d, nil
+}
+
+// list returns the list at path, or an empty list and an error if the store could not be reached
+func (c *Client) list(path string) ([]string, error) {
+	l := []string{}
+	if err := c.do(http.MethodGet, path, nil, &l); err != nil {
+		log.WithFields(log.Fields{"error": err.Error(), "url": c.url}).Error("store could not get list")
+		return []string{}, err
+	}
+	return l, nil
+}
+
+// WatchDenied polls the deny list, and sends booking IDs that are newly denied
+// to the denied channel so that their connections can be closed, until closed.
+// This lets a crossbar close connections that were denied via another replica.
+func (c *Client) WatchDenied(closed <-chan struct{}, every time.Duration, denied chan<- string) {
+
+	known := make(map[string]bool)
+
+	for {
+		ids, err := c.list("/deny")
+
+		// skip a poll that failed, rather than forget the known booking IDs
+		// and send them all again when the store can be reached
+		if err == nil {
+
+			current := make(map[string]bool)
+
+			for _, id := range ids {
+				current[id] = true
+				if !known[id] {
+					select {
+					case denied <- id:
+					case <-closed:
+						return
+					}
+				}
+			}
+
+			known = current
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-time.After(every):
+		}
+	}
+}
+
+// WatchDeniedTopics polls the denied topics, and sends each topic denial that is new
+// or has changed to the denied channel, so that connections to the topics can be closed,
+// until closed. This lets a crossbar close connections to topics denied via another replica.
+func (c *Client) WatchDeniedTopics(closed <-chan struct{}, every time.Duration, denied chan<- deny.Topic) {
+
+	known := make(map[string]string)
+
+	for {
+		topics, err := c.GetDeniedTopics()
+
+		// skip a poll that failed, rather than forget the known denials and
+		// send them all again when the store can be reached
+		if err == nil {
+
+			current := make(map[string]string)
+
+			for _, t := range topics {
+				b, err := json.Marshal(t)
+				if err != nil {
+					continue
+				}
+				current[t.Pattern] = string(b)
+				if known[t.Pattern] != string(b) {
+					select {
+					case denied <- t:
+					case <-closed:
+						return
+					}
+				}
+			}
+
+			known = current
+		}
+
+		select {
+		case <-closed:
+			return
+		case <-time.After(every):
+		}
+	}
+}
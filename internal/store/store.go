@@ -0,0 +1,161 @@
+// Package store serves a relay's code and deny backends over HTTP, and provides
+// clients for them, so that access and crossbar replicas in separate processes
+// can share codes and allow/deny lists.
+package store
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+)
+
+// Entry represents a booking ID and its expiry, for the allow and deny lists
+type Entry struct {
+	ID  string `json:"id"`
+	Exp int64  `json:"exp"`
+}
+
+// Code represents a code that can be exchanged for a token
+type Code struct {
+	Code string `json:"code"`
+}
+
+// Denied represents whether a booking ID is denied
+type Denied struct {
+	Denied bool `json:"denied"`
+}
+
+// Handler serves the code and deny backends. Requests must have the
+// secret in their Authorization header. Endpoints are:
+//
+//	POST   /codes           Token -> Code
+//	POST   /codes/exchange  Code -> Token, or 404 if the code is invalid
+//	DELETE /codes?bid=      delete codes for a booking ID
+//	POST   /allow           Entry
+//	POST   /deny            Entry
+//	GET    /allow           list of booking IDs
+//	GET    /deny            list of booking IDs
+//	GET    /denied?id=      Denied
+//	POST   /topics          deny.Topic
+//	GET    /topics          list of deny.Topic
+//	DELETE /topics?topic=   allow a topic pattern
+func Handler(cs ttlcode.Backend, ds deny.Backend, secret string) http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/codes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var token permission.Token
+			if !decode(w, r, &token) {
+				return
+			}
+			code := cs.SubmitToken(token)
+			if code == "" {
+				http.Error(w, "token not stored", http.StatusInternalServerError)
+				return
+			}
+			encode(w, Code{Code: code})
+		case http.MethodDelete:
+			cs.DeleteByBookingID(r.URL.Query().Get("bid"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/codes/exchange", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var code Code
+		if !decode(w, r, &code) {
+			return
+		}
+		token, err := cs.ExchangeCode(code.Code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		encode(w, token)
+	})
+
+	list := func(get func() []string, set func(string, int64)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				encode(w, get())
+			case http.MethodPost:
+				var e Entry
+				if !decode(w, r, &e) {
+					return
+				}
+				set(e.ID, e.Exp)
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			}
+		}
+	}
+
+	mux.HandleFunc("/allow", list(ds.GetAllowList, ds.Allow))
+	mux.HandleFunc("/deny", list(ds.GetDenyList, ds.Deny))
+
+	mux.HandleFunc("/denied", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, Denied{Denied: ds.IsDenied(r.URL.Query().Get("id"))})
+	})
+
+	mux.HandleFunc("/topics", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			d, err := ds.GetDeniedTopics()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			encode(w, d)
+		case http.MethodPost:
+			var t deny.Topic
+			if !decode(w, r, &t) {
+				return
+			}
+			ds.DenyTopic(t)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			ds.AllowTopic(r.URL.Query().Get("topic"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(secret)) != 1 {
+			log.WithFields(log.Fields{"remote_addr": r.RemoteAddr, "path": r.URL.Path}).Warn("store request rejected because secret did not match")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func encode(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithField("error", err.Error()).Error("store could not encode response")
+	}
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/permission"
+	"github.com/practable/relay/internal/ttlcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+
+	s := httptest.NewServer(Handler(cs, ds, "somesecret"))
+	defer s.Close()
+
+	c := NewClient(s.URL, "somesecret")
+
+	now := time.Now().Unix()
+	token := permission.NewToken("wss://relay.example.io", "session", "topic00", []string{"read"}, now-1, now-1, now+60)
+	token.SetBookingID("bid00")
+
+	// codes can be exchanged once, at either the client or the server
+	code := c.SubmitToken(token)
+	assert.NotEqual(t, "", code)
+	assert.Equal(t, 1, cs.GetCodeCount())
+
+	exchanged, err := c.ExchangeCode(code)
+	assert.NoError(t, err)
+	assert.Equal(t, "topic00", exchanged.Topic)
+	assert.Equal(t, "bid00", exchanged.BookingID)
+	assert.Equal(t, token.ExpiresAt.Unix(), exchanged.ExpiresAt.Unix())
+
+	_, err = c.ExchangeCode(code)
+	assert.Error(t, err)
+
+	code = cs.SubmitToken(token)
+	exchanged, err = c.ExchangeCode(code)
+	assert.NoError(t, err)
+	assert.Equal(t, "topic00", exchanged.Topic)
+
+	c.SubmitToken(token)
+	assert.Equal(t, 1, cs.GetCodeCount())
+	c.DeleteByBookingID("bid00")
+	assert.Equal(t, 0, cs.GetCodeCount())
+
+	// lists
+	c.Allow("bid00", now+60)
+	c.Allow("bid01", now+60)
+	assert.ElementsMatch(t, []string{"bid00", "bid01"}, c.GetAllowList())
+	assert.False(t, c.IsDenied("bid00"))
+
+	c.Deny("bid00", now+60)
+	assert.True(t, c.IsDenied("bid00"))
+	assert.True(t, ds.IsDenied("bid00"))
+	assert.Equal(t, []string{"bid00"}, c.GetDenyList())
+	assert.Equal(t, []string{"bid01"}, c.GetAllowList())
+
+	// topics
+	c.DenyTopic(deny.Topic{Pattern: "pend00/**", Exp: now + 60, Exempt: []string{"host"}, Reason: "broken"})
+	c.DenyTopic(deny.Topic{Pattern: "pend01", Exp: now + 60})
+	topics, err := c.GetDeniedTopics()
+	assert.NoError(t, err)
+	assert.Equal(t, []deny.Topic{
+		{Pattern: "pend00/**", Exp: now + 60, Exempt: []string{"host"}, Reason: "broken"},
+		{Pattern: "pend01", Exp: now + 60},
+	}, topics)
+	c.AllowTopic("pend01")
+	topics, err = ds.GetDeniedTopics()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(topics))
+
+	// wrong secret
+	bad := NewClient(s.URL, "wrongsecret")
+	assert.Equal(t, "", bad.SubmitToken(token))
+	assert.True(t, bad.IsDenied("bid01"), "must fail closed")
+	assert.Equal(t, []string{}, bad.GetDenyList())
+	_, err = bad.GetDeniedTopics()
+	assert.Error(t, err, "must fail closed")
+
+	resp, err := http.Get(s.URL + "/deny")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// unreachable store
+	s.Close()
+	assert.Equal(t, "", c.SubmitToken(token))
+	assert.True(t, c.IsDenied("bid01"))
+	_, err = c.GetDeniedTopics()
+	assert.Error(t, err)
+}
+
+// failing serves the handler, except while fail is set, when it refuses requests,
+// like a store that is briefly unavailable, counting the requests it refused
+type failing struct {
+	http.Handler
+	fail    int32
+	refused int32
+}
+
+func (f *failing) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&f.fail) == 1 {
+		atomic.AddInt32(&f.refused, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.Handler.ServeHTTP(w, r)
+}
+
+// outage makes the store fail until it has refused a poll, then restores it
+func outage(t *testing.T, f *failing) {
+	atomic.StoreInt32(&f.fail, 1)
+	defer atomic.StoreInt32(&f.fail, 0)
+	for i := 0; atomic.LoadInt32(&f.refused) == 0; i++ {
+		if i > 100 {
+			t.Fatal("store was not polled during the outage")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchDenied(t *testing.T) {
+
+	ds := deny.New()
+	f := &failing{Handler: Handler(ttlcode.NewDefaultCodeStore(), ds, "somesecret")}
+	s := httptest.NewServer(f)
+	defer s.Close()
+
+	c := NewClient(s.URL, "somesecret")
+
+	now := time.Now().Unix()
+	ds.Deny("bid00", now+60)
+
+	closed := make(chan struct{})
+	defer close(closed)
+	denied := make(chan string, 10)
+
+	go c.WatchDenied(closed, 10*time.Millisecond, denied)
+
+	select {
+	case id := <-denied:
+		assert.Equal(t, "bid00", id)
+	case <-time.After(time.Second):
+		t.Fatal("did not get existing denied booking ID")
+	}
+
+	ds.Deny("bid01", now+60)
+
+	select {
+	case id := <-denied:
+		assert.Equal(t, "bid01", id)
+	case <-time.After(time.Second):
+		t.Fatal("did not get newly denied booking ID")
+	}
+
+	// booking IDs are only sent once, even after the store could not be reached
+	outage(t, f)
+
+	select {
+	case id := <-denied:
+		t.Errorf("unexpected repeat of %s", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchDeniedTopics(t *testing.T) {
+
+	ds := deny.New()
+	f := &failing{Handler: Handler(ttlcode.NewDefaultCodeStore(), ds, "somesecret")}
+	s := httptest.NewServer(f)
+	defer s.Close()
+
+	c := NewClient(s.URL, "somesecret")
+
+	now := time.Now().Unix()
+	ds.DenyTopic(deny.Topic{Pattern: "pend00", Exp: now + 60})
+
+	closed := make(chan struct{})
+	defer close(closed)
+	denied := make(chan deny.Topic, 10)
+
+	go c.WatchDeniedTopics(closed, 10*time.Millisecond, denied)
+
+	next := func() deny.Topic {
+		select {
+		case d := <-denied:
+			return d
+		case <-time.After(time.Second):
+			t.Fatal("did not get denied topic")
+		}
+		return deny.Topic{}
+	}
+
+	assert.Equal(t, "pend00", next().Pattern)
+
+	// changes are sent again, e.g. so that a new reason is given
+	ds.DenyTopic(deny.Topic{Pattern: "pend00", Exp: now + 60, Reason: "still broken"})
+	assert.Equal(t, "still broken", next().Reason)
+
+	ds.DenyTopic(deny.Topic{Pattern: "pend01", Exp: now + 60})
+	assert.Equal(t, "pend01", next().Pattern)
+
+	// denials are only sent once, even after the store could not be reached
+	outage(t, f)
+
+	select {
+	case d := <-denied:
+		t.Errorf("unexpected repeat of %s", d.Pattern)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -33,6 +33,23 @@ func NewExpToken(token permission.Token, ttl int64) ExpToken {
 	}
 }
 
+// Backend swaps tokens for single-use codes, and back again. CodeStore keeps
+// codes in memory; other implementations let separate processes share codes.
+type Backend interface {
+
+	// SubmitToken returns a code that can be swapped for the token, or "" if the token could not be stored
+	SubmitToken(token permission.Token) string
+
+	// ExchangeCode swaps a (valid) code for the associated token, once only
+	ExchangeCode(code string) (permission.Token, error)
+
+	// DeleteByBookingID removes any codes for tokens with the booking ID
+	DeleteByBookingID(bid string)
+
+	// GetTime returns the current Unix time in seconds, as used by the backend
+	GetTime() int64
+}
+
 // CodeStore represents the codes, and their associated expiring tokens.
 type CodeStore struct {
 	// Prevent multiple clients getting the same token by mutexing.
@@ -152,6 +169,8 @@ func (c *CodeStore) GetCodeCount() int {
 
 // DeleteByBookingID uses the booking ID to delete a store entry
 func (c *CodeStore) DeleteByBookingID(bid string) {
+	c.Lock()
+	defer c.Unlock()
 
 	stale := []string{}
 
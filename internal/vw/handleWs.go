@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/practable/relay/internal/framing"
 	"github.com/practable/relay/internal/hub"
 	log "github.com/sirupsen/logrus"
 )
@@ -26,21 +27,33 @@ const (
 	maxMessageSize = 1024 * 1024 * 10
 )
 
+const (
+	// FramingStream appends queued messages to one websocket message, without
+	// delimiter, which suits MPEG-TS, and is the default
+	FramingStream = framing.Stream
+
+	// FramingMessage writes each queued message as its own websocket message
+	FramingMessage = framing.Message
+
+	// MessageProtocol is the websocket subprotocol that clients request for FramingMessage
+	MessageProtocol = framing.Protocol
+)
+
 // 4096 Bytes is the approx average message size
 // this number does not limit message size
 // So for key frames we just make a few more syscalls
-// null subprotocol required by Chrome
+// null subprotocol required by Chrome; MessageProtocol is chosen for clients that request it
 // TODO restrict CheckOrigin
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
-	Subprotocols:    []string{"null"},
+	Subprotocols:    []string{MessageProtocol, "null"},
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
 func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := framing.Upgrade(&upgrader, w, r, nil)
 	if err != nil {
 		log.WithField("error", err).Error("Failed upgrading to websocket connection in wsHandler")
 		return
@@ -60,6 +73,11 @@ func (app *App) handleWs(w http.ResponseWriter, r *http.Request) {
 		Conn:       conn,
 		UserAgent:  r.UserAgent(),
 		RemoteAddr: r.Header.Get("X-Forwarded-For"),
+		Framing:    FramingStream,
+	}
+
+	if conn.Subprotocol() == MessageProtocol {
+		client.Framing = FramingMessage
 	}
 
 	app.Hub.Register <- client.Messages
@@ -138,34 +156,7 @@ func (c *WsHandlerClient) writePump(closed <-chan struct{}) {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(message.Type)
-			if err != nil {
-				return
-			}
-
-			n, err := w.Write(message.Data)
-			if err != nil {
-				log.Errorf("writePump writing error: %v", err)
-			}
-			size := len(message.Data)
-			if n != size {
-				log.Errorf("writePump incomplete write %d of %d", n, size)
-			}
-			// Add queued chunks to the current websocket message, without delimiter.
-			m := len(c.Messages.Send)
-			for i := 0; i < m; i++ {
-				followOnMessage := <-c.Messages.Send
-				n, err := w.Write(followOnMessage.Data)
-				if err != nil {
-					log.Errorf("writePump writing error: %v", err)
-				}
-				size += n
-				if n != size {
-					log.Errorf("writePump incomplete write %d of %d", n, size)
-				}
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.writeQueued(message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -183,3 +174,23 @@ func (c *WsHandlerClient) writePump(closed <-chan struct{}) {
 		}
 	}
 }
+
+// writeQueued writes the message, and any others already queued, to the connection,
+// and sends them together. Messages are appended to the same websocket message for
+// FramingStream, unless their type changes, or written as separate websocket messages
+// for FramingMessage.
+func (c *WsHandlerClient) writeQueued(first hub.Message) error {
+
+	items := []framing.Item{{Type: first.Type, Data: first.Data}}
+
+	m := len(c.Messages.Send)
+	for i := 0; i < m; i++ {
+		next, ok := <-c.Messages.Send
+		if !ok {
+			break // hub closed the channel; we'll find out next time round
+		}
+		items = append(items, framing.Item{Type: next.Type, Data: next.Data})
+	}
+
+	return framing.Write(c.Conn, c.Framing, items)
+}
@@ -217,3 +217,62 @@ func echo(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+func TestHandleWsWriteQueued(t *testing.T) {
+
+	conns := make(chan *websocket.Conn)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		conns <- conn
+	}))
+	defer s.Close()
+
+	// read returns the websocket messages received from a client with the framing
+	read := func(framing string, messages ...string) []string {
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		sc := <-conns
+		defer sc.Close()
+
+		c := &WsHandlerClient{
+			Messages: &hub.Client{Send: make(chan hub.Message, len(messages))},
+			Conn:     sc,
+			Framing:  framing,
+		}
+
+		for _, m := range messages[1:] {
+			c.Messages.Send <- hub.Message{Data: []byte(m), Type: websocket.TextMessage}
+		}
+
+		if err := c.writeQueued(hub.Message{Data: []byte(messages[0]), Type: websocket.TextMessage}); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return got
+			}
+			got = append(got, string(data))
+		}
+	}
+
+	if got := read(FramingMessage, `{"a":1}`, `{"b":2}`); strings.Join(got, ",") != `{"a":1},{"b":2}` {
+		t.Errorf("message framing got %v", got)
+	}
+
+	if got := read(FramingStream, "ab", "cd"); strings.Join(got, ",") != "abcd" {
+		t.Errorf("stream framing got %v", got)
+	}
+}
@@ -25,6 +25,7 @@ type WsHandlerClient struct {
 	Conn       *websocket.Conn
 	UserAgent  string //r.UserAgent()
 	RemoteAddr string //r.Header.Get("X-Forwarded-For")
+	Framing    string //FramingStream or FramingMessage
 }
 
 type mutexBuffer struct {
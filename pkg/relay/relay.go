@@ -0,0 +1,254 @@
+/*
+   relay is a public wrapper for internal/access and internal/crossbar so
+   that a relay can be served inside another program, on handlers that the
+   program mounts where it likes, without having to commit to publically
+   declaring the specifics of the internal API, as this may change later.
+*/
+
+package relay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/practable/relay/internal/access"
+	"github.com/practable/relay/internal/crossbar"
+	"github.com/practable/relay/internal/deny"
+	"github.com/practable/relay/internal/forwarded"
+	"github.com/practable/relay/internal/limit"
+	"github.com/practable/relay/internal/ttlcode"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config represents the options for a relay. Audience, Secret and Target
+// must be set; the other options have the same defaults as relay serve.
+type Config struct {
+
+	// AdminClientCert refuses requests to the admin endpoints of access unless they
+	// present a verified client certificate, so access must be served with TLS,
+	// with ClientAuth of at least tls.VerifyClientCertIfGiven
+	AdminClientCert bool
+
+	// AllowNoBookingID accepts tokens without a booking ID at access
+	AllowNoBookingID bool
+
+	// Audience is the URL at which access is served, which tokens must be for
+	Audience string
+
+	// BufferSize sets the number of messages buffered for each connection, 1-512
+	BufferSize int64
+
+	// ExpiryWarning is how long before expiry to warn clients that use
+	// the session subprotocol, or zero for no warning
+	ExpiryWarning time.Duration
+
+	// FloorControl lists patterns for topics on which only one writer at a time holds control
+	FloorControl []string
+
+	// LimitBytes, LimitConnect, LimitMessages and LimitSession are rate limits
+	// in the form count/duration e.g. 60/1m, or unlimited if empty
+	LimitBytes    string
+	LimitConnect  string
+	LimitMessages string
+	LimitSession  string
+
+	// PruneEvery sets how often expired bookings are removed from the deny list
+	PruneEvery time.Duration
+
+	// Secret is the HMAC secret that tokens are signed with
+	Secret string
+
+	// SlowConsumer sets what happens to messages for clients that cannot keep up
+	// e.g. *-data=disconnect,*-video=drop-stale:500ms
+	SlowConsumer string
+
+	// StatsEvery sets how often stats are reported
+	StatsEvery time.Duration
+
+	// Target is the URL at which the crossbar is served e.g. wss://example.io/relay,
+	// which access sends clients to
+	Target string
+
+	// TrustedProxies lists the addresses and networks of proxies trusted to say where
+	// requests came from in X-Forwarded-For e.g. 127.0.0.1,10.0.0.0/8, or none if empty
+	TrustedProxies string
+}
+
+// drainWait is how long connections have to close, once drained, before the relay stops
+const drainWait = 100 * time.Millisecond
+
+// Relay is an access API and a crossbar, which share their codes and deny lists
+type Relay struct {
+	access   http.Handler
+	crossbar http.Handler
+
+	hub *crossbar.Hub
+
+	// closed stops the relay, after drain has closed its connections
+	closed chan struct{}
+	drain  chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// New returns a running relay, whose handlers are ready to be served.
+// Call Shutdown to stop it.
+func New(config Config) (*Relay, error) {
+
+	if config.Audience == "" || config.Secret == "" || config.Target == "" {
+		return nil, errors.New("Audience, Secret and Target must be set")
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = 128
+	}
+
+	if config.BufferSize < 1 || config.BufferSize > 512 {
+		return nil, errors.New("BufferSize must be between 1 and 512")
+	}
+
+	if config.PruneEvery == 0 {
+		config.PruneEvery = 5 * time.Minute
+	}
+
+	if config.StatsEvery == 0 {
+		config.StatsEvery = 5 * time.Second
+	}
+
+	limits := make(map[string]limit.Rate)
+
+	for name, s := range map[string]string{
+		"LimitBytes":    config.LimitBytes,
+		"LimitConnect":  config.LimitConnect,
+		"LimitMessages": config.LimitMessages,
+		"LimitSession":  config.LimitSession,
+	} {
+		r, err := limit.ParseRate(s)
+		if err != nil {
+			return nil, errors.New("cannot parse " + name + " because " + err.Error())
+		}
+		limits[name] = r
+	}
+
+	slowConsumer, err := crossbar.ParseSlowConsumerRules(config.SlowConsumer)
+	if err != nil {
+		return nil, errors.New("cannot parse SlowConsumer because " + err.Error())
+	}
+
+	proxies, err := forwarded.Parse(config.TrustedProxies)
+	if err != nil {
+		return nil, errors.New("cannot parse TrustedProxies because " + err.Error())
+	}
+
+	r := &Relay{
+		hub:    crossbar.New(),
+		closed: make(chan struct{}),
+		drain:  make(chan struct{}),
+	}
+
+	cs := ttlcode.NewDefaultCodeStore()
+	ds := deny.New()
+	denied := make(chan string, 64)
+
+	go func() {
+		for {
+			select {
+			case <-r.closed:
+				cs.Close()
+				return
+			case <-time.After(config.PruneEvery):
+				ds.Prune()
+			}
+		}
+	}()
+
+	r.wg.Add(1)
+
+	r.crossbar = crossbar.Handler(r.closed, &r.wg, denied, crossbar.Config{
+		Audience:      config.Target,
+		BufferSize:    config.BufferSize,
+		CodeStore:     cs,
+		DenyStore:     ds,
+		ExpiryWarning: config.ExpiryWarning,
+		FloorControl:  config.FloorControl,
+		Hub:           r.hub,
+		LimitBytes:    limits["LimitBytes"],
+		LimitConnect:  limits["LimitConnect"],
+		LimitMessages: limits["LimitMessages"],
+		Proxies:       proxies,
+		Secret:        config.Secret,
+		SlowConsumer:  slowConsumer,
+		StatsEvery:    config.StatsEvery,
+		TokenAudience: config.Audience,
+	})
+
+	r.access, err = access.Handler(r.closed, access.Config{
+		AdminClientCert:  config.AdminClientCert,
+		AllowNoBookingID: config.AllowNoBookingID,
+		CodeStore:        cs,
+		DenyStore:        ds,
+		DenyChannel:      denied,
+		Drain:            r.drain,
+		Host:             config.Audience,
+		Hub:              r.hub,
+		LimitSession:     limits["LimitSession"],
+		Proxies:          proxies,
+		Secret:           config.Secret,
+		Target:           config.Target,
+	})
+
+	if err != nil {
+		close(r.closed)
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"audience": config.Audience, "target": config.Target}).Info("relay started")
+
+	return r, nil
+}
+
+// Access returns the handler for the access API, which must be served at Audience
+func (r *Relay) Access() http.Handler {
+	return r.access
+}
+
+// Crossbar returns the handler for websocket connections, which must be served at Target
+func (r *Relay) Crossbar() http.Handler {
+	return r.crossbar
+}
+
+// Shutdown refuses new sessions, closes every websocket connection with going away,
+// so that clients reconnect, and stops the relay. It returns once the relay has
+// stopped, or with the context's error if the context is done first. The caller
+// remains responsible for shutting down whatever serves the handlers.
+func (r *Relay) Shutdown(ctx context.Context) error {
+
+	r.once.Do(func() {
+		close(r.drain)
+		r.hub.Drain()
+		// give the connections' write pumps time to send going away
+		select {
+		case <-time.After(drainWait):
+		case <-ctx.Done():
+		}
+		close(r.closed)
+	})
+
+	stopped := make(chan struct{})
+
+	go func() {
+		r.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Info("relay stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,130 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/relay/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+// session asks access for a connection to the topic, and dials it
+func session(t *testing.T, audience, secret, topic string) *websocket.Conn {
+
+	now := time.Now()
+	bearer, err := token.New(now, now, now.Add(time.Minute), []string{"read", "write"}, audience, "bid0", "session", secret, topic)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("POST", audience+"/session/"+topic, nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", bearer)
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		URI string `json:"uri"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	conn, _, err := websocket.DefaultDialer.Dial(body.URI, nil)
+	assert.NoError(t, err)
+
+	return conn
+}
+
+func TestNew(t *testing.T) {
+
+	_, err := New(Config{Audience: "http://a", Target: "ws://b"})
+	assert.Error(t, err, "secret must be set")
+
+	_, err = New(Config{Audience: "http://a", Secret: "s", Target: "ws://b", LimitConnect: "nonsense"})
+	assert.Error(t, err)
+
+	_, err = New(Config{Audience: "http://a", Secret: "s", Target: "ws://b", BufferSize: 1000})
+	assert.Error(t, err)
+}
+
+func TestTwoRelaysOnOneMux(t *testing.T) {
+
+	mux := http.NewServeMux()
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	ws := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	relays := make(map[string]*Relay)
+
+	for _, name := range []string{"red", "blue"} {
+
+		r, err := New(Config{
+			Audience: s.URL + "/" + name + "/access",
+			Secret:   name + "secret",
+			Target:   ws + "/" + name + "/relay",
+		})
+		assert.NoError(t, err)
+
+		mux.Handle("/"+name+"/access/", http.StripPrefix("/"+name+"/access", r.Access()))
+		mux.Handle("/"+name+"/relay/", http.StripPrefix("/"+name+"/relay", r.Crossbar()))
+
+		relays[name] = r
+	}
+
+	red0 := session(t, s.URL+"/red/access", "redsecret", "data")
+	red1 := session(t, s.URL+"/red/access", "redsecret", "data")
+	blue0 := session(t, s.URL+"/blue/access", "bluesecret", "data")
+
+	time.Sleep(100 * time.Millisecond)
+
+	// *** TestRelaysAreSeparate
+	assert.NoError(t, red0.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	assert.NoError(t, red1.SetReadDeadline(time.Now().Add(time.Second)))
+	_, data, err := red1.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// *** TestShutdownClosesWithGoingAway
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, relays["red"].Shutdown(ctx))
+
+	for _, conn := range []*websocket.Conn{red0, red1} {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "expected going away but got %v", err)
+	}
+
+	// *** TestShutdownRefusesSessions
+	now := time.Now()
+	bearer, err := token.New(now, now, now.Add(time.Minute), []string{"read"}, s.URL+"/red/access", "bid0", "session", "redsecret", "data")
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", s.URL+"/red/access/session/data", nil)
+	assert.NoError(t, err)
+	req.Header.Add("Authorization", bearer)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// *** TestOtherRelayCarriesOn
+	blue1 := session(t, s.URL+"/blue/access", "bluesecret", "data")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, blue1.WriteMessage(websocket.TextMessage, []byte("still here")))
+	assert.NoError(t, blue0.SetReadDeadline(time.Now().Add(time.Second)))
+	_, data, err = blue0.ReadMessage()
+	assert.NoError(t, err)
+	// the first message on the blue relay, so red's message did not reach it
+	assert.Equal(t, "still here", string(data))
+
+	assert.NoError(t, relays["blue"].Shutdown(ctx))
+}
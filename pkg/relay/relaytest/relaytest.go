@@ -0,0 +1,106 @@
+// Package relaytest provides a real relay, served on the loopback interface,
+// for integration tests in projects that use the relay, e.g. booking systems.
+package relaytest
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	"github.com/practable/relay/pkg/relay"
+	"github.com/practable/relay/pkg/token"
+)
+
+// Server is a relay with its access API and crossbar each served by an httptest.Server
+type Server struct {
+
+	// AccessURL is the URL of the access API e.g. http://127.0.0.1:34567,
+	// which is also the audience for tokens
+	AccessURL string
+
+	// RelayURL is the URL of the crossbar e.g. ws://127.0.0.1:34568,
+	// to which access sends clients
+	RelayURL string
+
+	// Secret is the secret that tokens must be signed with
+	Secret string
+
+	// Relay is the relay being served
+	Relay *relay.Relay
+
+	access, crossbar *httptest.Server
+}
+
+// NewServer starts and returns a new relay with the default configuration.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	return NewServerWithConfig(relay.Config{})
+}
+
+// NewServerWithConfig starts and returns a new relay with the configuration,
+// except that Audience and Target are set to the URLs it is served at, and
+// Secret is set if empty. It panics if the relay cannot be started, as
+// httptest.NewServer does. The caller should call Close when finished.
+func NewServerWithConfig(config relay.Config) *Server {
+
+	access := httptest.NewUnstartedServer(nil)
+	crossbar := httptest.NewUnstartedServer(nil)
+
+	config.Audience = "http://" + access.Listener.Addr().String()
+	config.Target = "ws://" + crossbar.Listener.Addr().String()
+
+	if config.Secret == "" {
+		config.Secret = "relaytest"
+	}
+
+	r, err := relay.New(config)
+
+	if err != nil {
+		access.Close()
+		crossbar.Close()
+		panic("relaytest: failed to start relay: " + err.Error())
+	}
+
+	access.Config.Handler = r.Access()
+	crossbar.Config.Handler = r.Crossbar()
+
+	access.Start()
+	crossbar.Start()
+
+	return &Server{
+		AccessURL: config.Audience,
+		RelayURL:  config.Target,
+		Secret:    config.Secret,
+		Relay:     r,
+		access:    access,
+		crossbar:  crossbar,
+	}
+}
+
+// Token returns a token for a session on the topic, signed for this relay, valid
+// from now for the duration; for shell connections, use connection type shell
+// and scopes host or client.
+func (s *Server) Token(connectionType, topic, bookingID string, scopes []string, duration time.Duration) (string, error) {
+	now := time.Now()
+	return token.New(now, now, now.Add(duration), scopes, s.AccessURL, bookingID, connectionType, s.Secret, topic)
+}
+
+// SessionURL returns the URL at which access issues connections to the topic,
+// for a POST with a token from Token in the Authorization header; the connection
+// type is set by the token
+func (s *Server) SessionURL(topic string) string {
+	return s.AccessURL + "/session/" + topic
+}
+
+// Close shuts down the relay, and the servers
+func (s *Server) Close() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the relay closes its websocket connections, which the servers do not track
+	_ = s.Relay.Shutdown(ctx)
+
+	s.access.Close()
+	s.crossbar.Close()
+}
@@ -0,0 +1,68 @@
+package relaytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+
+	before := runtime.NumGoroutine()
+
+	s := NewServer()
+
+	dial := func(scopes []string) *websocket.Conn {
+
+		bearer, err := s.Token("session", "pend00", "bid0", scopes, time.Minute)
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", s.SessionURL("pend00"), nil)
+		assert.NoError(t, err)
+		req.Header.Add("Authorization", bearer)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body struct {
+			URI string `json:"uri"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+		conn, _, err := websocket.DefaultDialer.Dial(body.URI, nil)
+		assert.NoError(t, err)
+
+		return conn
+	}
+
+	writer := dial([]string{"write"})
+	reader := dial([]string{"read"})
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.NoError(t, writer.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	assert.NoError(t, reader.SetReadDeadline(time.Now().Add(time.Second)))
+	_, data, err := reader.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	writer.Close()
+	reader.Close()
+	s.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	// every goroutine the relay started has stopped; poll here, because
+	// assert.Eventually checks the condition in a goroutine of its own
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(5 * time.Second); after > before && time.Now().Before(deadline); after = runtime.NumGoroutine() {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, after, before, "goroutines leaked")
+}
@@ -22,10 +22,12 @@ import (
 // Used to unmarshal report data in the format from internal/crossbar/models.ClientReport
 
 type Report struct {
+	BookingID  string    `json:"bookingID,omitempty"`
 	CanRead    bool      `json:"canRead"`
 	CanWrite   bool      `json:"canWrite"`
 	Connected  time.Time `json:"connected"`
 	ExpiresAt  time.Time `json:"expiresAt"`
+	ID         string    `json:"id"`
 	RemoteAddr string    `json:"remoteAddr"`
 	Scopes     []string  `json:"scopes"`
 	Stats      RxTx      `json:"stats"`
@@ -1,6 +1,8 @@
 package token
 
 import (
+	"errors"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -10,6 +12,67 @@ import (
 // New returns a signed JWT token
 func New(iat, nbf, exp time.Time, scopes []string, aud, bid, connectionType, secret, topic string) (string, error) {
 
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(iat, nbf, exp, scopes, aud, bid, connectionType, topic))
+
+	// Sign and return the complete encoded token as a string using the secret
+	return token.SignedString([]byte(secret))
+
+}
+
+// Signer signs tokens with a key that the relay finds in its key set by ID, which
+// is put in the kid header of the token. Use NewHMACSigner for a secret shared with
+// the relay, or LoadSigner for a private key whose public key is in the relay's key set.
+type Signer struct {
+	ID     string
+	Method jwt.SigningMethod
+	Key    interface{}
+}
+
+// NewHMACSigner returns a Signer that signs with HS256 and the secret
+func NewHMACSigner(id, secret string) Signer {
+	return Signer{
+		ID:     id,
+		Method: jwt.SigningMethodHS256,
+		Key:    []byte(secret),
+	}
+}
+
+// LoadSigner returns a Signer that signs with the PEM encoded private key in keyFile,
+// which can be an RSA key (signing with RS256) or an Ed25519 key (signing with EdDSA)
+func LoadSigner(id, keyFile string) (Signer, error) {
+
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return Signer{}, err
+	}
+
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return Signer{ID: id, Method: jwt.SigningMethodRS256, Key: key}, nil
+	}
+
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(pem); err == nil {
+		return Signer{ID: id, Method: jwt.SigningMethodEdDSA, Key: key}, nil
+	}
+
+	return Signer{}, errors.New(keyFile + " does not hold a PEM encoded RSA or Ed25519 private key")
+}
+
+// Sign returns a signed JWT token, with the signer's ID as its kid
+func (s Signer) Sign(iat, nbf, exp time.Time, scopes []string, aud, bid, connectionType, topic string) (string, error) {
+
+	if s.ID == "" {
+		return "", errors.New("signer has no ID")
+	}
+
+	token := jwt.NewWithClaims(s.Method, claims(iat, nbf, exp, scopes, aud, bid, connectionType, topic))
+	token.Header["kid"] = s.ID
+
+	return token.SignedString(s.Key)
+}
+
+// claims returns the claims for a token
+func claims(iat, nbf, exp time.Time, scopes []string, aud, bid, connectionType, topic string) permission.Token {
+
 	var claims permission.Token
 
 	claims.IssuedAt = jwt.NewNumericDate(iat)
@@ -22,9 +85,5 @@ func New(iat, nbf, exp time.Time, scopes []string, aud, bid, connectionType, sec
 	claims.ConnectionType = connectionType
 	claims.Scopes = scopes
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign and return the complete encoded token as a string using the secret
-	return token.SignedString([]byte(secret))
-
+	return claims
 }
@@ -1,9 +1,18 @@
 package token
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/practable/relay/internal/permission"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,3 +36,60 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, expected, token)
 
 }
+
+func TestSigner(t *testing.T) {
+
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaFile := filepath.Join(dir, "rsa.pem")
+	assert.NoError(t, os.WriteFile(rsaFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), 0600))
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NoError(t, err)
+	edFile := filepath.Join(dir, "ed.pem")
+	assert.NoError(t, os.WriteFile(edFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	badFile := filepath.Join(dir, "bad.pem")
+	assert.NoError(t, os.WriteFile(badFile, []byte("not a key"), 0600))
+
+	now := time.Now()
+
+	tests := []struct {
+		signer Signer
+		alg    string
+		verify interface{}
+	}{
+		{NewHMACSigner("hmac0", "some_secret"), "HS256", []byte("some_secret")},
+		{load(t, "rsa0", rsaFile), "RS256", &rsaKey.PublicKey},
+		{load(t, "ed0", edFile), "EdDSA", edPublic},
+	}
+
+	for _, test := range tests {
+
+		bearer, err := test.signer.Sign(now, now, now.Add(time.Minute), []string{"read"}, "https://example.com", "bid0", "session", "expt00")
+		assert.NoError(t, err)
+
+		claims := &permission.Token{}
+		token, err := jwt.ParseWithClaims(bearer, claims, func(*jwt.Token) (interface{}, error) { return test.verify, nil })
+		assert.NoError(t, err, test.alg)
+		assert.Equal(t, test.alg, token.Method.Alg())
+		assert.Equal(t, test.signer.ID, token.Header["kid"])
+		assert.Equal(t, "expt00", claims.Topic)
+	}
+
+	_, err = LoadSigner("bad0", badFile)
+	assert.Error(t, err)
+
+	_, err = NewHMACSigner("", "some_secret").Sign(now, now, now.Add(time.Minute), []string{"read"}, "https://example.com", "bid0", "session", "expt00")
+	assert.Error(t, err)
+}
+
+func load(t *testing.T, id, keyFile string) Signer {
+	s, err := LoadSigner(id, keyFile)
+	assert.NoError(t, err)
+	return s
+}